@@ -0,0 +1,225 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"goapi-template/cache"
+	"goapi-template/models"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Server serves the diagnostics and the operational actions on their own
+// address, so they are never exposed on the public port.
+type Server struct {
+	// Token authorizes every endpoint but the health checks and the metrics
+	Token string
+	// Ready checks the dependencies, e.g. the database
+	Ready http.HandlerFunc
+	// Config prints the effective configuration, redacted
+	Config func(w io.Writer)
+	// Cache is the cache /cache/flush flushes, nil when caching is disabled
+	Cache cache.Cacher
+	// ReloadPolicies reads the authorization policies again
+	ReloadPolicies func() error
+	Build          BuildInfo
+}
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"goVersion"`
+}
+
+// NewBuildInfo completes version with the commit the binary was built from,
+// when it was built from a git checkout.
+func NewBuildInfo(version string) BuildInfo {
+	info := BuildInfo{Version: version, GoVersion: runtime.Version()}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Commit = setting.Value
+			case "vcs.modified":
+				if setting.Value == "true" && info.Commit != "" {
+					info.Commit += "-dirty"
+				}
+			}
+		}
+	}
+
+	return info
+}
+
+// logLevel is the level of the default logger, see SetLogLevel
+var logLevel atomic.Int64
+
+// SetLogLevel changes the level of the default logger.
+func SetLogLevel(level slog.Level) {
+	logLevel.Store(int64(level))
+	slog.SetLogLoggerLevel(level)
+}
+
+func LogLevel() slog.Level {
+	return slog.Level(logLevel.Load())
+}
+
+func (s *Server) Handler() http.Handler {
+	router := http.NewServeMux()
+
+	router.HandleFunc("GET /health/live", s.getLive)
+	router.HandleFunc("GET /health/ready", s.Ready)
+	router.Handle("GET /metrics", promhttp.Handler())
+
+	router.Handle("GET /debug/pprof/", s.authorized(pprof.Index))
+	router.Handle("GET /debug/pprof/cmdline", s.authorized(pprof.Cmdline))
+	router.Handle("GET /debug/pprof/profile", s.authorized(pprof.Profile))
+	router.Handle("GET /debug/pprof/symbol", s.authorized(pprof.Symbol))
+	router.Handle("POST /debug/pprof/symbol", s.authorized(pprof.Symbol))
+	router.Handle("GET /debug/pprof/trace", s.authorized(pprof.Trace))
+
+	router.Handle("GET /info", s.authorized(s.getInfo))
+	router.Handle("GET /config", s.authorized(s.getConfig))
+	router.Handle("POST /cache/flush", s.authorized(s.postCacheFlush))
+	router.Handle("POST /policies/reload", s.authorized(s.postPoliciesReload))
+	router.Handle("GET /log-level", s.authorized(s.getLogLevel))
+	router.Handle("PUT /log-level", s.authorized(s.putLogLevel))
+
+	return router
+}
+
+// authorized requires the admin token as a bearer token.
+func (s *Server) authorized(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || s.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "Admin token was not provided or is invalid")
+			return
+		}
+
+		next(w, r)
+	})
+}
+
+func (s *Server) getLive(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &models.HealthResult{Healthy: true, Dependencies: []models.HealthResultItem{}})
+}
+
+func (s *Server) getInfo(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Build)
+}
+
+func (s *Server) getConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	s.Config(w)
+}
+
+// postCacheFlush deletes the key query parameter, or every key starting with
+// the prefix query parameter.
+func (s *Server) postCacheFlush(w http.ResponseWriter, r *http.Request) {
+	if s.Cache == nil {
+		writeError(w, http.StatusNotFound, "Caching is disabled")
+		return
+	}
+
+	key, prefix := r.URL.Query().Get("key"), r.URL.Query().Get("prefix")
+
+	var err error
+	switch {
+	case key != "":
+		err = s.Cache.DeleteKey(r.Context(), key)
+	case prefix != "":
+		err = s.Cache.DeleteByPattern(r.Context(), escapePattern(prefix)+"*")
+	default:
+		writeError(w, http.StatusBadRequest, "key or prefix is required")
+		return
+	}
+
+	if err != nil {
+		slog.Error("Error flushing cache", "error", err)
+		writeError(w, http.StatusInternalServerError, "Unable to flush the cache")
+		return
+	}
+
+	audit(r, "cache_flushed", "key", key, "prefix", prefix)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// escapePattern escapes the glob characters of prefix.
+func escapePattern(prefix string) string {
+	var escaped strings.Builder
+	for _, c := range prefix {
+		if strings.ContainsRune(`*?[]\`, c) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+
+	return escaped.String()
+}
+
+func (s *Server) postPoliciesReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.ReloadPolicies(); err != nil {
+		slog.Error("Error reloading policies", "error", err)
+		writeError(w, http.StatusUnprocessableEntity, "Unable to reload the policies: "+err.Error())
+		return
+	}
+
+	audit(r, "policies_reloaded")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type logLevelBody struct {
+	Level string `json:"level"`
+}
+
+func (s *Server) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, &logLevelBody{Level: strings.ToLower(LogLevel().String())})
+}
+
+func (s *Server) putLogLevel(w http.ResponseWriter, r *http.Request) {
+	body := &logLevelBody{}
+	var level slog.Level
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil || level.UnmarshalText([]byte(body.Level)) != nil {
+		writeError(w, http.StatusBadRequest, "level must be one of debug, info, warn or error")
+		return
+	}
+
+	SetLogLevel(level)
+	audit(r, "log_level_changed", "level", level)
+	writeJSON(w, http.StatusOK, &logLevelBody{Level: strings.ToLower(level.String())})
+}
+
+// audit records the actions with audit=true, like the security events of
+// the auth package.
+func audit(r *http.Request, event string, args ...any) {
+	args = append([]any{
+		"audit", true,
+		"event", event,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"remoteAddr", r.RemoteAddr,
+	}, args...)
+
+	slog.Log(r.Context(), slog.LevelInfo, "Audit", args...)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	result, _ := json.Marshal(data)
+	w.Write(result)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, &models.ErrorResult{Errors: []string{message}})
+}
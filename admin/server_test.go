@@ -0,0 +1,123 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goapi-template/cache"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer() (*Server, *cache.MemoryCacher) {
+	cacher := cache.NewMemoryCacher(100, 0, time.Minute)
+
+	return &Server{
+		Token: "secret",
+		Ready: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) },
+		Config: func(w io.Writer) {
+			fmt.Fprintln(w, "DB_CONNECTION_STRING=********")
+		},
+		Cache:          cacher,
+		ReloadPolicies: func() error { return nil },
+		Build:          BuildInfo{Version: "1.2.3", Commit: "abc", GoVersion: "go1.23"},
+	}, cacher
+}
+
+func serveAdmin(server *Server, method string, url string, token string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	server.Handler().ServeHTTP(w, r)
+
+	return w
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	server, _ := newTestServer()
+
+	assert.Equal(t, http.StatusOK, serveAdmin(server, "GET", "/health/live", "", "").Code)
+	assert.Equal(t, http.StatusOK, serveAdmin(server, "GET", "/health/ready", "", "").Code)
+	assert.Equal(t, http.StatusOK, serveAdmin(server, "GET", "/metrics", "", "").Code)
+
+	for _, url := range []string{"/info", "/config", "/log-level", "/debug/pprof/"} {
+		assert.Equal(t, http.StatusUnauthorized, serveAdmin(server, "GET", url, "", "").Code, url)
+		assert.Equal(t, http.StatusUnauthorized, serveAdmin(server, "GET", url, "wrong", "").Code, url)
+		assert.Equal(t, http.StatusOK, serveAdmin(server, "GET", url, "secret", "").Code, url)
+	}
+}
+
+func TestAdminInfoAndConfig(t *testing.T) {
+	server, _ := newTestServer()
+
+	w := serveAdmin(server, "GET", "/info", "secret", "")
+	assert.JSONEq(t, `{"version":"1.2.3","commit":"abc","goVersion":"go1.23"}`, w.Body.String())
+
+	w = serveAdmin(server, "GET", "/config", "secret", "")
+	assert.Equal(t, "DB_CONNECTION_STRING=********\n", w.Body.String())
+}
+
+func TestAdminCacheFlush(t *testing.T) {
+	server, cacher := newTestServer()
+	ctx := context.Background()
+	cacher.SetString(ctx, "person:1", "1")
+	cacher.SetString(ctx, "person:2", "2")
+	cacher.SetString(ctx, "people", "all")
+	cacher.SetString(ctx, "a*b", "glob")
+
+	w := serveAdmin(server, "POST", "/cache/flush?key=people", "secret", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	value, _ := cacher.GetString(ctx, "people")
+	assert.Empty(t, value)
+
+	serveAdmin(server, "POST", "/cache/flush?prefix=person:", "secret", "")
+	value, _ = cacher.GetString(ctx, "person:1")
+	assert.Empty(t, value)
+
+	// prefixes are matched literally
+	serveAdmin(server, "POST", "/cache/flush?prefix=a*", "secret", "")
+	value, _ = cacher.GetString(ctx, "a*b")
+	assert.Empty(t, value)
+
+	assert.Equal(t, http.StatusBadRequest, serveAdmin(server, "POST", "/cache/flush", "secret", "").Code)
+
+	server.Cache = nil
+	assert.Equal(t, http.StatusNotFound, serveAdmin(server, "POST", "/cache/flush?key=people", "secret", "").Code)
+}
+
+func TestAdminPoliciesReload(t *testing.T) {
+	server, _ := newTestServer()
+
+	assert.Equal(t, http.StatusNoContent, serveAdmin(server, "POST", "/policies/reload", "secret", "").Code)
+
+	server.ReloadPolicies = func() error { return errors.New("rego_parse_error") }
+	w := serveAdmin(server, "POST", "/policies/reload", "secret", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.JSONEq(t, `{"errors":["Unable to reload the policies: rego_parse_error"]}`, w.Body.String())
+}
+
+func TestAdminLogLevel(t *testing.T) {
+	server, _ := newTestServer()
+	t.Cleanup(func() { SetLogLevel(slog.LevelInfo) })
+
+	w := serveAdmin(server, "PUT", "/log-level", "secret", `{"level":"debug"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, slog.LevelDebug, LogLevel())
+	assert.True(t, slog.Default().Enabled(context.Background(), slog.LevelDebug))
+
+	w = serveAdmin(server, "GET", "/log-level", "secret", "")
+	assert.JSONEq(t, `{"level":"debug"}`, w.Body.String())
+
+	w = serveAdmin(server, "PUT", "/log-level", "secret", `{"level":"verbose"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, slog.LevelDebug, LogLevel())
+}
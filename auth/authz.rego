@@ -10,6 +10,26 @@ allow if {
 	startswith(input.path, "/person")
 }
 
+# Services authenticated by their client certificate, see
+# AUTH_CLIENT_CERT_IDENTITY.
+allow if {
+	input.certificate.id != ""
+	startswith(input.path, "/person")
+}
+
+# Row filter for collection queries. data.person is unknown at evaluation
+# time so the residual conditions are translated into a SQL WHERE clause.
+filter if {
+	endswith(payload.email, "@gmail.com")
+	payload.verified
+	data.person.update_user == payload.email
+}
+
+filter if {
+	input.certificate.id != ""
+	data.person.update_user == input.certificate.email
+}
+
 payload := {"verified": verified, "email": payload.email} if {
 	[_, payload, _] := io.jwt.decode(input.token)
 	verified := true
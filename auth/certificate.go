@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/x509"
+	"goapi-template/config"
+	"net/http"
+)
+
+// certificateUser returns the user of the client certificate of r, verified
+// against the client CA during the handshake. Requests with a bearer token
+// are authenticated by their token instead.
+func certificateUser(r *http.Request, authConfig *config.AuthConfiguration) (*User, bool) {
+	if authConfig.ClientCertIdentity == "" || r.Header.Get("Authorization") != "" {
+		return nil, false
+	}
+
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+
+	certificate := r.TLS.VerifiedChains[0][0]
+
+	user := &User{
+		ID:          certificateIdentity(certificate, authConfig.ClientCertIdentity),
+		Name:        certificate.Subject.CommonName,
+		Claims:      map[string]string{"subject": certificate.Subject.String()},
+		Certificate: true,
+	}
+	if len(certificate.EmailAddresses) > 0 {
+		user.Email = certificate.EmailAddresses[0]
+	}
+
+	return user, user.ID != ""
+}
+
+func certificateIdentity(certificate *x509.Certificate, identity string) string {
+	if identity == "subject" {
+		return certificate.Subject.CommonName
+	}
+
+	switch {
+	case len(certificate.URIs) > 0:
+		return certificate.URIs[0].String()
+	case len(certificate.DNSNames) > 0:
+		return certificate.DNSNames[0]
+	case len(certificate.EmailAddresses) > 0:
+		return certificate.EmailAddresses[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"goapi-template/config"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withClientCertificate(r *http.Request, certificate *x509.Certificate) *http.Request {
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}}
+	return r
+}
+
+func TestCertificateUser(t *testing.T) {
+	serviceURI, _ := url.Parse("spiffe://example.com/orders")
+	certificate := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "orders", Organization: []string{"Example"}},
+		URIs:           []*url.URL{serviceURI},
+		DNSNames:       []string{"orders.example.com"},
+		EmailAddresses: []string{"orders@example.com"},
+	}
+	r := withClientCertificate(httptest.NewRequest("GET", "/person", nil), certificate)
+
+	user, ok := certificateUser(r, &config.AuthConfiguration{ClientCertIdentity: "san"})
+	assert.True(t, ok)
+	assert.Equal(t, "spiffe://example.com/orders", user.ID)
+	assert.Equal(t, "orders", user.Name)
+	assert.Equal(t, "orders@example.com", user.Email)
+	assert.Equal(t, "CN=orders,O=Example", user.Claims["subject"])
+	assert.True(t, user.Certificate)
+
+	user, ok = certificateUser(r, &config.AuthConfiguration{ClientCertIdentity: "subject"})
+	assert.True(t, ok)
+	assert.Equal(t, "orders", user.ID)
+
+	// disabled, or the request has a token
+	_, ok = certificateUser(r, &config.AuthConfiguration{})
+	assert.False(t, ok)
+
+	r.Header.Set("Authorization", "Bearer pass")
+	_, ok = certificateUser(r, &config.AuthConfiguration{ClientCertIdentity: "san"})
+	assert.False(t, ok)
+
+	// not verified
+	_, ok = certificateUser(httptest.NewRequest("GET", "/person", nil), &config.AuthConfiguration{ClientCertIdentity: "san"})
+	assert.False(t, ok)
+}
+
+func TestAuthMiddlewareCertificate(t *testing.T) {
+	authConfig = &config.AuthConfiguration{ClientCertIdentity: "subject"}
+	// certificates don't depend on the keys of the provider
+	unavailable.Store(true)
+	t.Cleanup(func() { unavailable.Store(false) })
+	t.Setenv("AUTH_REGO_PATH", "./authz.rego")
+	opaQuery = loadOpaQuery()
+
+	var user *User
+	handler := TokenAuthMiddleware(OpaMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = r.Context().Value(UserKey).(*User)
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	r := withClientCertificate(httptest.NewRequest("GET", "/person", nil), &x509.Certificate{Subject: pkix.Name{CommonName: "orders"}})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "orders", user.ID)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/person", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goapi-template/db"
+	"goapi-template/middlewares"
+	"goapi-template/models"
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+const FilterKey key = 2
+
+// filterUnknown is the document the policy uses to refer to the rows being
+// filtered, e.g. data.person.update_user == payload.email
+const filterUnknown = "data.person"
+
+var filterUnknownRef = ast.MustParseRef(filterUnknown)
+
+// filterColumns maps the fields a policy may reference to person columns.
+// Anything not listed here is rejected so policies cannot inject SQL.
+var filterColumns = map[string]string{
+	"id":          "id",
+	"name":        "name",
+	"email":       "email",
+	"created_at":  "created_at",
+	"updated_at":  "updated_at",
+	"update_user": "update_user",
+}
+
+var filterOperators = map[string]string{
+	"eq":    "=",
+	"equal": "=",
+	"neq":   "<>",
+	"lt":    "<",
+	"lte":   "<=",
+	"gt":    ">",
+	"gte":   ">=",
+}
+
+var filterOperatorsReversed = map[string]string{
+	"=":  "=",
+	"<>": "<>",
+	"<":  ">",
+	"<=": ">=",
+	">":  "<",
+	">=": "<=",
+}
+
+var opaFilterQuery *rego.PreparedPartialQuery
+
+// OpaFilterMiddleware partially evaluates data.authz.filter for the request
+// and stores the resulting row filter in the context under FilterKey so
+// collection handlers can push authorization down to the database.
+func OpaFilterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		returnResult := "filtered"
+
+		defer func() {
+			elapsed := time.Since(start)
+			slog.Debug("OPA filter middleware",
+				"timeElapsed", elapsed,
+				"result", returnResult,
+				"traceId", r.Context().Value(middlewares.ContextKey("traceId")))
+		}()
+
+		input := policyInput(r)
+
+		filter, err := evalFilter(r.Context(), input)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			result, _ := json.Marshal(&models.ErrorResult{Errors: []string{"Unable to evaluate authorization filter"}})
+			w.Write(result)
+			returnResult = err.Error()
+			return
+		}
+
+		newReq := r.WithContext(context.WithValue(r.Context(), FilterKey, filter))
+
+		next.ServeHTTP(w, newReq)
+	})
+}
+
+func evalFilter(ctx context.Context, input map[string]interface{}) (db.Filter, error) {
+	_, filterQuery := policies()
+
+	res, err := filterQuery.Partial(ctx, rego.EvalInput(input))
+	if err != nil {
+		return db.DenyAllFilter, err
+	}
+
+	if len(res.Support) > 0 {
+		return db.DenyAllFilter, fmt.Errorf("filter policy produced support modules which cannot be translated to SQL")
+	}
+
+	return compileFilter(res.Queries)
+}
+
+// compileFilter translates OPA residual queries into a SQL WHERE fragment.
+// Queries are OR'ed together while the expressions of each query are AND'ed.
+// No queries means the policy can never be satisfied and an empty query means
+// it is always satisfied.
+func compileFilter(queries []ast.Body) (db.Filter, error) {
+	if len(queries) == 0 {
+		return db.DenyAllFilter, nil
+	}
+
+	args := []any{}
+	disjunction := make([]string, 0, len(queries))
+
+	for _, query := range queries {
+		if len(query) == 0 {
+			return db.AllowAllFilter, nil
+		}
+
+		conjunction := make([]string, 0, len(query))
+		for _, expr := range query {
+			clause, err := compileExpr(expr, &args)
+			if err != nil {
+				return db.DenyAllFilter, err
+			}
+			conjunction = append(conjunction, clause)
+		}
+
+		disjunction = append(disjunction, "("+strings.Join(conjunction, " AND ")+")")
+	}
+
+	return db.Filter{Where: strings.Join(disjunction, " OR "), Args: args}, nil
+}
+
+func compileExpr(expr *ast.Expr, args *[]any) (string, error) {
+	if !expr.IsCall() || len(expr.Operands()) != 2 {
+		return "", fmt.Errorf("unsupported filter expression: %v", expr)
+	}
+
+	operator, ok := filterOperators[expr.Operator().String()]
+	if !ok {
+		return "", fmt.Errorf("unsupported filter operator: %v", expr.Operator())
+	}
+
+	field, value := expr.Operand(0), expr.Operand(1)
+	if !isFilterUnknown(field) {
+		// the unknown may be on either side, e.g. 3 < data.person.id
+		field, value = value, field
+		operator = filterOperatorsReversed[operator]
+	}
+
+	column, err := filterColumn(field)
+	if err != nil {
+		return "", err
+	}
+
+	arg, err := filterValue(value)
+	if err != nil {
+		return "", err
+	}
+
+	*args = append(*args, arg)
+	clause := fmt.Sprintf("%s %s $%d", column, operator, len(*args))
+
+	if expr.Negated {
+		clause = "NOT (" + clause + ")"
+	}
+
+	return clause, nil
+}
+
+// filterValue only accepts scalars so they can be bound as query parameters.
+func filterValue(term *ast.Term) (any, error) {
+	switch value := term.Value.(type) {
+	case ast.String:
+		return string(value), nil
+	case ast.Boolean:
+		return bool(value), nil
+	case ast.Number:
+		if i, ok := value.Int64(); ok {
+			return i, nil
+		}
+		if f, ok := value.Float64(); ok {
+			return f, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported filter value: %v", term)
+}
+
+func isFilterUnknown(term *ast.Term) bool {
+	ref, ok := term.Value.(ast.Ref)
+	return ok && ref.HasPrefix(filterUnknownRef)
+}
+
+func filterColumn(term *ast.Term) (string, error) {
+	ref, ok := term.Value.(ast.Ref)
+	if !ok || len(ref) != 3 || !ref.HasPrefix(filterUnknownRef) {
+		return "", fmt.Errorf("filter expression must reference a %s field", filterUnknown)
+	}
+
+	field, ok := ref[2].Value.(ast.String)
+	if !ok {
+		return "", fmt.Errorf("filter expression must reference a %s field", filterUnknown)
+	}
+
+	column, ok := filterColumns[string(field)]
+	if !ok {
+		return "", fmt.Errorf("filter field %s is not allowed", field)
+	}
+
+	return column, nil
+}
+
+func loadOpaFilterQuery() *rego.PreparedPartialQuery {
+	query, err := prepareOpaFilterQuery()
+	if err != nil {
+		log.Fatalf("failed to create rego filter query. Error: %v", err)
+	}
+
+	return query
+}
+
+func prepareOpaFilterQuery() (*rego.PreparedPartialQuery, error) {
+	query, err := rego.New(
+		rego.Query("data.authz.filter == true"),
+		rego.Load([]string{regoPath()}, nil),
+		rego.Unknowns([]string{filterUnknown}),
+	).PrepareForPartial(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	return &query, nil
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"goapi-template/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadOPAFilterQuery(t *testing.T) {
+	t.Setenv("AUTH_REGO_PATH", "./test.rego")
+	query := loadOpaFilterQuery()
+
+	assert.NotNil(t, query)
+}
+
+func TestEvalFilterResidual(t *testing.T) {
+	t.Setenv("AUTH_REGO_PATH", "./test.rego")
+	opaFilterQuery = loadOpaFilterQuery()
+
+	filter, err := evalFilter(context.Background(), map[string]interface{}{"token": "pass"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "(update_user = $1)", filter.Where)
+	assert.Equal(t, []any{"pass@test.com"}, filter.Args)
+}
+
+func TestEvalFilterAllowAll(t *testing.T) {
+	t.Setenv("AUTH_REGO_PATH", "./test.rego")
+	opaFilterQuery = loadOpaFilterQuery()
+
+	filter, err := evalFilter(context.Background(), map[string]interface{}{"token": "admin"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, db.AllowAllFilter, filter)
+}
+
+func TestEvalFilterDenyAll(t *testing.T) {
+	t.Setenv("AUTH_REGO_PATH", "./test.rego")
+	opaFilterQuery = loadOpaFilterQuery()
+
+	filter, err := evalFilter(context.Background(), map[string]interface{}{"token": "deny"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, db.DenyAllFilter, filter)
+}
+
+func TestCompileFilterMultipleQueries(t *testing.T) {
+	queries := []ast.Body{
+		ast.MustParseBody(`data.person.id > 3; not data.person.name = "x"`),
+		ast.MustParseBody(`"a@b.com" = data.person.update_user`),
+		ast.MustParseBody(`10 >= data.person.id`),
+	}
+
+	filter, err := compileFilter(queries)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "(id > $1 AND NOT (name = $2)) OR (update_user = $3) OR (id <= $4)", filter.Where)
+	assert.Equal(t, []any{int64(3), "x", "a@b.com", int64(10)}, filter.Args)
+}
+
+func TestCompileFilterUnknownColumn(t *testing.T) {
+	queries := []ast.Body{
+		ast.MustParseBody(`data.person["id; DROP TABLE person"] = 1`),
+	}
+
+	_, err := compileFilter(queries)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "filter field \"id; DROP TABLE person\" is not allowed", err.Error())
+}
+
+func TestCompileFilterUnsupportedValue(t *testing.T) {
+	queries := []ast.Body{
+		ast.MustParseBody(`data.person.id = data.person.name`),
+	}
+
+	_, err := compileFilter(queries)
+
+	assert.NotNil(t, err)
+}
+
+func TestCompileFilterUnsupportedOperator(t *testing.T) {
+	queries := []ast.Body{
+		ast.MustParseBody(`startswith(data.person.name, "a")`),
+	}
+
+	_, err := compileFilter(queries)
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "unsupported filter operator: startswith", err.Error())
+}
+
+func TestOPAFilterMiddleware(t *testing.T) {
+	t.Setenv("AUTH_REGO_PATH", "./test.rego")
+	opaFilterQuery = loadOpaFilterQuery()
+
+	router := http.NewServeMux()
+
+	var filter db.Filter
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		filter = r.Context().Value(FilterKey).(db.Filter)
+		w.WriteHeader(200)
+	}
+
+	router.Handle("GET /test", OpaFilterMiddleware(http.HandlerFunc(handler)))
+
+	reqFound, _ := http.NewRequest("GET", "/test", nil)
+	reqFound.Header.Add("Authorization", fmt.Sprintf("Bearer %v", "pass"))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, reqFound)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "(update_user = $1)", filter.Where)
+}
@@ -4,12 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"goapi-template/config"
+	"goapi-template/db"
 	"goapi-template/middlewares"
 	"goapi-template/models"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	keyfunc "github.com/MicahParks/keyfunc/v2"
@@ -18,12 +21,61 @@ import (
 
 var authConfig *config.AuthConfiguration
 var opaQuery *rego.PreparedEvalQuery
+
+// policiesMu guards opaQuery and opaFilterQuery, which ReloadPolicies
+// replaces
+var policiesMu sync.RWMutex
 var cachedSet JKWS
 
+// unavailable is set while the keys of the provider can't be loaded, the
+// requests to authenticate are answered 503 meanwhile. authConfig and
+// cachedSet are only written before it is cleared.
+var unavailable atomic.Bool
+
+// maxRecoveryDelay caps the delay between attempts to load the keys while
+// auth is unavailable.
+var maxRecoveryDelay = time.Minute
+
 func Init(configValues *config.AuthConfiguration) {
 	authConfig = configValues
 	opaQuery = loadOpaQuery()
-	cachedSet = loadJWKSCache()
+	opaFilterQuery = loadOpaFilterQuery()
+
+	jwks, err := loadJWKS(configValues)
+	if err == nil {
+		cachedSet = jwks
+		return
+	}
+
+	if !configValues.AllowDegraded {
+		log.Fatalf("Failed to load the JWKS of the OpenID provider.\nError: %s", err.Error())
+	}
+
+	slog.Error("Auth is unavailable until the OpenID provider can be reached", "error", err)
+	unavailable.Store(true)
+	go recoverJWKS(configValues)
+}
+
+// recoverJWKS keeps trying to load the keys, doubling the delay between
+// attempts up to maxRecoveryDelay, and makes auth available once it can.
+func recoverJWKS(configValues *config.AuthConfiguration) {
+	delay := configValues.DiscoveryBackoff
+
+	for {
+		time.Sleep(delay)
+		delay = min(delay*2, maxRecoveryDelay)
+
+		jwks, err := loadJWKS(configValues)
+		if err != nil {
+			slog.Error("Auth is still unavailable", "error", err, "nextAttempt", delay)
+			continue
+		}
+
+		cachedSet = jwks
+		unavailable.Store(false)
+		slog.Info("Auth is available")
+		return
+	}
 }
 
 type key int
@@ -43,31 +95,63 @@ func TokenAuthMiddleware(next http.Handler) http.Handler {
 				"traceId", r.Context().Value(middlewares.ContextKey("traceId")))
 		}()
 
-		token, err := extractToken(r)
+		// service to service calls may authenticate with a client
+		// certificate, which doesn't need the keys of the provider
+		user, fromCertificate := certificateUser(r, authConfig)
+		if fromCertificate {
+			returnResult = "certificate"
+		} else {
+			if unavailable.Load() {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				data := &models.ErrorResult{Errors: []string{"Authentication is temporarily unavailable"}}
+				result, _ := json.Marshal(data)
+				w.Write(result)
+				returnResult = "unavailable"
+				return
+			}
 
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Header().Set("Content-Type", "application/json")
-			data := &models.ErrorResult{Errors: []string{"Auth token was not provided or is invalid"}}
-			result, _ := json.Marshal(data)
-			w.Write(result)
-			returnResult = err.Error()
-			return
+			token, err := extractToken(r)
+
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Header().Set("Content-Type", "application/json")
+				data := &models.ErrorResult{Errors: []string{"Auth token was not provided or is invalid"}}
+				result, _ := json.Marshal(data)
+				w.Write(result)
+				returnResult = err.Error()
+				return
+			}
+
+			user, err = validateUserToken(token, authConfig, cachedSet)
+
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Header().Set("Content-Type", "application/json")
+				data := &models.ErrorResult{Errors: []string{"Auth token is invalid"}}
+				result, _ := json.Marshal(data)
+				w.Write(result)
+				returnResult = err.Error()
+				return
+			}
 		}
 
-		user, err := validateUserToken(token, authConfig, cachedSet)
+		tenant, err := resolveTenant(r, user, authConfig)
 
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			w.WriteHeader(http.StatusForbidden)
 			w.Header().Set("Content-Type", "application/json")
-			data := &models.ErrorResult{Errors: []string{"Auth token is invalid"}}
+			data := &models.ErrorResult{Errors: []string{err.Error()}}
 			result, _ := json.Marshal(data)
 			w.Write(result)
 			returnResult = err.Error()
 			return
 		}
 
-		newReq := r.WithContext(context.WithValue(r.Context(), UserKey, user))
+		user.TenantID = tenant
+		ctx := db.WithTenant(context.WithValue(r.Context(), UserKey, user), tenant)
+		newReq := r.WithContext(ctx)
 
 		next.ServeHTTP(w, newReq)
 	})
@@ -86,14 +170,10 @@ func OpaMiddleware(next http.Handler) http.Handler {
 				"traceId", r.Context().Value(middlewares.ContextKey("traceId")))
 		}()
 
-		token, _ := extractToken(r)
+		input := policyInput(r)
+		query, _ := policies()
 
-		input := map[string]interface{}{
-			"method": r.Method,
-			"path":   r.RequestURI,
-			"token":  token,
-		}
-		res, err := opaQuery.Eval(r.Context(), rego.EvalInput(input))
+		res, err := query.Eval(r.Context(), rego.EvalInput(input))
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Header().Set("Content-Type", "application/json")
@@ -116,22 +196,95 @@ func OpaMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func loadOpaQuery() *rego.PreparedEvalQuery {
-	regoPath, ok := os.LookupEnv("AUTH_REGO_PATH")
+// policyInput is the input of the policies: the request and its token, or
+// the identity of its client certificate.
+func policyInput(r *http.Request) map[string]interface{} {
+	token, _ := extractToken(r)
 
-	if !ok {
-		regoPath = "./auth/authz.rego"
+	input := map[string]interface{}{
+		"method": r.Method,
+		"path":   r.RequestURI,
+		"token":  token,
 	}
 
-	query, err := rego.New(rego.Query("data.authz.allow"), rego.Load([]string{regoPath}, nil)).PrepareForEval(context.TODO())
+	if user, ok := r.Context().Value(UserKey).(*User); ok && user.Certificate {
+		input["certificate"] = map[string]interface{}{
+			"id":      user.ID,
+			"email":   user.Email,
+			"subject": user.Claims["subject"],
+		}
+	}
+
+	return input
+}
+
+func loadOpaQuery() *rego.PreparedEvalQuery {
+	query, err := prepareOpaQuery()
 	if err != nil {
 		log.Fatalf("failed to create rego query. Error: %v", err)
 	}
 
-	return &query
+	return query
+}
+
+func prepareOpaQuery() (*rego.PreparedEvalQuery, error) {
+	query, err := rego.New(rego.Query("data.authz.allow"), rego.Load([]string{regoPath()}, nil)).PrepareForEval(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	return &query, nil
+}
+
+func regoPath() string {
+	if regoPath, ok := os.LookupEnv("AUTH_REGO_PATH"); ok {
+		return regoPath
+	}
+
+	return "./auth/authz.rego"
+}
+
+// policies returns the queries of the current policies.
+func policies() (*rego.PreparedEvalQuery, *rego.PreparedPartialQuery) {
+	policiesMu.RLock()
+	defer policiesMu.RUnlock()
+
+	return opaQuery, opaFilterQuery
 }
 
-func loadJWKSCache() *keyfunc.JWKS {
+// ReloadPolicies reads the policies again, the current ones are kept when
+// the new ones can't be compiled.
+func ReloadPolicies() error {
+	query, err := prepareOpaQuery()
+	if err != nil {
+		return err
+	}
+
+	filterQuery, err := prepareOpaFilterQuery()
+	if err != nil {
+		return err
+	}
+
+	policiesMu.Lock()
+	opaQuery, opaFilterQuery = query, filterQuery
+	policiesMu.Unlock()
+
+	slog.Info("Policies reloaded", "path", regoPath())
+
+	return nil
+}
+
+// loadJWKS reads the OpenID configuration first if needed. A static JWKS is
+// used as is, the keys fetched from JWKSUri are refreshed every hour.
+func loadJWKS(configValues *config.AuthConfiguration) (*keyfunc.JWKS, error) {
+	if err := configValues.Discover(); err != nil {
+		return nil, err
+	}
+
+	if configValues.JWKS != "" {
+		return keyfunc.NewJSON(json.RawMessage(configValues.JWKS))
+	}
+
 	options := keyfunc.Options{
 		RefreshInterval: time.Hour,
 		RefreshTimeout:  time.Second * 10,
@@ -140,10 +293,11 @@ func loadJWKSCache() *keyfunc.JWKS {
 		},
 	}
 
-	jwks, err := keyfunc.Get(authConfig.JWKSUri, options)
-	if err != nil {
-		log.Fatalf("Failed to create JWKS from resource at the given URL.\nError: %s", err.Error())
-	}
+	var jwks *keyfunc.JWKS
+	err := configValues.WithRetries(func() (err error) {
+		jwks, err = keyfunc.Get(configValues.JWKSUri, options)
+		return err
+	})
 
-	return jwks
+	return jwks, err
 }
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"errors"
+	"goapi-template/config"
+	"goapi-template/db"
+	"goapi-template/middlewares"
+	"log/slog"
+	"net/http"
+	"regexp"
+)
+
+var (
+	errNoTenant      = errors.New("token doesn't have a tenant")
+	errInvalidTenant = errors.New("tenant is invalid")
+	errCrossTenant   = errors.New("access to another tenant is forbidden")
+)
+
+// tenants end up in cache keys and Postgres settings
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// resolveTenant returns the tenant user acts on: the tenant claim of its
+// token, or the default tenant when no claim is configured. Service
+// principals may act on behalf of any tenant by setting the tenant header,
+// other users setting it to another tenant are rejected.
+func resolveTenant(r *http.Request, user *User, authConfig *config.AuthConfiguration) (string, error) {
+	tenant := user.TenantID
+	if authConfig.TenantClaim == "" {
+		tenant = db.DefaultTenant
+	}
+
+	if authConfig.TenantHeader != "" {
+		if requested := r.Header.Get(authConfig.TenantHeader); requested != "" && requested != tenant {
+			if !sliceContains(authConfig.ServicePrincipals, user.ID) {
+				audit(r, slog.LevelWarn, "cross_tenant_access_denied", "user", user.ID, "tenant", tenant, "requestedTenant", requested)
+				return "", errCrossTenant
+			}
+
+			audit(r, slog.LevelInfo, "tenant_impersonated", "user", user.ID, "requestedTenant", requested)
+			tenant = requested
+		}
+	}
+
+	if tenant == "" {
+		return "", errNoTenant
+	}
+
+	if !tenantPattern.MatchString(tenant) {
+		return "", errInvalidTenant
+	}
+
+	return tenant, nil
+}
+
+// audit records security relevant events, they are logged with audit=true so
+// they can be routed apart from the other logs.
+func audit(r *http.Request, level slog.Level, event string, args ...any) {
+	args = append([]any{
+		"audit", true,
+		"event", event,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"remoteAddr", r.RemoteAddr,
+		"traceId", r.Context().Value(middlewares.ContextKey("traceId")),
+	}, args...)
+
+	slog.Log(r.Context(), level, "Audit", args...)
+}
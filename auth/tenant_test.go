@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"goapi-template/config"
+	"goapi-template/db"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+var tenantConfig = &config.AuthConfiguration{
+	TenantClaim:       "tid",
+	TenantHeader:      "X-Tenant-ID",
+	ServicePrincipals: []string{"service"},
+}
+
+func tenantRequest(tenantHeader string) *http.Request {
+	r := httptest.NewRequest("GET", "/person", nil)
+	if tenantHeader != "" {
+		r.Header.Set("X-Tenant-ID", tenantHeader)
+	}
+	return r
+}
+
+func TestResolveTenantDefault(t *testing.T) {
+	tenant, err := resolveTenant(tenantRequest(""), &User{ID: "user"}, &config.AuthConfiguration{TenantHeader: "X-Tenant-ID"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, db.DefaultTenant, tenant)
+}
+
+func TestResolveTenantFromClaim(t *testing.T) {
+	tenant, err := resolveTenant(tenantRequest("acme"), &User{ID: "user", TenantID: "acme"}, tenantConfig)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", tenant)
+}
+
+func TestResolveTenantMissingClaim(t *testing.T) {
+	_, err := resolveTenant(tenantRequest(""), &User{ID: "user"}, tenantConfig)
+
+	assert.Equal(t, errNoTenant, err)
+}
+
+func TestResolveTenantInvalid(t *testing.T) {
+	_, err := resolveTenant(tenantRequest(""), &User{ID: "user", TenantID: "acme:*"}, tenantConfig)
+
+	assert.Equal(t, errInvalidTenant, err)
+}
+
+func TestResolveTenantCrossTenantDenied(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	_, err := resolveTenant(tenantRequest("other"), &User{ID: "user", TenantID: "acme"}, tenantConfig)
+
+	assert.Equal(t, errCrossTenant, err)
+	assert.Contains(t, buffer.String(), "level=WARN")
+	assert.Contains(t, buffer.String(), "audit=true")
+	assert.Contains(t, buffer.String(), "event=cross_tenant_access_denied")
+	assert.Contains(t, buffer.String(), "requestedTenant=other")
+}
+
+func TestResolveTenantServicePrincipal(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	slog.SetDefault(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	tenant, err := resolveTenant(tenantRequest("other"), &User{ID: "service"}, tenantConfig)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "other", tenant)
+	assert.Contains(t, buffer.String(), "event=tenant_impersonated")
+}
+
+func TestAuthMiddlewareTenant(t *testing.T) {
+	authConfig = &config.AuthConfiguration{
+		Issuer:          "issuer",
+		TokenSigningAlg: []string{"RS256"},
+		Audience:        "audience",
+		ScopeClaim:      "scp",
+		Scopes:          []string{"api"},
+		TenantClaim:     "tid",
+		TenantHeader:    "X-Tenant-ID",
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"aud":   "audience",
+		"iss":   "issuer",
+		"scp":   "api",
+		"sub":   "sub",
+		"name":  "name",
+		"email": "test@email.com",
+		"tid":   "acme",
+		"nbf":   time.Now().Add(1).Unix(),
+	})
+
+	priv, pub := generateRsaKeyPair()
+	cachedSet = &TestJWKS{PublicKey: pub}
+	tokenString, _ := token.SignedString(priv)
+
+	handler := TokenAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(db.TenantFrom(r.Context()) + "|" + r.Context().Value(UserKey).(*User).TenantID))
+	}))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.Header.Add("Authorization", fmt.Sprintf("Bearer %v", tokenString))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "acme|acme", w.Body.String())
+
+	req.Header.Set("X-Tenant-ID", "other")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, 403, w.Code)
+	assert.Contains(t, w.Body.String(), errCrossTenant.Error())
+}
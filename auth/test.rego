@@ -6,4 +6,13 @@ default allow = false
 
 allow if {
 	input.token == "pass"
-}
\ No newline at end of file
+}
+
+filter if {
+	input.token == "pass"
+	data.person.update_user == "pass@test.com"
+}
+
+filter if {
+	input.token == "admin"
+}
@@ -55,6 +55,10 @@ func validateUserToken(tokenString string, authConfig *config.AuthConfiguration,
 		user.Claims[v] = claims[v].(string)
 	}
 
+	if authConfig.TenantClaim != "" {
+		user.TenantID, _ = claims[authConfig.TenantClaim].(string)
+	}
+
 	return user, nil
 }
 
@@ -70,7 +74,12 @@ func extractToken(r *http.Request) (string, error) {
 }
 
 func verifyToken(tokenString string, config *config.AuthConfiguration, jwks JKWS) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, jwks.Keyfunc)
+	var options []jwt.ParserOption
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+
+	token, err := jwt.Parse(tokenString, jwks.Keyfunc, options...)
 
 	if err != nil {
 		return nil, err
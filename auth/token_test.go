@@ -7,6 +7,9 @@ import (
 	"goapi-template/config"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -39,6 +42,7 @@ func TestValidToken(t *testing.T) {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
 		"aud":   "audience",
+		"iss":   "issuer",
 		"scp":   "api",
 		"sub":   "sub",
 		"name":  "name",
@@ -72,6 +76,7 @@ func TestTokenExpired(t *testing.T) {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
 		"aud":   "audience",
+		"iss":   "issuer",
 		"scp":   "api",
 		"sub":   "sub",
 		"name":  "name",
@@ -105,6 +110,7 @@ func TestBadTokenAudience(t *testing.T) {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
 		"aud":   "nope",
+		"iss":   "issuer",
 		"scp":   "api",
 		"sub":   "sub",
 		"name":  "name",
@@ -127,6 +133,42 @@ func TestBadTokenAudience(t *testing.T) {
 	assert.Equal(t, "token not issue to correct audience", err.Error())
 }
 
+func TestBadTokenIssuer(t *testing.T) {
+	authConfig := &config.AuthConfiguration{
+		Issuer:          "issuer",
+		TokenSigningAlg: []string{"RS256"},
+		Audience:        "audience",
+		ScopeClaim:      "scp",
+		Scopes:          []string{"api"},
+	}
+
+	priv, pub := generateRsaKeyPair()
+
+	jwks := &TestJWKS{PublicKey: pub}
+
+	for issuer, expected := range map[string]error{"other": jwt.ErrTokenInvalidIssuer, "": jwt.ErrTokenRequiredClaimMissing} {
+		claims := jwt.MapClaims{
+			"aud":   "audience",
+			"scp":   "api",
+			"sub":   "sub",
+			"name":  "name",
+			"email": "test@email.com",
+			"nbf":   time.Now().Add(time.Hour * -1).Unix(),
+			"exp":   time.Now().Add(time.Hour * 1).Unix(),
+		}
+		if issuer != "" {
+			claims["iss"] = issuer
+		}
+
+		tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(priv)
+
+		user, err := validateUserToken(tokenString, authConfig, jwks)
+
+		assert.ErrorIs(t, err, expected)
+		assert.Nil(t, user)
+	}
+}
+
 func TestBadTokenScope(t *testing.T) {
 	authConfig := &config.AuthConfiguration{
 		Issuer:          "issuer",
@@ -138,6 +180,7 @@ func TestBadTokenScope(t *testing.T) {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
 		"aud":   "audience",
+		"iss":   "issuer",
 		"scp":   "nope",
 		"sub":   "sub",
 		"name":  "name",
@@ -171,6 +214,7 @@ func TestBadTokenSignature(t *testing.T) {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
 		"aud":   "audience",
+		"iss":   "issuer",
 		"scp":   "api",
 		"sub":   "sub",
 		"name":  "name",
@@ -205,6 +249,7 @@ func TestBadTokenSignatureAlg(t *testing.T) {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodRS512, jwt.MapClaims{
 		"aud":   "audience",
+		"iss":   "issuer",
 		"scp":   "api",
 		"sub":   "sub",
 		"name":  "name",
@@ -227,10 +272,7 @@ func TestBadTokenSignatureAlg(t *testing.T) {
 	assert.Equal(t, "token signature alg and issuer alg do not match", err.Error())
 }
 
-func TestLoadJWKSCache(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{
+const testJWKS = `{
 			"keys": [
 				{
 					"kty": "RSA",
@@ -245,22 +287,92 @@ func TestLoadJWKSCache(t *testing.T) {
 					"issuer": "https://localhost/"
 				}
 			]
-		}`))
+		}`
+
+func TestLoadJWKS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(testJWKS))
 	}))
 	defer server.Close()
 
-	t.Setenv("AUTH_CONFIG_URL", server.URL)
-	t.Setenv("AUTH_AUDIENCE", "aud")
-
-	authConfig = &config.AuthConfiguration{
+	cache, err := loadJWKS(&config.AuthConfiguration{
 		JWKSUri: server.URL,
-	}
-	cache := loadJWKSCache()
+	})
+
+	assert.Nil(t, err)
+	assert.Contains(t, cache.KIDs(), "nOo3ZDrODXEK1jKWhXslHR_KXEg")
+}
+
+func TestLoadJWKSStatic(t *testing.T) {
+	cache, err := loadJWKS(&config.AuthConfiguration{
+		JWKS:            testJWKS,
+		TokenSigningAlg: []string{"RS256"},
+	})
 
-	assert.NotNil(t, cache)
+	assert.Nil(t, err)
 	assert.Contains(t, cache.KIDs(), "nOo3ZDrODXEK1jKWhXslHR_KXEg")
 }
 
+func TestLoadJWKSRetries(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(testJWKS))
+	}))
+	defer server.Close()
+
+	_, err := loadJWKS(&config.AuthConfiguration{JWKSUri: server.URL, DiscoveryBackoff: time.Millisecond})
+	assert.Error(t, err)
+
+	_, err = loadJWKS(&config.AuthConfiguration{JWKSUri: server.URL, DiscoveryRetries: 1, DiscoveryBackoff: time.Millisecond})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestAuthMiddlewareRecoversFromUnavailable(t *testing.T) {
+	var available atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(testJWKS))
+	}))
+	defer server.Close()
+
+	authConfig = &config.AuthConfiguration{JWKSUri: server.URL, DiscoveryBackoff: time.Millisecond}
+	unavailable.Store(true)
+	t.Cleanup(func() { unavailable.Store(false) })
+
+	router := http.NewServeMux()
+	router.Handle("GET /test", TokenAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	request, _ := http.NewRequest("GET", "/test", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, 503, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "Authentication is temporarily unavailable")
+
+	maxRecoveryDelay = 5 * time.Millisecond
+	go recoverJWKS(authConfig)
+	time.Sleep(20 * time.Millisecond)
+	available.Store(true)
+
+	assert.Eventually(t, func() bool { return !unavailable.Load() }, time.Second, 5*time.Millisecond)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+
+	assert.Equal(t, 401, w.Code)
+}
+
 func TestLoadOPAQuery(t *testing.T) {
 	t.Setenv("AUTH_REGO_PATH", "./test.rego")
 	query := loadOpaQuery()
@@ -268,6 +380,34 @@ func TestLoadOPAQuery(t *testing.T) {
 	assert.NotNil(t, query)
 }
 
+func TestReloadPolicies(t *testing.T) {
+	policy := filepath.Join(t.TempDir(), "authz.rego")
+	os.WriteFile(policy, []byte("package authz\n\ndefault allow = false\n"), 0600)
+	t.Setenv("AUTH_REGO_PATH", policy)
+	opaQuery = loadOpaQuery()
+	opaFilterQuery = loadOpaFilterQuery()
+
+	handler := OpaMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	serve := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/test", nil))
+		return w.Code
+	}
+
+	assert.Equal(t, 403, serve())
+
+	os.WriteFile(policy, []byte("package authz\n\ndefault allow = true\n"), 0600)
+	assert.Nil(t, ReloadPolicies())
+	assert.Equal(t, 200, serve())
+
+	// the current policies are kept when the new ones don't compile
+	os.WriteFile(policy, []byte("package authz\n\nallow if {"), 0600)
+	assert.NotNil(t, ReloadPolicies())
+	assert.Equal(t, 200, serve())
+}
+
 func TestAuthTokenMiddlewareWithoutToken(t *testing.T) {
 	router := http.NewServeMux()
 
@@ -324,6 +464,7 @@ func TestAuthMiddlewareValid(t *testing.T) {
 
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
 		"aud":   "audience",
+		"iss":   "issuer",
 		"scp":   "api",
 		"sub":   "sub",
 		"name":  "name",
@@ -5,4 +5,9 @@ type User struct {
 	Name   string
 	Email  string
 	Claims map[string]string
+	// TenantID is the tenant the user acts on, see resolveTenant
+	TenantID string
+	// Certificate is set for the users authenticated by their client
+	// certificate rather than a token
+	Certificate bool
 }
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ErrCircuitOpen is returned instead of calling the cache while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("cache circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+var (
+	breakerStateGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_circuit_breaker_state",
+		Help: "State of the cache circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+	breakerCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_circuit_breaker_calls_total",
+		Help: "Cache calls by outcome: success, failure or rejected while the breaker was open.",
+	}, []string{"outcome"})
+)
+
+// BreakerCacher is a circuit breaker around a Cacher. After FailureThreshold
+// consecutive failed or slow calls it opens and rejects every call with
+// ErrCircuitOpen, so callers go straight to the database instead of waiting
+// on a failing cache. After OpenTimeout a single probe call is let through
+// (half-open), closing the breaker if it succeeds and opening it again if it
+// doesn't.
+type BreakerCacher struct {
+	cacher Cacher
+	// FailureThreshold is how many consecutive failures open the breaker
+	FailureThreshold int
+	// SlowThreshold counts calls slower than it as failures, zero disables it
+	SlowThreshold time.Duration
+	// OpenTimeout is how long the breaker stays open before probing
+	OpenTimeout time.Duration
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	now      func() time.Time
+}
+
+// State returns the current state, an open breaker whose timeout elapsed is
+// reported half-open.
+func (b *BreakerCacher) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.OpenTimeout {
+		return BreakerHalfOpen
+	}
+
+	return b.state
+}
+
+// Healthy returns ErrCircuitOpen while the breaker is open.
+func (b *BreakerCacher) Healthy() error {
+	if b.State() == BreakerOpen {
+		return ErrCircuitOpen
+	}
+
+	return nil
+}
+
+// allow reports whether a call may go through, and whether it is the probe of
+// a half-open breaker.
+func (b *BreakerCacher) allow() (bool, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		return true, false
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.OpenTimeout {
+			return false, false
+		}
+		b.setState(BreakerHalfOpen)
+	}
+
+	// half-open, only one probe at a time
+	if b.probing {
+		return false, false
+	}
+
+	b.probing = true
+	return true, true
+}
+
+func (b *BreakerCacher) done(probe bool, err error, elapsed time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	failed := err != nil || (b.SlowThreshold > 0 && elapsed > b.SlowThreshold)
+	if failed {
+		breakerCalls.WithLabelValues("failure").Inc()
+	} else {
+		breakerCalls.WithLabelValues("success").Inc()
+	}
+
+	// calls started before the breaker opened don't get a say, only the probe
+	// decides whether it closes again
+	if b.state != BreakerClosed && !probe {
+		return
+	}
+
+	if !failed {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+
+	if probe || b.failures >= b.FailureThreshold {
+		slog.Warn("Cache circuit breaker opened, bypassing the cache", "error", err, "elapsed", elapsed)
+		b.openedAt = b.now()
+		b.setState(BreakerOpen)
+	}
+}
+
+func (b *BreakerCacher) setState(state BreakerState) {
+	if state == BreakerClosed {
+		slog.Info("Cache circuit breaker closed")
+	}
+
+	b.state = state
+	breakerStateGauge.Set(float64(state))
+}
+
+// call runs fn through the breaker.
+func (b *BreakerCacher) call(fn func() error) error {
+	allowed, probe := b.allow()
+	if !allowed {
+		breakerCalls.WithLabelValues("rejected").Inc()
+		return ErrCircuitOpen
+	}
+
+	start := b.now()
+	err := fn()
+	b.done(probe, err, b.now().Sub(start))
+
+	return err
+}
+
+func (b *BreakerCacher) GetString(ctx context.Context, key string) (string, error) {
+	var value string
+	err := b.call(func() (err error) {
+		value, err = b.cacher.GetString(ctx, key)
+		return err
+	})
+
+	return value, err
+}
+
+func (b *BreakerCacher) SetString(ctx context.Context, key string, value string, opts ...SetOption) error {
+	return b.call(func() error {
+		return b.cacher.SetString(ctx, key, value, opts...)
+	})
+}
+
+func (b *BreakerCacher) DeleteKey(ctx context.Context, key string) error {
+	return b.call(func() error {
+		return b.cacher.DeleteKey(ctx, key)
+	})
+}
+
+func (b *BreakerCacher) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	var values []string
+	err := b.call(func() (err error) {
+		values, err = b.cacher.MGet(ctx, keys...)
+		return err
+	})
+
+	return values, err
+}
+
+func (b *BreakerCacher) MSet(ctx context.Context, values map[string]string, opts ...SetOption) error {
+	return b.call(func() error {
+		return b.cacher.MSet(ctx, values, opts...)
+	})
+}
+
+func (b *BreakerCacher) DeleteByPattern(ctx context.Context, pattern string) error {
+	return b.call(func() error {
+		return b.cacher.DeleteByPattern(ctx, pattern)
+	})
+}
+
+// TryLock goes through the breaker as well. It must only be used when the
+// wrapped Cacher is a Locker.
+func (b *BreakerCacher) TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	release, acquired := func() {}, false
+	err := b.call(func() (err error) {
+		release, acquired, err = b.cacher.(Locker).TryLock(ctx, key, ttl)
+		return err
+	})
+
+	return release, acquired, err
+}
+
+func NewBreakerCacher(cacher Cacher, failureThreshold int, slowThreshold time.Duration, openTimeout time.Duration) *BreakerCacher {
+	breakerStateGauge.Set(float64(BreakerClosed))
+
+	return &BreakerCacher{
+		cacher:           cacher,
+		FailureThreshold: failureThreshold,
+		SlowThreshold:    slowThreshold,
+		OpenTimeout:      openTimeout,
+		now:              time.Now,
+	}
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingCacher fails every call while err is set and takes delay to answer
+type failingCacher struct {
+	*MemoryCacher
+	err   error
+	delay time.Duration
+	calls int
+}
+
+func (f *failingCacher) GetString(ctx context.Context, key string) (string, error) {
+	f.calls++
+	time.Sleep(f.delay)
+	if f.err != nil {
+		return "", f.err
+	}
+	return f.MemoryCacher.GetString(ctx, key)
+}
+
+func newTestBreaker(inner *failingCacher) (*BreakerCacher, *time.Time) {
+	now := time.Now()
+	breaker := NewBreakerCacher(inner, 3, 50*time.Millisecond, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	return breaker, &now
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	inner := &failingCacher{MemoryCacher: NewMemoryCacher(10, 0, time.Hour), err: errors.New("down")}
+	breaker, _ := newTestBreaker(inner)
+
+	for i := 0; i < 3; i++ {
+		_, err := breaker.GetString(context.Background(), "key")
+		assert.Equal(t, inner.err, err)
+	}
+
+	_, err := breaker.GetString(context.Background(), "key")
+
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 3, inner.calls)
+	assert.Equal(t, BreakerOpen, breaker.State())
+	assert.Equal(t, ErrCircuitOpen, breaker.Healthy())
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	inner := &failingCacher{MemoryCacher: NewMemoryCacher(10, 0, time.Hour), err: errors.New("down")}
+	breaker, _ := newTestBreaker(inner)
+
+	breaker.GetString(context.Background(), "key")
+	breaker.GetString(context.Background(), "key")
+	inner.err = nil
+	breaker.GetString(context.Background(), "key")
+	inner.err = errors.New("down")
+	breaker.GetString(context.Background(), "key")
+
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestBreakerOpensOnSlowCalls(t *testing.T) {
+	inner := &failingCacher{MemoryCacher: NewMemoryCacher(10, 0, time.Hour)}
+	breaker := NewBreakerCacher(inner, 2, 10*time.Millisecond, time.Minute)
+	inner.delay = 20 * time.Millisecond
+
+	breaker.GetString(context.Background(), "key")
+	breaker.GetString(context.Background(), "key")
+
+	assert.Equal(t, BreakerOpen, breaker.State())
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	inner := &failingCacher{MemoryCacher: NewMemoryCacher(10, 0, time.Hour), err: errors.New("down")}
+	breaker, now := newTestBreaker(inner)
+
+	for i := 0; i < 3; i++ {
+		breaker.GetString(context.Background(), "key")
+	}
+
+	// the probe fails, so the breaker opens again for another OpenTimeout
+	*now = now.Add(time.Minute)
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	_, err := breaker.GetString(context.Background(), "key")
+	assert.Equal(t, inner.err, err)
+	assert.Equal(t, BreakerOpen, breaker.State())
+	assert.Equal(t, 4, inner.calls)
+
+	// the probe succeeds and closes it
+	*now = now.Add(time.Minute)
+	inner.err = nil
+	_, err = breaker.GetString(context.Background(), "key")
+	assert.Nil(t, err)
+	assert.Equal(t, BreakerClosed, breaker.State())
+	assert.Nil(t, breaker.Healthy())
+}
+
+func TestBreakerOnlyOneProbeAtATime(t *testing.T) {
+	inner := &failingCacher{MemoryCacher: NewMemoryCacher(10, 0, time.Hour)}
+	breaker, _ := newTestBreaker(inner)
+	breaker.state = BreakerHalfOpen
+
+	allowed, probe := breaker.allow()
+	assert.True(t, allowed)
+	assert.True(t, probe)
+
+	allowed, _ = breaker.allow()
+	assert.False(t, allowed)
+}
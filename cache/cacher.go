@@ -2,72 +2,330 @@ package cache
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"goapi-template/config"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// Cacher stores string values. Missing keys are reported as empty strings
+// rather than errors, by GetString and MGet alike.
 type Cacher interface {
 	GetString(ctx context.Context, key string) (string, error)
-	SetString(ctx context.Context, key string, value string) error
+	SetString(ctx context.Context, key string, value string, opts ...SetOption) error
 	DeleteKey(ctx context.Context, key string) error
+	// MGet returns the values of keys in the same order, in one round trip
+	MGet(ctx context.Context, keys ...string) ([]string, error)
+	// MSet stores every value in one round trip
+	MSet(ctx context.Context, values map[string]string, opts ...SetOption) error
+	// DeleteByPattern deletes every key matching a glob style pattern such as
+	// "person:*"
+	DeleteByPattern(ctx context.Context, pattern string) error
+}
+
+type SetOptions struct {
+	// Expiration overrides the cacher's default expiration when positive
+	Expiration time.Duration
+}
+
+type SetOption func(*SetOptions)
+
+func WithExpiration(expiration time.Duration) SetOption {
+	return func(o *SetOptions) {
+		o.Expiration = expiration
+	}
+}
+
+// expirationOf returns the expiration requested by opts or def if none is.
+func expirationOf(def time.Duration, opts []SetOption) time.Duration {
+	options := &SetOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if options.Expiration > 0 {
+		return options.Expiration
+	}
+
+	return def
 }
 
 type RedisCacher struct {
-	client     *redis.Client
+	mu         sync.RWMutex
+	client     redis.UniversalClient
 	expiration time.Duration
+	// reconnected are called with the new client after a reconnection
+	reconnected []func(client redis.UniversalClient)
+}
+
+// Reconnector is implemented by cachers that can reconnect to Redis, with
+// rotated credentials for instance.
+type Reconnector interface {
+	Reconnect(config *config.CacheConfiguration) error
 }
 
+// reconnectGrace is how long the previous client is kept open after a
+// reconnection, for the commands still running on it.
+var reconnectGrace = 10 * time.Second
+
+func (t *RedisCacher) redisClient() redis.UniversalClient {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.client
+}
+
+// Reconnect replaces the client by a new one built from config, the previous
+// client is closed after reconnectGrace.
+func (t *RedisCacher) Reconnect(config *config.CacheConfiguration) error {
+	client, err := newRedisClient(config)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	previous := t.client
+	t.client = client
+	reconnected := t.reconnected
+	t.mu.Unlock()
+
+	for _, fn := range reconnected {
+		fn(client)
+	}
+
+	time.AfterFunc(reconnectGrace, func() {
+		if err := previous.Close(); err != nil {
+			slog.Error("Error closing previous redis connection", "error", err)
+		}
+	})
+
+	return nil
+}
+
+// onReconnect registers fn to be called with the new client after every
+// reconnection.
+func (t *RedisCacher) onReconnect(fn func(client redis.UniversalClient)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.reconnected = append(t.reconnected, fn)
+}
+
+// GetString returns an empty string when the key doesn't exist.
 func (t *RedisCacher) GetString(ctx context.Context, key string) (string, error) {
-	return t.client.Get(ctx, key).Result()
+	value, err := t.redisClient().Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+
+	return value, err
 }
 
-func (t *RedisCacher) SetString(ctx context.Context, key string, value string) error {
-	return t.client.Set(ctx, key, value, t.expiration).Err()
+func (t *RedisCacher) SetString(ctx context.Context, key string, value string, opts ...SetOption) error {
+	return t.redisClient().Set(ctx, key, value, expirationOf(t.expiration, opts)).Err()
 }
 
 func (t *RedisCacher) DeleteKey(ctx context.Context, key string) error {
-	return t.client.Del(ctx, key).Err()
+	return t.redisClient().Del(ctx, key).Err()
+}
+
+// MGet pipelines GET commands rather than using MGET so keys don't need to
+// share a hash slot when running against a cluster.
+func (t *RedisCacher) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	cmds := make([]*redis.StringCmd, len(keys))
+
+	_, err := t.redisClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	values := make([]string, len(keys))
+	for i, cmd := range cmds {
+		values[i] = cmd.Val()
+	}
+
+	return values, nil
+}
+
+// MSet pipelines SET commands since MSET can't set an expiration.
+func (t *RedisCacher) MSet(ctx context.Context, values map[string]string, opts ...SetOption) error {
+	expiration := expirationOf(t.expiration, opts)
+
+	_, err := t.redisClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(ctx, key, value, expiration)
+		}
+		return nil
+	})
+
+	return err
+}
+
+// DeleteByPattern scans every master when running against a cluster. Keys are
+// unlinked one by one since they don't necessarily share a hash slot.
+func (t *RedisCacher) DeleteByPattern(ctx context.Context, pattern string) error {
+	return forEachMaster(ctx, t.redisClient(), func(ctx context.Context, client redis.UniversalClient) error {
+		iter := client.Scan(ctx, 0, pattern, 500).Iterator()
+
+		keys := make([]string, 0, 500)
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+
+			if len(keys) == cap(keys) {
+				if err := unlink(ctx, client, keys); err != nil {
+					return err
+				}
+				keys = keys[:0]
+			}
+		}
+
+		if err := iter.Err(); err != nil {
+			return err
+		}
+
+		return unlink(ctx, client, keys)
+	})
+}
+
+func unlink(ctx context.Context, client redis.UniversalClient, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Unlink(ctx, key)
+		}
+		return nil
+	})
+
+	return err
 }
 
 func (t *RedisCacher) Close() {
-	err := t.client.Close()
+	err := t.redisClient().Close()
 
 	if err != nil {
 		slog.Error("Error closing redis connection", "error", err)
 	}
 }
 
-func NewRawCacher(config *config.CacheConfiguration) *RedisCacher {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     config.RedisAddress,
-		Password: config.RedisPassword,
-		DB:       config.RedisDb,
-	})
+func NewRawCacher(config *config.CacheConfiguration) (*RedisCacher, error) {
+	client, err := newRedisClient(config)
+	if err != nil {
+		return nil, err
+	}
 
-	return &RedisCacher{client: rdb, expiration: config.Expiration}
+	return &RedisCacher{client: client, expiration: config.Expiration}, nil
 }
 
+// NewCacher builds the Cacher selected by config.Provider along with a
+// function to release its resources.
+func NewCacher(config *config.CacheConfiguration) (Cacher, func(), error) {
+	if config.Provider == "memory" {
+		cacher := newMemoryCacher(config)
+		return cacher, cacher.Close, nil
+	}
+
+	l2, err := NewRawCacher(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if config.Provider == "tiered" {
+		cacher := NewTieredCacher(newMemoryCacher(config), l2, config.InvalidationChannel)
+		return cacher, cacher.Close, nil
+	}
+
+	return l2, l2.Close, nil
+}
+
+func newMemoryCacher(config *config.CacheConfiguration) *MemoryCacher {
+	return NewMemoryCacher(config.MemoryMaxEntries, config.MemoryMaxBytes, config.MemoryExpiration)
+}
+
+// GetObject returns nil for missing keys and for entries written in another
+// format or for another version of T (see Versioned).
 func GetObject[T any](cacher Cacher, ctx context.Context, key string) (*T, error) {
 	p, err := cacher.GetString(ctx, key)
 	if err != nil || p == "" {
 		return nil, err
 	}
 
-	result := new(T)
-	err = json.Unmarshal([]byte(p), result)
+	return decodeObject[T](p)
+}
+
+// GetObjects reads every key in one round trip. Missing keys, and keys that
+// can't be decoded, are returned as nil.
+func GetObjects[T any](cacher Cacher, ctx context.Context, keys ...string) ([]*T, error) {
+	values, err := cacher.MGet(ctx, keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*T, len(values))
+	for i, p := range values {
+		if p == "" {
+			continue
+		}
+
+		result, err := decodeObject[T](p)
+		if err != nil {
+			slog.Error("Error decoding cached value", "key", keys[i], "error", err)
+			continue
+		}
 
-	return result, err
+		results[i] = result
+	}
+
+	return results, nil
 }
 
-func SetObject[T any](cacher Cacher, ctx context.Context, key string, value *T) error {
-	p, err := json.Marshal(value)
+func SetObject[T any](cacher Cacher, ctx context.Context, key string, value *T, opts ...SetOption) error {
+	p, err := serializer.Load().Encode(value, versionOf[T]())
 	if err != nil {
 		return err
 	}
 
-	return cacher.SetString(ctx, key, string(p))
+	return cacher.SetString(ctx, key, string(p), opts...)
+}
+
+// SetObjects stores every value in one round trip.
+func SetObjects[T any](cacher Cacher, ctx context.Context, values map[string]*T, opts ...SetOption) error {
+	s, version := serializer.Load(), versionOf[T]()
+
+	encoded := make(map[string]string, len(values))
+	for key, value := range values {
+		p, err := s.Encode(value, version)
+		if err != nil {
+			return err
+		}
+
+		encoded[key] = string(p)
+	}
+
+	return cacher.MSet(ctx, encoded, opts...)
+}
+
+func decodeObject[T any](p string) (*T, error) {
+	result := new(T)
+
+	err := serializer.Load().Decode([]byte(p), versionOf[T](), result)
+	if errors.Is(err, errStaleEntry) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
 }
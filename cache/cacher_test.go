@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"context"
+	"goapi-template/config"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRedisCacher(t *testing.T, server *miniredis.Miniredis) *RedisCacher {
+	cacher, err := NewRawCacher(&config.CacheConfiguration{RedisAddress: server.Addr(), Expiration: time.Hour})
+	assert.Nil(t, err)
+	t.Cleanup(cacher.Close)
+
+	return cacher
+}
+
+func TestRedisCacherSetWithExpiration(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestRedisCacher(t, server)
+
+	cacher.SetString(context.Background(), "default", "value")
+	err := cacher.SetString(context.Background(), "key", "value", WithExpiration(time.Minute))
+
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, server.TTL("default"))
+	assert.Equal(t, time.Minute, server.TTL("key"))
+}
+
+func TestRedisCacherMGet(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestRedisCacher(t, server)
+	server.Set("key1", "value1")
+	server.Set("key3", "value3")
+
+	result, err := cacher.MGet(context.Background(), "key1", "key2", "key3")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"value1", "", "value3"}, result)
+}
+
+func TestRedisCacherMSet(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestRedisCacher(t, server)
+
+	err := cacher.MSet(context.Background(), map[string]string{"key1": "value1", "key2": "value2"}, WithExpiration(time.Minute))
+
+	assert.Nil(t, err)
+	value, _ := server.Get("key2")
+	assert.Equal(t, "value2", value)
+	assert.Equal(t, time.Minute, server.TTL("key1"))
+	assert.Equal(t, time.Minute, server.TTL("key2"))
+}
+
+func TestRedisCacherDeleteByPattern(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestRedisCacher(t, server)
+	server.Set("person:1", "value")
+	server.Set("person:2", "value")
+	server.Set("tag:person", "1")
+
+	err := cacher.DeleteByPattern(context.Background(), "person:*")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tag:person"}, server.Keys())
+}
+
+func TestGetSetObjects(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+	type person struct{ Name string }
+
+	err := SetObjects(cacher, context.Background(), map[string]*person{"person:1": {Name: "one"}, "person:2": {Name: "two"}})
+	assert.Nil(t, err)
+
+	cacher.SetString(context.Background(), "person:4", "not json")
+
+	result, err := GetObjects[person](cacher, context.Background(), "person:1", "person:3", "person:2", "person:4")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []*person{{Name: "one"}, nil, {Name: "two"}, nil}, result)
+}
+
+func TestRedisCacherReconnect(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("old")
+	cacher, err := NewRawCacher(&config.CacheConfiguration{RedisAddress: server.Addr(), RedisPassword: "old", Expiration: time.Hour})
+	assert.Nil(t, err)
+	t.Cleanup(cacher.Close)
+	previous := cacher.redisClient()
+
+	server.RequireAuth("new")
+	err = cacher.Reconnect(&config.CacheConfiguration{RedisAddress: server.Addr(), RedisPassword: "new", Expiration: time.Hour})
+	assert.Nil(t, err)
+
+	assert.NotSame(t, previous, cacher.redisClient())
+	assert.Nil(t, cacher.SetString(context.Background(), "key", "value"))
+	value, _ := server.Get("key")
+	assert.Equal(t, "value", value)
+}
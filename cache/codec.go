@@ -0,0 +1,234 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes cached objects.
+type Codec interface {
+	Marshal(value any) ([]byte, error)
+	Unmarshal(data []byte, value any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(value any) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, value any) error {
+	return json.Unmarshal(data, value)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(value any) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, value any) error {
+	return msgpack.Unmarshal(data, value)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(value)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, value any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+// Codec and compression ids are stored in entry headers, never change them.
+const (
+	CodecJSON byte = iota + 1
+	CodecMsgpack
+	CodecGob
+)
+
+const (
+	CompressionNone byte = iota
+	CompressionGzip
+	CompressionZstd
+)
+
+var codecs = map[byte]Codec{
+	CodecJSON:    jsonCodec{},
+	CodecMsgpack: msgpackCodec{},
+	CodecGob:     gobCodec{},
+}
+
+var codecNames = map[string]byte{"json": CodecJSON, "msgpack": CodecMsgpack, "gob": CodecGob}
+
+var compressionNames = map[string]byte{"none": CompressionNone, "gzip": CompressionGzip, "zstd": CompressionZstd}
+
+// Versioned is implemented by cached types whose layout may change. Entries
+// written with another version are treated as misses instead of being decoded
+// into the new layout.
+type Versioned interface {
+	CacheVersion() uint16
+}
+
+// Entries start with a header made of a magic byte, the codec id, the
+// compression id and the big endian version of the cached type. Entries
+// without the header, such as plain JSON written by older releases, are
+// treated as misses.
+const (
+	headerMagic byte = 0xCA
+	headerSize       = 5
+)
+
+// errStaleEntry is returned for entries that can't be decoded into the
+// requested type, they are treated as misses.
+var errStaleEntry = errors.New("cache entry was written in another format")
+
+// Serializer turns objects into cache entries and back. Entries are always
+// decoded with the codec and compression they were written with, so changing
+// either doesn't invalidate existing entries.
+type Serializer struct {
+	Codec       byte
+	Compression byte
+	// CompressionThreshold is the encoded size from which entries are
+	// compressed
+	CompressionThreshold int
+}
+
+func (s *Serializer) Encode(value any, version uint16) ([]byte, error) {
+	codec, ok := codecs[s.Codec]
+	if !ok {
+		return nil, fmt.Errorf("unknown cache codec %d", s.Codec)
+	}
+
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	compression := CompressionNone
+	if s.Compression != CompressionNone && len(data) >= s.CompressionThreshold {
+		compression = s.Compression
+		if data, err = compress(compression, data); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := make([]byte, headerSize, headerSize+len(data))
+	entry[0] = headerMagic
+	entry[1] = s.Codec
+	entry[2] = compression
+	binary.BigEndian.PutUint16(entry[3:], version)
+
+	return append(entry, data...), nil
+}
+
+// Decode returns errStaleEntry when the entry has no header, an unknown codec
+// or another version.
+func (s *Serializer) Decode(entry []byte, version uint16, value any) error {
+	if len(entry) < headerSize || entry[0] != headerMagic || binary.BigEndian.Uint16(entry[3:]) != version {
+		return errStaleEntry
+	}
+
+	codec, ok := codecs[entry[1]]
+	if !ok {
+		return errStaleEntry
+	}
+
+	data, err := decompress(entry[2], entry[headerSize:])
+	if err != nil {
+		return err
+	}
+
+	return codec.Unmarshal(data, value)
+}
+
+func compress(compression byte, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		err := writer.Close()
+		return buf.Bytes(), err
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("unknown cache compression %d", compression)
+	}
+}
+
+func decompress(compression byte, data []byte) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(reader)
+	case CompressionZstd:
+		return zstdDecoder.DecodeAll(data, nil)
+	default:
+		return nil, errStaleEntry
+	}
+}
+
+// the zstd encoder and decoder are safe for concurrent use of EncodeAll and
+// DecodeAll and expensive to create
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// NewSerializer validates the codec and compression names, e.g. "msgpack" and
+// "zstd".
+func NewSerializer(codec string, compression string, compressionThreshold int) (*Serializer, error) {
+	codecId, ok := codecNames[codec]
+	if !ok {
+		return nil, fmt.Errorf("unknown cache codec %s", codec)
+	}
+
+	compressionId, ok := compressionNames[compression]
+	if !ok {
+		return nil, fmt.Errorf("unknown cache compression %s", compression)
+	}
+
+	return &Serializer{Codec: codecId, Compression: compressionId, CompressionThreshold: compressionThreshold}, nil
+}
+
+var serializer atomic.Pointer[Serializer]
+
+func init() {
+	serializer.Store(&Serializer{Codec: CodecJSON})
+}
+
+// SetSerializer changes how GetObject and SetObject encode values, entries
+// written before keep being readable.
+func SetSerializer(s *Serializer) {
+	serializer.Store(s)
+}
+
+// versionOf returns the version of T when it implements Versioned.
+func versionOf[T any]() uint16 {
+	if versioned, ok := any(new(T)).(Versioned); ok {
+		return versioned.CacheVersion()
+	}
+
+	return 0
+}
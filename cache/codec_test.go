@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type codecTestValue struct {
+	Name  string
+	Tags  []string
+	Count int
+}
+
+type versionedValue struct {
+	Name string
+}
+
+func (versionedValue) CacheVersion() uint16 {
+	return 2
+}
+
+func TestSerializerRoundTrip(t *testing.T) {
+	value := &codecTestValue{Name: strings.Repeat("a", 100), Tags: []string{"x", "y"}, Count: 3}
+
+	for _, codec := range []string{"json", "msgpack", "gob"} {
+		for _, compression := range []string{"none", "gzip", "zstd"} {
+			serializer, err := NewSerializer(codec, compression, 10)
+			assert.Nil(t, err)
+
+			entry, err := serializer.Encode(value, 1)
+			assert.Nil(t, err)
+
+			result := &codecTestValue{}
+			err = serializer.Decode(entry, 1, result)
+
+			assert.Nil(t, err, codec+"/"+compression)
+			assert.Equal(t, value, result, codec+"/"+compression)
+		}
+	}
+}
+
+func TestSerializerCompressionThreshold(t *testing.T) {
+	serializer, _ := NewSerializer("json", "gzip", 1024)
+
+	small, _ := serializer.Encode(&codecTestValue{Name: "small"}, 0)
+	large, _ := serializer.Encode(&codecTestValue{Name: strings.Repeat("a", 2048)}, 0)
+
+	assert.Equal(t, CompressionNone, small[2])
+	assert.Equal(t, CompressionGzip, large[2])
+	assert.Less(t, len(large), 1024)
+}
+
+func TestSerializerReadsEntriesOfOtherCodecs(t *testing.T) {
+	msgpack, _ := NewSerializer("msgpack", "zstd", 0)
+	json, _ := NewSerializer("json", "none", 0)
+
+	entry, _ := msgpack.Encode(&codecTestValue{Name: "test"}, 0)
+	result := &codecTestValue{}
+	err := json.Decode(entry, 0, result)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "test", result.Name)
+}
+
+func TestSerializerStaleEntries(t *testing.T) {
+	serializer, _ := NewSerializer("json", "none", 0)
+	entry, _ := serializer.Encode(&codecTestValue{Name: "test"}, 1)
+
+	assert.Equal(t, errStaleEntry, serializer.Decode(entry, 2, &codecTestValue{}))
+	assert.Equal(t, errStaleEntry, serializer.Decode([]byte(`{"Name":"legacy"}`), 1, &codecTestValue{}))
+	assert.Equal(t, errStaleEntry, serializer.Decode([]byte{headerMagic, 42, 0, 0, 1}, 1, &codecTestValue{}))
+}
+
+func TestNewSerializerErrors(t *testing.T) {
+	_, err := NewSerializer("xml", "none", 0)
+	assert.Equal(t, "unknown cache codec xml", err.Error())
+
+	_, err = NewSerializer("json", "lz4", 0)
+	assert.Equal(t, "unknown cache compression lz4", err.Error())
+}
+
+func TestGetObjectVersionMismatchIsMiss(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+
+	SetObject(cacher, context.Background(), "unversioned", &codecTestValue{Name: "test"})
+	SetObject(cacher, context.Background(), "versioned", &versionedValue{Name: "test"})
+	cacher.SetString(context.Background(), "legacy", `{"Name":"test"}`)
+
+	// written without a version, read with version 2
+	result, err := GetObject[versionedValue](cacher, context.Background(), "unversioned")
+	assert.Nil(t, err)
+	assert.Nil(t, result)
+
+	result, err = GetObject[versionedValue](cacher, context.Background(), "legacy")
+	assert.Nil(t, err)
+	assert.Nil(t, result)
+
+	result, err = GetObject[versionedValue](cacher, context.Background(), "versioned")
+	assert.Nil(t, err)
+	assert.Equal(t, "test", result.Name)
+}
+
+func TestSetSerializer(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+	msgpack, _ := NewSerializer("msgpack", "none", 0)
+
+	SetSerializer(msgpack)
+	defer SetSerializer(&Serializer{Codec: CodecJSON})
+
+	SetObject(cacher, context.Background(), "key", &codecTestValue{Name: "test"})
+	entry, _ := cacher.GetString(context.Background(), "key")
+	result, err := GetObject[codecTestValue](cacher, context.Background(), "key")
+
+	assert.Equal(t, CodecMsgpack, entry[1])
+	assert.Nil(t, err)
+	assert.Equal(t, "test", result.Name)
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Locker is implemented by cachers that can hold short lived locks shared by
+// every replica. TryLock doesn't wait: it reports whether the lock was
+// acquired and, if so, returns the function that releases it. Locks expire
+// after ttl in case the holder never releases them.
+type Locker interface {
+	TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error)
+}
+
+// only delete the lock if it's still ours, it may have expired and been taken
+// by someone else in the meantime
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+func (t *RedisCacher) TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	token := uuid.NewString()
+
+	acquired, err := t.redisClient().SetNX(ctx, "lock:"+key, token, ttl).Result()
+	if err != nil || !acquired {
+		return func() {}, false, err
+	}
+
+	release := func() {
+		unlockScript.Run(context.Background(), t.redisClient(), []string{"lock:" + key}, token)
+	}
+
+	return release, true, nil
+}
+
+func (t *TieredCacher) TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	return t.l2.TryLock(ctx, key, ttl)
+}
@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisCacherTryLock(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestRedisCacher(t, server)
+
+	release, acquired, err := cacher.TryLock(context.Background(), "key", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, acquired)
+
+	_, acquiredAgain, err := cacher.TryLock(context.Background(), "key", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, acquiredAgain)
+
+	release()
+
+	_, acquiredAfterRelease, err := cacher.TryLock(context.Background(), "key", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, acquiredAfterRelease)
+}
+
+func TestRedisCacherReleaseDoesNotStealLock(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestRedisCacher(t, server)
+
+	release, _, _ := cacher.TryLock(context.Background(), "key", time.Second)
+
+	// the lock expires and is taken by someone else
+	server.FastForward(2 * time.Second)
+	_, acquired, _ := cacher.TryLock(context.Background(), "key", time.Minute)
+	assert.True(t, acquired)
+
+	release()
+
+	assert.True(t, server.Exists("lock:key"))
+}
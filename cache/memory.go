@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// MemoryCacher is an in-process LRU cache with a fixed time to live. Entries
+// are evicted least recently used first once either maxEntries or maxBytes
+// is exceeded. A zero limit disables that limit.
+type MemoryCacher struct {
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List
+	size       int
+	maxEntries int
+	maxBytes   int
+	expiration time.Duration
+	now        func() time.Time
+}
+
+type memoryEntry struct {
+	key       string
+	value     string
+	expiresAt time.Time
+}
+
+func (e *memoryEntry) size() int {
+	return len(e.key) + len(e.value)
+}
+
+func (t *MemoryCacher) GetString(ctx context.Context, key string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	element, ok := t.items[key]
+	if !ok {
+		return "", nil
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if !entry.expiresAt.IsZero() && t.now().After(entry.expiresAt) {
+		t.remove(element)
+		return "", nil
+	}
+
+	t.lru.MoveToFront(element)
+
+	return entry.value, nil
+}
+
+func (t *MemoryCacher) SetString(ctx context.Context, key string, value string, opts ...SetOption) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.set(key, value, expirationOf(t.expiration, opts))
+
+	return nil
+}
+
+func (t *MemoryCacher) DeleteKey(ctx context.Context, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if element, ok := t.items[key]; ok {
+		t.remove(element)
+	}
+
+	return nil
+}
+
+func (t *MemoryCacher) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i], _ = t.GetString(ctx, key)
+	}
+
+	return values, nil
+}
+
+func (t *MemoryCacher) MSet(ctx context.Context, values map[string]string, opts ...SetOption) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expiration := expirationOf(t.expiration, opts)
+	for key, value := range values {
+		t.set(key, value, expiration)
+	}
+
+	return nil
+}
+
+// DeleteByPattern supports the glob syntax of redis' KEYS and SCAN: "*",
+// "?", "[...]" with "^" and ranges, and "\" escapes. Unlike path.Match, "*"
+// and "?" match "/" as well.
+func (t *MemoryCacher) DeleteByPattern(ctx context.Context, pattern string) error {
+	if err := validGlob(pattern); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, element := range t.items {
+		if globMatch(pattern, key) {
+			t.remove(element)
+		}
+	}
+
+	return nil
+}
+
+// errBadPattern is returned for patterns with an unterminated "[".
+var errBadPattern = errors.New("syntax error in pattern")
+
+func validGlob(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			end := globClassEnd(pattern[i:])
+			if end < 0 {
+				return errBadPattern
+			}
+			i += end
+		}
+	}
+
+	return nil
+}
+
+// globClassEnd returns the index of the "]" closing the class pattern starts
+// with, -1 when there is none.
+func globClassEnd(pattern string) int {
+	for i := 1; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case ']':
+			return i
+		}
+	}
+
+	return -1
+}
+
+// globMatch matches s byte by byte like redis' stringmatchlen, pattern must
+// be valid.
+func globMatch(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			end := globClassEnd(pattern)
+			if len(s) == 0 || !globClassMatch(pattern[1:end], s[0]) {
+				return false
+			}
+			pattern = pattern[end:]
+		case '\\':
+			// a trailing backslash matches itself
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+
+		pattern, s = pattern[1:], s[1:]
+	}
+
+	return len(s) == 0
+}
+
+// globClassMatch reports whether c is in class, the content of a "[...]".
+func globClassMatch(class string, c byte) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(class); i++ {
+		switch {
+		case class[i] == '\\' && i+1 < len(class):
+			i++
+			matched = matched || class[i] == c
+		case i+2 < len(class) && class[i+1] == '-':
+			low, high := class[i], class[i+2]
+			if low > high {
+				low, high = high, low
+			}
+			matched = matched || (low <= c && c <= high)
+			i += 2
+		default:
+			matched = matched || class[i] == c
+		}
+	}
+
+	return matched != negate
+}
+
+// Len returns the number of entries currently held, including expired
+// entries that were not yet evicted.
+func (t *MemoryCacher) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.lru.Len()
+}
+
+func (t *MemoryCacher) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.items = map[string]*list.Element{}
+	t.lru.Init()
+	t.size = 0
+}
+
+func (t *MemoryCacher) set(key string, value string, expiration time.Duration) {
+	entry := &memoryEntry{key: key, value: value}
+	if expiration > 0 {
+		entry.expiresAt = t.now().Add(expiration)
+	}
+
+	if t.maxBytes > 0 && entry.size() > t.maxBytes {
+		// never going to fit, make sure we don't serve an older value either
+		if element, ok := t.items[key]; ok {
+			t.remove(element)
+		}
+		return
+	}
+
+	if element, ok := t.items[key]; ok {
+		t.size -= element.Value.(*memoryEntry).size()
+		element.Value = entry
+		t.lru.MoveToFront(element)
+	} else {
+		t.items[key] = t.lru.PushFront(entry)
+	}
+
+	t.size += entry.size()
+	t.evict()
+}
+
+func (t *MemoryCacher) evict() {
+	for t.lru.Len() > 0 && ((t.maxEntries > 0 && t.lru.Len() > t.maxEntries) || (t.maxBytes > 0 && t.size > t.maxBytes)) {
+		t.remove(t.lru.Back())
+	}
+}
+
+func (t *MemoryCacher) remove(element *list.Element) {
+	entry := t.lru.Remove(element).(*memoryEntry)
+	delete(t.items, entry.key)
+	t.size -= entry.size()
+}
+
+func NewMemoryCacher(maxEntries int, maxBytes int, expiration time.Duration) *MemoryCacher {
+	return &MemoryCacher{
+		items:      map[string]*list.Element{},
+		lru:        list.New(),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		expiration: expiration,
+		now:        time.Now,
+	}
+}
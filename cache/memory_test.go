@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCacherSetGet(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+
+	err := cacher.SetString(context.Background(), "key", "value")
+	result, getErr := cacher.GetString(context.Background(), "key")
+
+	assert.Nil(t, err)
+	assert.Nil(t, getErr)
+	assert.Equal(t, "value", result)
+}
+
+func TestMemoryCacherMiss(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+
+	result, err := cacher.GetString(context.Background(), "key")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", result)
+}
+
+func TestMemoryCacherDelete(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+	cacher.SetString(context.Background(), "key", "value")
+
+	err := cacher.DeleteKey(context.Background(), "key")
+	result, _ := cacher.GetString(context.Background(), "key")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", result)
+	assert.Equal(t, 0, cacher.Len())
+}
+
+func TestMemoryCacherExpiration(t *testing.T) {
+	now := time.Now()
+	cacher := NewMemoryCacher(10, 0, time.Minute)
+	cacher.now = func() time.Time { return now }
+	cacher.SetString(context.Background(), "key", "value")
+
+	now = now.Add(2 * time.Minute)
+	result, _ := cacher.GetString(context.Background(), "key")
+
+	assert.Equal(t, "", result)
+	assert.Equal(t, 0, cacher.Len())
+}
+
+func TestMemoryCacherEvictsLeastRecentlyUsed(t *testing.T) {
+	cacher := NewMemoryCacher(2, 0, time.Hour)
+	cacher.SetString(context.Background(), "key1", "value1")
+	cacher.SetString(context.Background(), "key2", "value2")
+
+	// touch key1 so key2 becomes the least recently used
+	cacher.GetString(context.Background(), "key1")
+	cacher.SetString(context.Background(), "key3", "value3")
+
+	key1, _ := cacher.GetString(context.Background(), "key1")
+	key2, _ := cacher.GetString(context.Background(), "key2")
+	key3, _ := cacher.GetString(context.Background(), "key3")
+
+	assert.Equal(t, "value1", key1)
+	assert.Equal(t, "", key2)
+	assert.Equal(t, "value3", key3)
+}
+
+func TestMemoryCacherEvictsBySize(t *testing.T) {
+	cacher := NewMemoryCacher(0, 20, time.Hour)
+	cacher.SetString(context.Background(), "key1", "0123456789")
+	cacher.SetString(context.Background(), "key2", "0123456789")
+
+	key1, _ := cacher.GetString(context.Background(), "key1")
+	key2, _ := cacher.GetString(context.Background(), "key2")
+
+	assert.Equal(t, "", key1)
+	assert.Equal(t, "0123456789", key2)
+	assert.Equal(t, 1, cacher.Len())
+}
+
+func TestMemoryCacherSkipsOversizedValues(t *testing.T) {
+	cacher := NewMemoryCacher(0, 10, time.Hour)
+	cacher.SetString(context.Background(), "key", "value")
+	cacher.SetString(context.Background(), "key", "0123456789")
+
+	result, _ := cacher.GetString(context.Background(), "key")
+
+	assert.Equal(t, "", result)
+	assert.Equal(t, 0, cacher.Len())
+}
+
+func TestMemoryCacherOverwrite(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+	cacher.SetString(context.Background(), "key", "value1")
+	cacher.SetString(context.Background(), "key", "value2")
+
+	result, _ := cacher.GetString(context.Background(), "key")
+
+	assert.Equal(t, "value2", result)
+	assert.Equal(t, 1, cacher.Len())
+}
+
+func TestMemoryCacherSetWithExpiration(t *testing.T) {
+	now := time.Now()
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+	cacher.now = func() time.Time { return now }
+	cacher.SetString(context.Background(), "short", "value", WithExpiration(time.Minute))
+	cacher.SetString(context.Background(), "long", "value")
+
+	now = now.Add(2 * time.Minute)
+	values, err := cacher.MGet(context.Background(), "short", "long")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"", "value"}, values)
+}
+
+func TestMemoryCacherDeleteByPattern(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+	cacher.MSet(context.Background(), map[string]string{"person:1": "1", "person:2": "2", "tag:person": "1"})
+
+	err := cacher.DeleteByPattern(context.Background(), "person:*")
+	values, _ := cacher.MGet(context.Background(), "person:1", "person:2", "tag:person")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"", "", "1"}, values)
+	assert.Equal(t, 1, cacher.Len())
+}
+
+func TestMemoryCacherDeleteByPatternAcrossSlashes(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+	cacher.MSet(context.Background(), map[string]string{
+		"response:/person/1":      "1",
+		"response:/person?page=2": "2",
+		"response:/health":        "3",
+	})
+
+	err := cacher.DeleteByPattern(context.Background(), "response:/person*")
+	values, _ := cacher.MGet(context.Background(), "response:/person/1", "response:/person?page=2", "response:/health")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"", "", "3"}, values)
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		key     string
+		matched bool
+	}{
+		{"*", "", true},
+		{"*", "a/b", true},
+		{"a*c", "a/b/c", true},
+		{"a**c", "abc", true},
+		{"a*c", "a/b/d", false},
+		{"h?llo", "h/llo", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h[\\]]llo", "h]llo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"a\\", "a\\", true},
+		{"tag:*", "person:1", false},
+	} {
+		assert.Equal(t, test.matched, globMatch(test.pattern, test.key), "%s %s", test.pattern, test.key)
+	}
+}
+
+func TestMemoryCacherDeleteByInvalidPattern(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+
+	err := cacher.DeleteByPattern(context.Background(), "person:[")
+
+	assert.NotNil(t, err)
+}
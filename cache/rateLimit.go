@@ -0,0 +1,286 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimiter is implemented by cachers that can count requests against a
+// quota. The Redis cachers share the counts between every replica.
+type RateLimiter interface {
+	// Take counts a request for key and tells whether quota allows it
+	Take(ctx context.Context, key string, quota Quota) (Allowance, error)
+}
+
+type Algorithm string
+
+const (
+	// TokenBucket allows bursts of Limit requests and refills Limit tokens
+	// every Window
+	TokenBucket Algorithm = "token-bucket"
+	// SlidingWindow allows Limit requests over any Window, weighting the
+	// count of the previous window by how much of it still overlaps
+	SlidingWindow Algorithm = "sliding-window"
+)
+
+type Quota struct {
+	Algorithm Algorithm
+	Limit     int
+	Window    time.Duration
+}
+
+type Allowance struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is when the quota is fully available again
+	Reset time.Duration
+	// RetryAfter is when the next request is allowed, set when this one
+	// isn't
+	RetryAfter time.Duration
+}
+
+// bucketAllowance describes a token bucket left with tokens.
+func (q Quota) bucketAllowance(allowed bool, tokens float64) Allowance {
+	perToken := float64(q.Window) / float64(q.Limit)
+
+	allowance := Allowance{
+		Allowed:   allowed,
+		Limit:     q.Limit,
+		Remaining: int(tokens),
+		Reset:     time.Duration(math.Ceil((float64(q.Limit) - tokens) * perToken)),
+	}
+	if !allowed {
+		allowance.RetryAfter = time.Duration(math.Ceil((1 - tokens) * perToken))
+	}
+
+	return allowance
+}
+
+// windowAllowance describes a sliding window counting current requests so
+// far, previous ones in the previous window, elapsed into the current window.
+func (q Quota) windowAllowance(allowed bool, current int, previous int, elapsed time.Duration) Allowance {
+	window := float64(q.Window)
+	count := float64(previous)*(1-float64(elapsed)/window) + float64(current)
+
+	allowance := Allowance{
+		Allowed:   allowed,
+		Limit:     q.Limit,
+		Remaining: max(0, q.Limit-int(math.Ceil(count))),
+	}
+
+	// the current requests are counted until the end of the next window
+	switch {
+	case current > 0:
+		allowance.Reset = 2*q.Window - elapsed
+	case previous > 0:
+		allowance.Reset = q.Window - elapsed
+	}
+
+	if allowed {
+		return allowance
+	}
+
+	// wait for enough of the previous window to slide out, or for the
+	// current window to become the previous one and slide out in turn
+	var retryAfter float64
+	if current < q.Limit {
+		needed := float64(q.Limit-1-current) / float64(previous)
+		retryAfter = (1-needed)*window - float64(elapsed)
+	} else {
+		needed := float64(q.Limit-1) / float64(current)
+		retryAfter = window - float64(elapsed) + (1-needed)*window
+	}
+	allowance.RetryAfter = time.Duration(math.Ceil(max(0, retryAfter)))
+
+	return allowance
+}
+
+// windowOf returns the index of the window now falls in and how long ago it
+// started.
+func (q Quota) windowOf(now time.Time) (int64, time.Duration) {
+	index := now.UnixNano() / int64(q.Window)
+	return index, time.Duration(now.UnixNano() - index*int64(q.Window))
+}
+
+// MemoryRateLimiter counts the requests of a single instance.
+type MemoryRateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*rateState
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type rateState struct {
+	// token bucket
+	tokens  float64
+	updated time.Time
+	// sliding window
+	window   int64
+	current  int
+	previous int
+
+	expiresAt time.Time
+}
+
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: map[string]*rateState{}, now: time.Now}
+}
+
+func (m *MemoryRateLimiter) Take(_ context.Context, key string, quota Quota) (Allowance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	state, ok := m.buckets[key]
+	if !ok || now.After(state.expiresAt) {
+		state = &rateState{tokens: float64(quota.Limit), updated: now}
+		m.buckets[key] = state
+	}
+
+	if quota.Algorithm == SlidingWindow {
+		window, elapsed := quota.windowOf(now)
+		switch {
+		case window == state.window+1:
+			state.previous, state.current = state.current, 0
+		case window != state.window:
+			state.previous, state.current = 0, 0
+		}
+		state.window = window
+		state.expiresAt = now.Add(2 * quota.Window)
+
+		weight := 1 - float64(elapsed)/float64(quota.Window)
+		allowed := float64(state.previous)*weight+float64(state.current)+1 <= float64(quota.Limit)
+		if allowed {
+			state.current++
+		}
+
+		return quota.windowAllowance(allowed, state.current, state.previous, elapsed), nil
+	}
+
+	state.tokens = math.Min(float64(quota.Limit), state.tokens+float64(now.Sub(state.updated))*float64(quota.Limit)/float64(quota.Window))
+	state.updated = now
+	state.expiresAt = now.Add(quota.Window)
+
+	allowed := state.tokens >= 1
+	if allowed {
+		state.tokens--
+	}
+
+	return quota.bucketAllowance(allowed, state.tokens), nil
+}
+
+// sweep drops the expired counts every minute.
+func (m *MemoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+
+	for key, state := range m.buckets {
+		if now.After(state.expiresAt) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// Len returns the number of keys counted.
+func (m *MemoryRateLimiter) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.buckets)
+}
+
+var tokenBucketScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("hmget", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1]) or limit
+local updated = tonumber(state[2]) or now
+tokens = math.min(limit, tokens + math.max(0, now - updated) * limit / window)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("hset", KEYS[1], "tokens", tostring(tokens), "updated", now)
+redis.call("pexpire", KEYS[1], window)
+return {allowed, tostring(tokens)}`)
+
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local weight = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+local current = tonumber(redis.call("get", KEYS[1]) or "0")
+local previous = tonumber(redis.call("get", KEYS[2]) or "0")
+local allowed = 0
+if previous * weight + current + 1 <= limit then
+	current = redis.call("incr", KEYS[1])
+	redis.call("pexpire", KEYS[1], ttl)
+	allowed = 1
+end
+return {allowed, current, previous}`)
+
+// Take runs the algorithm in a script so concurrent requests of every
+// replica are counted atomically. The keys of a quota share a hash slot.
+func (t *RedisCacher) Take(ctx context.Context, key string, quota Quota) (Allowance, error) {
+	key = "ratelimit:{" + key + "}"
+	now := time.Now()
+
+	if quota.Algorithm == SlidingWindow {
+		window, elapsed := quota.windowOf(now)
+		weight := 1 - float64(elapsed)/float64(quota.Window)
+
+		result, err := slidingWindowScript.Run(ctx, t.redisClient(),
+			[]string{key + ":" + strconv.FormatInt(window, 10), key + ":" + strconv.FormatInt(window-1, 10)},
+			quota.Limit, weight, (2 * quota.Window).Milliseconds()).Int64Slice()
+		if err != nil {
+			return Allowance{}, err
+		}
+
+		return quota.windowAllowance(result[0] == 1, int(result[1]), int(result[2]), elapsed), nil
+	}
+
+	result, err := tokenBucketScript.Run(ctx, t.redisClient(), []string{key},
+		quota.Limit, quota.Window.Milliseconds(), now.UnixMilli()).Slice()
+	if err != nil {
+		return Allowance{}, err
+	}
+	if len(result) != 2 {
+		return Allowance{}, fmt.Errorf("unexpected token bucket result %v", result)
+	}
+
+	allowed, _ := result[0].(int64)
+	tokens, err := strconv.ParseFloat(fmt.Sprint(result[1]), 64)
+	if err != nil {
+		return Allowance{}, err
+	}
+
+	return quota.bucketAllowance(allowed == 1, tokens), nil
+}
+
+func (t *TieredCacher) Take(ctx context.Context, key string, quota Quota) (Allowance, error) {
+	return t.l2.Take(ctx, key, quota)
+}
+
+// Take goes through the breaker as well. It must only be used when the
+// wrapped Cacher is a RateLimiter.
+func (b *BreakerCacher) Take(ctx context.Context, key string, quota Quota) (Allowance, error) {
+	var allowance Allowance
+	err := b.call(func() (err error) {
+		allowance, err = b.cacher.(RateLimiter).Take(ctx, key, quota)
+		return err
+	})
+
+	return allowance, err
+}
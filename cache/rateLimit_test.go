@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRateLimiterTokenBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := NewMemoryRateLimiter()
+	limiter.now = func() time.Time { return now }
+	quota := Quota{Algorithm: TokenBucket, Limit: 2, Window: 10 * time.Second}
+
+	first, _ := limiter.Take(context.Background(), "user", quota)
+	second, _ := limiter.Take(context.Background(), "user", quota)
+	third, _ := limiter.Take(context.Background(), "user", quota)
+
+	assert.Equal(t, Allowance{Allowed: true, Limit: 2, Remaining: 1, Reset: 5 * time.Second}, first)
+	assert.Equal(t, Allowance{Allowed: true, Limit: 2, Remaining: 0, Reset: 10 * time.Second}, second)
+	assert.Equal(t, Allowance{Allowed: false, Limit: 2, Remaining: 0, Reset: 10 * time.Second, RetryAfter: 5 * time.Second}, third)
+
+	// another key has its own bucket
+	other, _ := limiter.Take(context.Background(), "other", quota)
+	assert.True(t, other.Allowed)
+
+	// one token is back after half the window
+	now = now.Add(5 * time.Second)
+	fourth, _ := limiter.Take(context.Background(), "user", quota)
+	assert.True(t, fourth.Allowed)
+	assert.Equal(t, 0, fourth.Remaining)
+}
+
+func TestMemoryRateLimiterSlidingWindow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := NewMemoryRateLimiter()
+	limiter.now = func() time.Time { return now }
+	quota := Quota{Algorithm: SlidingWindow, Limit: 4, Window: 10 * time.Second}
+
+	for i := 0; i < 4; i++ {
+		allowance, _ := limiter.Take(context.Background(), "user", quota)
+		assert.True(t, allowance.Allowed)
+		assert.Equal(t, 3-i, allowance.Remaining)
+	}
+
+	blocked, _ := limiter.Take(context.Background(), "user", quota)
+	assert.False(t, blocked.Allowed)
+	assert.Equal(t, 10*time.Second+10*time.Second/4, blocked.RetryAfter)
+	assert.Equal(t, 20*time.Second, blocked.Reset)
+
+	// halfway through the next window, half of the previous requests count
+	now = now.Add(15 * time.Second)
+	allowance, _ := limiter.Take(context.Background(), "user", quota)
+	assert.True(t, allowance.Allowed)
+	assert.Equal(t, 1, allowance.Remaining)
+
+	allowance, _ = limiter.Take(context.Background(), "user", quota)
+	assert.True(t, allowance.Allowed)
+	allowance, _ = limiter.Take(context.Background(), "user", quota)
+	assert.False(t, allowance.Allowed)
+	assert.Equal(t, 2500*time.Millisecond, allowance.RetryAfter)
+}
+
+func TestMemoryRateLimiterSweepsExpiredKeys(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := NewMemoryRateLimiter()
+	limiter.now = func() time.Time { return now }
+	quota := Quota{Algorithm: TokenBucket, Limit: 2, Window: time.Second}
+
+	limiter.Take(context.Background(), "user1", quota)
+	now = now.Add(2 * time.Minute)
+	limiter.Take(context.Background(), "user2", quota)
+
+	assert.Equal(t, 1, limiter.Len())
+}
+
+func TestRedisCacherTakeTokenBucket(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestRedisCacher(t, server)
+	quota := Quota{Algorithm: TokenBucket, Limit: 2, Window: time.Minute}
+
+	first, err := cacher.Take(context.Background(), "user", quota)
+	assert.Nil(t, err)
+	second, _ := cacher.Take(context.Background(), "user", quota)
+	third, _ := cacher.Take(context.Background(), "user", quota)
+
+	assert.True(t, first.Allowed)
+	assert.Equal(t, 1, first.Remaining)
+	assert.True(t, second.Allowed)
+	assert.False(t, third.Allowed)
+	assert.InDelta(t, 30*time.Second, third.RetryAfter, float64(time.Second))
+	assert.True(t, server.Exists("ratelimit:{user}"))
+}
+
+func TestRedisCacherTakeSlidingWindow(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestRedisCacher(t, server)
+	quota := Quota{Algorithm: SlidingWindow, Limit: 2, Window: time.Hour}
+
+	first, err := cacher.Take(context.Background(), "user", quota)
+	assert.Nil(t, err)
+	second, _ := cacher.Take(context.Background(), "user", quota)
+	third, _ := cacher.Take(context.Background(), "user", quota)
+
+	assert.True(t, first.Allowed)
+	assert.True(t, second.Allowed)
+	assert.False(t, third.Allowed)
+	assert.Equal(t, 0, third.Remaining)
+	assert.Greater(t, third.RetryAfter, time.Duration(0))
+}
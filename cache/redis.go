@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"goapi-template/config"
+	"os"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newRedisClient builds the client matching config.RedisMode. Every mode is
+// used through redis.UniversalClient so the cachers don't need to know which
+// one they are talking to.
+func newRedisClient(config *config.CacheConfiguration) (redis.UniversalClient, error) {
+	tlsConfig, err := newRedisTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := config.RedisAddresses
+	if len(addresses) == 0 {
+		addresses = []string{config.RedisAddress}
+	}
+
+	switch config.RedisMode {
+	case "cluster":
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        addresses,
+			Username:     config.RedisUsername,
+			Password:     config.RedisPassword,
+			TLSConfig:    tlsConfig,
+			PoolSize:     config.RedisPoolSize,
+			MinIdleConns: config.RedisMinIdleConns,
+			DialTimeout:  config.RedisDialTimeout,
+			ReadTimeout:  config.RedisReadTimeout,
+			WriteTimeout: config.RedisWriteTimeout,
+			PoolTimeout:  config.RedisPoolTimeout,
+		}), nil
+	case "sentinel":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       config.RedisMasterName,
+			SentinelAddrs:    addresses,
+			SentinelUsername: config.RedisSentinelUsername,
+			SentinelPassword: config.RedisSentinelPassword,
+			Username:         config.RedisUsername,
+			Password:         config.RedisPassword,
+			DB:               config.RedisDb,
+			TLSConfig:        tlsConfig,
+			PoolSize:         config.RedisPoolSize,
+			MinIdleConns:     config.RedisMinIdleConns,
+			DialTimeout:      config.RedisDialTimeout,
+			ReadTimeout:      config.RedisReadTimeout,
+			WriteTimeout:     config.RedisWriteTimeout,
+			PoolTimeout:      config.RedisPoolTimeout,
+		}), nil
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:         addresses[0],
+			Username:     config.RedisUsername,
+			Password:     config.RedisPassword,
+			DB:           config.RedisDb,
+			TLSConfig:    tlsConfig,
+			PoolSize:     config.RedisPoolSize,
+			MinIdleConns: config.RedisMinIdleConns,
+			DialTimeout:  config.RedisDialTimeout,
+			ReadTimeout:  config.RedisReadTimeout,
+			WriteTimeout: config.RedisWriteTimeout,
+			PoolTimeout:  config.RedisPoolTimeout,
+		}), nil
+	}
+}
+
+// newRedisTLSConfig returns nil when TLS is disabled.
+func newRedisTLSConfig(config *config.CacheConfiguration) (*tls.Config, error) {
+	if !config.RedisTLS {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         config.RedisTLSServerName,
+		InsecureSkipVerify: config.RedisTLSSkipVerify,
+	}
+
+	if config.RedisTLSCAFile != "" {
+		ca, err := os.ReadFile(config.RedisTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading redis CA file: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in redis CA file %s", config.RedisTLSCAFile)
+		}
+	}
+
+	if config.RedisTLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.RedisTLSCertFile, config.RedisTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading redis client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// forEachMaster runs fn against every master of a cluster, or against the
+// client itself otherwise, for commands such as SCAN that only see the keys
+// of the node they're sent to.
+func forEachMaster(ctx context.Context, client redis.UniversalClient, fn func(ctx context.Context, client redis.UniversalClient) error) error {
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return fn(ctx, node)
+		})
+	}
+
+	return fn(ctx, client)
+}
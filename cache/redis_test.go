@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"goapi-template/config"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeSelfSignedCert writes a certificate valid for 127.0.0.1 which also
+// acts as its own CA, and returns the paths to it and its key.
+func writeSelfSignedCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis.test"},
+		DNSNames:              []string{"redis.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return certFile, keyFile
+}
+
+func TestNewRedisClientModes(t *testing.T) {
+	for mode, expected := range map[string]any{
+		"single":   &redis.Client{},
+		"cluster":  &redis.ClusterClient{},
+		"sentinel": &redis.Client{},
+	} {
+		client, err := newRedisClient(&config.CacheConfiguration{RedisMode: mode, RedisAddresses: []string{"localhost:6379"}, RedisMasterName: "mymaster"})
+
+		assert.Nil(t, err)
+		assert.IsType(t, expected, client)
+		client.Close()
+	}
+}
+
+func TestRedisCacherWithUsername(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireUserAuth("api", "secret")
+
+	cacher, err := NewRawCacher(&config.CacheConfiguration{RedisAddresses: []string{server.Addr()}, RedisUsername: "api", RedisPassword: "secret"})
+	assert.Nil(t, err)
+	defer cacher.Close()
+
+	err = cacher.SetString(context.Background(), "key", "value")
+
+	assert.Nil(t, err)
+	value, _ := server.Get("key")
+	assert.Equal(t, "value", value)
+}
+
+func TestRedisCacherCluster(t *testing.T) {
+	server := miniredis.RunT(t)
+
+	cacher, err := NewRawCacher(&config.CacheConfiguration{RedisMode: "cluster", RedisAddresses: []string{server.Addr()}})
+	assert.Nil(t, err)
+	defer cacher.Close()
+
+	err = cacher.MSet(context.Background(), map[string]string{"person:1": "1", "person:2": "2", "tag:person": "1"})
+	assert.Nil(t, err)
+
+	values, err := cacher.MGet(context.Background(), "person:1", "person:2")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2"}, values)
+
+	err = cacher.DeleteByPattern(context.Background(), "person:*")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tag:person"}, server.Keys())
+}
+
+func TestRedisCacherTLS(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	assert.Nil(t, err)
+
+	pool := x509.NewCertPool()
+	ca, _ := os.ReadFile(certFile)
+	pool.AppendCertsFromPEM(ca)
+
+	server, err := miniredis.RunTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	})
+	assert.Nil(t, err)
+	defer server.Close()
+
+	cacher, err := NewRawCacher(&config.CacheConfiguration{
+		RedisAddresses:     []string{server.Addr()},
+		RedisTLS:           true,
+		RedisTLSCAFile:     certFile,
+		RedisTLSCertFile:   certFile,
+		RedisTLSKeyFile:    keyFile,
+		RedisTLSServerName: "redis.test",
+	})
+	assert.Nil(t, err)
+	defer cacher.Close()
+
+	err = cacher.SetString(context.Background(), "key", "value")
+
+	assert.Nil(t, err)
+	value, _ := server.Get("key")
+	assert.Equal(t, "value", value)
+}
+
+func TestNewRedisTLSConfigErrors(t *testing.T) {
+	_, err := newRedisTLSConfig(&config.CacheConfiguration{RedisTLS: true, RedisTLSCAFile: "missing.pem"})
+	assert.NotNil(t, err)
+
+	_, err = newRedisTLSConfig(&config.CacheConfiguration{RedisTLS: true, RedisTLSCertFile: "missing.pem", RedisTLSKeyFile: "missing.pem"})
+	assert.NotNil(t, err)
+
+	_, keyFile := writeSelfSignedCert(t)
+	_, err = newRedisTLSConfig(&config.CacheConfiguration{RedisTLS: true, RedisTLSCAFile: keyFile})
+	assert.NotNil(t, err)
+
+	tlsConfig, err := newRedisTLSConfig(&config.CacheConfiguration{})
+	assert.Nil(t, err)
+	assert.Nil(t, tlsConfig)
+}
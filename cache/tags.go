@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tags are implemented with generation numbers: every tag has a generation
+// stored under "tag:<name>" and tagged keys embed the generations of their
+// tags. Invalidating a tag moves it to a new generation, so every key that
+// embedded the old one is never read again and simply expires.
+
+func tagKey(tag string) string {
+	return "tag:" + tag
+}
+
+func newGeneration() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// TaggedKey namespaces key with the current generation of each of its tags.
+func TaggedKey(ctx context.Context, cacher Cacher, key string, tags ...string) (string, error) {
+	if len(tags) == 0 {
+		return key, nil
+	}
+
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = tagKey(tag)
+	}
+
+	generations, err := cacher.MGet(ctx, keys...)
+	if err != nil {
+		return "", err
+	}
+
+	missing := map[string]string{}
+	for i, generation := range generations {
+		if generation == "" {
+			generations[i] = newGeneration()
+			missing[keys[i]] = generations[i]
+		}
+	}
+
+	if len(missing) > 0 {
+		if err := cacher.MSet(ctx, missing); err != nil {
+			return "", err
+		}
+	}
+
+	return key + "@" + strings.Join(generations, "."), nil
+}
+
+// InvalidateTags invalidates every key tagged with any of the given tags.
+func InvalidateTags(ctx context.Context, cacher Cacher, tags ...string) error {
+	generations := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		generations[tagKey(tag)] = newGeneration()
+	}
+
+	return cacher.MSet(ctx, generations)
+}
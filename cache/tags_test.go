@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaggedKeyWithoutTags(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+
+	key, err := TaggedKey(context.Background(), cacher, "key")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "key", key)
+}
+
+func TestTaggedKeyIsStable(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+
+	key1, err := TaggedKey(context.Background(), cacher, "key", "tag1", "tag2")
+	key2, _ := TaggedKey(context.Background(), cacher, "key", "tag1", "tag2")
+
+	assert.Nil(t, err)
+	assert.Equal(t, key1, key2)
+	assert.Regexp(t, `^key@\w+\.\w+$`, key1)
+}
+
+func TestInvalidateTagsChangesKey(t *testing.T) {
+	cacher := NewMemoryCacher(10, 0, time.Hour)
+
+	tagged, _ := TaggedKey(context.Background(), cacher, "key", "tag1", "tag2")
+	untouched, _ := TaggedKey(context.Background(), cacher, "other", "tag1")
+
+	err := InvalidateTags(context.Background(), cacher, "tag2")
+	taggedAfter, _ := TaggedKey(context.Background(), cacher, "key", "tag1", "tag2")
+	untouchedAfter, _ := TaggedKey(context.Background(), cacher, "other", "tag1")
+
+	assert.Nil(t, err)
+	assert.NotEqual(t, tagged, taggedAfter)
+	assert.Equal(t, untouched, untouchedAfter)
+}
@@ -0,0 +1,309 @@
+package cache
+
+import (
+	"context"
+	"goapi-template/config"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// TieredCacher checks an in-process L1 cache before the shared L2 cache and
+// fills both. Writes and deletes are broadcast over Redis pub/sub so other
+// replicas drop their now stale L1 entries.
+type TieredCacher struct {
+	l1         *MemoryCacher
+	l2         *RedisCacher
+	channel    string
+	instanceId string
+	done       chan struct{}
+
+	mu sync.Mutex
+	// pubsub is replaced when l2 reconnects
+	pubsub *redis.PubSub
+	closed bool
+
+	// l1Mu orders the changes of L1 with its fills from L2, see fillL1
+	l1Mu sync.Mutex
+	// generation is bumped by every change of L1
+	generation atomic.Uint64
+}
+
+// changeL1 applies change to L1, the fills of L1 from L2 started before it
+// are dropped since they may have read what the change replaced.
+func (t *TieredCacher) changeL1(change func()) {
+	t.l1Mu.Lock()
+	defer t.l1Mu.Unlock()
+
+	t.generation.Add(1)
+	change()
+}
+
+// fillL1 applies fill, which stores the values read from L2 in L1, unless L1
+// changed since generation was loaded, before reading them. A value read
+// before another replica's invalidation was received would otherwise be
+// kept in L1 after it.
+func (t *TieredCacher) fillL1(generation uint64, fill func()) {
+	t.l1Mu.Lock()
+	defer t.l1Mu.Unlock()
+
+	if t.generation.Load() == generation {
+		fill()
+	}
+}
+
+func (t *TieredCacher) GetString(ctx context.Context, key string) (string, error) {
+	if value, _ := t.l1.GetString(ctx, key); value != "" {
+		return value, nil
+	}
+
+	generation := t.generation.Load()
+	value, err := t.l2.GetString(ctx, key)
+	if err != nil || value == "" {
+		return value, err
+	}
+
+	t.fillL1(generation, func() {
+		t.l1.SetString(ctx, key, value)
+	})
+
+	return value, nil
+}
+
+func (t *TieredCacher) SetString(ctx context.Context, key string, value string, opts ...SetOption) error {
+	if err := t.l2.SetString(ctx, key, value, opts...); err != nil {
+		// L2 may still hold an older value, don't let L1 disagree with it
+		t.changeL1(func() {
+			t.l1.DeleteKey(ctx, key)
+		})
+		return err
+	}
+
+	t.changeL1(func() {
+		t.l1.SetString(ctx, key, value, t.l1Options(opts)...)
+	})
+
+	return t.publish(ctx, invalidateKey, key)
+}
+
+func (t *TieredCacher) DeleteKey(ctx context.Context, key string) error {
+	t.changeL1(func() {
+		t.l1.DeleteKey(ctx, key)
+	})
+
+	if err := t.l2.DeleteKey(ctx, key); err != nil {
+		return err
+	}
+
+	return t.publish(ctx, invalidateKey, key)
+}
+
+// MGet only asks L2 for the keys L1 doesn't have.
+func (t *TieredCacher) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	values, _ := t.l1.MGet(ctx, keys...)
+
+	missing := make([]string, 0, len(keys))
+	for i, key := range keys {
+		if values[i] == "" {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	generation := t.generation.Load()
+	fetched, err := t.l2.MGet(ctx, missing...)
+	if err != nil {
+		return nil, err
+	}
+
+	fill := make(map[string]string, len(fetched))
+	for i, j := 0, 0; i < len(keys); i++ {
+		if values[i] != "" {
+			continue
+		}
+
+		values[i] = fetched[j]
+		if fetched[j] != "" {
+			fill[keys[i]] = fetched[j]
+		}
+		j++
+	}
+
+	t.fillL1(generation, func() {
+		t.l1.MSet(ctx, fill)
+	})
+
+	return values, nil
+}
+
+func (t *TieredCacher) MSet(ctx context.Context, values map[string]string, opts ...SetOption) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	if err := t.l2.MSet(ctx, values, opts...); err != nil {
+		t.changeL1(func() {
+			for _, key := range keys {
+				t.l1.DeleteKey(ctx, key)
+			}
+		})
+		return err
+	}
+
+	t.changeL1(func() {
+		t.l1.MSet(ctx, values, t.l1Options(opts)...)
+	})
+
+	return t.publish(ctx, invalidateKey, keys...)
+}
+
+func (t *TieredCacher) DeleteByPattern(ctx context.Context, pattern string) error {
+	var err error
+	t.changeL1(func() {
+		err = t.l1.DeleteByPattern(ctx, pattern)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := t.l2.DeleteByPattern(ctx, pattern); err != nil {
+		return err
+	}
+
+	return t.publish(ctx, invalidatePattern, pattern)
+}
+
+// Reconnect reconnects L2, the invalidations are then received from the new
+// connection.
+func (t *TieredCacher) Reconnect(config *config.CacheConfiguration) error {
+	return t.l2.Reconnect(config)
+}
+
+func (t *TieredCacher) Close() {
+	t.mu.Lock()
+	t.closed = true
+	pubsub := t.pubsub
+	t.mu.Unlock()
+
+	if err := pubsub.Close(); err != nil {
+		slog.Error("Error closing cache invalidation subscription", "error", err)
+	}
+
+	<-t.done
+
+	t.l1.Close()
+	t.l2.Close()
+}
+
+// l1Options keeps L1 entries from outliving the L1 expiration when a longer
+// one is requested for L2.
+func (t *TieredCacher) l1Options(opts []SetOption) []SetOption {
+	expiration := expirationOf(0, opts)
+	if expiration <= 0 || (t.l1.expiration > 0 && expiration > t.l1.expiration) {
+		return nil
+	}
+
+	return []SetOption{WithExpiration(expiration)}
+}
+
+const (
+	invalidateKey     = "key"
+	invalidatePattern = "pattern"
+)
+
+// invalidation messages are "<instance id>|<kind>|<key or pattern>" so each
+// replica can skip the messages it published itself
+func (t *TieredCacher) publish(ctx context.Context, kind string, values ...string) error {
+	_, err := t.l2.redisClient().Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, value := range values {
+			pipe.Publish(ctx, t.channel, t.instanceId+"|"+kind+"|"+value)
+		}
+		return nil
+	})
+
+	return err
+}
+
+func (t *TieredCacher) listen() {
+	defer close(t.done)
+
+	for {
+		t.mu.Lock()
+		pubsub, closed := t.pubsub, t.closed
+		t.mu.Unlock()
+
+		if closed {
+			return
+		}
+
+		// the channel is closed when the cacher is closed or when the
+		// subscription is replaced after a reconnection
+		for message := range pubsub.Channel() {
+			parts := strings.SplitN(message.Payload, "|", 3)
+			if len(parts) != 3 || parts[0] == t.instanceId {
+				continue
+			}
+
+			t.changeL1(func() {
+				switch parts[1] {
+				case invalidateKey:
+					t.l1.DeleteKey(context.Background(), parts[2])
+				case invalidatePattern:
+					t.l1.DeleteByPattern(context.Background(), parts[2])
+				}
+			})
+		}
+	}
+}
+
+// subscribe waits for the subscription to be confirmed so no invalidation is
+// missed, go-redis keeps retrying in the background if redis is unavailable.
+func (t *TieredCacher) subscribe(client redis.UniversalClient) *redis.PubSub {
+	pubsub := client.Subscribe(context.Background(), t.channel)
+	if _, err := pubsub.Receive(context.Background()); err != nil {
+		slog.Error("Error subscribing to cache invalidation channel", "channel", t.channel, "error", err)
+	}
+
+	return pubsub
+}
+
+// resubscribe moves the subscription to the client L2 reconnected with.
+func (t *TieredCacher) resubscribe(client redis.UniversalClient) {
+	pubsub := t.subscribe(client)
+
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		pubsub.Close()
+		return
+	}
+	previous := t.pubsub
+	t.pubsub = pubsub
+	t.mu.Unlock()
+
+	previous.Close()
+}
+
+func NewTieredCacher(l1 *MemoryCacher, l2 *RedisCacher, channel string) *TieredCacher {
+	cacher := &TieredCacher{
+		l1:         l1,
+		l2:         l2,
+		channel:    channel,
+		instanceId: uuid.NewString(),
+		done:       make(chan struct{}),
+	}
+	cacher.pubsub = cacher.subscribe(l2.redisClient())
+	l2.onReconnect(cacher.resubscribe)
+
+	go cacher.listen()
+
+	return cacher
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"goapi-template/config"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTieredCacher(t *testing.T, server *miniredis.Miniredis) *TieredCacher {
+	l2, err := NewRawCacher(&config.CacheConfiguration{RedisAddress: server.Addr(), Expiration: time.Hour})
+	assert.Nil(t, err)
+	cacher := NewTieredCacher(NewMemoryCacher(10, 0, time.Hour), l2, "cache:invalidate")
+	t.Cleanup(cacher.Close)
+
+	return cacher
+}
+
+func TestTieredCacherFillsL1FromL2(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestTieredCacher(t, server)
+	server.Set("key", "value")
+
+	result, err := cacher.GetString(context.Background(), "key")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "value", result)
+
+	// served from L1 even after L2 loses the key
+	server.Del("key")
+	result, _ = cacher.GetString(context.Background(), "key")
+	assert.Equal(t, "value", result)
+}
+
+func TestTieredCacherSetWritesBothTiers(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestTieredCacher(t, server)
+
+	err := cacher.SetString(context.Background(), "key", "value")
+
+	assert.Nil(t, err)
+	l1, _ := cacher.l1.GetString(context.Background(), "key")
+	l2, _ := server.Get("key")
+	assert.Equal(t, "value", l1)
+	assert.Equal(t, "value", l2)
+}
+
+func TestTieredCacherMiss(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestTieredCacher(t, server)
+
+	result, err := cacher.GetString(context.Background(), "key")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", result)
+}
+
+func TestTieredCacherInvalidatesOtherReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	replica1 := newTestTieredCacher(t, server)
+	replica2 := newTestTieredCacher(t, server)
+
+	replica1.SetString(context.Background(), "key", "value1")
+	result, _ := replica2.GetString(context.Background(), "key")
+	assert.Equal(t, "value1", result)
+
+	replica1.SetString(context.Background(), "key", "value2")
+
+	assert.Eventually(t, func() bool {
+		result, _ := replica2.GetString(context.Background(), "key")
+		return result == "value2"
+	}, time.Second, 10*time.Millisecond)
+
+	replica1.DeleteKey(context.Background(), "key")
+
+	assert.Eventually(t, func() bool {
+		result, _ := replica2.GetString(context.Background(), "key")
+		return result == ""
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTieredCacherDropsFillsRacingInvalidations(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestTieredCacher(t, server)
+	ctx := context.Background()
+
+	generation := cacher.generation.Load()
+	// the invalidation is received while the value is read from L2
+	cacher.changeL1(func() {
+		cacher.l1.DeleteKey(ctx, "key")
+	})
+	cacher.fillL1(generation, func() {
+		cacher.l1.SetString(ctx, "key", "stale")
+	})
+
+	value, _ := cacher.l1.GetString(ctx, "key")
+	assert.Empty(t, value)
+
+	// the reads started afterwards fill L1
+	server.Set("key", "fresh")
+	cacher.GetString(ctx, "key")
+	value, _ = cacher.l1.GetString(ctx, "key")
+	assert.Equal(t, "fresh", value)
+}
+
+func TestTieredCacherReconnectResubscribes(t *testing.T) {
+	server := miniredis.RunT(t)
+	replica1 := newTestTieredCacher(t, server)
+	replica2 := newTestTieredCacher(t, server)
+
+	err := replica2.Reconnect(&config.CacheConfiguration{RedisAddress: server.Addr(), Expiration: time.Hour})
+	assert.Nil(t, err)
+
+	replica1.SetString(context.Background(), "key", "value1")
+	replica2.GetString(context.Background(), "key")
+	replica1.SetString(context.Background(), "key", "value2")
+
+	assert.Eventually(t, func() bool {
+		result, _ := replica2.GetString(context.Background(), "key")
+		return result == "value2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTieredCacherMGetFillsL1(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestTieredCacher(t, server)
+	cacher.l1.SetString(context.Background(), "key1", "l1")
+	server.Set("key1", "l2")
+	server.Set("key2", "value2")
+
+	result, err := cacher.MGet(context.Background(), "key1", "key2", "key3")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"l1", "value2", ""}, result)
+	l1, _ := cacher.l1.GetString(context.Background(), "key2")
+	assert.Equal(t, "value2", l1)
+}
+
+func TestTieredCacherL1DoesNotOutliveItsExpiration(t *testing.T) {
+	server := miniredis.RunT(t)
+	cacher := newTestTieredCacher(t, server)
+
+	assert.Nil(t, cacher.l1Options([]SetOption{WithExpiration(2 * time.Hour)}))
+	assert.Nil(t, cacher.l1Options(nil))
+	assert.Len(t, cacher.l1Options([]SetOption{WithExpiration(time.Minute)}), 1)
+}
+
+func TestTieredCacherInvalidatesPatternOnOtherReplicas(t *testing.T) {
+	server := miniredis.RunT(t)
+	replica1 := newTestTieredCacher(t, server)
+	replica2 := newTestTieredCacher(t, server)
+
+	server.Set("person:1", "1")
+	server.Set("person:2", "2")
+	replica2.MGet(context.Background(), "person:1", "person:2")
+	assert.Equal(t, 2, replica2.l1.Len())
+
+	replica1.DeleteByPattern(context.Background(), "person:*")
+
+	assert.Eventually(t, func() bool {
+		return replica2.l1.Len() == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, server.Keys())
+}
+
+func TestNewCacherProviders(t *testing.T) {
+	server := miniredis.RunT(t)
+	configValues := &config.CacheConfiguration{RedisAddress: server.Addr(), Expiration: time.Hour, MemoryMaxEntries: 10}
+
+	for provider, expected := range map[string]any{"redis": &RedisCacher{}, "memory": &MemoryCacher{}, "tiered": &TieredCacher{}} {
+		configValues.Provider = provider
+		cacher, dispose, err := NewCacher(configValues)
+
+		assert.Nil(t, err)
+		assert.IsType(t, expected, cacher)
+		dispose()
+	}
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+type AdminConfiguration struct {
+	// Address is where the admin server listens, e.g. localhost:9000. The
+	// admin server is disabled when empty
+	Address string `env:"ADMIN_ADDRESS"`
+	// Token is the bearer token of the admin endpoints, only the health
+	// checks and the metrics are served without it
+	Token string `env:"ADMIN_TOKEN" secret:"true"`
+	// LogLevel is the initial level of the logs, the admin server can
+	// change it
+	LogLevel string `env:"LOG_LEVEL" default:"info" validate:"oneof=debug info warn error"`
+}
+
+func loadAdminConfig() (*AdminConfiguration, error) {
+	return newLoader(nil).admin()
+}
+
+func (l *loader) admin() (*AdminConfiguration, error) {
+	config := &AdminConfiguration{}
+	errs := l.decode(config)
+	errs = append(errs, l.check(config)...)
+
+	if config.Address != "" && config.Token == "" {
+		errs = append(errs, fmt.Errorf("ADMIN_TOKEN is required when ADMIN_ADDRESS is set"))
+	}
+
+	return config, errors.Join(errs...)
+}
@@ -0,0 +1,455 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-playground/validator/v10"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Sources of a setting, by increasing precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceDotenv  = ".env"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// sections are the configuration structs settings are read into. Their fields
+// are tagged with:
+//   - env: the environment variable of the setting. The same name, lower or
+//     upper case, is its key in the config file, and its flag is the name in
+//     kebab case, e.g. --db-max-conns for DB_MAX_CONNS
+//   - default: the value of the setting when no layer sets it
+//   - validate: validator rules checked once every layer is applied
+//   - secret: masks the setting when printed redacted
+//
+// Every setting can be set to a reference to a secret, such as
+// file:///run/secrets/db_password, see SecretResolver.
+var sections = []any{
+	&WebServerConfiguration{},
+	&AuthConfiguration{},
+	&DatabaseConfiguration{},
+	&CacheConfiguration{},
+	&SecretsConfiguration{},
+	&RateLimitConfiguration{},
+	&AdminConfiguration{},
+}
+
+// loader reads settings from, by increasing precedence, their defaults, the
+// config file, the .env file, the environment and the command line flags.
+type loader struct {
+	env    map[string]string
+	dotenv map[string]string
+	file   map[string]string
+	flags  map[string]string
+	// dotenvErr is why .env couldn't be read, if it couldn't
+	dotenvErr error
+	// errs are the errors found reading the layers
+	errs []error
+	// invalid holds the settings that couldn't be parsed, they aren't
+	// validated
+	invalid map[string]bool
+	// secrets configures how references are resolved, by resolvers
+	secrets   *SecretsConfiguration
+	resolvers map[string]SecretResolver
+
+	// Sources holds the source of every setting read, by env name
+	Sources map[string]string
+	// References holds the secret references settings were set to, by env
+	// name
+	References map[string]string
+}
+
+func newLoader(args []string) *loader {
+	l := &loader{
+		env:        map[string]string{},
+		dotenv:     map[string]string{},
+		file:       map[string]string{},
+		flags:      map[string]string{},
+		invalid:    map[string]bool{},
+		Sources:    map[string]string{},
+		References: map[string]string{},
+	}
+
+	for _, entry := range os.Environ() {
+		key, value, _ := strings.Cut(entry, "=")
+		l.env[key] = value
+	}
+
+	configFile := l.parseFlags(args)
+	if configFile == "" {
+		configFile = l.env["CONFIG_FILE"]
+	}
+	if configFile != "" {
+		l.readFile(configFile)
+	}
+
+	l.dotenv, l.dotenvErr = godotenv.Read()
+
+	l.secrets = &SecretsConfiguration{}
+	l.errs = append(l.errs, l.decode(l.secrets)...)
+	l.errs = append(l.errs, l.check(l.secrets)...)
+	l.resolvers = l.secretResolvers()
+
+	return l
+}
+
+// exportDotenv sets the variables of .env that aren't in the environment,
+// for the packages that read their settings from the environment.
+func (l *loader) exportDotenv() {
+	for key, value := range l.dotenv {
+		if _, ok := l.env[key]; !ok {
+			os.Setenv(key, value)
+		}
+	}
+}
+
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// settingFlag records the flags set on the command line.
+type settingFlag struct {
+	key    string
+	flags  map[string]string
+	isBool bool
+}
+
+func (f *settingFlag) String() string { return "" }
+
+func (f *settingFlag) Set(value string) error {
+	f.flags[f.key] = value
+	return nil
+}
+
+func (f *settingFlag) IsBoolFlag() bool { return f.isBool }
+
+// parseFlags reads args into l.flags and returns the --config flag.
+func (l *loader) parseFlags(args []string) string {
+	flags := flag.NewFlagSet("goapi-template", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	configFile := flags.String("config", "", "YAML, JSON or TOML config file")
+	for _, section := range sections {
+		eachSetting(reflect.ValueOf(section).Elem(), func(key string, field reflect.StructField, _ reflect.Value) {
+			flags.Var(&settingFlag{key: key, flags: l.flags, isBool: field.Type.Kind() == reflect.Bool}, flagName(key), key)
+		})
+	}
+
+	if err := flags.Parse(args); err != nil {
+		l.errs = append(l.errs, err)
+	}
+
+	return *configFile
+}
+
+// readFile reads a flat YAML, JSON or TOML file of settings, keyed by their
+// env name.
+func (l *loader) readFile(path string) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("cannot read config file: %w", err))
+		return
+	}
+
+	values := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		err = yaml.Unmarshal(content, &values)
+	case ".toml":
+		err = toml.Unmarshal(content, &values)
+	default:
+		err = fmt.Errorf("must be a .yaml, .yml, .json or .toml file")
+	}
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("config file %s: %w", path, err))
+		return
+	}
+
+	known := map[string]bool{}
+	for _, section := range sections {
+		eachSetting(reflect.ValueOf(section).Elem(), func(key string, _ reflect.StructField, _ reflect.Value) {
+			known[key] = true
+		})
+	}
+
+	for key, value := range values {
+		if !known[strings.ToUpper(key)] {
+			l.errs = append(l.errs, fmt.Errorf("config file %s: unknown setting %s", path, key))
+			continue
+		}
+		l.file[strings.ToUpper(key)] = fileValue(value)
+	}
+}
+
+// fileValue formats file values the way they are set in the environment.
+func fileValue(value any) string {
+	switch value := value.(type) {
+	case []any:
+		items := make([]string, len(value))
+		for i, item := range value {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	case map[string]any:
+		items := make([]string, 0, len(value))
+		for key, item := range value {
+			if list, ok := item.([]any); ok {
+				// lists of a map are space separated, see parseSetting
+				words := make([]string, len(list))
+				for i, word := range list {
+					words[i] = fmt.Sprint(word)
+				}
+				item = strings.Join(words, " ")
+			}
+			items = append(items, key+"="+fmt.Sprint(item))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// lookup returns the value of key from the layer of highest precedence that
+// sets it.
+func (l *loader) lookup(key string) (string, string, bool) {
+	if value, ok := l.flags[key]; ok {
+		return value, SourceFlag, true
+	}
+	if value, ok := l.env[key]; ok {
+		return value, SourceEnv, true
+	}
+	if value, ok := l.dotenv[key]; ok {
+		return value, SourceDotenv, true
+	}
+	if value, ok := l.file[key]; ok {
+		return value, SourceFile, true
+	}
+	return "", "", false
+}
+
+// isSet tells whether a layer sets key, as opposed to its default.
+func (l *loader) isSet(key string) bool {
+	_, _, ok := l.lookup(key)
+	return ok
+}
+
+func eachSetting(section reflect.Value, fn func(key string, field reflect.StructField, value reflect.Value)) {
+	for i := 0; i < section.NumField(); i++ {
+		field := section.Type().Field(i)
+		if key, ok := field.Tag.Lookup("env"); ok {
+			fn(key, field, section.Field(i))
+		}
+	}
+}
+
+// decode sets the settings of section from the layers, or their default, and
+// returns the values that can't be parsed or resolved.
+func (l *loader) decode(section any) []error {
+	var errs []error
+
+	eachSetting(reflect.ValueOf(section).Elem(), func(key string, field reflect.StructField, value reflect.Value) {
+		raw, source, ok := l.lookup(key)
+		// empty values only make sense for strings and lists
+		if ok && strings.TrimSpace(raw) == "" && value.Kind() != reflect.String && value.Kind() != reflect.Slice {
+			ok = false
+		}
+		if !ok {
+			raw, ok = field.Tag.Lookup("default")
+			source = SourceDefault
+		}
+		l.Sources[key] = source
+		if !ok {
+			return
+		}
+
+		if source != SourceDefault {
+			secret, isReference, err := resolveSecret(l.resolvers, raw)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %s", key, err))
+				l.invalid[key] = true
+				return
+			}
+			if isReference {
+				l.References[key] = strings.TrimSpace(raw)
+				raw = secret
+			}
+		}
+
+		if err := parseSetting(value, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s", key, err))
+			l.invalid[key] = true
+		}
+	})
+
+	return errs
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func parseSetting(value reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	switch {
+	case value.Type() == durationType:
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return errors.New("must be a duration such as 30s or 5m")
+		}
+		value.SetInt(int64(parsed))
+	case value.Kind() == reflect.String:
+		value.SetString(raw)
+	case value.Kind() == reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return errors.New("must be true or false")
+		}
+		value.SetBool(parsed)
+	case value.Kind() == reflect.Int, value.Kind() == reflect.Int32:
+		parsed, err := strconv.ParseInt(raw, 10, value.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		value.SetInt(parsed)
+	case value.Kind() == reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		value.SetFloat(parsed)
+	case value.Kind() == reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	case value.Type() == reflect.TypeOf(map[string]time.Duration{}):
+		parsed := map[string]time.Duration{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, duration, _ := strings.Cut(item, "=")
+			expiration, err := time.ParseDuration(duration)
+			if key == "" || err != nil || expiration <= 0 {
+				return errors.New("must be a comma separated list of entity=duration")
+			}
+			parsed[key] = expiration
+		}
+		value.Set(reflect.ValueOf(parsed))
+	case value.Type() == reflect.TypeOf(map[string]string{}):
+		parsed := map[string]string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, entry, _ := strings.Cut(item, "=")
+			if key = strings.TrimSpace(key); key == "" || strings.TrimSpace(entry) == "" {
+				return errors.New("must be a comma separated list of key=value")
+			}
+			parsed[key] = strings.TrimSpace(entry)
+		}
+		value.Set(reflect.ValueOf(parsed))
+	case value.Type() == reflect.TypeOf(map[string][]string{}):
+		parsed := map[string][]string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, list, _ := strings.Cut(item, "=")
+			if key = strings.TrimSpace(key); key == "" || strings.TrimSpace(list) == "" {
+				return errors.New("must be a comma separated list of key=value, with space separated values")
+			}
+			parsed[key] = strings.Fields(list)
+		}
+		value.Set(reflect.ValueOf(parsed))
+	default:
+		return fmt.Errorf("has an unsupported type %s", value.Type())
+	}
+
+	return nil
+}
+
+var validate = validator.New()
+
+// check validates the settings of section against their validate tag.
+func (l *loader) check(section any) []error {
+	var errs []error
+
+	eachSetting(reflect.ValueOf(section).Elem(), func(key string, field reflect.StructField, value reflect.Value) {
+		rules, ok := field.Tag.Lookup("validate")
+		if !ok || l.invalid[key] {
+			return
+		}
+
+		if err := validate.Var(value.Interface(), rules); err != nil {
+			var fieldErrs validator.ValidationErrors
+			if errors.As(err, &fieldErrs) {
+				errs = append(errs, validationMessage(key, field.Type, fieldErrs[0]))
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	})
+
+	return errs
+}
+
+var oneOfValue = regexp.MustCompile(`'[^']*'|\S+`)
+
+func validationMessage(key string, fieldType reflect.Type, fe validator.FieldError) error {
+	switch fe.Tag() {
+	case "required":
+		return fmt.Errorf("%s is a required parameter", key)
+	case "oneof":
+		values := oneOfValue.FindAllString(fe.Param(), -1)
+		for i, value := range values {
+			values[i] = strings.Trim(value, "'")
+		}
+		return fmt.Errorf("%s must be one of %s", key, joinOr(values))
+	case "gt":
+		if fe.Param() == "0" {
+			if fieldType == durationType {
+				return fmt.Errorf("%s must be a positive duration", key)
+			}
+			return fmt.Errorf("%s must be a positive number", key)
+		}
+		return fmt.Errorf("%s must be greater than %s", key, fe.Param())
+	case "gte":
+		if fe.Param() == "0" {
+			return fmt.Errorf("%s must not be negative", key)
+		}
+		return fmt.Errorf("%s must be at least %s", key, fe.Param())
+	case "lte":
+		return fmt.Errorf("%s must be at most %s", key, fe.Param())
+	}
+
+	return fmt.Errorf("%s is invalid (%s)", key, fe.Tag())
+}
+
+// joinOr joins values as "a, b or c"
+func joinOr(values []string) string {
+	if len(values) < 2 {
+		return strings.Join(values, "")
+	}
+
+	return strings.Join(values[:len(values)-1], ", ") + " or " + values[len(values)-1]
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// inTempDir runs the test from an empty directory, where files such as .env
+// are written.
+func inTempDir(t *testing.T) string {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	t.Cleanup(func() { os.Chdir(wd) })
+	return dir
+}
+
+func TestLoaderPrecedence(t *testing.T) {
+	dir := inTempDir(t)
+	os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+db_tx_max_retries: 1
+db_max_conns: 10
+db_min_conns: 2
+DB_STATEMENT_TIMEOUT: 2s
+`), 0644)
+	os.WriteFile(filepath.Join(dir, ".env"), []byte("DB_MAX_CONNS=20\nDB_MIN_CONNS=3\n"), 0644)
+	t.Setenv("DB_MIN_CONNS", "4")
+
+	l := newLoader([]string{"--config", filepath.Join(dir, "config.yaml"), "--db-statement-timeout", "3s"})
+	config, err := l.database()
+
+	assert.Nil(t, err)
+	assert.Empty(t, l.errs)
+	assert.Equal(t, 1, config.TxMaxRetries)
+	assert.Equal(t, int32(20), config.MaxConns)
+	assert.Equal(t, int32(4), config.MinConns)
+	assert.Equal(t, 3*time.Second, config.StatementTimeout)
+	assert.Equal(t, 500*time.Millisecond, config.SlowQueryThreshold)
+
+	assert.Equal(t, SourceFile, l.Sources["DB_TX_MAX_RETRIES"])
+	assert.Equal(t, SourceDotenv, l.Sources["DB_MAX_CONNS"])
+	assert.Equal(t, SourceEnv, l.Sources["DB_MIN_CONNS"])
+	assert.Equal(t, SourceFlag, l.Sources["DB_STATEMENT_TIMEOUT"])
+	assert.Equal(t, SourceDefault, l.Sources["DB_SLOW_QUERY_THRESHOLD"])
+}
+
+func TestLoaderConfigFileFromEnv(t *testing.T) {
+	dir := inTempDir(t)
+	os.WriteFile(filepath.Join(dir, "config.toml"), []byte(`
+enable_transparent_cache = true
+redis_addresses = ["redis-1:6379", "redis-2:6379"]
+cache_expiration_jitter = 0.5
+
+[cache_entity_expirations]
+person = "10m"
+
+[cors_route_origins]
+"/public/" = ["*"]
+`), 0644)
+	t.Setenv("CONFIG_FILE", filepath.Join(dir, "config.toml"))
+
+	l := newLoader(nil)
+	config, err := l.cache()
+
+	assert.Nil(t, err)
+	assert.True(t, config.EnableTransparentCaching)
+	assert.Equal(t, []string{"redis-1:6379", "redis-2:6379"}, config.RedisAddresses)
+	assert.Equal(t, 0.5, config.ExpirationJitter)
+	assert.Equal(t, map[string]time.Duration{"person": 10 * time.Minute}, config.EntityExpirations)
+
+	webServerConfig, _ := l.webServer()
+	assert.Equal(t, map[string][]string{"/public/": {"*"}}, webServerConfig.CorsRouteOrigins)
+}
+
+func TestLoaderConfigFileErrors(t *testing.T) {
+	dir := inTempDir(t)
+	os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"db_max_conns": 10, "db_max_connections": 10}`), 0644)
+
+	l := newLoader([]string{"--config", filepath.Join(dir, "config.json")})
+
+	assert.Len(t, l.errs, 1)
+	assert.Contains(t, l.errs[0].Error(), "unknown setting db_max_connections")
+
+	l = newLoader([]string{"--config", filepath.Join(dir, "config.ini")})
+
+	assert.Len(t, l.errs, 1)
+	assert.Contains(t, l.errs[0].Error(), "cannot read config file")
+}
+
+func TestLoaderFlags(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("ENV", "TEST")
+
+	l := newLoader([]string{"--enable-swagger", "--web-port=:9000", "--db-connection-string", "postgres://db"})
+	config, err := l.webServer()
+
+	assert.Nil(t, err)
+	assert.True(t, config.EnableSwagger)
+	assert.Equal(t, ":9000", config.WebPort)
+	assert.Equal(t, "postgres://db", config.ConnectionString)
+
+	l = newLoader([]string{"--web-prot=:9000"})
+
+	assert.Len(t, l.errs, 1)
+	assert.Contains(t, l.errs[0].Error(), "web-prot")
+}
+
+func TestLoaderAggregatesErrors(t *testing.T) {
+	t.Setenv("DB_TX_MAX_RETRIES", "many")
+	t.Setenv("DB_AUTO_MIGRATE", "yes")
+	t.Setenv("DB_TX_ISOLATION_LEVEL", "snapshot")
+	t.Setenv("DB_MAX_CONNS", "-1")
+
+	_, err := loadDatabaseConfig()
+
+	assert.Equal(t, "DB_TX_MAX_RETRIES must be an integer\n"+
+		"DB_AUTO_MIGRATE must be true or false\n"+
+		"DB_TX_ISOLATION_LEVEL must be one of read committed, repeatable read or serializable\n"+
+		"DB_MAX_CONNS must not be negative", err.Error())
+}
+
+func TestLoaderEmptyValueKeepsDefault(t *testing.T) {
+	t.Setenv("DB_AUTO_MIGRATE", "")
+
+	config, err := loadDatabaseConfig()
+
+	assert.Nil(t, err)
+	assert.True(t, config.AutoMigrate)
+}
+
+func TestInspectReportsInvalidConfig(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_TX_MAX_RETRIES", "many")
+
+	config, err := Inspect(nil)
+
+	assert.NotNil(t, config.DatabaseConfig)
+	assert.Contains(t, err.Error(), "DB_CONNECTION_STRING is a required parameter")
+	assert.Contains(t, err.Error(), "AUTH_CONFIG_URL is a required parameter")
+	assert.Contains(t, err.Error(), "DB_TX_MAX_RETRIES must be an integer")
+}
+
+func TestPrintRedacted(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_CONNECTION_STRING", "postgres://user:password@db/api")
+	t.Setenv("AUTH_CONFIG_URL", "http://provider")
+
+	config, err := Inspect([]string{"--web-port", ":9000"})
+	assert.Nil(t, err)
+
+	buffer := new(bytes.Buffer)
+	config.Print(buffer, true)
+
+	assert.Contains(t, buffer.String(), "# web server\n")
+	assert.Contains(t, buffer.String(), "WEB_PORT=:9000 # flag\n")
+	assert.Contains(t, buffer.String(), "DB_CONNECTION_STRING=<redacted> # env\n")
+	assert.Contains(t, buffer.String(), "DB_TX_ISOLATION_LEVEL=read committed # default\n")
+	assert.Contains(t, buffer.String(), "REDIS_PASSWORD= # default\n")
+	assert.NotContains(t, buffer.String(), "password@db")
+
+	buffer.Reset()
+	config.Print(buffer, false)
+
+	assert.Contains(t, buffer.String(), "DB_CONNECTION_STRING=postgres://user:password@db/api # env\n")
+}
@@ -2,81 +2,356 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/rs/cors"
 )
 
 type AuthConfiguration struct {
-	Issuer          string   `json:"issuer"`
-	JWKSUri         string   `json:"jwks_uri"`
-	TokenSigningAlg []string `json:"id_token_signing_alg_values_supported"`
-	Audience        string   `json:"audience"`
-	ScopeClaim      string   `json:"scope_claim"`
-	Scopes          []string `json:"scopes"`
-	ClaimFields     []string `json:"claims"`
+	// Issuer, JWKSUri and TokenSigningAlg are read from the OpenID
+	// configuration of the provider unless they are set
+	Issuer          string   `json:"issuer" env:"AUTH_ISSUER"`
+	JWKSUri         string   `json:"jwks_uri" env:"AUTH_JWKS_URI"`
+	TokenSigningAlg []string `json:"id_token_signing_alg_values_supported" env:"AUTH_SIGNING_ALGS"`
+	// JWKS is a static JSON Web Key Set used instead of fetching JWKSUri, for
+	// air-gapped environments. JWKSFile is read into it
+	JWKS     string `json:"-" env:"AUTH_JWKS"`
+	JWKSFile string `json:"-" env:"AUTH_JWKS_FILE"`
+	// ConfigURL is the OpenID configuration document of the provider, it can
+	// be left empty when the keys are set with JWKS, JWKSFile or JWKSUri
+	ConfigURL string `json:"-" env:"AUTH_CONFIG_URL"`
+	// DiscoveryRetries is how many times fetching the OpenID configuration
+	// and the keys is retried, waiting DiscoveryBackoff then twice as long
+	// after every attempt
+	DiscoveryRetries int           `json:"-" env:"AUTH_DISCOVERY_RETRIES" default:"3" validate:"gte=0"`
+	DiscoveryBackoff time.Duration `json:"-" env:"AUTH_DISCOVERY_BACKOFF" default:"1s" validate:"gt=0"`
+	// AllowDegraded starts the API when the provider can't be reached, the
+	// authenticated routes answer 503 until it can
+	AllowDegraded bool     `json:"-" env:"AUTH_ALLOW_DEGRADED"`
+	Audience      string   `json:"audience" env:"AUTH_AUDIENCE"`
+	ScopeClaim    string   `json:"scope_claim" env:"AUTH_SCOPE_CLAIM" default:"scp"`
+	Scopes        []string `json:"scopes" env:"AUTH_SCOPES"`
+	ClaimFields   []string `json:"claims" env:"AUTH_CLAIMS"`
+	// TenantClaim is the token claim holding the caller's tenant, every
+	// caller belongs to the default tenant when empty
+	TenantClaim string `json:"-" env:"AUTH_TENANT_CLAIM"`
+	// TenantHeader is the request header service principals set to act on
+	// behalf of a tenant
+	TenantHeader string `json:"-" env:"AUTH_TENANT_HEADER" default:"X-Tenant-ID"`
+	// ServicePrincipals are the subjects allowed to use TenantHeader
+	ServicePrincipals []string `json:"-" env:"AUTH_SERVICE_PRINCIPALS"`
+	// ClientCertIdentity authenticates the requests without a bearer token
+	// by their verified client certificate, see TLS_CLIENT_AUTH. The user ID
+	// is the common name of its subject, or its first URI, DNS or email SAN.
+	// Empty disables it
+	ClientCertIdentity string `json:"-" env:"AUTH_CLIENT_CERT_IDENTITY" validate:"omitempty,oneof=subject san"`
 }
 
 type WebServerConfiguration struct {
-	Env              string
-	Cors             cors.Cors
-	EnableSwagger    bool
-	WebPort          string
-	TLSCertFile      string
-	TLSCertKeyFile   string
-	ConnectionString string
+	Env string `env:"ENV"`
+	// AllowedOrigin is the single allowed origin of earlier versions, used
+	// when CorsAllowedOrigins isn't set
+	AllowedOrigin string `env:"ALLOWED_ORIGIN"`
+	// CorsAllowedOrigins can hold one wildcard each, e.g.
+	// https://*.example.com
+	CorsAllowedOrigins   []string      `env:"CORS_ALLOWED_ORIGINS"`
+	CorsAllowedMethods   []string      `env:"CORS_ALLOWED_METHODS" default:"GET,HEAD,POST,PUT,PATCH,DELETE"`
+	CorsAllowedHeaders   []string      `env:"CORS_ALLOWED_HEADERS" default:"Accept,Authorization,Content-Type,If-Match,If-None-Match,X-Tenant-ID"`
+	CorsExposedHeaders   []string      `env:"CORS_EXPOSED_HEADERS" default:"ETag,X-Trace-Id"`
+	CorsAllowCredentials bool          `env:"CORS_ALLOW_CREDENTIALS"`
+	CorsMaxAge           time.Duration `env:"CORS_MAX_AGE" default:"10m" validate:"gte=0"`
+	// CorsRouteOrigins overrides the allowed origins of the paths starting
+	// with its keys, e.g. "/public/=*"
+	CorsRouteOrigins map[string][]string `env:"CORS_ROUTE_ORIGINS"`
+	EnableSwagger    bool                `env:"ENABLE_SWAGGER"`
+	WebPort          string              `env:"WEB_PORT" default:"localhost:8000"`
+	TLSCertFile      string              `env:"TLS_CERT_FILE"`
+	TLSCertKeyFile   string              `env:"TLS_CERT_KEY_FILE"`
+	TLSMinVersion    string              `env:"TLS_MIN_VERSION" default:"1.2" validate:"oneof=1.0 1.1 1.2 1.3"`
+	// TLSCipherSuites restricts the cipher suites of TLS 1.2 and below, TLS
+	// 1.3 suites aren't configurable
+	TLSCipherSuites []string `env:"TLS_CIPHER_SUITES"`
+	// TLSReloadInterval is how often the certificate files are checked for
+	// changes, 0 disables the reload
+	TLSReloadInterval time.Duration `env:"TLS_RELOAD_INTERVAL" default:"30s" validate:"gte=0"`
+	// TLSClientAuth is none, optional to verify the client certificates
+	// that are sent, or require to reject the clients without one
+	TLSClientAuth    string `env:"TLS_CLIENT_AUTH" default:"none" validate:"oneof=none optional require"`
+	TLSClientCAFile  string `env:"TLS_CLIENT_CA_FILE"`
+	ConnectionString string `env:"DB_CONNECTION_STRING" validate:"required" secret:"true"`
+	// EmailProviderRules normalizes emails with the rules of well known
+	// providers, see models.EmailNormalizer
+	EmailProviderRules bool `env:"EMAIL_PROVIDER_RULES"`
+	// ReadHeaderTimeout guards against clients sending their headers slowly,
+	// 0 disables every server timeout
+	ReadHeaderTimeout time.Duration `env:"SERVER_READ_HEADER_TIMEOUT" default:"5s" validate:"gte=0"`
+	ReadTimeout       time.Duration `env:"SERVER_READ_TIMEOUT" default:"30s" validate:"gte=0"`
+	WriteTimeout      time.Duration `env:"SERVER_WRITE_TIMEOUT" default:"1m" validate:"gte=0"`
+	IdleTimeout       time.Duration `env:"SERVER_IDLE_TIMEOUT" default:"2m" validate:"gte=0"`
+	MaxHeaderBytes    int           `env:"SERVER_MAX_HEADER_BYTES" default:"1048576" validate:"gt=0"`
+	// MaxBodyBytes limits request bodies, 0 disables the limit
+	MaxBodyBytes int `env:"MAX_BODY_BYTES" default:"1048576" validate:"gte=0"`
+	// MaxBodyBytesRoutes overrides MaxBodyBytes for routes by their pattern,
+	// e.g. "POST /person/batch=10485760"
+	MaxBodyBytesRoutes map[string]string `env:"MAX_BODY_BYTES_ROUTES"`
+	// RouteMaxBodyBytes are the parsed MaxBodyBytesRoutes
+	RouteMaxBodyBytes map[string]int64
+	// RequestDecompressionEncodings are the Content-Encodings request bodies
+	// can be sent with, empty to accept none
+	RequestDecompressionEncodings []string `env:"REQUEST_DECOMPRESSION_ENCODINGS" default:"gzip,zstd" validate:"dive,oneof=gzip zstd"`
+	// MaxDecompressedBodyBytes limits the decompressed request bodies, 0
+	// disables the limit
+	MaxDecompressedBodyBytes int `env:"MAX_DECOMPRESSED_BODY_BYTES" default:"10485760" validate:"gte=0"`
+	// MaxDecompressedBodyBytesRoutes overrides MaxDecompressedBodyBytes for
+	// routes by their pattern, e.g. "POST /person/batch=104857600"
+	MaxDecompressedBodyBytesRoutes map[string]string `env:"MAX_DECOMPRESSED_BODY_BYTES_ROUTES"`
+	// RouteMaxDecompressedBodyBytes are the parsed
+	// MaxDecompressedBodyBytesRoutes
+	RouteMaxDecompressedBodyBytes map[string]int64
+	// MaxDecompressionRatio limits how much larger a decompressed request
+	// body is than the one received, 0 disables the limit
+	MaxDecompressionRatio int `env:"MAX_DECOMPRESSION_RATIO" default:"100" validate:"gte=0"`
+	// RequestTimeout cancels the handlers running longer, 0 disables it
+	RequestTimeout time.Duration `env:"REQUEST_TIMEOUT" default:"30s" validate:"gte=0"`
+	// RequestTimeoutRoutes overrides RequestTimeout for routes by their
+	// pattern, e.g. "POST /person/batch=2m"
+	RequestTimeoutRoutes map[string]string `env:"REQUEST_TIMEOUT_ROUTES"`
+	// RouteRequestTimeouts are the parsed RequestTimeoutRoutes
+	RouteRequestTimeouts map[string]time.Duration
+	// JSONDisallowUnknownFields rejects request bodies with fields the
+	// target type doesn't have
+	JSONDisallowUnknownFields bool `env:"JSON_DISALLOW_UNKNOWN_FIELDS" default:"true"`
+	// JSONRejectTrailingData rejects request bodies with anything after
+	// their JSON value
+	JSONRejectTrailingData bool `env:"JSON_REJECT_TRAILING_DATA" default:"true"`
+	// CompressionEnabled compresses the responses of at least
+	// CompressionMinSize bytes with the first of CompressionEncodings the
+	// client accepts
+	CompressionEnabled   bool     `env:"COMPRESSION_ENABLED" default:"true"`
+	CompressionMinSize   int      `env:"COMPRESSION_MIN_SIZE" default:"1024" validate:"gte=0"`
+	CompressionEncodings []string `env:"COMPRESSION_ENCODINGS" default:"zstd,br,gzip" validate:"dive,oneof=zstd br gzip"`
 }
 
 type CacheConfiguration struct {
-	EnableTransparentCaching bool
-	RedisAddress             string
-	RedisPassword            string
-	RedisDb                  int
-	Expiration               time.Duration
+	EnableTransparentCaching bool `env:"ENABLE_TRANSPARENT_CACHE"`
+	// EnableResponseCache caches full GET responses, for ResponseMaxAge and
+	// keyed by the ResponseVary request headers as well
+	EnableResponseCache bool          `env:"ENABLE_RESPONSE_CACHE"`
+	ResponseMaxAge      time.Duration `env:"RESPONSE_CACHE_MAX_AGE" default:"1m" validate:"gt=0"`
+	ResponseVary        []string      `env:"RESPONSE_CACHE_VARY" default:"Accept"`
+	Provider            string        `env:"CACHE_PROVIDER" default:"redis" validate:"oneof=redis memory tiered"`
+	// RedisMode is one of single, cluster or sentinel
+	RedisMode string `env:"REDIS_MODE" default:"single" validate:"oneof=single cluster sentinel"`
+	// RedisAddress is kept for single node setups, RedisAddresses holds every
+	// seed node or sentinel and defaults to RedisAddress
+	RedisAddress          string        `env:"REDIS_ADDRESS" default:"localhost:6379"`
+	RedisAddresses        []string      `env:"REDIS_ADDRESSES"`
+	RedisUsername         string        `env:"REDIS_USERNAME"`
+	RedisPassword         string        `env:"REDIS_PASSWORD" secret:"true"`
+	RedisDb               int           `env:"REDIS_DB" default:"0" validate:"gte=0"`
+	RedisMasterName       string        `env:"REDIS_MASTER_NAME"`
+	RedisSentinelUsername string        `env:"REDIS_SENTINEL_USERNAME"`
+	RedisSentinelPassword string        `env:"REDIS_SENTINEL_PASSWORD" secret:"true"`
+	RedisTLS              bool          `env:"REDIS_TLS"`
+	RedisTLSCAFile        string        `env:"REDIS_TLS_CA_FILE"`
+	RedisTLSCertFile      string        `env:"REDIS_TLS_CERT_FILE"`
+	RedisTLSKeyFile       string        `env:"REDIS_TLS_KEY_FILE"`
+	RedisTLSServerName    string        `env:"REDIS_TLS_SERVER_NAME"`
+	RedisTLSSkipVerify    bool          `env:"REDIS_TLS_INSECURE_SKIP_VERIFY"`
+	RedisPoolSize         int           `env:"REDIS_POOL_SIZE" validate:"gte=0"`
+	RedisMinIdleConns     int           `env:"REDIS_MIN_IDLE_CONNS" validate:"gte=0"`
+	RedisDialTimeout      time.Duration `env:"REDIS_DIAL_TIMEOUT" validate:"gte=0"`
+	RedisReadTimeout      time.Duration `env:"REDIS_READ_TIMEOUT" validate:"gte=0"`
+	RedisWriteTimeout     time.Duration `env:"REDIS_WRITE_TIMEOUT" validate:"gte=0"`
+	RedisPoolTimeout      time.Duration `env:"REDIS_POOL_TIMEOUT" validate:"gte=0"`
+	Expiration            time.Duration `env:"REDIS_DEFAULT_EXPIRATION" default:"1h" validate:"gt=0"`
+	// SoftExpiration defaults to half of Expiration
+	SoftExpiration        time.Duration `env:"CACHE_SOFT_EXPIRATION" validate:"gte=0"`
+	NegativeExpiration    time.Duration `env:"CACHE_NEGATIVE_EXPIRATION" default:"30s" validate:"gte=0"`
+	ExpirationJitter      float64       `env:"CACHE_EXPIRATION_JITTER" default:"0.1" validate:"gte=0,lte=1"`
+	EnableDistributedLock bool          `env:"CACHE_DISTRIBUTED_LOCK"`
+	LockTimeout           time.Duration `env:"CACHE_LOCK_TIMEOUT" default:"5s" validate:"gt=0"`
+	MemoryMaxEntries      int           `env:"MEMORY_CACHE_MAX_ENTRIES" default:"10000" validate:"gt=0"`
+	MemoryMaxBytes        int           `env:"MEMORY_CACHE_MAX_BYTES" default:"67108864" validate:"gt=0"`
+	// MemoryExpiration defaults to Expiration
+	MemoryExpiration    time.Duration `env:"MEMORY_CACHE_EXPIRATION" validate:"gte=0"`
+	InvalidationChannel string        `env:"CACHE_INVALIDATION_CHANNEL" default:"cache:invalidate"`
+	// Codec is one of json, msgpack or gob
+	Codec string `env:"CACHE_CODEC" default:"json" validate:"oneof=json msgpack gob"`
+	// Compression is one of none, gzip or zstd, applied to entries of at least
+	// CompressionThreshold bytes
+	Compression          string `env:"CACHE_COMPRESSION" default:"none" validate:"oneof=none gzip zstd"`
+	CompressionThreshold int    `env:"CACHE_COMPRESSION_THRESHOLD" default:"1024" validate:"gte=0"`
+	// BreakerFailureThreshold consecutive failures open the cache circuit
+	// breaker, zero disables the breaker
+	BreakerFailureThreshold int           `env:"CACHE_BREAKER_FAILURE_THRESHOLD" default:"5" validate:"gte=0"`
+	BreakerSlowThreshold    time.Duration `env:"CACHE_BREAKER_SLOW_THRESHOLD" default:"500ms" validate:"gte=0"`
+	BreakerOpenTimeout      time.Duration `env:"CACHE_BREAKER_OPEN_TIMEOUT" default:"30s" validate:"gte=0"`
+	// EntityExpirations overrides Expiration per entity, e.g. "person"
+	EntityExpirations map[string]time.Duration `env:"CACHE_ENTITY_EXPIRATIONS" default:""`
+}
+
+type DatabaseConfiguration struct {
+	// TxIsolationLevel is the default isolation level of transactions, one of
+	// read committed, repeatable read or serializable
+	TxIsolationLevel string `env:"DB_TX_ISOLATION_LEVEL" default:"read committed" validate:"oneof='read committed' 'repeatable read' serializable"`
+	// TxMaxRetries is how many times a transaction is retried after a
+	// serialization failure or a deadlock
+	TxMaxRetries int `env:"DB_TX_MAX_RETRIES" default:"3" validate:"gte=0"`
+	// ReplicaConnectionStrings are read replicas of DB_CONNECTION_STRING,
+	// reads go to the primary when empty
+	ReplicaConnectionStrings []string `env:"DB_REPLICA_CONNECTION_STRINGS" secret:"true"`
+	// ReplicaMaxLag is the replication lag from which a replica is removed
+	// from rotation
+	ReplicaMaxLag        time.Duration `env:"DB_REPLICA_MAX_LAG" default:"5s" validate:"gte=0"`
+	ReplicaCheckInterval time.Duration `env:"DB_REPLICA_CHECK_INTERVAL" default:"5s" validate:"gt=0"`
+	// StickyPrimaryWindow is how long reads go to the primary after a write
+	StickyPrimaryWindow time.Duration `env:"DB_STICKY_PRIMARY_WINDOW" default:"5s" validate:"gte=0"`
+	// StickyPrimaryKey signs the sticky primary cookies when set, every
+	// instance needs the same one
+	StickyPrimaryKey string `env:"DB_STICKY_PRIMARY_KEY" secret:"true"`
+	// AutoMigrate applies pending migrations at startup
+	AutoMigrate bool `env:"DB_AUTO_MIGRATE" default:"true"`
+	// MigrationLockTimeout is how long to wait for another instance to finish
+	// migrating
+	MigrationLockTimeout time.Duration `env:"DB_MIGRATION_LOCK_TIMEOUT" default:"5m" validate:"gt=0"`
+	// AllowRLSBypass starts the API even though it connects with a role
+	// that bypasses row level security, for local databases only
+	AllowRLSBypass bool `env:"DB_ALLOW_RLS_BYPASS"`
+	// Pool settings keep the pgxpool defaults when zero
+	MaxConns          int32         `env:"DB_MAX_CONNS" validate:"gte=0"`
+	MinConns          int32         `env:"DB_MIN_CONNS" validate:"gte=0"`
+	MaxConnLifetime   time.Duration `env:"DB_MAX_CONN_LIFETIME" validate:"gte=0"`
+	MaxConnIdleTime   time.Duration `env:"DB_MAX_CONN_IDLE_TIME" validate:"gte=0"`
+	HealthCheckPeriod time.Duration `env:"DB_HEALTH_CHECK_PERIOD" validate:"gte=0"`
+	// StatementTimeout bounds every query, zero disables it
+	StatementTimeout time.Duration `env:"DB_STATEMENT_TIMEOUT" validate:"gte=0"`
+	// SlowQueryThreshold is the duration from which queries are logged, zero
+	// disables slow query logging
+	SlowQueryThreshold time.Duration `env:"DB_SLOW_QUERY_THRESHOLD" default:"500ms" validate:"gte=0"`
 }
 
 type Configuration struct {
 	WebServerConfig *WebServerConfiguration
+	DatabaseConfig  *DatabaseConfiguration
 	CacheConfig     *CacheConfiguration
 	AuthConfig      *AuthConfiguration
+	SecretsConfig   *SecretsConfiguration
+	RateLimitConfig *RateLimitConfiguration
+	AdminConfig     *AdminConfiguration
+	// Sources holds where every setting was read from, by env name
+	Sources map[string]string
+	// References holds the secret references of the settings set to one, by
+	// env name
+	References map[string]string
+
+	resolvers map[string]SecretResolver
 }
 
 func loadAuthConfig() (*AuthConfiguration, error) {
-	configUrl, ok := os.LookupEnv("AUTH_CONFIG_URL")
-	if !ok {
-		return nil, fmt.Errorf("AUTH_CONFIG_URL is a required parameter")
+	return newLoader(nil).auth(true)
+}
+
+// auth loads the auth settings, and reads the OpenID configuration of the
+// provider when discover is true.
+func (l *loader) auth(discover bool) (*AuthConfiguration, error) {
+	config := &AuthConfiguration{}
+	errs := append(l.decode(config), l.check(config)...)
+
+	if config.JWKSFile != "" {
+		if config.JWKS != "" {
+			errs = append(errs, fmt.Errorf("AUTH_JWKS and AUTH_JWKS_FILE cannot be set together"))
+		} else if content, err := os.ReadFile(config.JWKSFile); err != nil {
+			errs = append(errs, fmt.Errorf("AUTH_JWKS_FILE cannot be read: %w", err))
+		} else {
+			config.JWKS = string(content)
+		}
 	}
 
-	config, err := readOpenIdConfigurationFromURL(configUrl)
+	if config.JWKS != "" && !json.Valid([]byte(config.JWKS)) {
+		errs = append(errs, fmt.Errorf("AUTH_JWKS must be a JSON Web Key Set"))
+	}
 
-	if err != nil {
-		return nil, err
+	if config.ConfigURL == "" {
+		if config.JWKS == "" && config.JWKSUri == "" {
+			errs = append(errs, fmt.Errorf("AUTH_CONFIG_URL is a required parameter unless AUTH_JWKS, AUTH_JWKS_FILE or AUTH_JWKS_URI is set"))
+		} else if len(config.TokenSigningAlg) == 0 {
+			errs = append(errs, fmt.Errorf("AUTH_SIGNING_ALGS is required when AUTH_CONFIG_URL isn't set"))
+		}
 	}
 
-	config.Audience = os.Getenv("AUTH_AUDIENCE")
+	if err := errors.Join(errs...); err != nil || !discover {
+		return config, err
+	}
 
-	scopeClaim, ok := os.LookupEnv("AUTH_SCOPE_CLAIM")
-	if !ok {
-		scopeClaim = "scp"
+	if err := config.Discover(); err != nil {
+		if !config.AllowDegraded {
+			return config, err
+		}
+		slog.Warn("Cannot read the OpenID configuration, starting with auth unavailable", "error", err)
 	}
-	config.ScopeClaim = scopeClaim
 
-	if scopes, ok := os.LookupEnv("AUTH_SCOPES"); ok {
-		config.Scopes = strings.Split(scopes, ",")
+	return config, nil
+}
+
+// NeedsDiscovery tells whether some settings are still to be read from the
+// OpenID configuration of the provider.
+func (c *AuthConfiguration) NeedsDiscovery() bool {
+	return c.ConfigURL != "" && (c.Issuer == "" || (c.JWKS == "" && c.JWKSUri == "") || len(c.TokenSigningAlg) == 0)
+}
+
+// Discover reads Issuer, JWKSUri and TokenSigningAlg from the OpenID
+// configuration of the provider, keeping the ones already set.
+func (c *AuthConfiguration) Discover() error {
+	if !c.NeedsDiscovery() {
+		return nil
 	}
 
-	if authClaims, ok := os.LookupEnv("AUTH_CLAIMS"); ok {
-		config.ClaimFields = strings.Split(authClaims, ",")
+	var openIdConfig *AuthConfiguration
+	err := c.WithRetries(func() (err error) {
+		openIdConfig, err = readOpenIdConfigurationFromURL(c.ConfigURL)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	return config, nil
+	if c.Issuer == "" {
+		c.Issuer = openIdConfig.Issuer
+	}
+	if c.JWKSUri == "" {
+		c.JWKSUri = openIdConfig.JWKSUri
+	}
+	if len(c.TokenSigningAlg) == 0 {
+		c.TokenSigningAlg = openIdConfig.TokenSigningAlg
+	}
+
+	return nil
+}
+
+// WithRetries calls fn until it succeeds, at most DiscoveryRetries more
+// times, waiting DiscoveryBackoff and then twice as long after every attempt.
+func (c *AuthConfiguration) WithRetries(fn func() error) error {
+	backoff := c.DiscoveryBackoff
+
+	err := fn()
+	for attempt := 0; err != nil && attempt < c.DiscoveryRetries; attempt++ {
+		slog.Warn("Cannot reach the OpenID provider, retrying", "error", err, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+
+		err = fn()
+	}
+
+	return err
 }
 
 func readOpenIdConfigurationFromURL(configUrl string) (*AuthConfiguration, error) {
@@ -91,6 +366,10 @@ func readOpenIdConfigurationFromURL(configUrl string) (*AuthConfiguration, error
 
 	defer response.Body.Close()
 
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot read OpenId configuration: %s", response.Status)
+	}
+
 	target := &AuthConfiguration{}
 	err = json.NewDecoder(response.Body).Decode(target)
 	if err != nil {
@@ -101,97 +380,209 @@ func readOpenIdConfigurationFromURL(configUrl string) (*AuthConfiguration, error
 }
 
 func loadWebServerConfig() (*WebServerConfiguration, error) {
+	return newLoader(nil).webServer()
+}
+
+func (l *loader) webServer() (*WebServerConfiguration, error) {
 	config := &WebServerConfiguration{}
-	config.Env = os.Getenv("ENV")
+	errs := l.decode(config)
 
-	if err := godotenv.Load(); err != nil && config.Env == "" {
-		return nil, err
+	if l.dotenvErr != nil && config.Env == "" {
+		errs = append(errs, l.dotenvErr)
 	}
 
-	allowedOrigin, _ := os.LookupEnv("ALLOWED_ORIGIN")
+	if len(config.CorsAllowedOrigins) == 0 && config.AllowedOrigin != "" {
+		config.CorsAllowedOrigins = []string{config.AllowedOrigin}
+	}
 
-	config.Cors = *cors.New(cors.Options{
-		AllowedOrigins: []string{allowedOrigin},
-	})
+	errs = append(errs, l.check(config)...)
 
-	if enableSwagger, ok := os.LookupEnv("ENABLE_SWAGGER"); ok {
-		config.EnableSwagger = enableSwagger == "true"
+	if config.CorsAllowCredentials && slices.Contains(config.CorsAllowedOrigins, "*") {
+		errs = append(errs, fmt.Errorf("CORS_ALLOW_CREDENTIALS cannot be set when every origin is allowed"))
 	}
 
-	if webPort, ok := os.LookupEnv("WEB_PORT"); ok {
-		config.WebPort = webPort
-	} else {
-		config.WebPort = "localhost:8000"
+	errs = append(errs, config.checkTLS()...)
+
+	var routeErrs []error
+	config.RouteMaxBodyBytes, routeErrs = parseRouteBytes("MAX_BODY_BYTES_ROUTES", config.MaxBodyBytesRoutes)
+	errs = append(errs, routeErrs...)
+	config.RouteMaxDecompressedBodyBytes, routeErrs = parseRouteBytes("MAX_DECOMPRESSED_BODY_BYTES_ROUTES", config.MaxDecompressedBodyBytesRoutes)
+	errs = append(errs, routeErrs...)
+
+	// the 503 of timed out requests can't be written once the write timeout
+	// closed the connection
+	if config.WriteTimeout > 0 && config.RequestTimeout >= config.WriteTimeout {
+		errs = append(errs, fmt.Errorf("REQUEST_TIMEOUT must be less than SERVER_WRITE_TIMEOUT"))
+	}
+
+	config.RouteRequestTimeouts = map[string]time.Duration{}
+	for route, raw := range config.RequestTimeoutRoutes {
+		timeout, err := time.ParseDuration(raw)
+		if err != nil || timeout < 0 {
+			errs = append(errs, fmt.Errorf("REQUEST_TIMEOUT_ROUTES %s must be a duration such as 30s or 5m", route))
+			continue
+		}
+		if config.WriteTimeout > 0 && timeout >= config.WriteTimeout {
+			errs = append(errs, fmt.Errorf("REQUEST_TIMEOUT_ROUTES %s must be less than SERVER_WRITE_TIMEOUT", route))
+			continue
+		}
+		config.RouteRequestTimeouts[route] = timeout
 	}
 
-	config.TLSCertFile, _ = os.LookupEnv("TLS_CERT_FILE")
-	config.TLSCertKeyFile, _ = os.LookupEnv("TLS_CERT_KEY_FILE")
+	return config, errors.Join(errs...)
+}
 
-	if connectionString, ok := os.LookupEnv("DB_CONNECTION_STRING"); ok {
-		config.ConnectionString = connectionString
-	} else {
-		return nil, fmt.Errorf("must set DB_CONNECTION_STRING=<connection string>")
+// parseRouteBytes parses the byte counts of routes set by setting.
+func parseRouteBytes(setting string, routes map[string]string) (map[string]int64, []error) {
+	var errs []error
+	parsed := map[string]int64{}
+	for route, raw := range routes {
+		maxBytes, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || maxBytes < 0 {
+			errs = append(errs, fmt.Errorf("%s %s must be a number of bytes", setting, route))
+			continue
+		}
+		parsed[route] = maxBytes
 	}
 
-	return config, nil
+	return parsed, errs
+}
+
+// CorsOptions are the CORS settings of every route, see CorsRouteOrigins for
+// the overrides.
+func (c *WebServerConfiguration) CorsOptions() cors.Options {
+	return cors.Options{
+		AllowedOrigins:   c.CorsAllowedOrigins,
+		AllowedMethods:   c.CorsAllowedMethods,
+		AllowedHeaders:   c.CorsAllowedHeaders,
+		ExposedHeaders:   c.CorsExposedHeaders,
+		AllowCredentials: c.CorsAllowCredentials,
+		MaxAge:           int(c.CorsMaxAge.Seconds()),
+	}
+}
+
+func loadDatabaseConfig() (*DatabaseConfiguration, error) {
+	return newLoader(nil).database()
+}
+
+func (l *loader) database() (*DatabaseConfiguration, error) {
+	config := &DatabaseConfiguration{}
+	errs := l.decode(config)
+
+	config.TxIsolationLevel = strings.ToLower(config.TxIsolationLevel)
+
+	errs = append(errs, l.check(config)...)
+
+	if config.MaxConns > 0 && config.MinConns > config.MaxConns {
+		errs = append(errs, fmt.Errorf("DB_MIN_CONNS must not be greater than DB_MAX_CONNS"))
+	}
+
+	return config, errors.Join(errs...)
 }
 
 func loadCacheConfig() (*CacheConfiguration, error) {
+	return newLoader(nil).cache()
+}
+
+func (l *loader) cache() (*CacheConfiguration, error) {
 	config := &CacheConfiguration{}
+	errs := l.decode(config)
 
-	if enableTransparentCaching, ok := os.LookupEnv("ENABLE_TRANSPARENT_CACHE"); ok {
-		config.EnableTransparentCaching = enableTransparentCaching == "true"
+	if !config.EnableTransparentCaching && !config.EnableResponseCache {
+		// no reason to validate the cache config if we're not using it
+		return config, errors.Join(errs...)
 	}
 
-	if !config.EnableTransparentCaching {
-		// no reason to keep loading the cache config if we're not using it
-		return config, nil
+	if !l.isSet("CACHE_SOFT_EXPIRATION") {
+		config.SoftExpiration = config.Expiration / 2
 	}
 
-	if redisAddress, ok := os.LookupEnv("REDIS_ADDRESS"); ok {
-		config.RedisAddress = redisAddress
-	} else {
-		config.RedisAddress = "localhost:6379"
+	if !l.isSet("MEMORY_CACHE_EXPIRATION") {
+		config.MemoryExpiration = config.Expiration
 	}
 
-	if redisDbStr, ok := os.LookupEnv("REDIS_DB"); ok {
-		redisDb, _ := strconv.Atoi(redisDbStr)
-		config.RedisDb = redisDb
-	} else {
-		config.RedisDb = 0
+	if len(config.RedisAddresses) == 0 {
+		config.RedisAddresses = []string{config.RedisAddress}
 	}
 
-	if expiration, ok := os.LookupEnv("REDIS_DEFAULT_EXPIRATION"); ok {
-		expirationParsed, _ := time.ParseDuration(expiration)
-		config.Expiration = expirationParsed
-	} else {
-		config.Expiration = time.Hour
+	errs = append(errs, l.check(config)...)
+
+	if config.RedisMode == "sentinel" && config.RedisMasterName == "" {
+		errs = append(errs, fmt.Errorf("REDIS_MASTER_NAME is required when REDIS_MODE is sentinel"))
 	}
 
-	config.RedisPassword, _ = os.LookupEnv("REDIS_PASSWORD")
+	if (config.RedisTLSCertFile == "") != (config.RedisTLSKeyFile == "") {
+		errs = append(errs, fmt.Errorf("REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE must be set together"))
+	}
 
-	return config, nil
+	return config, errors.Join(errs...)
 }
 
-func LoadConfig() *Configuration {
-	webServerConfig, err := loadWebServerConfig()
-	if err != nil {
-		log.Fatal(err)
-	}
+// LoadMigration only loads what the migrate command needs, the web server
+// and database configurations.
+func LoadMigration(args []string) (*Configuration, error) {
+	l := newLoader(args)
+	l.exportDotenv()
 
-	authConfig, err := loadAuthConfig()
-	if err != nil {
-		log.Fatal(err)
-	}
+	webServerConfig, webServerErr := l.webServer()
+	databaseConfig, databaseErr := l.database()
 
-	cacheConfig, err := loadCacheConfig()
-	if err != nil {
-		log.Fatal(err)
+	if err := errors.Join(append(l.errs, webServerErr, databaseErr)...); err != nil {
+		return nil, err
 	}
 
 	return &Configuration{
 		WebServerConfig: webServerConfig,
+		DatabaseConfig:  databaseConfig,
+		SecretsConfig:   l.secrets,
+		Sources:         l.Sources,
+		References:      l.References,
+		resolvers:       l.resolvers,
+	}, nil
+}
+
+// Load reads the configuration from, by increasing precedence, the defaults,
+// the config file set by --config or CONFIG_FILE, the .env file, the
+// environment and the flags in args. Every invalid setting is reported.
+func Load(args []string) (*Configuration, error) {
+	return load(args, true)
+}
+
+// Inspect loads the configuration like Load, without contacting the OpenID
+// provider. The configuration is returned even when some settings are
+// invalid, along with their errors.
+func Inspect(args []string) (*Configuration, error) {
+	return load(args, false)
+}
+
+func load(args []string, discover bool) (*Configuration, error) {
+	l := newLoader(args)
+	l.exportDotenv()
+
+	webServerConfig, webServerErr := l.webServer()
+	authConfig, authErr := l.auth(discover)
+	databaseConfig, databaseErr := l.database()
+	cacheConfig, cacheErr := l.cache()
+	rateLimitConfig, rateLimitErr := l.rateLimit()
+	adminConfig, adminErr := l.admin()
+
+	configValues := &Configuration{
+		WebServerConfig: webServerConfig,
+		DatabaseConfig:  databaseConfig,
 		AuthConfig:      authConfig,
 		CacheConfig:     cacheConfig,
+		SecretsConfig:   l.secrets,
+		RateLimitConfig: rateLimitConfig,
+		AdminConfig:     adminConfig,
+		Sources:         l.Sources,
+		References:      l.References,
+		resolvers:       l.resolvers,
 	}
+
+	err := errors.Join(append(l.errs, webServerErr, authErr, databaseErr, cacheErr, rateLimitErr, adminErr)...)
+	if err != nil && discover {
+		return nil, err
+	}
+
+	return configValues, err
 }
@@ -3,6 +3,9 @@ package config
 import (
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -16,6 +19,7 @@ func TestLoadWebConfig(t *testing.T) {
 	t.Setenv("WEB_PORT", "localhost:8000")
 	t.Setenv("TLS_CERT_FILE", "tls_cert_file")
 	t.Setenv("TLS_CERT_KEY_FILE", "tls_cert_key_file")
+	t.Setenv("EMAIL_PROVIDER_RULES", "true")
 	t.Setenv("DB_CONNECTION_STRING", "connection_string")
 
 	config, err := loadWebServerConfig()
@@ -28,6 +32,44 @@ func TestLoadWebConfig(t *testing.T) {
 	assert.Contains(t, "TEST", config.Env)
 	assert.Contains(t, "tls_cert_file", config.TLSCertFile)
 	assert.Contains(t, "tls_cert_key_file", config.TLSCertKeyFile)
+	assert.True(t, config.EmailProviderRules)
+	assert.Equal(t, []string{"localhost:8000"}, config.CorsAllowedOrigins)
+}
+
+func TestLoadWebConfigCors(t *testing.T) {
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_CONNECTION_STRING", "connection_string")
+	t.Setenv("ALLOWED_ORIGIN", "https://legacy.com")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.com, https://*.partner.com")
+	t.Setenv("CORS_ALLOWED_METHODS", "GET,POST")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("CORS_MAX_AGE", "1h")
+	t.Setenv("CORS_ROUTE_ORIGINS", "/public/=*,/partner/=https://a.com https://b.com")
+
+	config, err := loadWebServerConfig()
+	assert.Nil(t, err)
+
+	options := config.CorsOptions()
+	assert.Equal(t, []string{"https://app.example.com", "https://*.partner.com"}, options.AllowedOrigins)
+	assert.Equal(t, []string{"GET", "POST"}, options.AllowedMethods)
+	assert.Contains(t, options.AllowedHeaders, "Authorization")
+	assert.Equal(t, []string{"ETag", "X-Trace-Id"}, options.ExposedHeaders)
+	assert.True(t, options.AllowCredentials)
+	assert.Equal(t, 3600, options.MaxAge)
+	assert.Equal(t, map[string][]string{"/public/": {"*"}, "/partner/": {"https://a.com", "https://b.com"}}, config.CorsRouteOrigins)
+}
+
+func TestLoadWebConfigCorsErrors(t *testing.T) {
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_CONNECTION_STRING", "connection_string")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "*")
+	t.Setenv("CORS_ALLOW_CREDENTIALS", "true")
+	t.Setenv("CORS_ROUTE_ORIGINS", "/public/")
+
+	_, err := loadWebServerConfig()
+
+	assert.Equal(t, "CORS_ROUTE_ORIGINS must be a comma separated list of key=value, with space separated values\n"+
+		"CORS_ALLOW_CREDENTIALS cannot be set when every origin is allowed", err.Error())
 }
 
 func TestLoadWebConfigDefaults(t *testing.T) {
@@ -44,6 +86,64 @@ func TestLoadWebConfigDefaults(t *testing.T) {
 	assert.Contains(t, "TEST", config.Env)
 	assert.Empty(t, config.TLSCertFile)
 	assert.Empty(t, config.TLSCertKeyFile)
+	assert.False(t, config.EmailProviderRules)
+	assert.Equal(t, 5*time.Second, config.ReadHeaderTimeout)
+	assert.Equal(t, time.Minute, config.WriteTimeout)
+	assert.Equal(t, 1<<20, config.MaxHeaderBytes)
+	assert.Equal(t, 1<<20, config.MaxBodyBytes)
+	assert.Equal(t, 30*time.Second, config.RequestTimeout)
+	assert.True(t, config.JSONDisallowUnknownFields)
+	assert.True(t, config.JSONRejectTrailingData)
+	assert.True(t, config.CompressionEnabled)
+	assert.Equal(t, 1024, config.CompressionMinSize)
+	assert.Equal(t, []string{"zstd", "br", "gzip"}, config.CompressionEncodings)
+	assert.Equal(t, []string{"gzip", "zstd"}, config.RequestDecompressionEncodings)
+	assert.Equal(t, 10<<20, config.MaxDecompressedBodyBytes)
+	assert.Equal(t, 100, config.MaxDecompressionRatio)
+}
+
+func TestLoadWebConfigLimits(t *testing.T) {
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_CONNECTION_STRING", "connection_string")
+	t.Setenv("MAX_BODY_BYTES_ROUTES", "POST /person/batch=10485760")
+	t.Setenv("MAX_DECOMPRESSED_BODY_BYTES_ROUTES", "POST /person/batch=104857600")
+	t.Setenv("REQUEST_TIMEOUT_ROUTES", "POST /person/batch=50s, GET /person=0s")
+	t.Setenv("JSON_DISALLOW_UNKNOWN_FIELDS", "false")
+
+	config, err := loadWebServerConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]int64{"POST /person/batch": 10 << 20}, config.RouteMaxBodyBytes)
+	assert.Equal(t, map[string]int64{"POST /person/batch": 100 << 20}, config.RouteMaxDecompressedBodyBytes)
+	assert.Equal(t, map[string]time.Duration{"POST /person/batch": 50 * time.Second, "GET /person": 0}, config.RouteRequestTimeouts)
+	assert.False(t, config.JSONDisallowUnknownFields)
+}
+
+func TestLoadWebConfigLimitsErrors(t *testing.T) {
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_CONNECTION_STRING", "connection_string")
+	t.Setenv("MAX_BODY_BYTES_ROUTES", "POST /person/batch=10MB")
+	t.Setenv("MAX_DECOMPRESSED_BODY_BYTES_ROUTES", "POST /person/batch=-1")
+	t.Setenv("REQUEST_TIMEOUT", "1m")
+	t.Setenv("REQUEST_TIMEOUT_ROUTES", "POST /person/batch=2m")
+
+	_, err := loadWebServerConfig()
+
+	assert.Equal(t, "MAX_BODY_BYTES_ROUTES POST /person/batch must be a number of bytes\n"+
+		"MAX_DECOMPRESSED_BODY_BYTES_ROUTES POST /person/batch must be a number of bytes\n"+
+		"REQUEST_TIMEOUT must be less than SERVER_WRITE_TIMEOUT\n"+
+		"REQUEST_TIMEOUT_ROUTES POST /person/batch must be less than SERVER_WRITE_TIMEOUT", err.Error())
+}
+
+func TestLoadWebConfigCompressionEncodings(t *testing.T) {
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_CONNECTION_STRING", "connection_string")
+	t.Setenv("COMPRESSION_ENCODINGS", "gzip,deflate")
+
+	_, err := loadWebServerConfig()
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "COMPRESSION_ENCODINGS")
 }
 
 func TestLoadWebConfigMissingConnectionString(t *testing.T) {
@@ -77,23 +177,243 @@ func TestLoadAuthConfig(t *testing.T) {
 	assert.Equal(t, "issuer", config.Issuer)
 	assert.Equal(t, "jwks_uri", config.JWKSUri)
 	assert.Contains(t, config.TokenSigningAlg, "alg")
+	assert.Equal(t, "", config.TenantClaim)
+	assert.Equal(t, "X-Tenant-ID", config.TenantHeader)
+	assert.Empty(t, config.ServicePrincipals)
+}
+
+func TestLoadAuthConfigTenancy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"issuer":"issuer"}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("AUTH_CONFIG_URL", server.URL)
+	t.Setenv("AUTH_TENANT_CLAIM", "tid")
+	t.Setenv("AUTH_TENANT_HEADER", "X-Tenant")
+	t.Setenv("AUTH_SERVICE_PRINCIPALS", "app1,app2")
+
+	config, err := loadAuthConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "tid", config.TenantClaim)
+	assert.Equal(t, "X-Tenant", config.TenantHeader)
+	assert.Equal(t, []string{"app1", "app2"}, config.ServicePrincipals)
 }
 
 func TestLoadAuthConfigMissingUrl(t *testing.T) {
 	_, err := loadAuthConfig()
 
 	assert.NotNil(t, err)
-	assert.Equal(t, err.Error(), "AUTH_CONFIG_URL is a required parameter")
+	assert.Equal(t, err.Error(), "AUTH_CONFIG_URL is a required parameter unless AUTH_JWKS, AUTH_JWKS_FILE or AUTH_JWKS_URI is set")
 }
 
 func TestLoadAuthConfigBadUrl(t *testing.T) {
 	t.Setenv("AUTH_CONFIG_URL", "http://localhost")
+	t.Setenv("AUTH_DISCOVERY_RETRIES", "0")
 
 	_, err := loadAuthConfig()
 
 	assert.NotNil(t, err)
 }
 
+func TestLoadAuthConfigRetriesDiscovery(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"issuer":"issuer","jwks_uri":"jwks_uri","id_token_signing_alg_values_supported":["RS256"]}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("AUTH_CONFIG_URL", server.URL)
+	t.Setenv("AUTH_DISCOVERY_BACKOFF", "1ms")
+	t.Setenv("AUTH_ISSUER", "configured")
+
+	config, err := loadAuthConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, "configured", config.Issuer)
+	assert.Equal(t, "jwks_uri", config.JWKSUri)
+}
+
+func TestLoadAuthConfigDegraded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	t.Setenv("AUTH_CONFIG_URL", server.URL)
+	t.Setenv("AUTH_DISCOVERY_RETRIES", "1")
+	t.Setenv("AUTH_DISCOVERY_BACKOFF", "1ms")
+
+	_, err := loadAuthConfig()
+	assert.Contains(t, err.Error(), "503 Service Unavailable")
+
+	t.Setenv("AUTH_ALLOW_DEGRADED", "true")
+
+	config, err := loadAuthConfig()
+	assert.Nil(t, err)
+	assert.True(t, config.NeedsDiscovery())
+}
+
+func TestLoadAuthConfigStatic(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "jwks.json"), []byte(`{"keys":[]}`), 0644)
+
+	t.Setenv("AUTH_ISSUER", "issuer")
+	t.Setenv("AUTH_SIGNING_ALGS", "RS256,ES256")
+	t.Setenv("AUTH_JWKS_FILE", filepath.Join(dir, "jwks.json"))
+
+	config, err := loadAuthConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"keys":[]}`, config.JWKS)
+	assert.Equal(t, []string{"RS256", "ES256"}, config.TokenSigningAlg)
+	assert.False(t, config.NeedsDiscovery())
+}
+
+func TestLoadAuthConfigStaticErrors(t *testing.T) {
+	t.Setenv("AUTH_JWKS", "{not json")
+	t.Setenv("AUTH_JWKS_FILE", "/does/not/exist.json")
+
+	_, err := loadAuthConfig()
+
+	assert.Equal(t, "AUTH_JWKS and AUTH_JWKS_FILE cannot be set together\n"+
+		"AUTH_JWKS must be a JSON Web Key Set\n"+
+		"AUTH_SIGNING_ALGS is required when AUTH_CONFIG_URL isn't set", err.Error())
+}
+
+func TestLoadDatabaseConfigDefaults(t *testing.T) {
+	config, err := loadDatabaseConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "read committed", config.TxIsolationLevel)
+	assert.Equal(t, 3, config.TxMaxRetries)
+	assert.Empty(t, config.ReplicaConnectionStrings)
+	assert.Equal(t, 5*time.Second, config.ReplicaMaxLag)
+	assert.Equal(t, 5*time.Second, config.ReplicaCheckInterval)
+	assert.Equal(t, 5*time.Second, config.StickyPrimaryWindow)
+	assert.True(t, config.AutoMigrate)
+	assert.Equal(t, 5*time.Minute, config.MigrationLockTimeout)
+	assert.Equal(t, int32(0), config.MaxConns)
+	assert.Equal(t, time.Duration(0), config.StatementTimeout)
+	assert.Equal(t, 500*time.Millisecond, config.SlowQueryThreshold)
+}
+
+func TestLoadDatabaseConfig(t *testing.T) {
+	t.Setenv("DB_TX_ISOLATION_LEVEL", "Serializable")
+	t.Setenv("DB_TX_MAX_RETRIES", "5")
+
+	config, err := loadDatabaseConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "serializable", config.TxIsolationLevel)
+	assert.Equal(t, 5, config.TxMaxRetries)
+}
+
+func TestLoadDatabaseConfigMigrations(t *testing.T) {
+	t.Setenv("DB_AUTO_MIGRATE", "false")
+	t.Setenv("DB_MIGRATION_LOCK_TIMEOUT", "30s")
+
+	config, err := loadDatabaseConfig()
+
+	assert.Nil(t, err)
+	assert.False(t, config.AutoMigrate)
+	assert.Equal(t, 30*time.Second, config.MigrationLockTimeout)
+}
+
+func TestLoadDatabaseConfigAllowRLSBypass(t *testing.T) {
+	config, err := loadDatabaseConfig()
+
+	assert.Nil(t, err)
+	assert.False(t, config.AllowRLSBypass)
+
+	t.Setenv("DB_ALLOW_RLS_BYPASS", "true")
+
+	config, err = loadDatabaseConfig()
+
+	assert.Nil(t, err)
+	assert.True(t, config.AllowRLSBypass)
+}
+
+func TestLoadDatabaseConfigReplicas(t *testing.T) {
+	t.Setenv("DB_REPLICA_CONNECTION_STRINGS", "postgres://replica1/db, postgres://replica2/db")
+	t.Setenv("DB_REPLICA_MAX_LAG", "1s")
+	t.Setenv("DB_REPLICA_CHECK_INTERVAL", "2s")
+	t.Setenv("DB_STICKY_PRIMARY_WINDOW", "10s")
+	t.Setenv("DB_STICKY_PRIMARY_KEY", "secret")
+
+	config, err := loadDatabaseConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"postgres://replica1/db", "postgres://replica2/db"}, config.ReplicaConnectionStrings)
+	assert.Equal(t, time.Second, config.ReplicaMaxLag)
+	assert.Equal(t, 2*time.Second, config.ReplicaCheckInterval)
+	assert.Equal(t, 10*time.Second, config.StickyPrimaryWindow)
+	assert.Equal(t, "secret", config.StickyPrimaryKey)
+}
+
+func TestLoadDatabaseConfigPool(t *testing.T) {
+	t.Setenv("DB_MAX_CONNS", "20")
+	t.Setenv("DB_MIN_CONNS", "2")
+	t.Setenv("DB_MAX_CONN_LIFETIME", "1h")
+	t.Setenv("DB_MAX_CONN_IDLE_TIME", "10m")
+	t.Setenv("DB_HEALTH_CHECK_PERIOD", "30s")
+	t.Setenv("DB_STATEMENT_TIMEOUT", "3s")
+	t.Setenv("DB_SLOW_QUERY_THRESHOLD", "100ms")
+
+	config, err := loadDatabaseConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(20), config.MaxConns)
+	assert.Equal(t, int32(2), config.MinConns)
+	assert.Equal(t, time.Hour, config.MaxConnLifetime)
+	assert.Equal(t, 10*time.Minute, config.MaxConnIdleTime)
+	assert.Equal(t, 30*time.Second, config.HealthCheckPeriod)
+	assert.Equal(t, 3*time.Second, config.StatementTimeout)
+	assert.Equal(t, 100*time.Millisecond, config.SlowQueryThreshold)
+}
+
+func TestLoadDatabaseConfigMinConnsAboveMax(t *testing.T) {
+	t.Setenv("DB_MAX_CONNS", "2")
+	t.Setenv("DB_MIN_CONNS", "5")
+
+	_, err := loadDatabaseConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "DB_MIN_CONNS must not be greater than DB_MAX_CONNS", err.Error())
+}
+
+func TestLoadDatabaseConfigInvalidCheckInterval(t *testing.T) {
+	t.Setenv("DB_REPLICA_CHECK_INTERVAL", "0s")
+
+	_, err := loadDatabaseConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "DB_REPLICA_CHECK_INTERVAL must be a positive duration", err.Error())
+
+	t.Setenv("DB_REPLICA_CHECK_INTERVAL", "never")
+
+	_, err = loadDatabaseConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "DB_REPLICA_CHECK_INTERVAL must be a duration such as 30s or 5m", err.Error())
+}
+
+func TestLoadDatabaseConfigInvalidIsolationLevel(t *testing.T) {
+	t.Setenv("DB_TX_ISOLATION_LEVEL", "snapshot")
+
+	_, err := loadDatabaseConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "DB_TX_ISOLATION_LEVEL must be one of read committed, repeatable read or serializable", err.Error())
+}
+
 func TestLoadCacheConfigDisableCache(t *testing.T) {
 	t.Setenv("ENABLE_TRANSPARENT_CACHE", "false")
 
@@ -122,6 +442,7 @@ func TestLoadCacheConfigValid(t *testing.T) {
 	t.Setenv("REDIS_PASSWORD", "password")
 	t.Setenv("REDIS_DB", "1")
 	t.Setenv("REDIS_DEFAULT_EXPIRATION", "2h")
+	t.Setenv("CACHE_NEGATIVE_EXPIRATION", "5s")
 
 	config, _ := loadCacheConfig()
 
@@ -130,9 +451,323 @@ func TestLoadCacheConfigValid(t *testing.T) {
 	assert.Equal(t, "localhost:6379", config.RedisAddress)
 	assert.Equal(t, "password", config.RedisPassword)
 	assert.Equal(t, time.Hour*2, config.Expiration)
+	assert.Equal(t, time.Second*5, config.NegativeExpiration)
 	assert.Equal(t, 1, config.RedisDb)
 }
 
+func TestLoadCacheConfigProviderDefaults(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+
+	config, _ := loadCacheConfig()
+
+	assert.Equal(t, "redis", config.Provider)
+	assert.Equal(t, 10000, config.MemoryMaxEntries)
+	assert.Equal(t, 64*1024*1024, config.MemoryMaxBytes)
+	assert.Equal(t, time.Hour, config.MemoryExpiration)
+	assert.Equal(t, "cache:invalidate", config.InvalidationChannel)
+	assert.Equal(t, 30*time.Second, config.NegativeExpiration)
+	assert.Equal(t, 30*time.Minute, config.SoftExpiration)
+	assert.Equal(t, 0.1, config.ExpirationJitter)
+	assert.False(t, config.EnableDistributedLock)
+	assert.Equal(t, 5*time.Second, config.LockTimeout)
+}
+
+func TestLoadCacheConfigStampedeProtection(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("CACHE_SOFT_EXPIRATION", "10m")
+	t.Setenv("CACHE_EXPIRATION_JITTER", "0.2")
+	t.Setenv("CACHE_DISTRIBUTED_LOCK", "true")
+	t.Setenv("CACHE_LOCK_TIMEOUT", "1s")
+
+	config, _ := loadCacheConfig()
+
+	assert.Equal(t, 10*time.Minute, config.SoftExpiration)
+	assert.Equal(t, 0.2, config.ExpirationJitter)
+	assert.True(t, config.EnableDistributedLock)
+	assert.Equal(t, time.Second, config.LockTimeout)
+}
+
+func TestLoadCacheConfigTiered(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("CACHE_PROVIDER", "tiered")
+	t.Setenv("MEMORY_CACHE_MAX_ENTRIES", "100")
+	t.Setenv("MEMORY_CACHE_MAX_BYTES", "1024")
+	t.Setenv("MEMORY_CACHE_EXPIRATION", "1m")
+	t.Setenv("CACHE_INVALIDATION_CHANNEL", "invalidate")
+
+	config, err := loadCacheConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "tiered", config.Provider)
+	assert.Equal(t, 100, config.MemoryMaxEntries)
+	assert.Equal(t, 1024, config.MemoryMaxBytes)
+	assert.Equal(t, time.Minute, config.MemoryExpiration)
+	assert.Equal(t, "invalidate", config.InvalidationChannel)
+}
+
+func TestLoadCacheConfigEntityExpirations(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("CACHE_ENTITY_EXPIRATIONS", "person=10m, person:list=1m")
+
+	config, err := loadCacheConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]time.Duration{"person": 10 * time.Minute, "person:list": time.Minute}, config.EntityExpirations)
+}
+
+func TestLoadCacheConfigInvalidEntityExpirations(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("CACHE_ENTITY_EXPIRATIONS", "person=soon")
+
+	_, err := loadCacheConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "CACHE_ENTITY_EXPIRATIONS must be a comma separated list of entity=duration", err.Error())
+}
+
+func TestLoadCacheConfigRedisDefaults(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("REDIS_ADDRESS", "redis:6379")
+
+	config, err := loadCacheConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "single", config.RedisMode)
+	assert.Equal(t, []string{"redis:6379"}, config.RedisAddresses)
+	assert.False(t, config.RedisTLS)
+	assert.Equal(t, 0, config.RedisPoolSize)
+}
+
+func TestLoadCacheConfigRedisCluster(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("REDIS_MODE", "cluster")
+	t.Setenv("REDIS_ADDRESSES", "redis-1:6379, redis-2:6379")
+	t.Setenv("REDIS_USERNAME", "api")
+	t.Setenv("REDIS_TLS", "true")
+	t.Setenv("REDIS_TLS_CA_FILE", "ca.pem")
+	t.Setenv("REDIS_TLS_CERT_FILE", "cert.pem")
+	t.Setenv("REDIS_TLS_KEY_FILE", "key.pem")
+	t.Setenv("REDIS_TLS_SERVER_NAME", "redis.internal")
+	t.Setenv("REDIS_POOL_SIZE", "20")
+	t.Setenv("REDIS_MIN_IDLE_CONNS", "5")
+	t.Setenv("REDIS_DIAL_TIMEOUT", "2s")
+	t.Setenv("REDIS_READ_TIMEOUT", "500ms")
+	t.Setenv("REDIS_WRITE_TIMEOUT", "1s")
+	t.Setenv("REDIS_POOL_TIMEOUT", "3s")
+
+	config, err := loadCacheConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "cluster", config.RedisMode)
+	assert.Equal(t, []string{"redis-1:6379", "redis-2:6379"}, config.RedisAddresses)
+	assert.Equal(t, "api", config.RedisUsername)
+	assert.True(t, config.RedisTLS)
+	assert.Equal(t, "ca.pem", config.RedisTLSCAFile)
+	assert.Equal(t, "cert.pem", config.RedisTLSCertFile)
+	assert.Equal(t, "key.pem", config.RedisTLSKeyFile)
+	assert.Equal(t, "redis.internal", config.RedisTLSServerName)
+	assert.Equal(t, 20, config.RedisPoolSize)
+	assert.Equal(t, 5, config.RedisMinIdleConns)
+	assert.Equal(t, 2*time.Second, config.RedisDialTimeout)
+	assert.Equal(t, 500*time.Millisecond, config.RedisReadTimeout)
+	assert.Equal(t, time.Second, config.RedisWriteTimeout)
+	assert.Equal(t, 3*time.Second, config.RedisPoolTimeout)
+}
+
+func TestLoadCacheConfigRedisSentinelRequiresMasterName(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("REDIS_MODE", "sentinel")
+
+	_, err := loadCacheConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "REDIS_MASTER_NAME is required when REDIS_MODE is sentinel", err.Error())
+
+	t.Setenv("REDIS_MASTER_NAME", "mymaster")
+	t.Setenv("REDIS_SENTINEL_PASSWORD", "secret")
+
+	config, err := loadCacheConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "mymaster", config.RedisMasterName)
+	assert.Equal(t, "secret", config.RedisSentinelPassword)
+}
+
+func TestLoadCacheConfigInvalidRedisMode(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("REDIS_MODE", "replicated")
+
+	_, err := loadCacheConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "REDIS_MODE must be one of single, cluster or sentinel", err.Error())
+
+	t.Setenv("REDIS_MODE", "failover")
+
+	_, err = loadCacheConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "REDIS_MODE must be one of single, cluster or sentinel", err.Error())
+}
+
+func TestLoadCacheConfigRedisClientCertWithoutKey(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("REDIS_TLS_CERT_FILE", "cert.pem")
+
+	_, err := loadCacheConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE must be set together", err.Error())
+}
+
+func TestLoadCacheConfigBreaker(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+
+	config, _ := loadCacheConfig()
+
+	assert.Equal(t, 5, config.BreakerFailureThreshold)
+	assert.Equal(t, 500*time.Millisecond, config.BreakerSlowThreshold)
+	assert.Equal(t, 30*time.Second, config.BreakerOpenTimeout)
+
+	t.Setenv("CACHE_BREAKER_FAILURE_THRESHOLD", "0")
+	t.Setenv("CACHE_BREAKER_SLOW_THRESHOLD", "1s")
+	t.Setenv("CACHE_BREAKER_OPEN_TIMEOUT", "10s")
+
+	config, _ = loadCacheConfig()
+
+	assert.Equal(t, 0, config.BreakerFailureThreshold)
+	assert.Equal(t, time.Second, config.BreakerSlowThreshold)
+	assert.Equal(t, 10*time.Second, config.BreakerOpenTimeout)
+}
+
+func TestLoadCacheConfigCodec(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+
+	config, _ := loadCacheConfig()
+
+	assert.Equal(t, "json", config.Codec)
+	assert.Equal(t, "none", config.Compression)
+	assert.Equal(t, 1024, config.CompressionThreshold)
+
+	t.Setenv("CACHE_CODEC", "msgpack")
+	t.Setenv("CACHE_COMPRESSION", "zstd")
+	t.Setenv("CACHE_COMPRESSION_THRESHOLD", "4096")
+
+	config, err := loadCacheConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "msgpack", config.Codec)
+	assert.Equal(t, "zstd", config.Compression)
+	assert.Equal(t, 4096, config.CompressionThreshold)
+}
+
+func TestLoadCacheConfigInvalidCodec(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("CACHE_CODEC", "xml")
+
+	_, err := loadCacheConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "CACHE_CODEC must be one of json, msgpack or gob", err.Error())
+
+	t.Setenv("CACHE_CODEC", "gob")
+	t.Setenv("CACHE_COMPRESSION", "lz4")
+
+	_, err = loadCacheConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "CACHE_COMPRESSION must be one of none, gzip or zstd", err.Error())
+}
+
+func TestLoadCacheConfigResponseCache(t *testing.T) {
+	t.Setenv("ENABLE_RESPONSE_CACHE", "true")
+
+	config, _ := loadCacheConfig()
+
+	assert.False(t, config.EnableTransparentCaching)
+	assert.True(t, config.EnableResponseCache)
+	assert.Equal(t, "localhost:6379", config.RedisAddress)
+	assert.Equal(t, time.Minute, config.ResponseMaxAge)
+	assert.Equal(t, []string{"Accept"}, config.ResponseVary)
+
+	t.Setenv("RESPONSE_CACHE_MAX_AGE", "5m")
+	t.Setenv("RESPONSE_CACHE_VARY", "Accept, Accept-Language")
+
+	config, _ = loadCacheConfig()
+
+	assert.Equal(t, 5*time.Minute, config.ResponseMaxAge)
+	assert.Equal(t, []string{"Accept", "Accept-Language"}, config.ResponseVary)
+}
+
+func TestLoadCacheConfigInvalidProvider(t *testing.T) {
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("CACHE_PROVIDER", "memcached")
+
+	_, err := loadCacheConfig()
+
+	assert.NotNil(t, err)
+	assert.Equal(t, "CACHE_PROVIDER must be one of redis, memory or tiered", err.Error())
+}
+
+func TestLoadRateLimitConfig(t *testing.T) {
+	config, err := loadRateLimitConfig()
+
+	assert.Nil(t, err)
+	assert.False(t, config.Enabled)
+
+	t.Setenv("RATE_LIMIT_ENABLED", "true")
+	t.Setenv("RATE_LIMIT_ALGORITHM", "sliding-window")
+	t.Setenv("RATE_LIMIT_ROUTES", "POST /person/batch=10/1h, GET /person=off")
+	t.Setenv("RATE_LIMIT_KEYS", "api-key,ip")
+	t.Setenv("RATE_LIMIT_IP_REQUESTS", "1000")
+	t.Setenv("RATE_LIMIT_TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1")
+
+	config, err = loadRateLimitConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "sliding-window", config.Algorithm)
+	assert.Equal(t, 100, config.Requests)
+	assert.Equal(t, time.Minute, config.Window)
+	assert.Equal(t, map[string]*RouteQuota{"POST /person/batch": {Requests: 10, Window: time.Hour}, "GET /person": nil}, config.RouteQuotas)
+	assert.Equal(t, []string{"api-key", "ip"}, config.Keys)
+	assert.Equal(t, "X-API-Key", config.APIKeyHeader)
+	assert.Equal(t, 1000, config.IPRequests)
+	assert.Equal(t, time.Minute, config.IPWindow)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.1/32")}, config.TrustedPrefixes)
+	assert.Equal(t, "memory", config.Backend)
+}
+
+func TestLoadRateLimitConfigErrors(t *testing.T) {
+	t.Setenv("RATE_LIMIT_ENABLED", "true")
+	t.Setenv("RATE_LIMIT_BACKEND", "memcached")
+	t.Setenv("RATE_LIMIT_KEYS", "api-key,key")
+	t.Setenv("RATE_LIMIT_ROUTES", "POST /person=10")
+	t.Setenv("RATE_LIMIT_TRUSTED_PROXIES", "proxy")
+
+	_, err := loadRateLimitConfig()
+
+	assert.Equal(t, "RATE_LIMIT_KEYS must be one of user, api-key or ip\n"+
+		"RATE_LIMIT_BACKEND must be one of memory or redis\n"+
+		"RATE_LIMIT_ROUTES POST /person must be <requests>/<window> such as 10/1m, or off\n"+
+		"RATE_LIMIT_TRUSTED_PROXIES proxy must be an IP address or a CIDR network", err.Error())
+}
+
+func TestLoadAdminConfig(t *testing.T) {
+	config, err := loadAdminConfig()
+
+	assert.Nil(t, err)
+	assert.Empty(t, config.Address)
+	assert.Equal(t, "info", config.LogLevel)
+
+	t.Setenv("ADMIN_ADDRESS", "localhost:9000")
+	t.Setenv("LOG_LEVEL", "verbose")
+
+	_, err = loadAdminConfig()
+
+	assert.Equal(t, "LOG_LEVEL must be one of debug, info, warn or error\n"+
+		"ADMIN_TOKEN is required when ADMIN_ADDRESS is set", err.Error())
+}
+
 func TestLoadAllConfig(t *testing.T) {
 	t.Setenv("ENV", "TEST")
 	t.Setenv("ALLOWED_ORIGIN", "localhost:8000")
@@ -158,7 +793,11 @@ func TestLoadAllConfig(t *testing.T) {
 	t.Setenv("AUTH_AUDIENCE", "aud")
 	t.Setenv("ENABLE_TRANSPARENT_CACHE", "false")
 
-	config := LoadConfig()
+	config, err := Load(nil)
 
+	assert.Nil(t, err)
 	assert.NotNil(t, config)
+	assert.Equal(t, "issuer", config.AuthConfig.Issuer)
+	assert.Equal(t, SourceEnv, config.Sources["WEB_PORT"])
+	assert.Equal(t, SourceDefault, config.Sources["DB_TX_MAX_RETRIES"])
 }
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const redacted = "<redacted>"
+
+// Print writes every setting of c as KEY=value, followed by where it was read
+// from and the secret it references, if any. The settings tagged secret, and
+// the settings set to a reference, are masked when redact is true.
+func (c *Configuration) Print(w io.Writer, redact bool) {
+	c.printSection(w, "web server", c.WebServerConfig, redact)
+	c.printSection(w, "auth", c.AuthConfig, redact)
+	c.printSection(w, "database", c.DatabaseConfig, redact)
+	c.printSection(w, "cache", c.CacheConfig, redact)
+	c.printSection(w, "secrets", c.SecretsConfig, redact)
+	c.printSection(w, "rate limit", c.RateLimitConfig, redact)
+	c.printSection(w, "admin", c.AdminConfig, redact)
+}
+
+func (c *Configuration) printSection(w io.Writer, name string, section any, redact bool) {
+	value := reflect.ValueOf(section)
+	if value.IsNil() {
+		return
+	}
+
+	fmt.Fprintf(w, "# %s\n", name)
+	eachSetting(value.Elem(), func(key string, field reflect.StructField, value reflect.Value) {
+		formatted := formatSetting(value)
+		reference, isReference := c.References[key]
+		if redact && (field.Tag.Get("secret") == "true" || isReference) && formatted != "" {
+			formatted = redacted
+		}
+
+		source := c.Sources[key]
+		if isReference {
+			source += ", " + reference
+		}
+
+		fmt.Fprintf(w, "%s=%s # %s\n", key, formatted, source)
+	})
+	fmt.Fprintln(w)
+}
+
+// formatSetting formats value the way it is set in the environment.
+func formatSetting(value reflect.Value) string {
+	switch value := value.Interface().(type) {
+	case []string:
+		return strings.Join(value, ",")
+	case map[string]time.Duration:
+		items := make([]string, 0, len(value))
+		for key, duration := range value {
+			items = append(items, key+"="+duration.String())
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	case map[string]string:
+		items := make([]string, 0, len(value))
+		for key, entry := range value {
+			items = append(items, key+"="+entry)
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	case map[string][]string:
+		items := make([]string, 0, len(value))
+		for key, list := range value {
+			items = append(items, key+"="+strings.Join(list, " "))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RateLimitConfiguration struct {
+	Enabled bool `env:"RATE_LIMIT_ENABLED"`
+	// Algorithm is one of token-bucket or sliding-window
+	Algorithm string `env:"RATE_LIMIT_ALGORITHM" default:"token-bucket" validate:"oneof=token-bucket sliding-window"`
+	// Requests are allowed every Window by default, for each caller
+	Requests int           `env:"RATE_LIMIT_REQUESTS" default:"100" validate:"gt=0"`
+	Window   time.Duration `env:"RATE_LIMIT_WINDOW" default:"1m" validate:"gt=0"`
+	// Routes overrides the quota of routes by their pattern, e.g.
+	// "POST /person/batch=10/1m", or disables it with "off"
+	Routes map[string]string `env:"RATE_LIMIT_ROUTES"`
+	// RouteQuotas are the parsed Routes, nil for the routes that aren't
+	// limited
+	RouteQuotas map[string]*RouteQuota
+	// Keys identify the caller, the first one that applies is used: user is
+	// the authenticated user, api-key the APIKeyHeader of an authenticated
+	// user and ip the client IP
+	Keys         []string `env:"RATE_LIMIT_KEYS" default:"user,ip" validate:"dive,oneof=user api-key ip"`
+	APIKeyHeader string   `env:"RATE_LIMIT_API_KEY_HEADER" default:"X-API-Key"`
+	// IPRequests are allowed every IPWindow for each client IP before the
+	// requests are authenticated, 0 disables it
+	IPRequests int           `env:"RATE_LIMIT_IP_REQUESTS" default:"300" validate:"gte=0"`
+	IPWindow   time.Duration `env:"RATE_LIMIT_IP_WINDOW" default:"1m" validate:"gt=0"`
+	// TrustedProxies are the addresses or networks of the proxies whose
+	// X-Forwarded-For header is trusted to find the client IP
+	TrustedProxies []string `env:"RATE_LIMIT_TRUSTED_PROXIES"`
+	// TrustedPrefixes are the parsed TrustedProxies
+	TrustedPrefixes []netip.Prefix
+	// Backend is memory for a single instance, or redis to share the counts
+	// between instances through the cache connection
+	Backend string `env:"RATE_LIMIT_BACKEND" default:"memory" validate:"oneof=memory redis"`
+}
+
+type RouteQuota struct {
+	Requests int
+	Window   time.Duration
+}
+
+func loadRateLimitConfig() (*RateLimitConfiguration, error) {
+	return newLoader(nil).rateLimit()
+}
+
+func (l *loader) rateLimit() (*RateLimitConfiguration, error) {
+	config := &RateLimitConfiguration{}
+	errs := l.decode(config)
+
+	if !config.Enabled {
+		return config, errors.Join(errs...)
+	}
+
+	errs = append(errs, l.check(config)...)
+
+	config.RouteQuotas = map[string]*RouteQuota{}
+	for route, quota := range config.Routes {
+		parsed, err := parseRouteQuota(quota)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("RATE_LIMIT_ROUTES %s %s", route, err))
+			continue
+		}
+		config.RouteQuotas[route] = parsed
+	}
+
+	for _, proxy := range config.TrustedProxies {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("RATE_LIMIT_TRUSTED_PROXIES %s must be an IP address or a CIDR network", proxy))
+			continue
+		}
+		config.TrustedPrefixes = append(config.TrustedPrefixes, prefix)
+	}
+
+	return config, errors.Join(errs...)
+}
+
+// parseRouteQuota parses "<requests>/<window>" quotas, or "off".
+func parseRouteQuota(quota string) (*RouteQuota, error) {
+	if quota == "off" {
+		return nil, nil
+	}
+
+	requests, window, _ := strings.Cut(quota, "/")
+	parsedRequests, err := strconv.Atoi(requests)
+	if err != nil || parsedRequests <= 0 {
+		return nil, errors.New("must be <requests>/<window> such as 10/1m, or off")
+	}
+	parsedWindow, err := time.ParseDuration(window)
+	if err != nil || parsedWindow <= 0 {
+		return nil, errors.New("must be <requests>/<window> such as 10/1m, or off")
+	}
+
+	return &RouteQuota{Requests: parsedRequests, Window: parsedWindow}, nil
+}
+
+// parsePrefix parses networks, and addresses as the network of that single
+// address.
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+
+	address, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(address, address.BitLen()), nil
+}
@@ -0,0 +1,217 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretResolver returns the secret a reference points to, given the
+// reference without its scheme, e.g. /run/secrets/db_password for
+// file:///run/secrets/db_password.
+type SecretResolver func(location string) (string, error)
+
+var (
+	resolversMu sync.RWMutex
+	resolvers   = map[string]SecretResolver{
+		"file": readSecretFile,
+	}
+)
+
+// RegisterSecretResolver lets settings be set to scheme://location
+// references resolved by resolver. It must be called before the configuration
+// is loaded.
+func RegisterSecretResolver(scheme string, resolver SecretResolver) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+
+	resolvers[scheme] = resolver
+}
+
+type SecretsConfiguration struct {
+	// KubernetesDir is where Kubernetes secrets are mounted, k8s://name/key
+	// references read the file <KubernetesDir>/name/key
+	KubernetesDir string `env:"SECRETS_KUBERNETES_DIR" default:"/etc/secrets"`
+	// RefreshInterval is how often referenced secrets are read again to pick
+	// up rotations, zero disables it
+	RefreshInterval time.Duration `env:"SECRETS_REFRESH_INTERVAL" default:"30s" validate:"gte=0"`
+}
+
+// readSecretFile ignores the line break editors and `echo` add at the end of
+// files.
+func readSecretFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// kubernetesResolver reads the keys of the secrets mounted under dir.
+func kubernetesResolver(dir string) SecretResolver {
+	return func(location string) (string, error) {
+		name, key, ok := strings.Cut(location, "/")
+		if !ok || name == "" || key == "" || strings.Contains(key, "..") || strings.Contains(name, "..") {
+			return "", errors.New("must be k8s://<secret name>/<key>")
+		}
+
+		return readSecretFile(filepath.Join(dir, name, key))
+	}
+}
+
+// secretResolvers returns the registered resolvers along with the built-in
+// env and k8s ones. env references are resolved against the environment and
+// the .env file.
+func (l *loader) secretResolvers() map[string]SecretResolver {
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+
+	all := map[string]SecretResolver{
+		"env": func(name string) (string, error) {
+			if value, ok := l.env[name]; ok {
+				return value, nil
+			}
+			if value, ok := l.dotenv[name]; ok {
+				return value, nil
+			}
+			return "", fmt.Errorf("%s is not set", name)
+		},
+		"k8s": kubernetesResolver(l.secrets.KubernetesDir),
+	}
+	for scheme, resolver := range resolvers {
+		all[scheme] = resolver
+	}
+
+	return all
+}
+
+// resolveSecret returns the secret value points to, ok is false when value
+// isn't a reference.
+func resolveSecret(resolvers map[string]SecretResolver, value string) (secret string, ok bool, err error) {
+	scheme, location, found := strings.Cut(strings.TrimSpace(value), "://")
+	if !found {
+		return value, false, nil
+	}
+
+	resolver, found := resolvers[scheme]
+	if !found {
+		return value, false, nil
+	}
+
+	secret, err = resolver(location)
+	if err != nil {
+		return "", true, fmt.Errorf("cannot resolve %s: %w", value, err)
+	}
+
+	return secret, true, nil
+}
+
+// SecretWatcher reads referenced secrets again to notice when they are
+// rotated.
+type SecretWatcher struct {
+	resolvers  map[string]SecretResolver
+	references map[string]string
+
+	mu      sync.Mutex
+	watches map[string]*secretWatch
+}
+
+type secretWatch struct {
+	value     string
+	listeners []func(value string)
+}
+
+func NewSecretWatcher(c *Configuration) *SecretWatcher {
+	return &SecretWatcher{
+		resolvers:  c.resolvers,
+		references: c.References,
+		watches:    map[string]*secretWatch{},
+	}
+}
+
+// OnChange calls fn with the new value of the setting key whenever the secret
+// it references changes. Settings that aren't references never change.
+func (w *SecretWatcher) OnChange(key string, fn func(value string)) {
+	reference, ok := w.references[key]
+	if !ok {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watch, ok := w.watches[key]
+	if !ok {
+		value, _, err := resolveSecret(w.resolvers, reference)
+		if err != nil {
+			slog.Error("Error reading secret", "setting", key, "error", err)
+		}
+		watch = &secretWatch{value: value}
+		w.watches[key] = watch
+	}
+
+	watch.listeners = append(watch.listeners, fn)
+}
+
+// Check reads every watched secret and notifies the listeners of the ones
+// that changed. Secrets that can't be read keep their last value, rotations
+// often remove the file before writing the new one.
+func (w *SecretWatcher) Check() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for key, watch := range w.watches {
+		value, _, err := resolveSecret(w.resolvers, w.references[key])
+		if err != nil {
+			slog.Error("Error reading secret", "setting", key, "error", err)
+			continue
+		}
+		if value == watch.value || value == "" {
+			continue
+		}
+
+		slog.Info("Secret rotated", "setting", key)
+		watch.value = value
+		for _, listener := range watch.listeners {
+			listener(value)
+		}
+	}
+}
+
+// Start checks the secrets every interval until the returned function is
+// called.
+func (w *SecretWatcher) Start(interval time.Duration) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				w.Check()
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoaderResolvesReferences(t *testing.T) {
+	dir := inTempDir(t)
+	os.WriteFile(filepath.Join(dir, "db_password"), []byte("postgres://user:secret@db/api\n"), 0600)
+	os.MkdirAll(filepath.Join(dir, "redis"), 0700)
+	os.WriteFile(filepath.Join(dir, "redis", "password"), []byte("redis-secret"), 0600)
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_CONNECTION_STRING", "file://"+filepath.Join(dir, "db_password"))
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("REDIS_PASSWORD", "k8s://redis/password")
+	t.Setenv("REDIS_SENTINEL_PASSWORD", "env://SENTINEL_SECRET")
+	t.Setenv("SENTINEL_SECRET", "sentinel-secret")
+	t.Setenv("SECRETS_KUBERNETES_DIR", dir)
+
+	l := newLoader(nil)
+	webServerConfig, webServerErr := l.webServer()
+	cacheConfig, cacheErr := l.cache()
+
+	assert.Nil(t, webServerErr)
+	assert.Nil(t, cacheErr)
+	assert.Equal(t, "postgres://user:secret@db/api", webServerConfig.ConnectionString)
+	assert.Equal(t, "redis-secret", cacheConfig.RedisPassword)
+	assert.Equal(t, "sentinel-secret", cacheConfig.RedisSentinelPassword)
+	assert.Equal(t, "file://"+filepath.Join(dir, "db_password"), l.References["DB_CONNECTION_STRING"])
+	assert.Equal(t, SourceEnv, l.Sources["DB_CONNECTION_STRING"])
+	assert.NotContains(t, l.References, "ENV")
+}
+
+func TestLoaderReferenceErrors(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_CONNECTION_STRING", "file:///does/not/exist")
+	t.Setenv("ENABLE_TRANSPARENT_CACHE", "true")
+	t.Setenv("REDIS_PASSWORD", "env://MISSING_SECRET")
+	t.Setenv("REDIS_SENTINEL_PASSWORD", "k8s://../password")
+
+	l := newLoader(nil)
+	_, webServerErr := l.webServer()
+	_, cacheErr := l.cache()
+
+	assert.Contains(t, webServerErr.Error(), "DB_CONNECTION_STRING cannot resolve file:///does/not/exist")
+	assert.NotContains(t, webServerErr.Error(), "DB_CONNECTION_STRING is a required parameter")
+	assert.Contains(t, cacheErr.Error(), "REDIS_PASSWORD cannot resolve env://MISSING_SECRET: MISSING_SECRET is not set")
+	assert.Contains(t, cacheErr.Error(), "REDIS_SENTINEL_PASSWORD cannot resolve k8s://../password: must be k8s://<secret name>/<key>")
+}
+
+func TestRegisterSecretResolver(t *testing.T) {
+	inTempDir(t)
+	RegisterSecretResolver("vault", func(location string) (string, error) {
+		if location == "secret/db" {
+			return "postgres://vault@db/api", nil
+		}
+		return "", errors.New("not found")
+	})
+	t.Cleanup(func() {
+		resolversMu.Lock()
+		delete(resolvers, "vault")
+		resolversMu.Unlock()
+	})
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_CONNECTION_STRING", "vault://secret/db")
+
+	config, err := newLoader(nil).webServer()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "postgres://vault@db/api", config.ConnectionString)
+}
+
+func TestPrintReferences(t *testing.T) {
+	dir := inTempDir(t)
+	os.WriteFile(filepath.Join(dir, "db_password"), []byte("postgres://user:password@db/api"), 0600)
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_CONNECTION_STRING", "file://"+filepath.Join(dir, "db_password"))
+	t.Setenv("AUTH_CONFIG_URL", "http://provider")
+	t.Setenv("AUTH_AUDIENCE", "env://AUDIENCE")
+	t.Setenv("AUDIENCE", "api")
+
+	config, err := Inspect(nil)
+	assert.Nil(t, err)
+
+	buffer := new(bytes.Buffer)
+	config.Print(buffer, true)
+
+	assert.Contains(t, buffer.String(), "DB_CONNECTION_STRING=<redacted> # env, file://"+filepath.Join(dir, "db_password")+"\n")
+	assert.Contains(t, buffer.String(), "AUTH_AUDIENCE=<redacted> # env, env://AUDIENCE\n")
+	assert.Contains(t, buffer.String(), "# secrets\n")
+	assert.NotContains(t, buffer.String(), "password@db")
+}
+
+func TestSecretWatcher(t *testing.T) {
+	dir := inTempDir(t)
+	path := filepath.Join(dir, "db_password")
+	os.WriteFile(path, []byte("postgres://user:old@db/api"), 0600)
+	t.Setenv("ENV", "TEST")
+	t.Setenv("DB_CONNECTION_STRING", "file://"+path)
+	t.Setenv("AUTH_CONFIG_URL", "http://provider")
+
+	config, err := Inspect(nil)
+	assert.Nil(t, err)
+
+	var rotated []string
+	watcher := NewSecretWatcher(config)
+	watcher.OnChange("DB_CONNECTION_STRING", func(value string) { rotated = append(rotated, value) })
+	watcher.OnChange("AUTH_CONFIG_URL", func(value string) { t.Error("AUTH_CONFIG_URL isn't a reference") })
+
+	watcher.Check()
+	assert.Empty(t, rotated)
+
+	os.WriteFile(path, []byte("postgres://user:new@db/api\n"), 0600)
+	watcher.Check()
+	assert.Equal(t, []string{"postgres://user:new@db/api"}, rotated)
+
+	// the previous value is kept while the file is being replaced
+	os.Remove(path)
+	watcher.Check()
+	watcher.Check()
+	assert.Len(t, rotated, 1)
+
+	os.WriteFile(path, []byte("postgres://user:newer@db/api"), 0600)
+	watcher.Check()
+	assert.Equal(t, "postgres://user:newer@db/api", rotated[len(rotated)-1])
+	assert.True(t, strings.HasSuffix(config.References["DB_CONNECTION_STRING"], "db_password"))
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuths = map[string]tls.ClientAuthType{
+	"none":     tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"require":  tls.RequireAndVerifyClientCert,
+}
+
+// UseTLS tells whether the server is served over TLS.
+func (c *WebServerConfiguration) UseTLS() bool {
+	return c.TLSCertFile != "" && c.TLSCertKeyFile != ""
+}
+
+func (c *WebServerConfiguration) checkTLS() []error {
+	var errs []error
+
+	for _, name := range c.TLSCipherSuites {
+		if _, ok := cipherSuite(name); !ok {
+			errs = append(errs, fmt.Errorf("TLS_CIPHER_SUITES %s isn't a supported cipher suite", name))
+		}
+	}
+
+	if c.TLSClientAuth == "" || c.TLSClientAuth == "none" {
+		return errs
+	}
+
+	if !c.UseTLS() {
+		errs = append(errs, fmt.Errorf("TLS_CLIENT_AUTH requires TLS_CERT_FILE and TLS_CERT_KEY_FILE"))
+	}
+
+	if c.TLSClientCAFile == "" {
+		errs = append(errs, fmt.Errorf("TLS_CLIENT_CA_FILE is required when TLS_CLIENT_AUTH is %s", c.TLSClientAuth))
+	} else if _, err := c.clientCAs(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+func cipherSuite(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+
+	return 0, false
+}
+
+func (c *WebServerConfiguration) clientCAs() (*x509.CertPool, error) {
+	ca, err := os.ReadFile(c.TLSClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("TLS_CLIENT_CA_FILE cannot be read: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("TLS_CLIENT_CA_FILE has no certificate")
+	}
+
+	return pool, nil
+}
+
+// TLSConfig is the TLS configuration of the server. The certificate is
+// reloaded when its files change, so it can be rotated without a restart.
+func (c *WebServerConfiguration) TLSConfig() (*tls.Config, error) {
+	reloader, err := newCertificateReloader(c.TLSCertFile, c.TLSCertKeyFile, c.TLSReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tlsVersions[c.TLSMinVersion],
+		GetCertificate: reloader.GetCertificate,
+		ClientAuth:     tlsClientAuths[c.TLSClientAuth],
+	}
+
+	for _, name := range c.TLSCipherSuites {
+		id, _ := cipherSuite(name)
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+	}
+
+	if tlsConfig.ClientAuth != tls.NoClientCert {
+		if tlsConfig.ClientCAs, err = c.clientCAs(); err != nil {
+			return nil, err
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// certificateReloader serves a certificate and reloads it when its files
+// were modified, at most every interval. The last certificate is kept while
+// the files can't be loaded, e.g. halfway through their rotation.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	modified    time.Time
+	checked     time.Time
+	now         func() time.Time
+}
+
+func newCertificateReloader(certFile string, keyFile string, interval time.Duration) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile, interval: interval, now: time.Now}
+
+	modified, err := r.lastModified()
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	r.certificate, r.modified, r.checked = &certificate, modified, r.now()
+
+	return r, nil
+}
+
+// lastModified is the latest modification of the certificate or key file.
+func (r *certificateReloader) lastModified() (time.Time, error) {
+	var modified time.Time
+
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("reading TLS certificate: %w", err)
+		}
+
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+
+	return modified, nil
+}
+
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if r.interval <= 0 || now.Sub(r.checked) < r.interval {
+		return r.certificate, nil
+	}
+	r.checked = now
+
+	modified, err := r.lastModified()
+	if err != nil {
+		slog.Error("Error checking TLS certificate, keeping the current one", "error", err)
+		return r.certificate, nil
+	}
+
+	if modified.Equal(r.modified) {
+		return r.certificate, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		slog.Error("Error reloading TLS certificate, keeping the current one", "error", err)
+		return r.certificate, nil
+	}
+
+	r.certificate, r.modified = &certificate, modified
+	slog.Info("TLS certificate reloaded", "file", r.certFile)
+
+	return r.certificate, nil
+}
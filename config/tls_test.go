@@ -0,0 +1,128 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCertificate writes a self-signed certificate for commonName and its
+// key into dir.
+func writeCertificate(t *testing.T, dir string, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, certificate *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	assert.Nil(t, err)
+	return parsed.Subject.CommonName
+}
+
+func TestTLSConfig(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir(), "server")
+	caFile, _ := writeCertificate(t, t.TempDir(), "ca")
+
+	config := &WebServerConfiguration{
+		TLSCertFile:     certFile,
+		TLSCertKeyFile:  keyFile,
+		TLSMinVersion:   "1.3",
+		TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		TLSClientAuth:   "require",
+		TLSClientCAFile: caFile,
+	}
+	assert.Empty(t, config.checkTLS())
+
+	tlsConfig, err := config.TLSConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, tlsConfig.CipherSuites)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+	assert.NotNil(t, tlsConfig.ClientCAs)
+
+	certificate, err := tlsConfig.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "server", commonName(t, certificate))
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	config := &WebServerConfiguration{
+		TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+		TLSClientAuth:   "optional",
+	}
+
+	assert.Equal(t, []string{
+		"TLS_CIPHER_SUITES TLS_RSA_WITH_RC4_128_SHA isn't a supported cipher suite",
+		"TLS_CLIENT_AUTH requires TLS_CERT_FILE and TLS_CERT_KEY_FILE",
+		"TLS_CLIENT_CA_FILE is required when TLS_CLIENT_AUTH is optional",
+	}, errorStrings(config.checkTLS()))
+}
+
+func errorStrings(errs []error) []string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return messages
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeCertificate(t, dir, "first")
+
+	reloader, err := newCertificateReloader(certFile, keyFile, time.Minute)
+	assert.Nil(t, err)
+	now := time.Now()
+	reloader.now = func() time.Time { return now }
+
+	writeCertificate(t, dir, "second")
+	future := time.Now().Add(time.Second)
+	assert.Nil(t, os.Chtimes(certFile, future, future))
+
+	// checked at most every interval
+	certificate, _ := reloader.GetCertificate(nil)
+	assert.Equal(t, "first", commonName(t, certificate))
+
+	now = now.Add(time.Minute)
+	certificate, _ = reloader.GetCertificate(nil)
+	assert.Equal(t, "second", commonName(t, certificate))
+
+	// a broken rotation keeps the current certificate
+	assert.Nil(t, os.WriteFile(keyFile, []byte("broken"), 0600))
+	later := future.Add(time.Second)
+	assert.Nil(t, os.Chtimes(keyFile, later, later))
+	now = now.Add(time.Minute)
+	certificate, _ = reloader.GetCertificate(nil)
+	assert.Equal(t, "second", commonName(t, certificate))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"io"
+
+	"goapi-template/config"
+)
+
+const configUsage = `usage: config print [--redacted] [flags]
+
+commands:
+  print  print the effective configuration and where each setting comes from,
+         --redacted masks secrets such as connection strings and passwords`
+
+var errConfigUsage = errors.New(configUsage)
+
+// runConfig runs the config subcommand, e.g. `go run . config print --redacted`.
+// The flags following print are applied like they are when starting the API.
+func runConfig(args []string, w io.Writer) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errConfigUsage
+	}
+
+	redact := false
+	var flags []string
+	for _, arg := range args[1:] {
+		if arg == "--redacted" || arg == "-redacted" {
+			redact = true
+		} else {
+			flags = append(flags, arg)
+		}
+	}
+
+	configValues, err := config.Inspect(flags)
+	configValues.Print(w, redact)
+
+	return err
+}
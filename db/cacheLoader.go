@@ -0,0 +1,198 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"goapi-template/cache"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// cachedValue wraps cached results with a soft and a hard expiration. Until
+// the soft expiration the value is fresh, then until the hard expiration it is
+// served stale while it is refreshed in the background, after which it is
+// ignored. Misses are cached as NotFound entries.
+type cachedValue[T any] struct {
+	Value         T     `json:"value"`
+	NotFound      bool  `json:"notFound,omitempty"`
+	SoftExpiresAt int64 `json:"softExpiresAt"`
+	HardExpiresAt int64 `json:"hardExpiresAt"`
+}
+
+// cacheVersion must be bumped whenever Person or the layout of cachedValue
+// changes, so entries cached by previous releases are ignored rather than
+// decoded into the new layout.
+const cacheVersion = 2
+
+func (v cachedValue[T]) CacheVersion() uint16 {
+	return cacheVersion
+}
+
+func (v *cachedValue[T]) result() (T, error) {
+	if v.NotFound {
+		return v.Value, pgx.ErrNoRows
+	}
+
+	return v.Value, nil
+}
+
+// newEntry wraps value for caching along with how long the cache should keep
+// it, which is until the value can no longer be served at all.
+func newEntry[T any](c *CachingQuerier, entity string, value T, notFound bool) (*cachedValue[T], time.Duration) {
+	now := time.Now()
+
+	if notFound {
+		expiresAt := now.Add(c.NegativeExpiration).UnixMilli()
+		return &cachedValue[T]{NotFound: true, SoftExpiresAt: expiresAt, HardExpiresAt: expiresAt}, c.NegativeExpiration
+	}
+
+	soft, hard := c.expirations(entity)
+	hard = c.jitter(hard)
+
+	return &cachedValue[T]{
+		Value:         value,
+		SoftExpiresAt: now.Add(c.jitter(soft)).UnixMilli(),
+		HardExpiresAt: now.Add(hard).UnixMilli(),
+	}, hard
+}
+
+// expirations returns the soft and hard expirations of entity. An entity
+// expiration replaces HardExpiration and the soft expiration keeps the same
+// proportion of it.
+func (c *CachingQuerier) expirations(entity string) (time.Duration, time.Duration) {
+	expiration, ok := c.EntityExpirations[entity]
+	if !ok || c.HardExpiration <= 0 {
+		return c.SoftExpiration, c.HardExpiration
+	}
+
+	return time.Duration(float64(c.SoftExpiration) * float64(expiration) / float64(c.HardExpiration)), expiration
+}
+
+// jitter spreads expirations by +/- Jitter so keys cached at the same time
+// don't all expire at the same time either
+func (c *CachingQuerier) jitter(d time.Duration) time.Duration {
+	if c.Jitter <= 0 || d <= 0 {
+		return d
+	}
+
+	spread := float64(d) * c.Jitter
+	return d + time.Duration(spread*(2*rand.Float64()-1))
+}
+
+// load returns the value cached under key or fetches it. Concurrent misses
+// for the same key are coalesced so only one of them reaches the database,
+// and across replicas as well when a distributed Locker is configured. The
+// fetch is shared with other requests and its result cached, see
+// fillContext.
+func load[T any](c *CachingQuerier, ctx context.Context, entity string, key string, fetch func(ctx context.Context) (T, error)) (T, error) {
+	cached, err := cache.GetObject[cachedValue[T]](c.Cache, ctx, key)
+	if err != nil {
+		logCacheError("Error getting value from cache", err, "key", key)
+	}
+
+	now := time.Now().UnixMilli()
+
+	if cached != nil && now < cached.HardExpiresAt {
+		if now >= cached.SoftExpiresAt {
+			// stale while revalidate
+			c.group.DoChan(key, func() (any, error) {
+				return refresh(c, fillContext(ctx), entity, key, fetch)
+			})
+		}
+
+		return cached.result()
+	}
+
+	// the shared fetch must not be cancelled because the first caller went
+	// away, every other caller waiting on it would fail as well
+	resultChan := c.group.DoChan(key, func() (any, error) {
+		return refresh(c, fillContext(ctx), entity, key, fetch)
+	})
+
+	select {
+	case <-ctx.Done():
+		var empty T
+		return empty, ctx.Err()
+	case result := <-resultChan:
+		if result.Err != nil {
+			var empty T
+			return empty, result.Err
+		}
+
+		return result.Val.(*cachedValue[T]).result()
+	}
+}
+
+// fillContext is the context of the fetches filling the cache. They outlive
+// the request of ctx, don't run in its transaction whose uncommitted writes
+// they would cache, and read from the primary rather than from a replica
+// that may be behind.
+func fillContext(ctx context.Context) context.Context {
+	return WithPrimaryReads(withoutRequestTx(context.WithoutCancel(ctx)))
+}
+
+// refresh fetches the value and caches it. With a distributed Locker, a
+// replica that doesn't get the lock waits for the holder to fill the cache
+// and only fetches on its own if that takes longer than LockTimeout.
+func refresh[T any](c *CachingQuerier, ctx context.Context, entity string, key string, fetch func(ctx context.Context) (T, error)) (*cachedValue[T], error) {
+	if c.Locker != nil {
+		release, acquired, err := c.Locker.TryLock(ctx, key, c.LockTimeout)
+		if err != nil {
+			logCacheError("Error acquiring cache lock", err, "key", key)
+		}
+		defer release()
+
+		if err == nil && !acquired {
+			if cached := waitForFill[T](c, ctx, key); cached != nil {
+				return cached, nil
+			}
+		}
+	}
+
+	value, err := fetch(ctx)
+
+	notFound := errors.Is(err, pgx.ErrNoRows)
+	if err != nil && !notFound {
+		// errors are never cached
+		return nil, err
+	}
+
+	entry, expiration := newEntry(c, entity, value, notFound)
+	if err := cache.SetObject(c.Cache, ctx, key, entry, cache.WithExpiration(expiration)); err != nil {
+		logCacheError("Error setting value into cache", err, "key", key)
+	}
+
+	return &cachedValue[T]{Value: value, NotFound: notFound}, nil
+}
+
+func waitForFill[T any](c *CachingQuerier, ctx context.Context, key string) *cachedValue[T] {
+	deadline := time.Now().Add(c.LockTimeout)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+
+		cached, err := cache.GetObject[cachedValue[T]](c.Cache, ctx, key)
+		if err != nil {
+			return nil
+		}
+
+		if cached != nil && time.Now().UnixMilli() < cached.SoftExpiresAt {
+			return cached
+		}
+	}
+
+	return nil
+}
+
+// logCacheError logs cache failures, except while the circuit breaker is open
+// since the cache is bypassed on purpose then.
+func logCacheError(msg string, err error, args ...any) {
+	if errors.Is(err, cache.ErrCircuitOpen) {
+		return
+	}
+
+	slog.Error(msg, append(args, "error", err)...)
+}
@@ -2,52 +2,104 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"goapi-template/cache"
-	"log/slog"
+	"sync/atomic"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache keys are namespaced by tag generations (see cache.TaggedKey).
+// Every person key is tagged with personTag so all of them can be dropped at
+// once, list keys are additionally tagged with the personListTag of their
+// tenant which is invalidated by every write. Person keys are tagged with
+// their own key as well, which is invalidated by the writes to the person so
+// that a refresh started before a write stores its value under the previous
+// generation, where it is never read.
+const (
+	personTag     = "person"
+	personListTag = "person:list"
 )
 
 type CachingQuerier struct {
 	Queries Querier
 	Cache   cache.Cacher
+	// SoftExpiration is how long a value is fresh, after that it is served
+	// stale while being refreshed in the background
+	SoftExpiration time.Duration
+	// HardExpiration is how long a value may be served at all
+	HardExpiration time.Duration
+	// NegativeExpiration is how long a missing person is remembered
+	NegativeExpiration time.Duration
+	// EntityExpirations overrides HardExpiration per entity, either personTag
+	// or personListTag
+	EntityExpirations map[string]time.Duration
+	// Jitter spreads expirations by a fraction of their duration, e.g. 0.1
+	Jitter float64
+	// Locker, when set, coalesces cache misses across replicas
+	Locker      cache.Locker
+	LockTimeout time.Duration
+	group       singleflight.Group
+	// stale is set when a write couldn't update the cache
+	stale atomic.Bool
 }
 
-func (c *CachingQuerier) GetPeople(ctx context.Context) ([]Person, error) {
-	cached, err := cache.GetObject[[]Person](c.Cache, ctx, "person:all")
+func personKey(id int32) string {
+	return fmt.Sprintf("person:%d", id)
+}
 
-	if err != nil {
-		slog.Error("Error getting person by id from cache", "error", err)
+// personCacheKey is the key person id is currently cached under.
+func (c *CachingQuerier) personCacheKey(ctx context.Context, id int32) (string, error) {
+	key := scoped(ctx, personKey(id))
+	return cache.TaggedKey(ctx, c.Cache, key, personTag, key)
+}
+
+// scoped namespaces key by the tenant of ctx, tenants never share entries.
+func scoped(ctx context.Context, key string) string {
+	if tenant := TenantFrom(ctx); tenant != "" {
+		return "tenant:" + tenant + ":" + key
 	}
 
-	if cached != nil {
-		return *cached, nil
+	return key
+}
+
+func (c *CachingQuerier) GetPeople(ctx context.Context) ([]Person, error) {
+	if !c.cacheUsable(ctx) {
+		return c.Queries.GetPeople(ctx)
 	}
 
-	result, err := c.Queries.GetPeople(ctx)
+	key, err := cache.TaggedKey(ctx, c.Cache, scoped(ctx, "person:all"), personTag, scoped(ctx, personListTag))
+	if err != nil {
+		logCacheError("Error getting people from cache", err)
+		return c.Queries.GetPeople(ctx)
+	}
 
-	cache.SetObject(c.Cache, ctx, "person:all", &result)
+	return load(c, ctx, personListTag, key, c.Queries.GetPeople)
+}
 
-	return result, err
+// GetPeopleFiltered is not cached since the filter is derived from the
+// caller's authorization and results would leak between users.
+func (c *CachingQuerier) GetPeopleFiltered(ctx context.Context, filter Filter) ([]Person, error) {
+	return c.Queries.GetPeopleFiltered(ctx, filter)
 }
 
 func (c *CachingQuerier) GetPersonById(ctx context.Context, id int32) (Person, error) {
-	cached, err := cache.GetObject[Person](c.Cache, ctx, fmt.Sprintf("person:%d", id))
-
-	if err != nil {
-		slog.Error("Error getting person by id from cache", "error", err)
+	if !c.cacheUsable(ctx) {
+		return c.Queries.GetPersonById(ctx, id)
 	}
 
-	if cached != nil {
-		return *cached, nil
+	key, err := c.personCacheKey(ctx, id)
+	if err != nil {
+		logCacheError("Error getting person by id from cache", err)
+		return c.Queries.GetPersonById(ctx, id)
 	}
 
-	result, err := c.Queries.GetPersonById(ctx, id)
-
-	cache.SetObject(c.Cache, ctx, fmt.Sprintf("person:%d", id), &result)
-
-	return result, err
+	return load(c, ctx, personTag, key, func(ctx context.Context) (Person, error) {
+		return c.Queries.GetPersonById(ctx, id)
+	})
 }
 
 func (c *CachingQuerier) InsertPerson(ctx context.Context, arg InsertPersonParams) (Person, error) {
@@ -57,13 +109,32 @@ func (c *CachingQuerier) InsertPerson(ctx context.Context, arg InsertPersonParam
 		return person, err
 	}
 
-	err = cache.SetObject(c.Cache, ctx, fmt.Sprintf("person:%d", person.ID), &person)
+	afterCommitOf(ctx, func(ctx context.Context) {
+		if err := c.setPerson(ctx, &person); err != nil {
+			c.cacheWriteFailed("Error setting person by id into cache", err)
+		}
+	})
+
+	// the person was saved, a cache failure must not fail the request
+	return person, nil
+}
+
+// InsertPeople only invalidates the lists, caching every person of a large
+// batch would evict the entries that are actually read.
+func (c *CachingQuerier) InsertPeople(ctx context.Context, arg InsertPeopleParams) ([]Person, error) {
+	people, err := c.Queries.InsertPeople(ctx, arg)
 
 	if err != nil {
-		slog.Error("Error setting person by id into cache", "error", err)
+		return people, err
 	}
 
-	return person, err
+	afterCommitOf(ctx, func(ctx context.Context) {
+		if err := c.forgetPeopleLists(ctx); err != nil {
+			c.cacheWriteFailed("Error invalidating people lists in cache", err)
+		}
+	})
+
+	return people, nil
 }
 
 func (c *CachingQuerier) UpdatePerson(ctx context.Context, arg UpdatePersonParams) (int64, error) {
@@ -73,22 +144,18 @@ func (c *CachingQuerier) UpdatePerson(ctx context.Context, arg UpdatePersonParam
 		return personId, err
 	}
 
-	cacheObj := &Person{
-		ID:         arg.ID,
-		Name:       arg.Name,
-		Email:      arg.Email,
-		UpdateUser: arg.UpdateUser,
-		CreatedAt:  pgtype.Timestamp{Time: arg.CreatedAt.Time, Valid: true},
-		UpdatedAt:  pgtype.Timestamp{Time: arg.UpdatedAt.Time, Valid: true},
+	if personId == 0 {
+		// nothing was updated, so there's nothing to refresh either
+		return personId, nil
 	}
 
-	err = cache.SetObject(c.Cache, ctx, fmt.Sprintf("person:%d", arg.ID), cacheObj)
+	afterCommitOf(ctx, func(ctx context.Context) {
+		if err := c.setPerson(ctx, updatedPerson(ctx, arg)); err != nil {
+			c.cacheWriteFailed("Error setting person by id into cache", err)
+		}
+	})
 
-	if err != nil {
-		slog.Error("Error setting person by id into cache", "error", err)
-	}
-
-	return personId, err
+	return personId, nil
 }
 
 func (c *CachingQuerier) DeletePerson(ctx context.Context, id int32) (int64, error) {
@@ -98,19 +165,117 @@ func (c *CachingQuerier) DeletePerson(ctx context.Context, id int32) (int64, err
 		return personId, err
 	}
 
-	err = c.Cache.DeleteKey(ctx, fmt.Sprintf("person:%d", id))
+	afterCommitOf(ctx, func(ctx context.Context) {
+		if err := c.forgetPerson(ctx, id); err != nil {
+			c.cacheWriteFailed("Error deleting person by id from cache", err)
+		}
+	})
+
+	return personId, nil
+}
+
+func (c *CachingQuerier) PingDb(ctx context.Context) (int32, error) {
+	return c.Queries.PingDb(ctx)
+}
+
+// InvalidateAll drops every cached person and list of people.
+func (c *CachingQuerier) InvalidateAll(ctx context.Context) error {
+	return cache.InvalidateTags(ctx, c.Cache, personTag)
+}
+
+// CacheHealthy returns an error while the cache is bypassed because its
+// circuit breaker is open.
+func (c *CachingQuerier) CacheHealthy() error {
+	if checker, ok := c.Cache.(interface{ Healthy() error }); ok {
+		return checker.Healthy()
+	}
+
+	return nil
+}
+
+// cacheWriteFailed marks the cache as stale since it may still hold what the
+// write changed.
+func (c *CachingQuerier) cacheWriteFailed(msg string, err error) {
+	logCacheError(msg, err)
+	c.stale.Store(true)
+}
+
+// cacheUsable reports whether the cache can be read. After a write couldn't
+// update it, every cached person is dropped as soon as the cache is reachable
+// again, until then it is bypassed.
+func (c *CachingQuerier) cacheUsable(ctx context.Context) bool {
+	if !c.stale.Load() {
+		return true
+	}
+
+	if err := c.InvalidateAll(ctx); err != nil {
+		logCacheError("Error invalidating stale cache", err)
+		return false
+	}
+
+	c.stale.Store(false)
+
+	return true
+}
+
+// setPerson caches person under a new generation of its key, and
+// invalidates the lists it may belong to.
+func (c *CachingQuerier) setPerson(ctx context.Context, person *Person) error {
+	if err := cache.InvalidateTags(ctx, c.Cache, scoped(ctx, personKey(person.ID)), scoped(ctx, personListTag)); err != nil {
+		return err
+	}
+
+	key, err := c.personCacheKey(ctx, person.ID)
+	if err != nil {
+		return err
+	}
 
+	entry, expiration := newEntry(c, personTag, *person, false)
+
+	return cache.SetObject(c.Cache, ctx, key, entry, cache.WithExpiration(expiration))
+}
+
+// forgetPerson drops person id, moves its key to a new generation and
+// invalidates the lists it belonged to.
+func (c *CachingQuerier) forgetPerson(ctx context.Context, id int32) error {
+	key, err := c.personCacheKey(ctx, id)
 	if err != nil {
-		slog.Error("Error deleting person by id from cache", "error", err)
+		return err
 	}
 
-	return personId, err
+	return errors.Join(
+		cache.InvalidateTags(ctx, c.Cache, scoped(ctx, personKey(id)), scoped(ctx, personListTag)),
+		c.Cache.DeleteKey(ctx, key),
+	)
 }
 
-func (c *CachingQuerier) PingDb(ctx context.Context) (int32, error) {
-	return c.Queries.PingDb(ctx)
+// forgetPeopleLists drops the cached lists of the tenant, the people
+// themselves are cached once read.
+func (c *CachingQuerier) forgetPeopleLists(ctx context.Context) error {
+	return cache.InvalidateTags(ctx, c.Cache, scoped(ctx, personListTag))
+}
+
+// updatedPerson is the person as saved by UpdatePerson.
+func updatedPerson(ctx context.Context, arg UpdatePersonParams) *Person {
+	return &Person{
+		TenantID:   TenantFrom(ctx),
+		ID:         arg.ID,
+		Name:       arg.Name,
+		Email:      arg.Email,
+		UpdateUser: arg.UpdateUser,
+		CreatedAt:  pgtype.Timestamp{Time: arg.CreatedAt.Time, Valid: true},
+		UpdatedAt:  pgtype.Timestamp{Time: arg.UpdatedAt.Time, Valid: true},
+	}
 }
 
 func NewCachingQuerier(querier Querier, cacher cache.Cacher) *CachingQuerier {
-	return &CachingQuerier{Queries: querier, Cache: cacher}
+	return &CachingQuerier{
+		Queries:            querier,
+		Cache:              cacher,
+		SoftExpiration:     30 * time.Minute,
+		HardExpiration:     time.Hour,
+		NegativeExpiration: 30 * time.Second,
+		Jitter:             0.1,
+		LockTimeout:        5 * time.Second,
+	}
 }
@@ -2,44 +2,163 @@ package db
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"goapi-template/cache"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
 )
 
 type CacherMock struct {
-	GetStringResult string
-	GetStringError  error
-	SetStringKey    string
-	SetStringValue  string
-	SetStringError  error
-	DeleteKeyError  error
-	DeleteKeyKey    string
+	mu             sync.Mutex
+	Values         map[string]string
+	GetStringError error
+	SetStringError error
+	DeleteKeyError error
+	DeleteKeyKey   string
+	Expirations    map[string]time.Duration
 }
 
 func (m *CacherMock) GetString(ctx context.Context, key string) (string, error) {
-	return m.GetStringResult, m.GetStringError
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.GetStringError != nil {
+		return "", m.GetStringError
+	}
+	return m.Values[key], nil
 }
 
-func (m *CacherMock) SetString(ctx context.Context, key string, value string) error {
-	m.SetStringKey = key
-	m.SetStringValue = value
-	return m.SetStringError
+func (m *CacherMock) SetString(ctx context.Context, key string, value string, opts ...cache.SetOption) error {
+	return m.MSet(ctx, map[string]string{key: value}, opts...)
 }
 
 func (m *CacherMock) DeleteKey(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.DeleteKeyKey = key
-	return m.DeleteKeyError
+	if m.DeleteKeyError != nil {
+		return m.DeleteKeyError
+	}
+	delete(m.Values, key)
+	return nil
+}
+
+func (m *CacherMock) MGet(ctx context.Context, keys ...string) ([]string, error) {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		value, err := m.GetString(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (m *CacherMock) MSet(ctx context.Context, values map[string]string, opts ...cache.SetOption) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.SetStringError != nil {
+		return m.SetStringError
+	}
+	options := &cache.SetOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if m.Expirations == nil {
+		m.Expirations = map[string]time.Duration{}
+	}
+	for key, value := range values {
+		m.Values[key] = value
+		m.Expirations[key] = options.Expiration
+	}
+	return nil
+}
+
+func (m *CacherMock) DeleteByPattern(ctx context.Context, pattern string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key := range m.Values {
+		if matched, _ := path.Match(pattern, key); matched {
+			delete(m.Values, key)
+		}
+	}
+	return nil
+}
+
+// newCacherMock returns a cache where every tag is at generation "1", the
+// tags of people 1 and 2 included
+func newCacherMock(values map[string]string) *CacherMock {
+	mock := &CacherMock{
+		Values: map[string]string{
+			"tag:person":               "1",
+			"tag:person:list":          "1",
+			"tag:person:1":             "1",
+			"tag:person:2":             "1",
+			"tag:tenant:acme:person:1": "1",
+			"tag:tenant:acme:person:2": "1",
+		},
+		Expirations: map[string]time.Duration{},
+	}
+	for key, value := range values {
+		mock.Values[key] = value
+	}
+	return mock
+}
+
+// currentPersonKey is the key person id is cached under after the writes
+// moved it to a new generation
+func currentPersonKey(t *testing.T, querier *CachingQuerier, ctx context.Context, id int32) string {
+	key, err := querier.personCacheKey(ctx, id)
+	assert.Nil(t, err)
+	return key
+}
+
+// entry encodes a JSON cache entry the way cache.SetObject does
+func entry(value string) string {
+	p, _ := (&cache.Serializer{Codec: cache.CodecJSON}).Encode(json.RawMessage(value), cacheVersion)
+	return string(p)
+}
+
+// fresh wraps value in a cache entry that hasn't expired
+func fresh(value string) string {
+	expiresAt := time.Now().Add(time.Hour).UnixMilli()
+	return entry(fmt.Sprintf(`{"value":%s,"softExpiresAt":%d,"hardExpiresAt":%d}`, value, expiresAt, expiresAt))
+}
+
+type LockerMock struct {
+	Acquired bool
+	OnWait   func()
+}
+
+func (m *LockerMock) TryLock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	if !m.Acquired && m.OnWait != nil {
+		m.OnWait()
+	}
+	return func() {}, m.Acquired, nil
 }
 
 type QuerierMock struct {
 	GetPeopleResult     []Person
 	GetPeopleError      error
+	GetPeopleFilter     Filter
 	GetPersonByIdResult Person
 	GetPersonByIdError  error
+	GetPersonByIdCalls  atomic.Int32
+	GetPersonByIdDelay  time.Duration
 	InsertPersonResult  Person
 	InsertPersonError   error
+	InsertPeopleResult  []Person
+	InsertPeopleError   error
 	UpdatePersonResult  int64
 	UpdatePersonError   error
 	DeletePersonResult  int64
@@ -52,7 +171,14 @@ func (m *QuerierMock) GetPeople(ctx context.Context) ([]Person, error) {
 	return m.GetPeopleResult, m.GetPeopleError
 }
 
+func (m *QuerierMock) GetPeopleFiltered(ctx context.Context, filter Filter) ([]Person, error) {
+	m.GetPeopleFilter = filter
+	return m.GetPeopleResult, m.GetPeopleError
+}
+
 func (m *QuerierMock) GetPersonById(ctx context.Context, id int32) (Person, error) {
+	m.GetPersonByIdCalls.Add(1)
+	time.Sleep(m.GetPersonByIdDelay)
 	return m.GetPersonByIdResult, m.GetPersonByIdError
 }
 
@@ -60,6 +186,10 @@ func (m *QuerierMock) InsertPerson(ctx context.Context, arg InsertPersonParams)
 	return m.InsertPersonResult, m.InsertPersonError
 }
 
+func (m *QuerierMock) InsertPeople(ctx context.Context, arg InsertPeopleParams) ([]Person, error) {
+	return m.InsertPeopleResult, m.InsertPeopleError
+}
+
 func (m *QuerierMock) UpdatePerson(ctx context.Context, arg UpdatePersonParams) (int64, error) {
 	return m.UpdatePersonResult, m.UpdatePersonError
 }
@@ -79,9 +209,9 @@ func TestNewCachingQuerier(t *testing.T) {
 }
 
 func TestGetPeopleWithCacheSuccess(t *testing.T) {
-	querier := NewCachingQuerier(&QuerierMock{}, &CacherMock{
-		GetStringResult: `[{"ID":1,"Name":"Test"}]`,
-	})
+	querier := NewCachingQuerier(&QuerierMock{}, newCacherMock(map[string]string{
+		"person:all@1.1": fresh(`[{"ID":1,"Name":"Test"}]`),
+	}))
 	result, err := querier.GetPeople(context.Background())
 
 	assert.Nil(t, err)
@@ -90,14 +220,16 @@ func TestGetPeopleWithCacheSuccess(t *testing.T) {
 }
 
 func TestGetPeopleWithCacheMiss(t *testing.T) {
+	cacherMock := newCacherMock(nil)
 	querier := NewCachingQuerier(&QuerierMock{
 		GetPeopleResult: []Person{{ID: 1, Name: "Test"}},
-	}, &CacherMock{})
+	}, cacherMock)
 	result, err := querier.GetPeople(context.Background())
 
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(result))
 	assert.Equal(t, int32(1), result[0].ID)
+	assert.Contains(t, cacherMock.Values, "person:all@1.1")
 }
 
 func TestGetPeopleWithCacheFail(t *testing.T) {
@@ -113,26 +245,53 @@ func TestGetPeopleWithCacheFail(t *testing.T) {
 	assert.Equal(t, int32(1), result[0].ID)
 }
 
+func TestGetPeopleErrorNotCached(t *testing.T) {
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(&QuerierMock{
+		GetPeopleError: fmt.Errorf("error"),
+	}, cacherMock)
+	_, err := querier.GetPeople(context.Background())
+
+	assert.NotNil(t, err)
+	assert.NotContains(t, cacherMock.Values, "person:all@1.1")
+}
+
+func TestGetPeopleCreatesTagGenerations(t *testing.T) {
+	cacherMock := &CacherMock{Values: map[string]string{}}
+	querier := NewCachingQuerier(&QuerierMock{
+		GetPeopleResult: []Person{{ID: 1, Name: "Test"}},
+	}, cacherMock)
+	querier.GetPeople(context.Background())
+
+	assert.NotEmpty(t, cacherMock.Values["tag:person"])
+	assert.NotEmpty(t, cacherMock.Values["tag:person:list"])
+	assert.Len(t, cacherMock.Values, 3)
+}
+
 func TestGetPersonWithCacheSuccess(t *testing.T) {
-	querier := NewCachingQuerier(&QuerierMock{}, &CacherMock{
-		GetStringResult: `{"ID":1,"Name":"Test"}`,
-	})
+	querierMock := &QuerierMock{}
+	querier := NewCachingQuerier(querierMock, newCacherMock(map[string]string{
+		"person:1@1.1": fresh(`{"ID":1,"Name":"Test"}`),
+	}))
 	result, err := querier.GetPersonById(context.Background(), 1)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, result)
 	assert.Equal(t, int32(1), result.ID)
+	assert.Equal(t, int32(0), querierMock.GetPersonByIdCalls.Load())
 }
 
 func TestGetPersonWithCacheMiss(t *testing.T) {
+	cacherMock := newCacherMock(nil)
 	querier := NewCachingQuerier(&QuerierMock{
 		GetPersonByIdResult: Person{ID: 1, Name: "Test"},
-	}, &CacherMock{})
+	}, cacherMock)
 	result, err := querier.GetPersonById(context.Background(), 1)
 
 	assert.Nil(t, err)
 	assert.NotNil(t, result)
 	assert.Equal(t, int32(1), result.ID)
+	assert.Contains(t, cacherMock.Values["person:1@1.1"], `"ID":1`)
 }
 
 func TestGetPersonWithCacheFail(t *testing.T) {
@@ -148,8 +307,245 @@ func TestGetPersonWithCacheFail(t *testing.T) {
 	assert.Equal(t, int32(1), result.ID)
 }
 
+func TestGetPersonNotFoundIsNegativelyCached(t *testing.T) {
+	querierMock := &QuerierMock{GetPersonByIdError: pgx.ErrNoRows}
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(querierMock, cacherMock)
+
+	_, err := querier.GetPersonById(context.Background(), 1)
+	assert.Equal(t, pgx.ErrNoRows, err)
+
+	_, err = querier.GetPersonById(context.Background(), 1)
+	assert.Equal(t, pgx.ErrNoRows, err)
+
+	assert.Equal(t, int32(1), querierMock.GetPersonByIdCalls.Load())
+	assert.Contains(t, cacherMock.Values["person:1@1.1"], `"notFound":true`)
+}
+
+func TestGetPersonEntityExpiration(t *testing.T) {
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(&QuerierMock{GetPersonByIdResult: Person{ID: 1}}, cacherMock)
+	querier.Jitter = 0
+	querier.EntityExpirations = map[string]time.Duration{personTag: 10 * time.Minute}
+
+	_, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 10*time.Minute, cacherMock.Expirations["person:1@1.1"])
+
+	soft, hard := querier.expirations(personTag)
+	assert.Equal(t, 5*time.Minute, soft)
+	assert.Equal(t, 10*time.Minute, hard)
+
+	soft, hard = querier.expirations(personListTag)
+	assert.Equal(t, 30*time.Minute, soft)
+	assert.Equal(t, time.Hour, hard)
+}
+
+func TestGetPersonNotFoundUsesNegativeExpiration(t *testing.T) {
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(&QuerierMock{GetPersonByIdError: pgx.ErrNoRows}, cacherMock)
+
+	_, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Equal(t, pgx.ErrNoRows, err)
+	assert.Equal(t, 30*time.Second, cacherMock.Expirations["person:1@1.1"])
+}
+
+func TestGetPersonIgnoresLegacyEntry(t *testing.T) {
+	querierMock := &QuerierMock{GetPersonByIdResult: Person{ID: 1, Name: "New"}}
+	querier := NewCachingQuerier(querierMock, newCacherMock(map[string]string{
+		"person:1@1.1": fmt.Sprintf(`{"value":{"ID":1,"Name":"Legacy"},"softExpiresAt":%[1]d,"hardExpiresAt":%[1]d}`, time.Now().Add(time.Hour).UnixMilli()),
+	}))
+
+	result, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "New", result.Name)
+	assert.Equal(t, int32(1), querierMock.GetPersonByIdCalls.Load())
+}
+
+func TestCachedPersonRoundTripsWithEveryCodec(t *testing.T) {
+	person := Person{
+		ID:        1,
+		Name:      "Test",
+		CreatedAt: pgtype.Timestamp{Time: time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC), Valid: true},
+	}
+
+	for _, codec := range []string{"json", "msgpack", "gob"} {
+		serializer, err := cache.NewSerializer(codec, "zstd", 0)
+		assert.Nil(t, err)
+		cache.SetSerializer(serializer)
+
+		cacherMock := newCacherMock(nil)
+		querier := NewCachingQuerier(&QuerierMock{GetPersonByIdResult: person}, cacherMock)
+		querier.GetPersonById(context.Background(), 1)
+		querier.Queries = &QuerierMock{GetPersonByIdError: errors.New("not cached")}
+
+		result, err := querier.GetPersonById(context.Background(), 1)
+
+		assert.Nil(t, err, codec)
+		// msgpack keeps the instant but not the location
+		assert.True(t, person.CreatedAt.Time.Equal(result.CreatedAt.Time), codec)
+		result.CreatedAt.Time = person.CreatedAt.Time
+		assert.Equal(t, person, result, codec)
+	}
+
+	cache.SetSerializer(&cache.Serializer{Codec: cache.CodecJSON})
+}
+
+func TestGetPersonNegativeCacheExpired(t *testing.T) {
+	querierMock := &QuerierMock{GetPersonByIdResult: Person{ID: 1, Name: "Test"}}
+	querier := NewCachingQuerier(querierMock, newCacherMock(map[string]string{
+		"person:1@1.1": entry(fmt.Sprintf(`{"value":{},"notFound":true,"softExpiresAt":%[1]d,"hardExpiresAt":%[1]d}`, time.Now().Add(-time.Second).UnixMilli())),
+	}))
+
+	result, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), result.ID)
+	assert.Equal(t, int32(1), querierMock.GetPersonByIdCalls.Load())
+}
+
+func TestGetPersonErrorNotCached(t *testing.T) {
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(&QuerierMock{
+		GetPersonByIdError: fmt.Errorf("error"),
+	}, cacherMock)
+
+	_, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.NotNil(t, err)
+	assert.NotContains(t, cacherMock.Values, "person:1@1.1")
+}
+
+func TestGetPersonCoalescesConcurrentMisses(t *testing.T) {
+	querierMock := &QuerierMock{
+		GetPersonByIdResult: Person{ID: 1, Name: "Test"},
+		GetPersonByIdDelay:  50 * time.Millisecond,
+	}
+	querier := NewCachingQuerier(querierMock, newCacherMock(nil))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := querier.GetPersonById(context.Background(), 1)
+
+			assert.Nil(t, err)
+			assert.Equal(t, int32(1), result.ID)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), querierMock.GetPersonByIdCalls.Load())
+}
+
+func TestGetPersonStaleWhileRevalidate(t *testing.T) {
+	querierMock := &QuerierMock{GetPersonByIdResult: Person{ID: 1, Name: "Fresh"}}
+	cacherMock := newCacherMock(map[string]string{
+		"person:1@1.1": entry(fmt.Sprintf(`{"value":{"ID":1,"Name":"Stale"},"softExpiresAt":%d,"hardExpiresAt":%d}`,
+			time.Now().Add(-time.Minute).UnixMilli(), time.Now().Add(time.Minute).UnixMilli())),
+	})
+	querier := NewCachingQuerier(querierMock, cacherMock)
+
+	result, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Stale", result.Name)
+	assert.Eventually(t, func() bool {
+		value, _ := cacherMock.GetString(context.Background(), "person:1@1.1")
+		return strings.Contains(value, "Fresh")
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), querierMock.GetPersonByIdCalls.Load())
+}
+
+func TestGetPersonRefreshDoesNotOverwriteWrites(t *testing.T) {
+	querierMock := &QuerierMock{
+		GetPersonByIdResult: Person{ID: 1, Name: "Before the write"},
+		GetPersonByIdDelay:  50 * time.Millisecond,
+		UpdatePersonResult:  1,
+	}
+	cacherMock := newCacherMock(map[string]string{
+		"person:1@1.1": entry(fmt.Sprintf(`{"value":{"ID":1,"Name":"Stale"},"softExpiresAt":%d,"hardExpiresAt":%d}`,
+			time.Now().Add(-time.Minute).UnixMilli(), time.Now().Add(time.Minute).UnixMilli())),
+	})
+	querier := NewCachingQuerier(querierMock, cacherMock)
+
+	// the refresh reads the person before the write and stores it after
+	querier.GetPersonById(context.Background(), 1)
+	_, err := querier.UpdatePerson(context.Background(), UpdatePersonParams{ID: 1, Name: "Written"})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		value, _ := cacherMock.GetString(context.Background(), "person:1@1.1")
+		return strings.Contains(value, "Before the write")
+	}, time.Second, 10*time.Millisecond)
+
+	result, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Written", result.Name)
+}
+
+func TestGetPersonHardExpired(t *testing.T) {
+	querierMock := &QuerierMock{GetPersonByIdResult: Person{ID: 1, Name: "Fresh"}}
+	querier := NewCachingQuerier(querierMock, newCacherMock(map[string]string{
+		"person:1@1.1": entry(fmt.Sprintf(`{"value":{"ID":1,"Name":"Stale"},"softExpiresAt":%[1]d,"hardExpiresAt":%[1]d}`,
+			time.Now().Add(-time.Minute).UnixMilli())),
+	}))
+
+	result, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Fresh", result.Name)
+}
+
+func TestGetPersonWaitsForLockHolder(t *testing.T) {
+	querierMock := &QuerierMock{GetPersonByIdResult: Person{ID: 1, Name: "Database"}}
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(querierMock, cacherMock)
+	querier.Locker = &LockerMock{OnWait: func() {
+		// another replica fills the cache while we wait
+		cacherMock.SetString(context.Background(), "person:1@1.1", fresh(`{"ID":1,"Name":"Replica"}`))
+	}}
+
+	result, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Replica", result.Name)
+	assert.Equal(t, int32(0), querierMock.GetPersonByIdCalls.Load())
+}
+
+func TestGetPersonLockWaitTimesOut(t *testing.T) {
+	querierMock := &QuerierMock{GetPersonByIdResult: Person{ID: 1, Name: "Database"}}
+	querier := NewCachingQuerier(querierMock, newCacherMock(nil))
+	querier.Locker = &LockerMock{}
+	querier.LockTimeout = 100 * time.Millisecond
+
+	result, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Database", result.Name)
+	assert.Equal(t, int32(1), querierMock.GetPersonByIdCalls.Load())
+}
+
+func TestJitter(t *testing.T) {
+	querier := NewCachingQuerier(nil, nil)
+	querier.Jitter = 0.1
+
+	for i := 0; i < 100; i++ {
+		d := querier.jitter(time.Minute)
+		assert.GreaterOrEqual(t, d, 54*time.Second)
+		assert.LessOrEqual(t, d, 66*time.Second)
+	}
+
+	querier.Jitter = 0
+	assert.Equal(t, time.Minute, querier.jitter(time.Minute))
+}
+
 func TestInsertPersonWithCacheSuccess(t *testing.T) {
-	cacherMock := &CacherMock{}
+	cacherMock := newCacherMock(nil)
 	querier := NewCachingQuerier(&QuerierMock{
 		InsertPersonResult: Person{ID: 1, Name: "Test", Email: "email@email.com"},
 	}, cacherMock)
@@ -163,8 +559,10 @@ func TestInsertPersonWithCacheSuccess(t *testing.T) {
 	assert.Equal(t, int32(1), result.ID)
 	assert.Equal(t, "Test", result.Name)
 	assert.Equal(t, "email@email.com", result.Email)
-	assert.Equal(t, "person:1", cacherMock.SetStringKey)
-	assert.Equal(t, `{"ID":1,"Name":"Test","Email":"email@email.com","CreatedAt":null,"UpdatedAt":null,"UpdateUser":""}`, cacherMock.SetStringValue)
+	assert.NotEqual(t, "1", cacherMock.Values["tag:person:1"])
+	assert.Contains(t, cacherMock.Values[currentPersonKey(t, querier, context.Background(), 1)], `{"value":{"ID":1,"Name":"Test","Email":"email@email.com","CreatedAt":null,"UpdatedAt":null,"UpdateUser":"","TenantID":""},`)
+	assert.NotEqual(t, "1", cacherMock.Values["tag:person:list"])
+	assert.Equal(t, "1", cacherMock.Values["tag:person"])
 }
 
 func TestInsertPersonWithCacheFail(t *testing.T) {
@@ -178,15 +576,32 @@ func TestInsertPersonWithCacheFail(t *testing.T) {
 		Email: "email@email.com",
 	})
 
-	assert.NotNil(t, err)
+	assert.Nil(t, err)
 	assert.NotNil(t, result)
 	assert.Equal(t, int32(1), result.ID)
 	assert.Equal(t, "Test", result.Name)
 	assert.Equal(t, "email@email.com", result.Email)
+	assert.True(t, querier.stale.Load())
+}
+
+func TestInsertPeopleInvalidatesLists(t *testing.T) {
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(&QuerierMock{
+		InsertPeopleResult: []Person{{ID: 1, Name: "Test", Email: "email@email.com"}},
+	}, cacherMock)
+	result, err := querier.InsertPeople(context.Background(), InsertPeopleParams{
+		Names:  []string{"Test"},
+		Emails: []string{"email@email.com"},
+	})
+
+	assert.Nil(t, err)
+	assert.Len(t, result, 1)
+	assert.NotContains(t, cacherMock.Values, "person:1@1.1")
+	assert.NotEqual(t, "1", cacherMock.Values["tag:person:list"])
 }
 
 func TestUpdatePersonWithCacheSuccess(t *testing.T) {
-	cacherMock := &CacherMock{}
+	cacherMock := newCacherMock(nil)
 	querier := NewCachingQuerier(&QuerierMock{
 		UpdatePersonResult: 1,
 	}, cacherMock)
@@ -199,8 +614,22 @@ func TestUpdatePersonWithCacheSuccess(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, result)
 	assert.Equal(t, int64(1), result)
-	assert.Equal(t, "person:1", cacherMock.SetStringKey)
-	assert.Equal(t, `{"ID":1,"Name":"Test","Email":"email@email.com","CreatedAt":"0001-01-01T00:00:00","UpdatedAt":"0001-01-01T00:00:00","UpdateUser":""}`, cacherMock.SetStringValue)
+	assert.NotEqual(t, "1", cacherMock.Values["tag:person:1"])
+	assert.Contains(t, cacherMock.Values[currentPersonKey(t, querier, context.Background(), 1)], `{"value":{"ID":1,"Name":"Test","Email":"email@email.com","CreatedAt":"0001-01-01T00:00:00","UpdatedAt":"0001-01-01T00:00:00","UpdateUser":"","TenantID":""},`)
+	assert.NotEqual(t, "1", cacherMock.Values["tag:person:list"])
+}
+
+func TestUpdatePersonNotFoundSkipsCache(t *testing.T) {
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(&QuerierMock{
+		UpdatePersonResult: 0,
+	}, cacherMock)
+	result, err := querier.UpdatePerson(context.Background(), UpdatePersonParams{ID: 1})
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), result)
+	assert.NotContains(t, cacherMock.Values, "person:1@1.1")
+	assert.Equal(t, "1", cacherMock.Values["tag:person:list"])
 }
 
 func TestUpdatePersonWithCacheFail(t *testing.T) {
@@ -215,13 +644,14 @@ func TestUpdatePersonWithCacheFail(t *testing.T) {
 		Email: "email@email.com",
 	})
 
-	assert.NotNil(t, err)
+	assert.Nil(t, err)
 	assert.NotNil(t, result)
 	assert.Equal(t, int64(1), result)
+	assert.True(t, querier.stale.Load())
 }
 
 func TestDeletePersonWithCacheSuccess(t *testing.T) {
-	cacherMock := &CacherMock{}
+	cacherMock := newCacherMock(map[string]string{"person:1@1.1": `{"value":{"ID":1}}`})
 	querier := NewCachingQuerier(&QuerierMock{
 		DeletePersonResult: 1,
 	}, cacherMock)
@@ -230,18 +660,67 @@ func TestDeletePersonWithCacheSuccess(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, result)
 	assert.Equal(t, int64(1), result)
-	assert.Equal(t, "person:1", cacherMock.DeleteKeyKey)
+	assert.Equal(t, "person:1@1.1", cacherMock.DeleteKeyKey)
+	assert.NotContains(t, cacherMock.Values, "person:1@1.1")
+	assert.NotEqual(t, "1", cacherMock.Values["tag:person:list"])
 }
 
 func TestDeletePersonWithCacheFail(t *testing.T) {
 	querier := NewCachingQuerier(&QuerierMock{
 		DeletePersonResult: 1,
-	}, &CacherMock{
-		DeleteKeyError: fmt.Errorf("error"),
-	})
+	}, newCacherMock(nil))
+	querier.Cache.(*CacherMock).DeleteKeyError = fmt.Errorf("error")
 	result, err := querier.DeletePerson(context.Background(), 1)
 
-	assert.NotNil(t, err)
+	assert.Nil(t, err)
 	assert.NotNil(t, result)
 	assert.Equal(t, int64(1), result)
+	assert.True(t, querier.stale.Load())
+}
+
+func TestStaleCacheIsBypassedUntilInvalidated(t *testing.T) {
+	cacherMock := newCacherMock(map[string]string{"person:1@1.1": fresh(`{"ID":1,"Name":"Old"}`)})
+	querierMock := &QuerierMock{GetPersonByIdResult: Person{ID: 1, Name: "New"}}
+	querier := NewCachingQuerier(querierMock, cacherMock)
+	querier.stale.Store(true)
+
+	cacherMock.SetStringError = fmt.Errorf("error")
+	result, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "New", result.Name)
+	assert.True(t, querier.stale.Load())
+
+	cacherMock.SetStringError = nil
+	result, err = querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "New", result.Name)
+	assert.False(t, querier.stale.Load())
+	assert.NotEqual(t, "1", cacherMock.Values["tag:person"])
+}
+
+func TestCacheHealthy(t *testing.T) {
+	querier := NewCachingQuerier(&QuerierMock{}, newCacherMock(nil))
+	assert.Nil(t, querier.CacheHealthy())
+
+	breaker := cache.NewBreakerCacher(&CacherMock{GetStringError: fmt.Errorf("error")}, 1, 0, time.Minute)
+	querier = NewCachingQuerier(&QuerierMock{GetPersonByIdResult: Person{ID: 1}}, breaker)
+
+	result, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), result.ID)
+	assert.Equal(t, cache.ErrCircuitOpen, querier.CacheHealthy())
+}
+
+func TestInvalidateAll(t *testing.T) {
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(&QuerierMock{}, cacherMock)
+
+	err := querier.InvalidateAll(context.Background())
+
+	assert.Nil(t, err)
+	assert.NotEqual(t, "1", cacherMock.Values["tag:person"])
+	assert.Equal(t, "1", cacherMock.Values["tag:person:list"])
 }
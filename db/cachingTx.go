@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"sync"
+)
+
+// txCachingQuerier is the CachingQuerier of a transaction. Reads go to the
+// transaction since the cache can't know about its uncommitted writes, and
+// nothing read within it is cached. Cache updates for its writes are
+// recorded and only applied once the transaction committed.
+type txCachingQuerier struct {
+	parent  *CachingQuerier
+	queries Querier
+
+	mu       sync.Mutex
+	deferred []func(ctx context.Context)
+}
+
+// InTx implements TxDecorator.
+func (c *CachingQuerier) InTx(q Querier) (Querier, func(ctx context.Context)) {
+	tx := &txCachingQuerier{parent: c, queries: q}
+	return tx, tx.committed
+}
+
+func (t *txCachingQuerier) afterCommit(fn func(ctx context.Context)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.deferred = append(t.deferred, fn)
+}
+
+func (t *txCachingQuerier) committed(ctx context.Context) {
+	t.mu.Lock()
+	deferred := t.deferred
+	t.deferred = nil
+	t.mu.Unlock()
+
+	for _, fn := range deferred {
+		fn(ctx)
+	}
+}
+
+func (t *txCachingQuerier) GetPeople(ctx context.Context) ([]Person, error) {
+	return t.queries.GetPeople(ctx)
+}
+
+func (t *txCachingQuerier) GetPeopleFiltered(ctx context.Context, filter Filter) ([]Person, error) {
+	return t.queries.GetPeopleFiltered(ctx, filter)
+}
+
+func (t *txCachingQuerier) GetPersonById(ctx context.Context, id int32) (Person, error) {
+	return t.queries.GetPersonById(ctx, id)
+}
+
+func (t *txCachingQuerier) InsertPerson(ctx context.Context, arg InsertPersonParams) (Person, error) {
+	person, err := t.queries.InsertPerson(ctx, arg)
+	if err != nil {
+		return person, err
+	}
+
+	t.afterCommit(func(ctx context.Context) {
+		if err := t.parent.setPerson(ctx, &person); err != nil {
+			t.parent.cacheWriteFailed("Error setting person by id into cache", err)
+		}
+	})
+
+	return person, nil
+}
+
+func (t *txCachingQuerier) InsertPeople(ctx context.Context, arg InsertPeopleParams) ([]Person, error) {
+	people, err := t.queries.InsertPeople(ctx, arg)
+	if err != nil {
+		return people, err
+	}
+
+	t.afterCommit(func(ctx context.Context) {
+		if err := t.parent.forgetPeopleLists(ctx); err != nil {
+			t.parent.cacheWriteFailed("Error invalidating people lists in cache", err)
+		}
+	})
+
+	return people, nil
+}
+
+func (t *txCachingQuerier) UpdatePerson(ctx context.Context, arg UpdatePersonParams) (int64, error) {
+	personId, err := t.queries.UpdatePerson(ctx, arg)
+	if err != nil || personId == 0 {
+		return personId, err
+	}
+
+	t.afterCommit(func(ctx context.Context) {
+		if err := t.parent.setPerson(ctx, updatedPerson(ctx, arg)); err != nil {
+			t.parent.cacheWriteFailed("Error setting person by id into cache", err)
+		}
+	})
+
+	return personId, nil
+}
+
+func (t *txCachingQuerier) DeletePerson(ctx context.Context, id int32) (int64, error) {
+	personId, err := t.queries.DeletePerson(ctx, id)
+	if err != nil {
+		return personId, err
+	}
+
+	t.afterCommit(func(ctx context.Context) {
+		if err := t.parent.forgetPerson(ctx, id); err != nil {
+			t.parent.cacheWriteFailed("Error deleting person by id from cache", err)
+		}
+	})
+
+	return personId, nil
+}
+
+func (t *txCachingQuerier) PingDb(ctx context.Context) (int32, error) {
+	return t.queries.PingDb(ctx)
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+)
+
+// Filter is a parameterized SQL WHERE fragment. Where uses $1..$n
+// placeholders that map positionally to Args.
+type Filter struct {
+	Where string
+	Args  []any
+}
+
+// AllowAllFilter matches every row.
+var AllowAllFilter = Filter{Where: "TRUE"}
+
+// DenyAllFilter matches no rows.
+var DenyAllFilter = Filter{Where: "FALSE"}
+
+const getPeopleFiltered = `-- name: GetPeopleFiltered :many
+SELECT id, name, email, created_at, updated_at, update_user, tenant_id
+FROM person
+WHERE `
+
+func (q *Queries) GetPeopleFiltered(ctx context.Context, filter Filter) ([]Person, error) {
+	if filter.Where == "" {
+		filter = DenyAllFilter
+	}
+
+	rows, err := q.db.Query(ctx, getPeopleFiltered+filter.Where, filter.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Person
+	for rows.Next() {
+		var i Person
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UpdateUser,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -1,31 +1,176 @@
 package db
 
 import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5"
 )
 
-func Init(connString string) error {
+// MigrationsDir is where `migrate create` writes new migrations, relative to
+// the repository root. They are embedded in the binary at build time.
+const MigrationsDir = "db/migrations"
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLockId is the key of the advisory lock held while migrating at
+// startup. migrate's own lock gives up after 15s, replicas starting together
+// wait on this one instead.
+const migrationLockId int64 = 0x676f617069
+
+// Migrator applies the embedded migrations.
+type Migrator struct {
+	m *migrate.Migrate
+}
+
+func NewMigrator(connString string) (*Migrator, error) {
+	source, err := iofs.New(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", source, connString)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{m: m}, nil
+}
+
+// Up applies n migrations, all pending ones when n is 0.
+func (m *Migrator) Up(n int) error {
+	if n > 0 {
+		return ignoreNoChange(m.m.Steps(n))
+	}
+
+	return ignoreNoChange(m.m.Up())
+}
+
+// Down rolls back n migrations, n must be positive so that all of them are
+// only rolled back through DownAll.
+func (m *Migrator) Down(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("number of migrations to roll back must be positive, got %d", n)
+	}
+
+	return ignoreNoChange(m.m.Steps(-n))
+}
+
+// DownAll rolls back every migration, dropping every table.
+func (m *Migrator) DownAll() error {
+	return ignoreNoChange(m.m.Down())
+}
+
+// Goto migrates up or down to version.
+func (m *Migrator) Goto(version uint) error {
+	return ignoreNoChange(m.m.Migrate(version))
+}
+
+// Version returns the current version, and whether the last migration failed
+// half way and the version must be forced.
+func (m *Migrator) Version() (version uint, dirty bool, err error) {
+	version, dirty, err = m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
+
+// Force sets the version without running migrations, to recover from a
+// failed one. -1 means no migration was applied.
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+func (m *Migrator) Close() error {
+	sourceErr, dbErr := m.m.Close()
+	return errors.Join(sourceErr, dbErr)
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		slog.Info("Db Migration Complete", "status", err)
+		return nil
+	}
+
+	return err
+}
+
+// Init applies the pending migrations. It holds an advisory lock while doing
+// so, instances starting together wait for the first one to finish until ctx
+// is done.
+func Init(ctx context.Context, connString string) error {
 	slog.Info("Initializing database...")
 
-	m, err := migrate.New(
-		"file://db/migrations",
-		connString)
+	conn, err := pgx.Connect(ctx, connString)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
 
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockId); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", migrationLockId)
+
+	migrator, err := NewMigrator(connString)
 	if err != nil {
 		return err
 	}
+	defer migrator.Close()
+
+	return migrator.Up(0)
+}
+
+var migrationName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+var migrationFile = regexp.MustCompile(`^(\d+)_.*\.(up|down)\.sql$`)
+
+// CreateMigration writes empty up and down migrations named after the next
+// version in dir, e.g. 002_add_tenant.up.sql, and returns their paths.
+func CreateMigration(dir string, name string) ([]string, error) {
+	name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), " ", "_")
+	if !migrationName.MatchString(name) {
+		return nil, fmt.Errorf("migration name must only contain letters, digits and underscores")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	next := 1
+	for _, entry := range entries {
+		if match := migrationFile.FindStringSubmatch(entry.Name()); match != nil {
+			if version, _ := strconv.Atoi(match[1]); version >= next {
+				next = version + 1
+			}
+		}
+	}
 
-	if err := m.Up(); err != nil {
-		if err.Error() == "no change" {
-			slog.Info("Db Migration Complete", "status", err)
-		} else {
-			return err
+	var paths []string
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(dir, fmt.Sprintf("%03d_%s.%s.sql", next, name, direction))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return paths, err
 		}
+		file.Close()
+		paths = append(paths, path)
 	}
 
-	return nil
+	return paths, nil
 }
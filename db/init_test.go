@@ -1,13 +1,142 @@
 package db
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestDBInitBadConnectionString(t *testing.T) {
-	err := Init("Justbad")
+	err := Init(context.Background(), "Justbad")
 
 	assert.Error(t, err)
 }
+
+func TestNewMigratorBadConnectionString(t *testing.T) {
+	_, err := NewMigrator("Justbad")
+
+	assert.Error(t, err)
+}
+
+func TestMigrationsAreEmbedded(t *testing.T) {
+	up, err := migrations.ReadFile("migrations/001_initial.up.sql")
+	assert.Nil(t, err)
+	assert.Contains(t, string(up), "CREATE TABLE person")
+
+	down, err := migrations.ReadFile("migrations/001_initial.down.sql")
+	assert.Nil(t, err)
+	assert.Contains(t, string(down), "DROP TABLE person")
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "001_initial.up.sql"), nil, 0644)
+	os.WriteFile(filepath.Join(dir, "009_other.down.sql"), nil, 0644)
+
+	paths, err := CreateMigration(dir, "Add Tenant")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "010_add_tenant.up.sql"),
+		filepath.Join(dir, "010_add_tenant.down.sql"),
+	}, paths)
+	assert.FileExists(t, paths[0])
+	assert.FileExists(t, paths[1])
+}
+
+func TestCreateFirstMigration(t *testing.T) {
+	paths, err := CreateMigration(t.TempDir(), "initial")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "001_initial.up.sql", filepath.Base(paths[0]))
+}
+
+func TestCreateMigrationInvalidName(t *testing.T) {
+	_, err := CreateMigration(t.TempDir(), "drop table;")
+
+	assert.EqualError(t, err, "migration name must only contain letters, digits and underscores")
+}
+
+// TestEmailNormalizationMigration needs a disposable database, every table of
+// TEST_DB_CONNECTION_STRING is dropped.
+func TestEmailNormalizationMigration(t *testing.T) {
+	connString := os.Getenv("TEST_DB_CONNECTION_STRING")
+	if connString == "" {
+		t.Skip("TEST_DB_CONNECTION_STRING isn't set")
+	}
+	ctx := context.Background()
+
+	migrator, err := NewMigrator(connString)
+	assert.Nil(t, err)
+	assert.Nil(t, migrator.m.Drop())
+	migrator.Close()
+
+	migrator, err = NewMigrator(connString)
+	assert.Nil(t, err)
+	defer migrator.Close()
+	assert.Nil(t, migrator.Goto(2))
+
+	conn, err := pgx.Connect(ctx, connString)
+	assert.Nil(t, err)
+	defer conn.Close(ctx)
+
+	seed := map[string][]string{
+		// a@X.com and a@x.com are equal once normalized, B@x.com and b@x.com
+		// regardless of case
+		"acme":  {"a@X.com", "a@x.com", "B@x.com", "b@x.com", "c@x.com"},
+		"other": {"a@x.com"},
+	}
+	for _, tenant := range []string{"acme", "other"} {
+		tx, err := conn.Begin(ctx)
+		assert.Nil(t, err)
+		_, err = tx.Exec(ctx, "SELECT set_config('app.tenant_id', $1, true)", tenant)
+		assert.Nil(t, err)
+		for _, email := range seed[tenant] {
+			_, err = tx.Exec(ctx, `INSERT INTO person (name, email, created_at, updated_at, update_user, tenant_id)
+				VALUES ('Test', $1, now(), now(), 'test', $2)`, email, tenant)
+			assert.Nil(t, err)
+		}
+		assert.Nil(t, tx.Commit(ctx))
+	}
+
+	assert.Nil(t, migrator.Goto(3))
+
+	version, dirty, err := migrator.Version()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(3), version)
+	assert.False(t, dirty)
+
+	rows, _ := conn.Query(ctx, "SELECT id, kept_id, email FROM person_email_conflict ORDER BY id")
+	conflicts, err := pgx.CollectRows(rows, pgx.RowToStructByPos[struct {
+		ID     int32
+		KeptID int32
+		Email  string
+	}])
+	assert.Nil(t, err)
+	assert.Len(t, conflicts, 2)
+	assert.Equal(t, int32(2), conflicts[0].ID)
+	assert.Equal(t, int32(1), conflicts[0].KeptID)
+	assert.Equal(t, "a@x.com", conflicts[0].Email)
+	assert.Equal(t, int32(4), conflicts[1].ID)
+	assert.Equal(t, int32(3), conflicts[1].KeptID)
+
+	tx, err := conn.Begin(ctx)
+	assert.Nil(t, err)
+	defer tx.Rollback(ctx)
+	tx.Exec(ctx, "SELECT set_config('app.tenant_id', 'acme', true)")
+	rows, _ = tx.Query(ctx, "SELECT email FROM person ORDER BY id")
+	emails, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a@x.com", "B@x.com", "c@x.com"}, emails)
+}
+
+func TestMigratorDownRequiresSteps(t *testing.T) {
+	migrator := &Migrator{}
+
+	assert.ErrorContains(t, migrator.Down(0), "must be positive")
+	assert.ErrorContains(t, migrator.Down(-1), "must be positive")
+}
@@ -0,0 +1,8 @@
+DROP POLICY person_tenant_isolation ON person;
+ALTER TABLE person NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE person DISABLE ROW LEVEL SECURITY;
+
+ALTER TABLE person DROP CONSTRAINT person_tenant_id_email_key;
+ALTER TABLE person ADD CONSTRAINT person_email_key UNIQUE (email);
+
+ALTER TABLE person DROP COLUMN tenant_id;
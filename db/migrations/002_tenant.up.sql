@@ -0,0 +1,17 @@
+-- Existing people belong to the default tenant, new ones to the tenant of the
+-- transaction (see db.WithTenantIsolation and db.WithRequestTx).
+ALTER TABLE person ADD COLUMN tenant_id varchar(255) NOT NULL DEFAULT 'default';
+ALTER TABLE person ALTER COLUMN tenant_id SET DEFAULT current_setting('app.tenant_id');
+ALTER TABLE person ADD CONSTRAINT person_tenant_id_check CHECK (tenant_id <> '');
+
+ALTER TABLE person DROP CONSTRAINT person_email_key;
+ALTER TABLE person ADD CONSTRAINT person_tenant_id_email_key UNIQUE (tenant_id, email);
+
+-- FORCE applies the policy to the table owner as well, superusers and roles
+-- with BYPASSRLS still bypass it.
+ALTER TABLE person ENABLE ROW LEVEL SECURITY;
+ALTER TABLE person FORCE ROW LEVEL SECURITY;
+
+CREATE POLICY person_tenant_isolation ON person
+  USING (tenant_id = current_setting('app.tenant_id', true))
+  WITH CHECK (tenant_id = current_setting('app.tenant_id', true));
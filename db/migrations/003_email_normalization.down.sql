@@ -0,0 +1,3 @@
+-- person_email_conflict is kept, its rows still need a manual review
+DROP INDEX person_tenant_id_lower_email_key;
+ALTER TABLE person ADD CONSTRAINT person_tenant_id_email_key UNIQUE (tenant_id, email);
@@ -0,0 +1,47 @@
+-- Emails are stored trimmed with a lower-case domain (see
+-- models.EmailNormalizer), and are unique per tenant regardless of case.
+-- Row level security is lifted so every tenant's rows are normalized.
+ALTER TABLE person NO FORCE ROW LEVEL SECURITY;
+
+-- dropped first, normalizing can make emails that were unique equal
+ALTER TABLE person DROP CONSTRAINT person_tenant_id_email_key;
+
+UPDATE person SET email = btrim(email) WHERE email <> btrim(email);
+UPDATE person
+  SET email = substring(email from '^(.*)@') || '@' || lower(substring(email from '@([^@]*)$'))
+  WHERE email LIKE '%@%' AND substring(email from '@([^@]*)$') <> lower(substring(email from '@([^@]*)$'));
+
+-- The people sharing an email regardless of case with an older person of
+-- their tenant are moved aside for a manual review, kept_id being the person
+-- that stays.
+CREATE TABLE IF NOT EXISTS person_email_conflict (
+  LIKE person,
+  kept_id integer NOT NULL,
+  moved_at timestamp NOT NULL DEFAULT now()
+);
+
+WITH ranked AS (
+  SELECT id, first_value(id) OVER (PARTITION BY tenant_id, lower(email) ORDER BY id) AS kept_id
+  FROM person
+), moved AS (
+  DELETE FROM person
+  USING ranked
+  WHERE person.id = ranked.id AND ranked.id <> ranked.kept_id
+  RETURNING person.*, ranked.kept_id
+)
+INSERT INTO person_email_conflict (id, name, email, created_at, updated_at, update_user, tenant_id, kept_id)
+SELECT id, name, email, created_at, updated_at, update_user, tenant_id, kept_id FROM moved;
+
+DO $$
+DECLARE
+  moved integer;
+BEGIN
+  SELECT count(*) INTO moved FROM person_email_conflict;
+  IF moved > 0 THEN
+    RAISE WARNING '% people with duplicate emails were moved to person_email_conflict', moved;
+  END IF;
+END $$;
+
+CREATE UNIQUE INDEX person_tenant_id_lower_email_key ON person (tenant_id, lower(email));
+
+ALTER TABLE person FORCE ROW LEVEL SECURITY;
@@ -15,4 +15,5 @@ type Person struct {
 	CreatedAt  pgtype.Timestamp
 	UpdatedAt  pgtype.Timestamp
 	UpdateUser string
+	TenantID   string
 }
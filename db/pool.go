@@ -0,0 +1,235 @@
+package db
+
+import (
+	"context"
+	"goapi-template/config"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Credentials hold the connection string of a pool. They can be rotated
+// while the pool runs, the connections opened afterwards use the host, user
+// and password of the new connection string.
+type Credentials struct {
+	connString atomic.Pointer[string]
+	config     atomic.Pointer[pgconn.Config]
+}
+
+func NewCredentials(connString string) (*Credentials, error) {
+	credentials := &Credentials{}
+	if err := credentials.Update(connString); err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+// Update replaces the connection string, it is left unchanged when connString
+// can't be parsed.
+func (c *Credentials) Update(connString string) error {
+	connConfig, err := pgconn.ParseConfig(connString)
+	if err != nil {
+		return err
+	}
+
+	c.connString.Store(&connString)
+	c.config.Store(connConfig)
+
+	return nil
+}
+
+func (c *Credentials) ConnString() string {
+	return *c.connString.Load()
+}
+
+// beforeConnect points the new connections of a pool to the current
+// credentials.
+func (c *Credentials) beforeConnect(_ context.Context, connConfig *pgx.ConnConfig) error {
+	current := c.config.Load()
+
+	connConfig.Host = current.Host
+	connConfig.Port = current.Port
+	connConfig.Database = current.Database
+	connConfig.User = current.User
+	connConfig.Password = current.Password
+	connConfig.TLSConfig = current.TLSConfig
+	connConfig.Fallbacks = current.Fallbacks
+
+	return nil
+}
+
+// RotateCredentials makes pool connect with connString. Idle connections are
+// closed right away and the ones in use once they are released, the pool
+// opens new ones as needed.
+func RotateCredentials(pool *pgxpool.Pool, credentials *Credentials, connString string) error {
+	if err := credentials.Update(connString); err != nil {
+		return err
+	}
+
+	pool.Reset()
+
+	return nil
+}
+
+// NewPool creates a pool tuned by configValues, the settings left to zero keep
+// the pgxpool defaults.
+func NewPool(ctx context.Context, credentials *Credentials, configValues *config.DatabaseConfiguration, tracer pgx.QueryTracer) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(credentials.ConnString())
+	if err != nil {
+		return nil, err
+	}
+
+	if configValues.MaxConns > 0 {
+		poolConfig.MaxConns = configValues.MaxConns
+	}
+	if configValues.MinConns > 0 {
+		poolConfig.MinConns = configValues.MinConns
+	}
+	if configValues.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = configValues.MaxConnLifetime
+	}
+	if configValues.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = configValues.MaxConnIdleTime
+	}
+	if configValues.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = configValues.HealthCheckPeriod
+	}
+
+	poolConfig.ConnConfig.Tracer = tracer
+	poolConfig.BeforeConnect = credentials.beforeConnect
+
+	return pgxpool.NewWithConfig(ctx, poolConfig)
+}
+
+// timeoutDBTX cancels every query that runs longer than timeout. The context
+// of Query and QueryRow lives until their rows are closed or scanned.
+type timeoutDBTX struct {
+	db      DBTX
+	timeout time.Duration
+}
+
+// WithStatementTimeout bounds the queries made through db, it returns db
+// itself when timeout is zero.
+func WithStatementTimeout(db DBTX, timeout time.Duration) DBTX {
+	if timeout <= 0 {
+		return db
+	}
+
+	return &timeoutDBTX{db: db, timeout: timeout}
+}
+
+func (t *timeoutDBTX) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	return t.db.Exec(ctx, sql, args...)
+}
+
+func (t *timeoutDBTX) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+
+	rows, err := t.db.Query(ctx, sql, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &timeoutRows{Rows: rows, cancel: cancel}, nil
+}
+
+func (t *timeoutDBTX) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+
+	return &timeoutRow{row: t.db.QueryRow(ctx, sql, args...), cancel: cancel}
+}
+
+type timeoutRows struct {
+	pgx.Rows
+	cancel context.CancelFunc
+}
+
+func (r *timeoutRows) Close() {
+	r.Rows.Close()
+	r.cancel()
+}
+
+type timeoutRow struct {
+	row    pgx.Row
+	cancel context.CancelFunc
+}
+
+func (r *timeoutRow) Scan(dest ...any) error {
+	defer r.cancel()
+
+	return r.row.Scan(dest...)
+}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	sql    string
+	params int
+	start  time.Time
+}
+
+// SlowQueryTracer logs the queries that took at least Threshold. Queries are
+// logged by their sqlc name when they have one, parameters are never logged.
+type SlowQueryTracer struct {
+	Threshold time.Duration
+	// TraceId returns the trace id of the request a query is made for
+	TraceId func(ctx context.Context) any
+
+	now func() time.Time
+}
+
+func (t *SlowQueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, &queryStart{sql: data.SQL, params: len(data.Args), start: t.now()})
+}
+
+func (t *SlowQueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	query, ok := ctx.Value(queryStartKey{}).(*queryStart)
+	if !ok {
+		return
+	}
+
+	duration := t.now().Sub(query.start)
+	if duration < t.Threshold {
+		return
+	}
+
+	var traceId any
+	if t.TraceId != nil {
+		traceId = t.TraceId(ctx)
+	}
+
+	slog.Warn("Slow query",
+		"query", queryName(query.sql),
+		"duration", duration,
+		"params", query.params,
+		"error", data.Err,
+		"traceId", traceId,
+	)
+}
+
+// queryName returns the name of sqlc queries, which start with a
+// "-- name: GetPersonById :one" comment, and the first line of other queries.
+func queryName(sql string) string {
+	firstLine, _, _ := strings.Cut(strings.TrimSpace(sql), "\n")
+
+	if name, ok := strings.CutPrefix(firstLine, "-- name: "); ok {
+		name, _, _ = strings.Cut(name, " ")
+		return name
+	}
+
+	return firstLine
+}
+
+func NewSlowQueryTracer(threshold time.Duration, traceId func(ctx context.Context) any) *SlowQueryTracer {
+	return &SlowQueryTracer{Threshold: threshold, TraceId: traceId, now: time.Now}
+}
@@ -4,8 +4,10 @@ import "context"
 
 type Querier interface {
 	GetPeople(ctx context.Context) ([]Person, error)
+	GetPeopleFiltered(ctx context.Context, filter Filter) ([]Person, error)
 	GetPersonById(ctx context.Context, id int32) (Person, error)
 	InsertPerson(ctx context.Context, arg InsertPersonParams) (Person, error)
+	InsertPeople(ctx context.Context, arg InsertPeopleParams) ([]Person, error)
 	UpdatePerson(ctx context.Context, arg UpdatePersonParams) (int64, error)
 	DeletePerson(ctx context.Context, id int32) (int64, error)
 	PingDb(ctx context.Context) (int32, error)
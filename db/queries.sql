@@ -1,9 +1,9 @@
 -- name: GetPeople :many
-SELECT id, name, email, created_at, updated_at, update_user
+SELECT id, name, email, created_at, updated_at, update_user, tenant_id
 FROM person;
 
 -- name: GetPersonById :one
-SELECT id, name, email, created_at, updated_at, update_user
+SELECT id, name, email, created_at, updated_at, update_user, tenant_id
 FROM person
 WHERE id = $1;
 
@@ -21,6 +21,11 @@ INSERT INTO person (name, email, created_at, updated_at, update_user)
 VALUES ($1, $2, $3, $4, $5)
 RETURNING *;
 
+-- name: InsertPeople :many
+INSERT INTO person (name, email, created_at, updated_at, update_user)
+SELECT unnest(@names::varchar[]), unnest(@emails::varchar[]), unnest(@created_ats::timestamp[]), unnest(@updated_ats::timestamp[]), unnest(@update_users::varchar[])
+RETURNING *;
+
 -- name: DeletePerson :execrows
 DELETE from person
 WHERE id = $1;
@@ -25,7 +25,7 @@ func (q *Queries) DeletePerson(ctx context.Context, id int32) (int64, error) {
 }
 
 const getPeople = `-- name: GetPeople :many
-SELECT id, name, email, created_at, updated_at, update_user
+SELECT id, name, email, created_at, updated_at, update_user, tenant_id
 FROM person
 `
 
@@ -45,6 +45,7 @@ func (q *Queries) GetPeople(ctx context.Context) ([]Person, error) {
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.UpdateUser,
+			&i.TenantID,
 		); err != nil {
 			return nil, err
 		}
@@ -57,7 +58,7 @@ func (q *Queries) GetPeople(ctx context.Context) ([]Person, error) {
 }
 
 const getPersonById = `-- name: GetPersonById :one
-SELECT id, name, email, created_at, updated_at, update_user
+SELECT id, name, email, created_at, updated_at, update_user, tenant_id
 FROM person
 WHERE id = $1
 `
@@ -72,14 +73,63 @@ func (q *Queries) GetPersonById(ctx context.Context, id int32) (Person, error) {
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UpdateUser,
+		&i.TenantID,
 	)
 	return i, err
 }
 
+const insertPeople = `-- name: InsertPeople :many
+INSERT INTO person (name, email, created_at, updated_at, update_user)
+SELECT unnest($1::varchar[]), unnest($2::varchar[]), unnest($3::timestamp[]), unnest($4::timestamp[]), unnest($5::varchar[])
+RETURNING id, name, email, created_at, updated_at, update_user, tenant_id
+`
+
+type InsertPeopleParams struct {
+	Names       []string
+	Emails      []string
+	CreatedAts  []pgtype.Timestamp
+	UpdatedAts  []pgtype.Timestamp
+	UpdateUsers []string
+}
+
+func (q *Queries) InsertPeople(ctx context.Context, arg InsertPeopleParams) ([]Person, error) {
+	rows, err := q.db.Query(ctx, insertPeople,
+		arg.Names,
+		arg.Emails,
+		arg.CreatedAts,
+		arg.UpdatedAts,
+		arg.UpdateUsers,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Person
+	for rows.Next() {
+		var i Person
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Email,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.UpdateUser,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const insertPerson = `-- name: InsertPerson :one
 INSERT INTO person (name, email, created_at, updated_at, update_user)
 VALUES ($1, $2, $3, $4, $5)
-RETURNING id, name, email, created_at, updated_at, update_user
+RETURNING id, name, email, created_at, updated_at, update_user, tenant_id
 `
 
 type InsertPersonParams struct {
@@ -106,6 +156,7 @@ func (q *Queries) InsertPerson(ctx context.Context, arg InsertPersonParams) (Per
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UpdateUser,
+		&i.TenantID,
 	)
 	return i, err
 }
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type requestTxKey struct{}
+
+// RequestTx is the transaction the queries of a request made with a tenant
+// share, see WithTenantIsolation, so that app.tenant_id is set once per
+// request rather than for every query. There is one transaction per pool the
+// request queries, begun by its first query, and a single query runs in it at
+// a time.
+type RequestTx struct {
+	mu  sync.Mutex
+	txs map[TxBeginner]*poolTx
+	// last is the transaction of the last query
+	last  *poolTx
+	ended bool
+}
+
+type poolTx struct {
+	tx pgx.Tx
+	// afterCommit is what the writes made in tx deferred until it commits
+	afterCommit []func()
+}
+
+// WithRequestTx returns a context whose queries share a transaction until
+// Commit or Rollback, the queries made afterwards run in their own
+// transaction again.
+func WithRequestTx(ctx context.Context) (context.Context, *RequestTx) {
+	requestTx := &RequestTx{txs: map[TxBeginner]*poolTx{}}
+
+	return context.WithValue(ctx, requestTxKey{}, requestTx), requestTx
+}
+
+// withoutRequestTx returns a context whose queries run in their own
+// transaction, so that they neither see the uncommitted writes of the
+// request nor have to wait for its queries.
+func withoutRequestTx(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestTxKey{}, (*RequestTx)(nil))
+}
+
+func requestTxFrom(ctx context.Context) *RequestTx {
+	requestTx, _ := ctx.Value(requestTxKey{}).(*RequestTx)
+	return requestTx
+}
+
+// acquire returns the transaction of db, beginning it scoped to the tenant
+// of ctx, and holds it until release is called. It returns nil once the
+// request transaction ended.
+func (r *RequestTx) acquire(ctx context.Context, db TxBeginner) (pgx.Tx, error) {
+	r.mu.Lock()
+
+	if r.ended {
+		r.mu.Unlock()
+		return nil, nil
+	}
+
+	if current, ok := r.txs[db]; ok {
+		r.last = current
+		return current.tx, nil
+	}
+
+	// the transaction outlives the query that begins it
+	beginCtx := context.WithoutCancel(ctx)
+	tx, err := db.BeginTx(beginCtx, pgx.TxOptions{})
+	if err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+
+	if err := setTenant(ctx, tx); err != nil {
+		tx.Rollback(beginCtx)
+		r.mu.Unlock()
+		return nil, err
+	}
+
+	r.last = &poolTx{tx: tx}
+	r.txs[db] = r.last
+	return tx, nil
+}
+
+// release lets the next query run, it returns the error of the query
+// unchanged.
+func (r *RequestTx) release(err error) error {
+	r.mu.Unlock()
+	return err
+}
+
+// afterCommitOf calls fn with ctx once the write just made with ctx
+// committed: right away unless it was made in the request transaction of
+// ctx, in which case fn is called if that transaction commits.
+func afterCommitOf(ctx context.Context, fn func(ctx context.Context)) {
+	if requestTx := requestTxFrom(ctx); requestTx != nil {
+		requestTx.mu.Lock()
+		if !requestTx.ended && requestTx.last != nil {
+			requestTx.last.afterCommit = append(requestTx.last.afterCommit, func() {
+				fn(context.WithoutCancel(ctx))
+			})
+			requestTx.mu.Unlock()
+			return
+		}
+		requestTx.mu.Unlock()
+	}
+
+	fn(ctx)
+}
+
+// end marks the request transaction ended and returns its transactions.
+func (r *RequestTx) end() []*poolTx {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ended {
+		return nil
+	}
+	r.ended = true
+
+	txs := make([]*poolTx, 0, len(r.txs))
+	for _, tx := range r.txs {
+		txs = append(txs, tx)
+	}
+
+	return txs
+}
+
+// Commit commits the transactions of the request, and applies what their
+// writes deferred once they committed. The writes go to the primary, so at
+// most one of them has any, the others are only read from.
+func (r *RequestTx) Commit(ctx context.Context) error {
+	var err error
+	for _, current := range r.end() {
+		if commitErr := current.tx.Commit(ctx); commitErr != nil {
+			current.tx.Rollback(context.WithoutCancel(ctx))
+			err = errors.Join(err, commitErr)
+			continue
+		}
+
+		for _, fn := range current.afterCommit {
+			fn()
+		}
+	}
+
+	return err
+}
+
+// Rollback rolls back the transactions of the request, it is a no-op once
+// the request transaction ended.
+func (r *RequestTx) Rollback(ctx context.Context) error {
+	var err error
+	for _, current := range r.end() {
+		err = errors.Join(err, current.tx.Rollback(ctx))
+	}
+
+	return err
+}
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replica is a read replica of the primary database.
+type Replica struct {
+	// Name identifies the replica in logs, it must not contain credentials
+	Name    string
+	Queries Querier
+	// Lag returns how far the replica is behind the primary
+	Lag func(ctx context.Context) (time.Duration, error)
+
+	lagging atomic.Bool
+}
+
+// Healthy reports whether the replica is in rotation.
+func (r *Replica) Healthy() bool {
+	return !r.lagging.Load()
+}
+
+// replicationLagQuery returns 0 when the replica replayed everything it
+// received, pg_last_xact_replay_timestamp alone keeps growing while the
+// primary is idle.
+const replicationLagQuery = `SELECT CASE
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+END::float8`
+
+func replicationLag(conn DBTX) func(ctx context.Context) (time.Duration, error) {
+	return func(ctx context.Context) (time.Duration, error) {
+		var seconds float64
+		if err := conn.QueryRow(ctx, replicationLagQuery).Scan(&seconds); err != nil {
+			return 0, err
+		}
+
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+}
+
+func NewReplica(name string, conn DBTX) *Replica {
+	return &Replica{Name: name, Queries: New(conn), Lag: replicationLag(conn)}
+}
+
+type stickyKey struct{}
+
+// Sticky remembers until when the reads of a request go to the primary, so
+// callers read their own writes despite replication lag.
+type Sticky struct {
+	until atomic.Int64
+}
+
+// WithSticky returns a context whose reads go to the primary until until,
+// writes made with it extend the window.
+func WithSticky(ctx context.Context, until time.Time) (context.Context, *Sticky) {
+	sticky := &Sticky{}
+	if !until.IsZero() {
+		sticky.until.Store(until.UnixNano())
+	}
+
+	return context.WithValue(ctx, stickyKey{}, sticky), sticky
+}
+
+// Until returns the end of the window, the zero time when there is none.
+func (s *Sticky) Until() time.Time {
+	until := s.until.Load()
+	if until == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, until)
+}
+
+// stickToPrimary extends the window of ctx, if any, to until.
+func stickToPrimary(ctx context.Context, until time.Time) {
+	if sticky, ok := ctx.Value(stickyKey{}).(*Sticky); ok {
+		sticky.extend(until)
+	}
+}
+
+func (s *Sticky) extend(until time.Time) {
+	for {
+		current := s.until.Load()
+		if current >= until.UnixNano() || s.until.CompareAndSwap(current, until.UnixNano()) {
+			return
+		}
+	}
+}
+
+type primaryReadsKey struct{}
+
+// WithPrimaryReads returns a context whose reads go to the primary. The reads
+// whose results are cached use it: a replica may not have replayed a write
+// made by another caller yet, within MaxLag, and its result would be served
+// from the cache long after the replica caught up.
+func WithPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+// RoutingQuerier sends writes to the primary and reads to the replicas in
+// rotation, round-robin. Replicas lagging more than MaxLag are taken out of
+// rotation by CheckReplicas, and reads go to the primary when none is left,
+// when the request made a write within StickyWindow, or when the context
+// asks for it, see WithPrimaryReads.
+type RoutingQuerier struct {
+	Primary      Querier
+	Replicas     []*Replica
+	MaxLag       time.Duration
+	StickyWindow time.Duration
+
+	next atomic.Uint64
+	now  func() time.Time
+}
+
+func (r *RoutingQuerier) reader(ctx context.Context) Querier {
+	if primary, _ := ctx.Value(primaryReadsKey{}).(bool); primary {
+		return r.Primary
+	}
+
+	if sticky, ok := ctx.Value(stickyKey{}).(*Sticky); ok && r.now().Before(sticky.Until()) {
+		return r.Primary
+	}
+
+	count := uint64(len(r.Replicas))
+	start := r.next.Add(1)
+	for i := uint64(0); i < count; i++ {
+		if replica := r.Replicas[(start+i)%count]; replica.Healthy() {
+			return replica.Queries
+		}
+	}
+
+	return r.Primary
+}
+
+func (r *RoutingQuerier) writer(ctx context.Context) Querier {
+	stickToPrimary(ctx, r.now().Add(r.StickyWindow))
+
+	return r.Primary
+}
+
+// CheckReplicas takes the replicas that lag more than MaxLag, or can't be
+// reached, out of rotation and puts the others back.
+func (r *RoutingQuerier) CheckReplicas(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, replica := range r.Replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			lag, err := replica.Lag(ctx)
+			lagging := err != nil || lag > r.MaxLag
+			if replica.lagging.Swap(lagging) == lagging {
+				return
+			}
+
+			if lagging {
+				slog.Warn("Replica removed from rotation", "replica", replica.Name, "lag", lag, "error", err)
+			} else {
+				slog.Info("Replica back in rotation", "replica", replica.Name, "lag", lag)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// StartReplicaChecks runs CheckReplicas every interval until the returned
+// function is called.
+func (r *RoutingQuerier) StartReplicaChecks(interval time.Duration) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				checkCtx, checkCancel := context.WithTimeout(ctx, interval)
+				r.CheckReplicas(checkCtx)
+				checkCancel()
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (r *RoutingQuerier) GetPeople(ctx context.Context) ([]Person, error) {
+	return r.reader(ctx).GetPeople(ctx)
+}
+
+func (r *RoutingQuerier) GetPeopleFiltered(ctx context.Context, filter Filter) ([]Person, error) {
+	return r.reader(ctx).GetPeopleFiltered(ctx, filter)
+}
+
+func (r *RoutingQuerier) GetPersonById(ctx context.Context, id int32) (Person, error) {
+	return r.reader(ctx).GetPersonById(ctx, id)
+}
+
+func (r *RoutingQuerier) InsertPerson(ctx context.Context, arg InsertPersonParams) (Person, error) {
+	return r.writer(ctx).InsertPerson(ctx, arg)
+}
+
+func (r *RoutingQuerier) InsertPeople(ctx context.Context, arg InsertPeopleParams) ([]Person, error) {
+	return r.writer(ctx).InsertPeople(ctx, arg)
+}
+
+func (r *RoutingQuerier) UpdatePerson(ctx context.Context, arg UpdatePersonParams) (int64, error) {
+	return r.writer(ctx).UpdatePerson(ctx, arg)
+}
+
+func (r *RoutingQuerier) DeletePerson(ctx context.Context, id int32) (int64, error) {
+	return r.writer(ctx).DeletePerson(ctx, id)
+}
+
+func (r *RoutingQuerier) PingDb(ctx context.Context) (int32, error) {
+	return r.reader(ctx).PingDb(ctx)
+}
+
+func NewRoutingQuerier(primary Querier, replicas []*Replica, maxLag time.Duration, stickyWindow time.Duration) *RoutingQuerier {
+	return &RoutingQuerier{
+		Primary:      primary,
+		Replicas:     replicas,
+		MaxLag:       maxLag,
+		StickyWindow: stickyWindow,
+		now:          time.Now,
+	}
+}
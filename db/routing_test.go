@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestReplica(name string, lag time.Duration, lagErr error) *Replica {
+	return &Replica{
+		Name:    name,
+		Queries: &QuerierMock{GetPersonByIdResult: Person{Name: name}},
+		Lag: func(ctx context.Context) (time.Duration, error) {
+			return lag, lagErr
+		},
+	}
+}
+
+func newTestRoutingQuerier(replicas ...*Replica) *RoutingQuerier {
+	primary := &QuerierMock{GetPersonByIdResult: Person{Name: "primary"}, InsertPersonResult: Person{ID: 1}}
+	return NewRoutingQuerier(primary, replicas, time.Second, time.Minute)
+}
+
+func readFrom(t *testing.T, querier *RoutingQuerier, ctx context.Context) string {
+	person, err := querier.GetPersonById(ctx, 1)
+	assert.Nil(t, err)
+	return person.Name
+}
+
+func TestRoutingQuerierRoundRobin(t *testing.T) {
+	querier := newTestRoutingQuerier(newTestReplica("replica1", 0, nil), newTestReplica("replica2", 0, nil))
+
+	first := readFrom(t, querier, context.Background())
+	second := readFrom(t, querier, context.Background())
+	third := readFrom(t, querier, context.Background())
+
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, first, third)
+	assert.Contains(t, []string{"replica1", "replica2"}, first)
+}
+
+func TestRoutingQuerierWithoutReplicas(t *testing.T) {
+	querier := newTestRoutingQuerier()
+
+	assert.Equal(t, "primary", readFrom(t, querier, context.Background()))
+}
+
+func TestRoutingQuerierWritesGoToPrimary(t *testing.T) {
+	replica := newTestReplica("replica", 0, nil)
+	querier := newTestRoutingQuerier(replica)
+
+	person, err := querier.InsertPerson(context.Background(), InsertPersonParams{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), person.ID)
+	assert.Equal(t, Person{}, replica.Queries.(*QuerierMock).InsertPersonResult)
+}
+
+func TestRoutingQuerierPrimaryReads(t *testing.T) {
+	querier := newTestRoutingQuerier(newTestReplica("replica", 0, nil))
+
+	assert.Equal(t, "primary", readFrom(t, querier, WithPrimaryReads(context.Background())))
+	assert.Equal(t, "replica", readFrom(t, querier, context.Background()))
+}
+
+func TestCachingQuerierFillsFromPrimary(t *testing.T) {
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(newTestRoutingQuerier(newTestReplica("replica", 0, nil)), cacherMock)
+
+	person, err := querier.GetPersonById(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "primary", person.Name)
+	assert.Contains(t, cacherMock.Values["person:1@1.1"], "primary")
+}
+
+func TestRoutingQuerierRemovesLaggingReplicas(t *testing.T) {
+	lagging := newTestReplica("lagging", 2*time.Second, nil)
+	down := newTestReplica("down", 0, errors.New("connection refused"))
+	healthy := newTestReplica("healthy", 500*time.Millisecond, nil)
+	querier := newTestRoutingQuerier(lagging, down, healthy)
+
+	querier.CheckReplicas(context.Background())
+
+	assert.False(t, lagging.Healthy())
+	assert.False(t, down.Healthy())
+	assert.True(t, healthy.Healthy())
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "healthy", readFrom(t, querier, context.Background()))
+	}
+
+	lagging.Lag = func(ctx context.Context) (time.Duration, error) { return 0, nil }
+	querier.CheckReplicas(context.Background())
+
+	assert.True(t, lagging.Healthy())
+}
+
+func TestRoutingQuerierFallsBackToPrimary(t *testing.T) {
+	querier := newTestRoutingQuerier(newTestReplica("lagging", time.Hour, nil))
+
+	querier.CheckReplicas(context.Background())
+
+	assert.Equal(t, "primary", readFrom(t, querier, context.Background()))
+}
+
+func TestRoutingQuerierStickyPrimaryAfterWrite(t *testing.T) {
+	querier := newTestRoutingQuerier(newTestReplica("replica", 0, nil))
+	now := time.Now()
+	querier.now = func() time.Time { return now }
+
+	ctx, sticky := WithSticky(context.Background(), time.Time{})
+	assert.Equal(t, "replica", readFrom(t, querier, ctx))
+
+	querier.InsertPerson(ctx, InsertPersonParams{})
+
+	assert.Equal(t, now.Add(time.Minute).UnixNano(), sticky.Until().UnixNano())
+	assert.Equal(t, "primary", readFrom(t, querier, ctx))
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, "replica", readFrom(t, querier, ctx))
+}
+
+func TestRoutingQuerierStickyPrimaryFromPreviousRequest(t *testing.T) {
+	querier := newTestRoutingQuerier(newTestReplica("replica", 0, nil))
+
+	ctx, _ := WithSticky(context.Background(), time.Now().Add(time.Minute))
+	assert.Equal(t, "primary", readFrom(t, querier, ctx))
+
+	ctx, _ = WithSticky(context.Background(), time.Now().Add(-time.Minute))
+	assert.Equal(t, "replica", readFrom(t, querier, ctx))
+}
+
+func TestStickyNeverShrinks(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	ctx, sticky := WithSticky(context.Background(), until)
+
+	stickToPrimary(ctx, time.Now())
+
+	assert.Equal(t, until.UnixNano(), sticky.Until().UnixNano())
+}
+
+func TestStartReplicaChecks(t *testing.T) {
+	replica := newTestReplica("replica", time.Hour, nil)
+	querier := newTestRoutingQuerier(replica)
+
+	stop := querier.StartReplicaChecks(time.Millisecond)
+	assert.Eventually(t, func() bool { return !replica.Healthy() }, time.Second, time.Millisecond)
+	stop()
+}
+
+func TestRunInTxSticksToPrimary(t *testing.T) {
+	runner := newTestTxRunner(&TxBeginnerMock{}, nil)
+	runner.StickyWindow = time.Minute
+
+	ctx, sticky := WithSticky(context.Background(), time.Time{})
+	runner.RunInTx(ctx, TxOptions{ReadOnly: true}, func(ctx context.Context, q Querier) error { return nil })
+	assert.True(t, sticky.Until().IsZero())
+
+	runner.RunInTx(ctx, TxOptions{}, func(ctx context.Context, q Querier) error { return nil })
+	assert.True(t, sticky.Until().After(time.Now()))
+}
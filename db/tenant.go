@@ -0,0 +1,239 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DefaultTenant owns the rows created before multi-tenancy, and every row
+// when callers have no tenant claim.
+const DefaultTenant = "default"
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose queries only see the rows of tenant.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant of ctx, "" when there is none.
+func TenantFrom(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// setTenantQuery is SET LOCAL app.tenant_id, which can't take parameters.
+// The row level security policies of the tenant tables compare it with their
+// tenant_id column.
+const setTenantQuery = "SELECT set_config('app.tenant_id', $1, true)"
+
+// setTenant scopes tx to the tenant of ctx, if any.
+func setTenant(ctx context.Context, tx pgx.Tx) error {
+	tenant := TenantFrom(ctx)
+	if tenant == "" {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx, setTenantQuery, tenant)
+	return err
+}
+
+// ErrRLSBypassed is returned by CheckRole for the roles row level security
+// doesn't apply to.
+var ErrRLSBypassed = errors.New("database role bypasses row level security, tenants are not isolated")
+
+const checkRoleQuery = `SELECT current_user, rolsuper, rolbypassrls,
+  EXISTS (SELECT 1 FROM pg_tables WHERE tablename = 'person' AND tableowner = current_user)
+FROM pg_roles WHERE rolname = current_user`
+
+// CheckRole returns ErrRLSBypassed when conn connects as a superuser or a
+// role with BYPASSRLS. Owning the tenant tables is only logged: the
+// migrations FORCE ROW LEVEL SECURITY so the policies apply to the owner
+// too, but the owner can turn that off.
+func CheckRole(ctx context.Context, conn DBTX) error {
+	var role string
+	var super, bypassRLS, owner bool
+	if err := conn.QueryRow(ctx, checkRoleQuery).Scan(&role, &super, &bypassRLS, &owner); err != nil {
+		return err
+	}
+
+	if super || bypassRLS {
+		return fmt.Errorf("%w: role %s", ErrRLSBypassed, role)
+	}
+
+	if owner {
+		slog.Warn("Database role owns the tenant tables, connect with a role that was only granted access to them", "role", role)
+	}
+
+	return nil
+}
+
+// TenantBeginner is implemented by *pgxpool.Pool.
+type TenantBeginner interface {
+	DBTX
+	TxBeginner
+}
+
+// tenantDBTX runs the queries made with a tenant in a transaction scoped to
+// that tenant: the transaction of their request when there is one, see
+// WithRequestTx, and a transaction of their own otherwise. Query and
+// QueryRow hold the transaction until their rows are closed or scanned.
+type tenantDBTX struct {
+	db TenantBeginner
+}
+
+// WithTenantIsolation scopes the queries made through db to the tenant of
+// their context, see WithTenant. Queries without a tenant are sent as is and
+// see no tenant rows.
+func WithTenantIsolation(db TenantBeginner) DBTX {
+	return &tenantDBTX{db: db}
+}
+
+// begin returns the transaction the query of ctx runs in, and the function
+// to call with the error of the query once it is done, which returns the
+// error of the query or of committing. The transaction of the request is
+// left open for its next queries, a transaction of its own is committed
+// unless the query failed.
+func (t *tenantDBTX) begin(ctx context.Context) (pgx.Tx, func(err error) error, error) {
+	if requestTx := requestTxFrom(ctx); requestTx != nil {
+		tx, err := requestTx.acquire(ctx, t.db)
+		if err != nil {
+			return nil, nil, err
+		}
+		if tx != nil {
+			return tx, requestTx.release, nil
+		}
+	}
+
+	tx, err := t.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := setTenant(ctx, tx); err != nil {
+		tx.Rollback(context.WithoutCancel(ctx))
+		return nil, nil, err
+	}
+
+	return tx, endQueryTx(ctx, tx), nil
+}
+
+// endQueryTx returns the function ending tx, the transaction of a single
+// query: it is committed unless the query failed.
+func endQueryTx(ctx context.Context, tx pgx.Tx) func(err error) error {
+	return func(err error) error {
+		defer tx.Rollback(context.WithoutCancel(ctx))
+
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit(ctx)
+	}
+}
+
+func (t *tenantDBTX) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	if TenantFrom(ctx) == "" {
+		return t.db.Exec(ctx, sql, args...)
+	}
+
+	tx, end, err := t.begin(ctx)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+
+	tag, err := tx.Exec(ctx, sql, args...)
+	return tag, end(err)
+}
+
+func (t *tenantDBTX) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	if TenantFrom(ctx) == "" {
+		return t.db.Query(ctx, sql, args...)
+	}
+
+	tx, end, err := t.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, end(err)
+	}
+
+	return &tenantRows{Rows: rows, end: end}, nil
+}
+
+func (t *tenantDBTX) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	if TenantFrom(ctx) == "" {
+		return t.db.QueryRow(ctx, sql, args...)
+	}
+
+	tx, end, err := t.begin(ctx)
+	if err != nil {
+		return errRow{err: err}
+	}
+
+	return &tenantRow{row: tx.QueryRow(ctx, sql, args...), end: end}
+}
+
+type tenantRows struct {
+	pgx.Rows
+	end func(err error) error
+	err error
+}
+
+// Next ends the query once every row was read, so that Err reports the
+// commit failing.
+func (r *tenantRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+
+	r.finish()
+	return false
+}
+
+func (r *tenantRows) Close() {
+	r.Rows.Close()
+	r.finish()
+}
+
+func (r *tenantRows) Err() error {
+	if err := r.Rows.Err(); err != nil {
+		return err
+	}
+
+	return r.err
+}
+
+func (r *tenantRows) finish() {
+	if r.end == nil {
+		return
+	}
+
+	r.err = r.end(r.Rows.Err())
+	r.end = nil
+}
+
+type tenantRow struct {
+	row pgx.Row
+	end func(err error) error
+}
+
+func (r *tenantRow) Scan(dest ...any) error {
+	return r.end(r.row.Scan(dest...))
+}
+
+type errRow struct {
+	err error
+}
+
+func (r errRow) Scan(dest ...any) error {
+	return r.err
+}
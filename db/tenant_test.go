@@ -0,0 +1,337 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// TenantBeginnerMock sends the queries without a tenant to its DBTXMock and
+// the others to the transactions it begins.
+type TenantBeginnerMock struct {
+	DBTXMock
+	TxBeginnerMock
+}
+
+type ErrorRowsMock struct {
+	pgx.Rows
+	next int
+	err  error
+}
+
+func (m *ErrorRowsMock) Next() bool {
+	m.next--
+	return m.next >= 0
+}
+
+func (m *ErrorRowsMock) Err() error {
+	return m.err
+}
+
+func (m *ErrorRowsMock) Close() {}
+
+func TestTenantFrom(t *testing.T) {
+	assert.Equal(t, "", TenantFrom(context.Background()))
+	assert.Equal(t, "acme", TenantFrom(WithTenant(context.Background(), "acme")))
+}
+
+func TestTenantIsolationWithoutTenant(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	db := WithTenantIsolation(mock)
+
+	db.Exec(context.Background(), "SELECT 1")
+	db.QueryRow(context.Background(), "SELECT 1").Scan()
+	rows, _ := db.Query(context.Background(), "SELECT 1")
+	rows.Close()
+
+	assert.Empty(t, mock.Txs)
+	assert.NotNil(t, mock.Ctx)
+}
+
+func TestTenantIsolationExec(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	db := WithTenantIsolation(mock)
+
+	_, err := db.Exec(WithTenant(context.Background(), "acme"), "DELETE FROM person", 1)
+
+	assert.Nil(t, err)
+	assert.Len(t, mock.Txs, 1)
+	assert.Equal(t, []string{setTenantQuery + "[acme]", "DELETE FROM person[1]"}, mock.Txs[0].Statements)
+	assert.True(t, mock.Txs[0].Committed)
+	assert.Nil(t, mock.Ctx)
+}
+
+func TestTenantIsolationExecError(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	db := WithTenantIsolation(mock)
+	ctx, cancel := context.WithCancel(WithTenant(context.Background(), "acme"))
+	cancel()
+
+	_, err := db.Exec(ctx, "DELETE FROM person")
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, mock.Txs[0].Committed)
+	assert.True(t, mock.Txs[0].RolledBack)
+}
+
+func TestTenantIsolationBeginError(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	mock.TxBeginnerMock.Error = errors.New("no connection")
+	db := WithTenantIsolation(mock)
+	ctx := WithTenant(context.Background(), "acme")
+
+	_, err := db.Exec(ctx, "SELECT 1")
+	assert.EqualError(t, err, "no connection")
+
+	_, err = db.Query(ctx, "SELECT 1")
+	assert.EqualError(t, err, "no connection")
+
+	assert.EqualError(t, db.QueryRow(ctx, "SELECT 1").Scan(), "no connection")
+}
+
+func TestTenantIsolationQueryRowCommitsAfterScan(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	db := WithTenantIsolation(mock)
+
+	row := db.QueryRow(WithTenant(context.Background(), "acme"), "SELECT 1")
+
+	assert.False(t, mock.Txs[0].Committed)
+	assert.Nil(t, row.Scan())
+	assert.True(t, mock.Txs[0].Committed)
+	assert.Equal(t, []string{setTenantQuery + "[acme]", "SELECT 1[]"}, mock.Txs[0].Statements)
+}
+
+func TestTenantIsolationQueryCommitsAfterClose(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	db := WithTenantIsolation(mock)
+
+	rows, err := db.Query(WithTenant(context.Background(), "acme"), "SELECT 1")
+
+	assert.Nil(t, err)
+	assert.False(t, mock.Txs[0].Committed)
+	rows.Close()
+	assert.True(t, mock.Txs[0].Committed)
+}
+
+func TestTenantRowsReportsCommitError(t *testing.T) {
+	tx := &TxMock{CommitError: errors.New("commit failed")}
+	rows := &tenantRows{Rows: &ErrorRowsMock{next: 1}, end: endQueryTx(context.Background(), tx)}
+
+	assert.True(t, rows.Next())
+	assert.False(t, rows.Next())
+	assert.EqualError(t, rows.Err(), "commit failed")
+	rows.Close()
+}
+
+func TestTenantRowsSkipsCommitOnError(t *testing.T) {
+	tx := &TxMock{}
+	rows := &tenantRows{Rows: &ErrorRowsMock{err: errors.New("failed")}, end: endQueryTx(context.Background(), tx)}
+
+	assert.False(t, rows.Next())
+	assert.EqualError(t, rows.Err(), "failed")
+	assert.False(t, tx.Committed)
+	assert.True(t, tx.RolledBack)
+}
+
+func TestRequestTxSharedByTheQueriesOfARequest(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	db := WithTenantIsolation(mock)
+	ctx, requestTx := WithRequestTx(WithTenant(context.Background(), "acme"))
+
+	_, err := db.Exec(ctx, "DELETE FROM person", 1)
+	assert.Nil(t, err)
+	assert.Nil(t, db.QueryRow(ctx, "SELECT 1").Scan())
+	rows, err := db.Query(ctx, "SELECT 2")
+	assert.Nil(t, err)
+	rows.Close()
+
+	assert.Len(t, mock.Txs, 1)
+	assert.Equal(t, []string{setTenantQuery + "[acme]", "DELETE FROM person[1]", "SELECT 1[]", "SELECT 2[]"}, mock.Txs[0].Statements)
+	assert.False(t, mock.Txs[0].Committed)
+
+	assert.Nil(t, requestTx.Commit(context.Background()))
+	assert.True(t, mock.Txs[0].Committed)
+}
+
+func TestRequestTxPerPool(t *testing.T) {
+	primary := &TenantBeginnerMock{}
+	replica := &TenantBeginnerMock{}
+	ctx, requestTx := WithRequestTx(WithTenant(context.Background(), "acme"))
+
+	WithTenantIsolation(primary).Exec(ctx, "DELETE FROM person")
+	WithTenantIsolation(replica).QueryRow(ctx, "SELECT 1").Scan()
+	WithTenantIsolation(primary).Exec(ctx, "DELETE FROM person")
+
+	assert.Len(t, primary.Txs, 1)
+	assert.Len(t, replica.Txs, 1)
+
+	assert.Nil(t, requestTx.Rollback(context.Background()))
+	assert.True(t, primary.Txs[0].RolledBack)
+	assert.True(t, replica.Txs[0].RolledBack)
+}
+
+func TestRequestTxEnded(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	db := WithTenantIsolation(mock)
+	ctx, requestTx := WithRequestTx(WithTenant(context.Background(), "acme"))
+	db.Exec(ctx, "SELECT 1")
+	assert.Nil(t, requestTx.Commit(context.Background()))
+
+	// the queries made afterwards, e.g. by a background refresh, run on
+	// their own
+	db.Exec(ctx, "SELECT 2")
+
+	assert.Len(t, mock.Txs, 2)
+	assert.True(t, mock.Txs[1].Committed)
+	// ending it again is a no-op
+	assert.Nil(t, requestTx.Rollback(context.Background()))
+	assert.False(t, mock.Txs[0].RolledBack)
+}
+
+func TestRequestTxBeginError(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	mock.TxBeginnerMock.Error = errors.New("no connection")
+	ctx, _ := WithRequestTx(WithTenant(context.Background(), "acme"))
+
+	_, err := WithTenantIsolation(mock).Exec(ctx, "SELECT 1")
+	assert.EqualError(t, err, "no connection")
+
+	// the request isn't left holding the transaction
+	mock.TxBeginnerMock.Error = nil
+	_, err = WithTenantIsolation(mock).Exec(ctx, "SELECT 1")
+	assert.Nil(t, err)
+}
+
+func TestWithoutRequestTx(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	db := WithTenantIsolation(mock)
+	ctx, requestTx := WithRequestTx(WithTenant(context.Background(), "acme"))
+	db.Exec(ctx, "SELECT 1")
+
+	db.Exec(withoutRequestTx(ctx), "SELECT 2")
+
+	assert.Len(t, mock.Txs, 2)
+	assert.True(t, mock.Txs[1].Committed)
+	assert.False(t, mock.Txs[0].Committed)
+	requestTx.Rollback(context.Background())
+}
+
+func TestRequestTxDefersUntilCommit(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	ctx, requestTx := WithRequestTx(WithTenant(context.Background(), "acme"))
+	WithTenantIsolation(mock).Exec(ctx, "DELETE FROM person")
+
+	called := 0
+	afterCommitOf(ctx, func(ctx context.Context) { called++ })
+	assert.Equal(t, 0, called)
+
+	assert.Nil(t, requestTx.Commit(context.Background()))
+	assert.Equal(t, 1, called)
+}
+
+func TestRequestTxDropsDeferredOnFailure(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	ctx, requestTx := WithRequestTx(WithTenant(context.Background(), "acme"))
+	WithTenantIsolation(mock).Exec(ctx, "DELETE FROM person")
+	mock.Txs[0].CommitError = errors.New("commit failed")
+
+	called := 0
+	afterCommitOf(ctx, func(ctx context.Context) { called++ })
+
+	assert.EqualError(t, requestTx.Commit(context.Background()), "commit failed")
+	assert.Equal(t, 0, called)
+	assert.True(t, mock.Txs[0].RolledBack)
+}
+
+func TestAfterCommitOfWithoutRequestTx(t *testing.T) {
+	called := 0
+	afterCommitOf(context.Background(), func(ctx context.Context) { called++ })
+
+	// nothing was written in the request transaction yet
+	ctx, _ := WithRequestTx(context.Background())
+	afterCommitOf(ctx, func(ctx context.Context) { called++ })
+
+	assert.Equal(t, 2, called)
+}
+
+func TestCachingQuerierWritesWaitForRequestCommit(t *testing.T) {
+	mock := &TenantBeginnerMock{}
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(&QuerierMock{InsertPersonResult: Person{ID: 1, Name: "Test", TenantID: "acme"}}, cacherMock)
+	ctx, requestTx := WithRequestTx(WithTenant(context.Background(), "acme"))
+	// the write of the querier mock, in the request transaction
+	WithTenantIsolation(mock).Exec(ctx, "INSERT INTO person")
+
+	_, err := querier.InsertPerson(ctx, InsertPersonParams{Name: "Test"})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", cacherMock.Values["tag:tenant:acme:person:1"])
+
+	assert.Nil(t, requestTx.Commit(context.Background()))
+	assert.Contains(t, cacherMock.Values, currentPersonKey(t, querier, ctx, 1))
+}
+
+func TestCachingQuerierScopesKeysByTenant(t *testing.T) {
+	cacherMock := newCacherMock(map[string]string{"tag:tenant:acme:person:list": "1"})
+	querier := NewCachingQuerier(&QuerierMock{
+		InsertPersonResult:  Person{ID: 1, Name: "Test", TenantID: "acme"},
+		GetPersonByIdResult: Person{ID: 2, Name: "Other", TenantID: "acme"},
+	}, cacherMock)
+	ctx := WithTenant(context.Background(), "acme")
+
+	_, err := querier.InsertPerson(ctx, InsertPersonParams{Name: "Test"})
+	assert.Nil(t, err)
+	_, err = querier.GetPersonById(ctx, 2)
+	assert.Nil(t, err)
+
+	assert.Contains(t, cacherMock.Values[currentPersonKey(t, querier, ctx, 1)], `"TenantID":"acme"`)
+	assert.Contains(t, cacherMock.Values, "tenant:acme:person:2@1.1")
+	assert.NotContains(t, cacherMock.Values, "person:1@1.1")
+	assert.NotEqual(t, "1", cacherMock.Values["tag:tenant:acme:person:list"])
+	assert.Equal(t, "1", cacherMock.Values["tag:person:list"])
+}
+
+func TestTenantMigrationIsEmbedded(t *testing.T) {
+	up, err := migrations.ReadFile("migrations/002_tenant.up.sql")
+
+	assert.Nil(t, err)
+	assert.Contains(t, string(up), "ENABLE ROW LEVEL SECURITY")
+}
+
+// roleDBTXMock answers the query of CheckRole.
+type roleDBTXMock struct {
+	DBTXMock
+	super, bypassRLS, owner bool
+}
+
+func (m *roleDBTXMock) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return &roleRowMock{m}
+}
+
+type roleRowMock struct {
+	mock *roleDBTXMock
+}
+
+func (r *roleRowMock) Scan(dest ...any) error {
+	*dest[0].(*string) = "api"
+	*dest[1].(*bool) = r.mock.super
+	*dest[2].(*bool) = r.mock.bypassRLS
+	*dest[3].(*bool) = r.mock.owner
+	return nil
+}
+
+func TestCheckRole(t *testing.T) {
+	assert.Nil(t, CheckRole(context.Background(), &roleDBTXMock{}))
+	// owners are subject to the forced policies
+	assert.Nil(t, CheckRole(context.Background(), &roleDBTXMock{owner: true}))
+}
+
+func TestCheckRoleBypassingRLS(t *testing.T) {
+	err := CheckRole(context.Background(), &roleDBTXMock{super: true})
+	assert.ErrorIs(t, err, ErrRLSBypassed)
+	assert.ErrorContains(t, err, "role api")
+
+	assert.ErrorIs(t, CheckRole(context.Background(), &roleDBTXMock{bypassRLS: true}), ErrRLSBypassed)
+}
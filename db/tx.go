@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type TxOptions struct {
+	// IsoLevel overrides the runner's default isolation level when set
+	IsoLevel pgx.TxIsoLevel
+	ReadOnly bool
+}
+
+// TxRunner runs units of work in a transaction. The transaction is committed
+// when fn returns nil and rolled back otherwise. fn is retried on
+// serialization failures and deadlocks, so it must not have side effects
+// outside of the Querier it is given.
+type TxRunner interface {
+	RunInTx(ctx context.Context, opts TxOptions, fn func(ctx context.Context, q Querier) error) error
+}
+
+// TxBeginner is implemented by *pgxpool.Pool, *pgx.Conn and pgx.Tx.
+type TxBeginner interface {
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+// TxDecorator is implemented by queriers that wrap Queries and need to know
+// about transactions, CachingQuerier for instance doesn't touch the cache
+// before the transaction committed.
+type TxDecorator interface {
+	// InTx returns the Querier to use in place of q within a transaction, and
+	// a function to call once the transaction committed.
+	InTx(q Querier) (Querier, func(ctx context.Context))
+}
+
+type PoolTxRunner struct {
+	Pool TxBeginner
+	// Decorator, when set, wraps the Querier of every transaction
+	Decorator TxDecorator
+	// IsoLevel is used when TxOptions has none
+	IsoLevel   pgx.TxIsoLevel
+	MaxRetries int
+	// RetryDelay is doubled after every retry
+	RetryDelay time.Duration
+	// StickyWindow is how long the reads of a request go to the primary
+	// after it committed a read-write transaction, see WithSticky
+	StickyWindow time.Duration
+	// StatementTimeout bounds every query of the transactions, see
+	// WithStatementTimeout
+	StatementTimeout time.Duration
+}
+
+func (r *PoolTxRunner) RunInTx(ctx context.Context, opts TxOptions, fn func(ctx context.Context, q Querier) error) error {
+	delay := r.RetryDelay
+
+	for attempt := 0; ; attempt++ {
+		err := r.runOnce(ctx, opts, fn)
+		if err == nil || !isRetryable(err) || attempt >= r.MaxRetries {
+			return err
+		}
+
+		slog.Warn("Retrying transaction", "attempt", attempt+1, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+	}
+}
+
+func (r *PoolTxRunner) runOnce(ctx context.Context, opts TxOptions, fn func(ctx context.Context, q Querier) error) error {
+	txOptions := pgx.TxOptions{IsoLevel: opts.IsoLevel}
+	if txOptions.IsoLevel == "" {
+		txOptions.IsoLevel = r.IsoLevel
+	}
+	if opts.ReadOnly {
+		txOptions.AccessMode = pgx.ReadOnly
+	}
+
+	tx, err := r.Pool.BeginTx(ctx, txOptions)
+	if err != nil {
+		return err
+	}
+
+	// no-op once committed, and covers fn panicking
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	if err := setTenant(ctx, tx); err != nil {
+		return err
+	}
+
+	var querier Querier = New(WithStatementTimeout(tx, r.StatementTimeout))
+	var committed func(ctx context.Context)
+	if r.Decorator != nil {
+		querier, committed = r.Decorator.InTx(querier)
+	}
+
+	if err := fn(ctx, querier); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	if !opts.ReadOnly {
+		stickToPrimary(ctx, time.Now().Add(r.StickyWindow))
+	}
+
+	if committed != nil {
+		committed(ctx)
+	}
+
+	return nil
+}
+
+// isRetryable reports serialization failures and deadlocks, the transaction
+// is expected to succeed if it runs again.
+func isRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+
+	return pgErr.Code == "40001" || pgErr.Code == "40P01"
+}
+
+// ParseIsoLevel maps the isolation levels accepted in configuration, e.g.
+// "repeatable read", to pgx's.
+func ParseIsoLevel(level string) pgx.TxIsoLevel {
+	switch level {
+	case "serializable":
+		return pgx.Serializable
+	case "repeatable read":
+		return pgx.RepeatableRead
+	default:
+		return pgx.ReadCommitted
+	}
+}
+
+func NewTxRunner(pool TxBeginner, decorator TxDecorator) *PoolTxRunner {
+	return &PoolTxRunner{
+		Pool:       pool,
+		Decorator:  decorator,
+		IsoLevel:   pgx.ReadCommitted,
+		MaxRetries: 3,
+		RetryDelay: 10 * time.Millisecond,
+	}
+}
@@ -0,0 +1,295 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+// TxMock only implements what PoolTxRunner and tenantDBTX use, other
+// methods panic. Queries are recorded in Statements.
+type TxMock struct {
+	pgx.Tx
+	DBTXMock
+	CommitError error
+	Committed   bool
+	RolledBack  bool
+	Statements  []string
+}
+
+func (m *TxMock) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	m.Statements = append(m.Statements, fmt.Sprint(sql, args))
+	return m.DBTXMock.Exec(ctx, sql, args...)
+}
+
+func (m *TxMock) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	m.Statements = append(m.Statements, fmt.Sprint(sql, args))
+	return m.DBTXMock.Query(ctx, sql, args...)
+}
+
+func (m *TxMock) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	m.Statements = append(m.Statements, fmt.Sprint(sql, args))
+	return m.DBTXMock.QueryRow(ctx, sql, args...)
+}
+
+func (m *TxMock) Commit(ctx context.Context) error {
+	if m.CommitError != nil {
+		return m.CommitError
+	}
+	m.Committed = true
+	return nil
+}
+
+func (m *TxMock) Rollback(ctx context.Context) error {
+	if !m.Committed {
+		m.RolledBack = true
+	}
+	return nil
+}
+
+type TxBeginnerMock struct {
+	Txs     []*TxMock
+	Options []pgx.TxOptions
+	Error   error
+}
+
+func (m *TxBeginnerMock) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	if m.Error != nil {
+		return nil, m.Error
+	}
+	tx := &TxMock{}
+	m.Txs = append(m.Txs, tx)
+	m.Options = append(m.Options, txOptions)
+	return tx, nil
+}
+
+func newTestTxRunner(beginner *TxBeginnerMock, decorator TxDecorator) *PoolTxRunner {
+	runner := NewTxRunner(beginner, decorator)
+	runner.RetryDelay = 0
+	return runner
+}
+
+func TestRunInTxCommits(t *testing.T) {
+	beginner := &TxBeginnerMock{}
+	runner := newTestTxRunner(beginner, nil)
+
+	err := runner.RunInTx(context.Background(), TxOptions{}, func(ctx context.Context, q Querier) error {
+		assert.NotNil(t, q)
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Len(t, beginner.Txs, 1)
+	assert.True(t, beginner.Txs[0].Committed)
+	assert.False(t, beginner.Txs[0].RolledBack)
+	assert.Equal(t, pgx.ReadCommitted, beginner.Options[0].IsoLevel)
+}
+
+func TestRunInTxRollsBackOnError(t *testing.T) {
+	beginner := &TxBeginnerMock{}
+	runner := newTestTxRunner(beginner, nil)
+
+	err := runner.RunInTx(context.Background(), TxOptions{}, func(ctx context.Context, q Querier) error {
+		return errors.New("failed")
+	})
+
+	assert.EqualError(t, err, "failed")
+	assert.Len(t, beginner.Txs, 1)
+	assert.False(t, beginner.Txs[0].Committed)
+	assert.True(t, beginner.Txs[0].RolledBack)
+}
+
+func TestRunInTxRetriesSerializationFailures(t *testing.T) {
+	beginner := &TxBeginnerMock{}
+	runner := newTestTxRunner(beginner, nil)
+
+	attempts := 0
+	err := runner.RunInTx(context.Background(), TxOptions{}, func(ctx context.Context, q Querier) error {
+		attempts++
+		switch attempts {
+		case 1:
+			return &pgconn.PgError{Code: "40001"}
+		case 2:
+			return &pgconn.PgError{Code: "40P01"}
+		default:
+			return nil
+		}
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Len(t, beginner.Txs, 3)
+	assert.True(t, beginner.Txs[0].RolledBack)
+	assert.True(t, beginner.Txs[1].RolledBack)
+	assert.True(t, beginner.Txs[2].Committed)
+}
+
+func TestRunInTxGivesUpAfterMaxRetries(t *testing.T) {
+	beginner := &TxBeginnerMock{}
+	runner := newTestTxRunner(beginner, nil)
+	runner.MaxRetries = 2
+
+	err := runner.RunInTx(context.Background(), TxOptions{}, func(ctx context.Context, q Querier) error {
+		return &pgconn.PgError{Code: "40001"}
+	})
+
+	assert.True(t, isRetryable(err))
+	assert.Len(t, beginner.Txs, 3)
+}
+
+func TestRunInTxDoesNotRetryOtherErrors(t *testing.T) {
+	beginner := &TxBeginnerMock{}
+	runner := newTestTxRunner(beginner, nil)
+
+	err := runner.RunInTx(context.Background(), TxOptions{}, func(ctx context.Context, q Querier) error {
+		return &pgconn.PgError{Code: "23505"}
+	})
+
+	assert.NotNil(t, err)
+	assert.Len(t, beginner.Txs, 1)
+}
+
+func TestRunInTxRetriesCommitFailures(t *testing.T) {
+	beginner := &TxBeginnerMock{}
+	runner := newTestTxRunner(beginner, nil)
+
+	attempts := 0
+	err := runner.RunInTx(context.Background(), TxOptions{}, func(ctx context.Context, q Querier) error {
+		attempts++
+		if attempts == 1 {
+			beginner.Txs[0].CommitError = &pgconn.PgError{Code: "40001"}
+		}
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.True(t, beginner.Txs[1].Committed)
+}
+
+func TestRunInTxBeginError(t *testing.T) {
+	runner := newTestTxRunner(&TxBeginnerMock{Error: errors.New("no connection")}, nil)
+
+	called := false
+	err := runner.RunInTx(context.Background(), TxOptions{}, func(ctx context.Context, q Querier) error {
+		called = true
+		return nil
+	})
+
+	assert.EqualError(t, err, "no connection")
+	assert.False(t, called)
+}
+
+func TestRunInTxOptions(t *testing.T) {
+	beginner := &TxBeginnerMock{}
+	runner := newTestTxRunner(beginner, nil)
+	runner.IsoLevel = pgx.RepeatableRead
+
+	runner.RunInTx(context.Background(), TxOptions{ReadOnly: true}, func(ctx context.Context, q Querier) error {
+		return nil
+	})
+	runner.RunInTx(context.Background(), TxOptions{IsoLevel: pgx.Serializable}, func(ctx context.Context, q Querier) error {
+		return nil
+	})
+
+	assert.Equal(t, pgx.RepeatableRead, beginner.Options[0].IsoLevel)
+	assert.Equal(t, pgx.ReadOnly, beginner.Options[0].AccessMode)
+	assert.Equal(t, pgx.Serializable, beginner.Options[1].IsoLevel)
+	assert.Equal(t, pgx.TxAccessMode(""), beginner.Options[1].AccessMode)
+}
+
+func TestParseIsoLevel(t *testing.T) {
+	assert.Equal(t, pgx.ReadCommitted, ParseIsoLevel("read committed"))
+	assert.Equal(t, pgx.RepeatableRead, ParseIsoLevel("repeatable read"))
+	assert.Equal(t, pgx.Serializable, ParseIsoLevel("serializable"))
+	assert.Equal(t, pgx.ReadCommitted, ParseIsoLevel(""))
+}
+
+func TestRunInTxDefersCacheWritesUntilCommit(t *testing.T) {
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(&QuerierMock{}, cacherMock)
+	runner := newTestTxRunner(&TxBeginnerMock{}, querier)
+
+	var decorated Querier
+	err := runner.RunInTx(context.Background(), TxOptions{}, func(ctx context.Context, q Querier) error {
+		decorated = q
+		q.(*txCachingQuerier).queries = &QuerierMock{
+			InsertPersonResult: Person{ID: 1, Name: "Test"},
+		}
+
+		_, err := q.InsertPerson(ctx, InsertPersonParams{Name: "Test"})
+		assert.Equal(t, "1", cacherMock.Values["tag:person:1"])
+		return err
+	})
+
+	assert.Nil(t, err)
+	assert.IsType(t, &txCachingQuerier{}, decorated)
+	assert.Contains(t, cacherMock.Values, currentPersonKey(t, querier, context.Background(), 1))
+	assert.NotEqual(t, "1", cacherMock.Values["tag:person:list"])
+}
+
+func TestRunInTxSkipsCacheWritesOnRollback(t *testing.T) {
+	cacherMock := newCacherMock(map[string]string{"person:1@1.1": fresh(`{"ID":1}`)})
+	querier := NewCachingQuerier(&QuerierMock{}, cacherMock)
+	runner := newTestTxRunner(&TxBeginnerMock{}, querier)
+
+	err := runner.RunInTx(context.Background(), TxOptions{}, func(ctx context.Context, q Querier) error {
+		q.(*txCachingQuerier).queries = &QuerierMock{DeletePersonResult: 1}
+
+		q.DeletePerson(ctx, 1)
+		return errors.New("failed")
+	})
+
+	assert.EqualError(t, err, "failed")
+	assert.Contains(t, cacherMock.Values, "person:1@1.1")
+	assert.Equal(t, "1", cacherMock.Values["tag:person:list"])
+}
+
+func TestTxCachingQuerierReadsBypassCache(t *testing.T) {
+	cached := fresh(`{"ID":1,"Name":"Cached"}`)
+	cacherMock := newCacherMock(map[string]string{"person:1@1.1": cached})
+	querier := NewCachingQuerier(&QuerierMock{}, cacherMock)
+
+	tx, committed := querier.InTx(&QuerierMock{GetPersonByIdResult: Person{ID: 1, Name: "Uncommitted"}})
+	result, err := tx.GetPersonById(context.Background(), 1)
+	committed(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Uncommitted", result.Name)
+	assert.Equal(t, cached, cacherMock.Values["person:1@1.1"])
+}
+
+func TestTxCachingQuerierCacheFailureMarksStale(t *testing.T) {
+	cacherMock := newCacherMock(nil)
+	querier := NewCachingQuerier(&QuerierMock{}, cacherMock)
+
+	tx, committed := querier.InTx(&QuerierMock{UpdatePersonResult: 1})
+	_, err := tx.UpdatePerson(context.Background(), UpdatePersonParams{ID: 1})
+	assert.Nil(t, err)
+	assert.False(t, querier.stale.Load())
+
+	cacherMock.SetStringError = errors.New("error")
+	committed(context.Background())
+
+	assert.True(t, querier.stale.Load())
+}
+
+func TestRunInTxSetsTenant(t *testing.T) {
+	beginner := &TxBeginnerMock{}
+	runner := newTestTxRunner(beginner, nil)
+
+	runner.RunInTx(WithTenant(context.Background(), "acme"), TxOptions{}, func(ctx context.Context, q Querier) error {
+		return nil
+	})
+	runner.RunInTx(context.Background(), TxOptions{}, func(ctx context.Context, q Querier) error {
+		return nil
+	})
+
+	assert.Equal(t, []string{setTenantQuery + "[acme]"}, beginner.Txs[0].Statements)
+	assert.Empty(t, beginner.Txs[1].Statements)
+}
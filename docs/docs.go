@@ -17,7 +17,7 @@ const docTemplate = `{
     "paths": {
         "/health": {
             "get": {
-                "description": "Returns HTTP 200 if the app is healthy and 400 if not",
+                "description": "Returns HTTP 200 if the app is healthy and 400 if not. An unavailable cache only degrades the app since it is bypassed",
                 "produces": [
                     "application/json"
                 ],
@@ -42,6 +42,46 @@ const docTemplate = `{
             }
         },
         "/person": {
+            "get": {
+                "security": [
+                    {
+                        "OAuth2Implicit": []
+                    }
+                ],
+                "description": "get people filtered by the authorization policy",
+                "produces": [
+                    "application/json",
+                    "text/csv",
+                    "application/msgpack"
+                ],
+                "tags": [
+                    "person"
+                ],
+                "summary": "Retrieves the people the caller is allowed to see",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Person"
+                            }
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    }
+                }
+            },
             "post": {
                 "security": [
                     {
@@ -85,6 +125,105 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/person/batch": {
+            "post": {
+                "security": [
+                    {
+                        "OAuth2Implicit": []
+                    }
+                ],
+                "description": "add by json people, either all of them are added or none. The body may be sent compressed with gzip or zstd",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "person"
+                ],
+                "summary": "Add people",
+                "parameters": [
+                    {
+                        "description": "Add people",
+                        "name": "people",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Person"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.IdResult"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
                     }
                 }
             }
@@ -98,7 +237,9 @@ const docTemplate = `{
                 ],
                 "description": "get person by ID",
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "text/csv",
+                    "application/msgpack"
                 ],
                 "tags": [
                     "person"
@@ -125,6 +266,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResult"
                         }
+                    },
+                    "406": {
+                        "description": "Not Acceptable",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
                     }
                 }
             },
@@ -175,6 +322,24 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResult"
                         }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResult"
+                        }
                     }
                 }
             },
@@ -233,6 +398,9 @@ const docTemplate = `{
         "models.HealthResult": {
             "type": "object",
             "properties": {
+                "degraded": {
+                    "type": "boolean"
+                },
                 "dependencies": {
                     "type": "array",
                     "items": {
@@ -258,6 +426,14 @@ const docTemplate = `{
                 }
             }
         },
+        "models.IdResult": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer"
+                }
+            }
+        },
         "models.Person": {
             "type": "object",
             "required": [
@@ -296,7 +472,7 @@ const docTemplate = `{
             "flow": "implicit",
             "authorizationUrl": "https://login.microsoftonline.com/9e6b9f31-c202-4cbd-a9b1-7e5cb3874384/oauth2/v2.0/authorize",
             "scopes": {
-                "api://c571ab3c-0fde-43b2-b010-77e7bdd0d6f7/api": "\tAPI"
+                "api://c571ab3c-0fde-43b2-b010-77e7bdd0d6f7/api": "API"
             }
         }
     }
@@ -3,14 +3,17 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"goapi-template/auth"
 	"goapi-template/db"
 	"goapi-template/middlewares"
 	"goapi-template/models"
+	"io"
 	"log/slog"
 	"net/http"
 	"reflect"
+	"strings"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/jackc/pgx/v5"
@@ -19,10 +22,57 @@ import (
 
 type Handlers struct {
 	Queries db.Querier
+	// Tx runs operations made of several queries atomically
+	Tx db.TxRunner
+	// Emails normalizes the emails of people before they are stored
+	Emails models.EmailNormalizer
+	// JSON makes the decoding of request bodies stricter
+	JSON JSONOptions
 }
 
-func New(querier db.Querier) Handlers {
-	return Handlers{Queries: querier}
+type JSONOptions struct {
+	// DisallowUnknownFields rejects fields the target type doesn't have
+	DisallowUnknownFields bool
+	// RejectTrailingData rejects anything after the JSON value
+	RejectTrailingData bool
+}
+
+// invalidBodyError is returned for request bodies that can't be decoded.
+type invalidBodyError struct {
+	err error
+}
+
+func (e *invalidBodyError) Error() string {
+	return "Invalid request body: " + strings.TrimPrefix(e.err.Error(), "json: ")
+}
+
+func (e *invalidBodyError) Unwrap() error {
+	return e.err
+}
+
+// itemError is returned for the item at index of an array streamed by
+// decodeJSONArray.
+type itemError struct {
+	index int
+	err   error
+}
+
+func (e *itemError) Error() string {
+	return fmt.Sprintf("[%d]: %s", e.index, e.err)
+}
+
+func (e *itemError) Unwrap() error {
+	return e.err
+}
+
+// uniqueViolations maps unique constraints to the conflict reported to
+// clients, constraints missing from it are reported as a duplication.
+var uniqueViolations = map[string]string{
+	"person_tenant_id_lower_email_key": "Email is already in use",
+}
+
+func New(querier db.Querier, txRunner db.TxRunner) Handlers {
+	return Handlers{Queries: querier, Tx: txRunner}
 }
 
 func errorToHttpResult(err error, ctx context.Context) (int, *models.ErrorResult) {
@@ -31,19 +81,68 @@ func errorToHttpResult(err error, ctx context.Context) (int, *models.ErrorResult
 		"traceId", ctx.Value(middlewares.ContextKey("traceId")),
 	)
 
+	return httpResult(err)
+}
+
+func httpResult(err error) (int, *models.ErrorResult) {
+	// the client errors of an item are reported at its index
+	var item *itemError
+	if errors.As(err, &item) {
+		status, result := httpResult(item.err)
+		if status == http.StatusBadRequest || status == http.StatusConflict {
+			for i, message := range result.Errors {
+				result.Errors[i] = fmt.Sprintf("[%d]: %s", item.index, message)
+			}
+		}
+		return status, result
+	}
+
 	if vErrs, ok := err.(validator.ValidationErrors); ok {
 		out := translateErrors(vErrs)
 		return http.StatusBadRequest, &models.ErrorResult{Errors: out}
 	}
 
+	if vErrs, ok := err.(models.ValidationErrors); ok {
+		out := make([]string, 0)
+		for i, itemErr := range vErrs {
+			if itemErr, ok := itemErr.(validator.ValidationErrors); ok {
+				for _, msg := range translateErrors(itemErr) {
+					out = append(out, fmt.Sprintf("[%d]: %s", i, msg))
+				}
+			}
+		}
+		return http.StatusBadRequest, &models.ErrorResult{Errors: out}
+	}
+
+	var invalidBody *invalidBodyError
+	if errors.As(err, &invalidBody) {
+		return http.StatusBadRequest, &models.ErrorResult{Errors: []string{invalidBody.Error()}}
+	}
+
+	if errors.Is(err, errDuplicateEmail) {
+		return http.StatusConflict, &models.ErrorResult{Errors: []string{uniqueViolations["person_tenant_id_lower_email_key"]}}
+	}
+
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return http.StatusRequestEntityTooLarge, &models.ErrorResult{Errors: []string{"Request body is too large"}}
+	}
+
+	// the request timed out, see middlewares.Timeout
+	if errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) {
+		return http.StatusServiceUnavailable, &models.ErrorResult{Errors: []string{"Request timed out"}}
+	}
+
 	if err == pgx.ErrNoRows {
 		return http.StatusNotFound, nil
 	}
 
-	if dbError, ok := err.(*pgconn.PgError); ok {
-		if dbError.Code == "23505" {
-			return http.StatusConflict, &models.ErrorResult{Errors: []string{"Record duplication detected"}}
+	var dbError *pgconn.PgError
+	if errors.As(err, &dbError) && dbError.Code == "23505" {
+		if message, ok := uniqueViolations[dbError.ConstraintName]; ok {
+			return http.StatusConflict, &models.ErrorResult{Errors: []string{message}}
 		}
+		return http.StatusConflict, &models.ErrorResult{Errors: []string{"Record duplication detected"}}
 	}
 
 	return http.StatusInternalServerError, &models.ErrorResult{Errors: []string{"Unknown error"}}
@@ -69,30 +168,49 @@ func getUserEmail(ctx context.Context) string {
 	return ""
 }
 
-func bindJSON(r *http.Request, result any) error {
-	err := json.NewDecoder(r.Body).Decode(result)
+// getFilter returns the row filter produced by the authorization policy.
+// Without one, no rows are visible.
+func getFilter(ctx context.Context) db.Filter {
+	if filter, ok := ctx.Value(auth.FilterKey).(db.Filter); ok {
+		return filter
+	}
 
-	if err != nil {
-		return err
+	return db.DenyAllFilter
+}
+
+func (h Handlers) bindJSON(r *http.Request, result any) error {
+	decoder := json.NewDecoder(r.Body)
+	if h.JSON.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
 	}
 
-	validate := validator.New()
-	validate.SetTagName("binding")
-	value := reflect.ValueOf(result)
+	if err := decoder.Decode(result); err != nil {
+		return decodeError(err, err)
+	}
+
+	if h.JSON.RejectTrailingData {
+		if _, err := decoder.Token(); err != io.EOF {
+			return decodeError(err, errors.New("unexpected data after the JSON value"))
+		}
+	}
+
+	validate := newValidator()
+	value := reflect.Indirect(reflect.ValueOf(result))
 	switch value.Kind() {
-	case reflect.Ptr:
-		return validate.Struct(value.Elem().Interface())
 	case reflect.Struct:
-		return validate.Struct(result)
+		return validate.Struct(value.Interface())
 	case reflect.Slice, reflect.Array:
+		// errors are kept at the index of their item, nil for valid ones
 		count := value.Len()
-		validateRet := make(models.ValidationErrors, 0)
+		validateRet := make(models.ValidationErrors, count)
+		failed := false
 		for i := 0; i < count; i++ {
 			if err := validate.Struct(value.Index(i).Interface()); err != nil {
-				validateRet = append(validateRet, err)
+				validateRet[i] = err
+				failed = true
 			}
 		}
-		if len(validateRet) == 0 {
+		if !failed {
 			return nil
 		}
 		return validateRet
@@ -101,6 +219,88 @@ func bindJSON(r *http.Request, result any) error {
 	}
 }
 
+// decodeJSONArray streams the JSON array of the body of r, calling each with
+// every item that is valid. Only the current item is held in memory. The
+// errors of an item are returned at its index, and since the whole array is
+// rejected then, each isn't called anymore once an item is invalid. The
+// validation errors of every item are returned as models.ValidationErrors.
+func decodeJSONArray[T any](h Handlers, r *http.Request, each func(i int, item *T) error) (int, error) {
+	decoder := json.NewDecoder(r.Body)
+	if h.JSON.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		if err == nil {
+			err = errors.New("expected a JSON array")
+		}
+		return 0, decodeError(err, err)
+	}
+
+	validate := newValidator()
+	var validationErrs models.ValidationErrors
+	count := 0
+	for ; decoder.More(); count++ {
+		var item T
+		if err := decoder.Decode(&item); err != nil {
+			err = decodeError(err, err)
+			if _, ok := err.(*invalidBodyError); ok {
+				err = &itemError{index: count, err: err}
+			}
+			return count, err
+		}
+
+		if err := validate.Struct(item); err != nil {
+			// nil for the valid items before, see errorToHttpResult
+			validationErrs = append(validationErrs, make(models.ValidationErrors, count-len(validationErrs))...)
+			validationErrs = append(validationErrs, err)
+			continue
+		}
+
+		if validationErrs != nil {
+			continue
+		}
+
+		if err := each(count, &item); err != nil {
+			return count, &itemError{index: count, err: err}
+		}
+	}
+
+	if _, err := decoder.Token(); err != nil {
+		return count, decodeError(err, err)
+	}
+
+	if h.JSON.RejectTrailingData {
+		if _, err := decoder.Token(); err != io.EOF {
+			return count, decodeError(err, errors.New("unexpected data after the JSON value"))
+		}
+	}
+
+	if validationErrs != nil {
+		return count, validationErrs
+	}
+
+	return count, nil
+}
+
+func newValidator() *validator.Validate {
+	validate := validator.New()
+	validate.SetTagName("binding")
+	return validate
+}
+
+// decodeError keeps the errors of the body reader, such as the
+// *http.MaxBytesError of too large bodies, and reports the others as an
+// invalid body.
+func decodeError(err error, invalid error) error {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		return err
+	}
+
+	return &invalidBodyError{err: invalid}
+}
+
 func writeJSON(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
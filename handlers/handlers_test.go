@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"goapi-template/auth"
 	"goapi-template/db"
+	"goapi-template/models"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -19,10 +20,15 @@ import (
 type QuerierMock struct {
 	GetPeopleResult     []db.Person
 	GetPeopleError      error
+	GetPeopleFilter     db.Filter
 	GetPersonByIdResult db.Person
 	GetPersonByIdError  error
 	InsertPersonResult  db.Person
 	InsertPersonError   error
+	InsertPersonCalls   int
+	InsertPersonArg     db.InsertPersonParams
+	InsertPeopleError   error
+	InsertPeopleArgs    []db.InsertPeopleParams
 	UpdatePersonResult  int64
 	UpdatePersonError   error
 	DeletePersonResult  int64
@@ -35,14 +41,40 @@ func (m *QuerierMock) GetPeople(ctx context.Context) ([]db.Person, error) {
 	return m.GetPeopleResult, m.GetPeopleError
 }
 
+func (m *QuerierMock) GetPeopleFiltered(ctx context.Context, filter db.Filter) ([]db.Person, error) {
+	m.GetPeopleFilter = filter
+	return m.GetPeopleResult, m.GetPeopleError
+}
+
 func (m *QuerierMock) GetPersonById(ctx context.Context, id int32) (db.Person, error) {
 	return m.GetPersonByIdResult, m.GetPersonByIdError
 }
 
 func (m *QuerierMock) InsertPerson(ctx context.Context, arg db.InsertPersonParams) (db.Person, error) {
+	m.InsertPersonCalls++
+	m.InsertPersonArg = arg
 	return m.InsertPersonResult, m.InsertPersonError
 }
 
+// InsertPeople numbers the people from 1 across its calls.
+func (m *QuerierMock) InsertPeople(ctx context.Context, arg db.InsertPeopleParams) ([]db.Person, error) {
+	inserted := 0
+	for _, previous := range m.InsertPeopleArgs {
+		inserted += len(previous.Names)
+	}
+	m.InsertPeopleArgs = append(m.InsertPeopleArgs, arg)
+
+	if m.InsertPeopleError != nil {
+		return nil, m.InsertPeopleError
+	}
+
+	people := make([]db.Person, len(arg.Names))
+	for i := range people {
+		people[i] = db.Person{ID: int32(inserted + i + 1), Name: arg.Names[i], Email: arg.Emails[i]}
+	}
+	return people, nil
+}
+
 func (m *QuerierMock) UpdatePerson(ctx context.Context, arg db.UpdatePersonParams) (int64, error) {
 	return m.UpdatePersonResult, m.UpdatePersonError
 }
@@ -55,6 +87,28 @@ func (m *QuerierMock) PingDb(ctx context.Context) (int32, error) {
 	return m.PingDbResult, m.PingDbError
 }
 
+// TxRunnerMock runs units of work against Queries without a transaction.
+type TxRunnerMock struct {
+	Queries db.Querier
+}
+
+func (m *TxRunnerMock) RunInTx(ctx context.Context, opts db.TxOptions, fn func(ctx context.Context, q db.Querier) error) error {
+	return fn(ctx, m.Queries)
+}
+
+// retryingTxRunner runs units of work twice, as PoolTxRunner does after a
+// serialization failure.
+type retryingTxRunner struct {
+	Queries db.Querier
+}
+
+func (m *retryingTxRunner) RunInTx(ctx context.Context, opts db.TxOptions, fn func(ctx context.Context, q db.Querier) error) error {
+	if err := fn(ctx, m.Queries); err != nil {
+		return err
+	}
+	return fn(ctx, m.Queries)
+}
+
 func TestGetUser(t *testing.T) {
 
 	req, _ := http.NewRequest("GET", "/dummy", bytes.NewReader([]byte("")))
@@ -118,11 +172,17 @@ func makeRequest[K any | []any](router *http.ServeMux, method string, url string
 }
 
 func setup(querierMock *QuerierMock) *http.ServeMux {
+	return setupWithTx(querierMock, &TxRunnerMock{Queries: querierMock})
+}
+
+func setupWithTx(querierMock *QuerierMock, txRunner db.TxRunner) *http.ServeMux {
 	router := http.NewServeMux()
-	handlers := New(querierMock)
+	handlers := New(querierMock, txRunner)
+	router.Handle("GET /person", mockAuthMiddleware(http.HandlerFunc(handlers.GetPeople)))
 	router.Handle("GET /person/{id}", mockAuthMiddleware(http.HandlerFunc(handlers.GetPerson)))
 	router.Handle("PUT /person/{id}", mockAuthMiddleware(http.HandlerFunc(handlers.PutPerson)))
 	router.Handle("POST /person", mockAuthMiddleware(http.HandlerFunc(handlers.PostPerson)))
+	router.Handle("POST /person/batch", mockAuthMiddleware(http.HandlerFunc(handlers.PostPeople)))
 	router.Handle("DELETE /person/{id}", mockAuthMiddleware(http.HandlerFunc(handlers.DeletePerson)))
 	router.HandleFunc("GET /health", handlers.GetHealth)
 
@@ -135,7 +195,9 @@ func mockAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		user := &auth.User{ID: "Test", Name: "Test", Email: "mail@test.com"}
-		r = r.WithContext(context.WithValue(ctx, auth.UserKey, user))
+		ctx = context.WithValue(ctx, auth.UserKey, user)
+		ctx = context.WithValue(ctx, auth.FilterKey, db.Filter{Where: "update_user = $1", Args: []any{user.Email}})
+		r = r.WithContext(ctx)
 
 		next.ServeHTTP(w, r)
 	})
@@ -181,6 +243,19 @@ func TestErrorTranslationSuccess(t *testing.T) {
 	assert.Equal(t, "Alpha should contain alpha characters only", result.Errors[7])
 }
 
+func TestErrorTranslationItems(t *testing.T) {
+	type TestStruct struct {
+		Req string `validate:"required"`
+	}
+
+	validate := validator.New()
+	err := models.ValidationErrors{nil, validate.Struct(TestStruct{})}
+	code, result := errorToHttpResult(err, context.Background())
+
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{"[1]: Req is required"}, result.Errors)
+}
+
 func TestErrorTranslationServerError(t *testing.T) {
 	code, result := errorToHttpResult(fmt.Errorf("Something went wrong"), context.Background())
 	assert.Equal(t, http.StatusInternalServerError, code)
@@ -227,3 +302,48 @@ func TestTranslateErrorSuccess(t *testing.T) {
 	assert.Equal(t, "Max should have maximum length of 10", result[6])
 	assert.Equal(t, "Alpha should contain alpha characters only", result[7])
 }
+
+func TestBindJSONStrict(t *testing.T) {
+	handlers := Handlers{JSON: JSONOptions{DisallowUnknownFields: true, RejectTrailingData: true}}
+
+	bind := func(body string) error {
+		r := httptest.NewRequest("POST", "/person", bytes.NewReader([]byte(body)))
+		return handlers.bindJSON(r, &models.Person{})
+	}
+
+	assert.Nil(t, bind(`{"name":"Demo","email":"demo@company.com"} `))
+
+	code, result := errorToHttpResult(bind(`{"name":"Demo","email":"demo@company.com","admin":true}`), context.Background())
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{`Invalid request body: unknown field "admin"`}, result.Errors)
+
+	code, result = errorToHttpResult(bind(`{"name":"Demo","email":"demo@company.com"}{}`), context.Background())
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{"Invalid request body: unexpected data after the JSON value"}, result.Errors)
+
+	code, _ = errorToHttpResult(bind(`{"name":`), context.Background())
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// the default options keep accepting both
+	handlers.JSON = JSONOptions{}
+	assert.Nil(t, bind(`{"name":"Demo","email":"demo@company.com","admin":true}{}`))
+}
+
+func TestBindJSONTooLarge(t *testing.T) {
+	handlers := Handlers{}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/person", bytes.NewReader([]byte(`{"name":"Demo","email":"demo@company.com"}`)))
+	r.Body = http.MaxBytesReader(w, r.Body, 10)
+
+	code, result := errorToHttpResult(handlers.bindJSON(r, &models.Person{}), context.Background())
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, []string{"Request body is too large"}, result.Errors)
+}
+
+func TestErrorTranslationTimeout(t *testing.T) {
+	code, result := errorToHttpResult(fmt.Errorf("querying: %w", context.DeadlineExceeded), context.Background())
+
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []string{"Request timed out"}, result.Errors)
+}
@@ -5,11 +5,16 @@ import (
 	"net/http"
 )
 
+// cacheHealthChecker is implemented by queriers that cache their results
+type cacheHealthChecker interface {
+	CacheHealthy() error
+}
+
 // GetHealth godoc
 //
 //	@Summary	Determines if the app is healthy
 //	@Schemes
-//	@Description	Returns HTTP 200 if the app is healthy and 400 if not
+//	@Description	Returns HTTP 200 if the app is healthy and 400 if not. An unavailable cache only degrades the app since it is bypassed
 //	@Tags			health
 //	@Produce		json
 //	@Success		200	{object}	models.HealthResult
@@ -26,6 +31,17 @@ func (h Handlers) GetHealth(w http.ResponseWriter, r *http.Request) {
 	dbHealth := models.HealthResultItem{Name: "DB", Healthy: isDbHealthy}
 	result := &models.HealthResult{Healthy: isDbHealthy, Dependencies: []models.HealthResultItem{dbHealth}}
 
+	if checker, ok := h.Queries.(cacheHealthChecker); ok {
+		cacheHealth := models.HealthResultItem{Name: "Cache", Healthy: true}
+		if err := checker.CacheHealthy(); err != nil {
+			cacheHealth.Healthy = false
+			cacheHealth.Error = err.Error()
+			result.Degraded = true
+		}
+
+		result.Dependencies = append(result.Dependencies, cacheHealth)
+	}
+
 	status := http.StatusOK
 	if !isDbHealthy {
 		status = http.StatusInternalServerError
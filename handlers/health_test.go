@@ -32,3 +32,30 @@ func TestHealthBadDB(t *testing.T) {
 	assert.False(t, result.Healthy)
 	assert.False(t, result.Dependencies[0].Healthy)
 }
+
+type cachingQuerierMock struct {
+	*QuerierMock
+	CacheError error
+}
+
+func (m *cachingQuerierMock) CacheHealthy() error {
+	return m.CacheError
+}
+
+func TestHealthCacheDown(t *testing.T) {
+	router := http.NewServeMux()
+	router.HandleFunc("GET /health", New(&cachingQuerierMock{
+		QuerierMock: &QuerierMock{PingDbResult: 1},
+		CacheError:  errors.New("cache circuit breaker is open"),
+	}, nil).GetHealth)
+
+	code, result, _, err := makeRequest[models.HealthResult](router, "GET", "/health", nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, result.Healthy)
+	assert.True(t, result.Degraded)
+	assert.Equal(t, "Cache", result.Dependencies[1].Name)
+	assert.False(t, result.Dependencies[1].Healthy)
+	assert.Equal(t, "cache circuit breaker is open", result.Dependencies[1].Error)
+}
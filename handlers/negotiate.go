@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"goapi-template/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	mediaTypeJSON    = "application/json"
+	mediaTypeCSV     = "text/csv"
+	mediaTypeMsgPack = "application/msgpack"
+)
+
+// personMediaTypes are the formats of person resources, by order of
+// preference when the client accepts several equally.
+var personMediaTypes = []string{mediaTypeJSON, mediaTypeCSV, mediaTypeMsgPack}
+
+// mediaTypeAliases are the other names of the media types
+var mediaTypeAliases = map[string]string{
+	"application/x-msgpack":   mediaTypeMsgPack,
+	"application/vnd.msgpack": mediaTypeMsgPack,
+}
+
+// negotiate returns the media type of offers the Accept header of r prefers.
+// It answers 406 and returns false when none of them is acceptable.
+func negotiate(w http.ResponseWriter, r *http.Request, offers ...string) (string, bool) {
+	if mediaType := acceptedMediaType(r.Header.Get("Accept"), offers); mediaType != "" {
+		return mediaType, true
+	}
+
+	writeJSON(w, http.StatusNotAcceptable, &models.ErrorResult{Errors: []string{"Acceptable media types are " + strings.Join(offers, ", ")}})
+	return "", false
+}
+
+// acceptedMediaType returns the offer with the highest weight in accept,
+// weighted by its most specific media range. Ties go to the first offer.
+func acceptedMediaType(accept string, offers []string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+
+	best, bestWeight := "", 0.0
+	for _, offer := range offers {
+		weight, specificity := 0.0, -1
+
+		for _, item := range strings.Split(accept, ",") {
+			mediaRange, params, _ := strings.Cut(item, ";")
+			mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+			if alias, ok := mediaTypeAliases[mediaRange]; ok {
+				mediaRange = alias
+			}
+
+			rangeSpecificity := matchMediaRange(mediaRange, offer)
+			if rangeSpecificity <= specificity {
+				continue
+			}
+
+			specificity, weight = rangeSpecificity, 1
+			for _, param := range strings.Split(params, ";") {
+				if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+					if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+						weight = parsed
+					}
+				}
+			}
+		}
+
+		if weight > bestWeight {
+			best, bestWeight = offer, weight
+		}
+	}
+
+	return best
+}
+
+// matchMediaRange returns how specifically mediaRange matches mediaType: 2
+// for the media type itself, 1 for type/* and 0 for */*, -1 otherwise.
+func matchMediaRange(mediaRange string, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+
+	return -1
+}
+
+// writeNegotiated writes data as mediaType, a person or a list of people for
+// CSV.
+func writeNegotiated(w http.ResponseWriter, statusCode int, mediaType string, data any) {
+	switch mediaType {
+	case mediaTypeMsgPack:
+		var body bytes.Buffer
+		encoder := msgpack.NewEncoder(&body)
+		// the same field names as the JSON responses
+		encoder.SetCustomStructTag("json")
+		encoder.Encode(data)
+
+		w.Header().Set("Content-Type", mediaTypeMsgPack)
+		w.WriteHeader(statusCode)
+		w.Write(body.Bytes())
+	case mediaTypeCSV:
+		var people []models.Person
+		switch data := data.(type) {
+		case models.Person:
+			people = []models.Person{data}
+		case []models.Person:
+			people = data
+		default:
+			writeJSON(w, statusCode, data)
+			return
+		}
+
+		w.Header().Set("Content-Type", mediaTypeCSV+"; charset=utf-8")
+		w.WriteHeader(statusCode)
+		writer := csv.NewWriter(w)
+		writer.Write(personCSVHeader)
+		for _, person := range people {
+			writer.Write(personCSVRecord(person))
+		}
+		writer.Flush()
+	default:
+		writeJSON(w, statusCode, data)
+	}
+}
+
+var personCSVHeader = []string{"id", "name", "email", "created_at", "updated_at", "update_user"}
+
+func personCSVRecord(person models.Person) []string {
+	return []string{
+		strconv.Itoa(person.ID),
+		csvText(person.Name),
+		csvText(person.Email),
+		person.CreatedAt.Format(time.RFC3339Nano),
+		person.UpdatedAt.Format(time.RFC3339Nano),
+		csvText(person.UpdateUser),
+	}
+}
+
+// csvText quotes the text cells spreadsheets would run as formulas with a
+// leading ', see https://owasp.org/www-community/attacks/CSV_Injection.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+
+	return value
+}
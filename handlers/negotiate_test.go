@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"goapi-template/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestAcceptedMediaType(t *testing.T) {
+	tests := map[string]string{
+		"":                                    mediaTypeJSON,
+		"*/*":                                 mediaTypeJSON,
+		"text/*":                              mediaTypeCSV,
+		"text/csv, application/json;q=0.9":    mediaTypeCSV,
+		"application/*;q=0.5, text/csv;q=0.1": mediaTypeJSON,
+		"application/msgpack, */*;q=0.1":      mediaTypeMsgPack,
+		"application/x-msgpack":               mediaTypeMsgPack,
+		"*/*, application/json;q=0":           mediaTypeCSV,
+		"text/html":                           "",
+		"application/json;q=0":                "",
+	}
+
+	for accept, expected := range tests {
+		assert.Equal(t, expected, acceptedMediaType(accept, personMediaTypes), accept)
+	}
+}
+
+func TestGetPeopleCSV(t *testing.T) {
+	querier := &QuerierMock{
+		GetPeopleResult: []db.Person{
+			{ID: 1, Name: "Test", Email: "mail@company.com", UpdateUser: "mail@test.com"},
+			{ID: 2, Name: "Test, 2", Email: "mail2@company.com", UpdateUser: "mail@test.com"},
+		},
+	}
+	r := setup(querier)
+
+	req := httptest.NewRequest("GET", "/person", nil)
+	req.Header.Set("Accept", "text/csv")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "id,name,email,created_at,updated_at,update_user\n"+
+		"1,Test,mail@company.com,0001-01-01T00:00:00Z,0001-01-01T00:00:00Z,mail@test.com\n"+
+		"2,\"Test, 2\",mail2@company.com,0001-01-01T00:00:00Z,0001-01-01T00:00:00Z,mail@test.com\n", rr.Body.String())
+}
+
+func TestGetPeopleCSVFormulas(t *testing.T) {
+	querier := &QuerierMock{
+		GetPeopleResult: []db.Person{
+			{ID: 1, Name: "=HYPERLINK(\"http://evil\")", Email: "+1@company.com", UpdateUser: "@mail"},
+			{ID: 2, Name: "-2", Email: "a=b@company.com", UpdateUser: "\tmail"},
+		},
+	}
+	r := setup(querier)
+
+	req := httptest.NewRequest("GET", "/person", nil)
+	req.Header.Set("Accept", "text/csv")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "id,name,email,created_at,updated_at,update_user\n"+
+		"1,\"'=HYPERLINK(\"\"http://evil\"\")\",'+1@company.com,0001-01-01T00:00:00Z,0001-01-01T00:00:00Z,'@mail\n"+
+		"2,'-2,a=b@company.com,0001-01-01T00:00:00Z,0001-01-01T00:00:00Z,'\tmail\n", rr.Body.String())
+}
+
+func TestGetPersonMsgPack(t *testing.T) {
+	querier := &QuerierMock{
+		GetPersonByIdResult: db.Person{ID: 1, Name: "Test", Email: "mail@company.com"},
+	}
+	r := setup(querier)
+
+	req := httptest.NewRequest("GET", "/person/1", nil)
+	req.Header.Set("Accept", "application/msgpack")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	var result map[string]any
+	err := msgpack.Unmarshal(rr.Body.Bytes(), &result)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/msgpack", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "Test", result["name"])
+	assert.Equal(t, "mail@company.com", result["email"])
+}
+
+func TestGetPersonNotAcceptable(t *testing.T) {
+	querier := &QuerierMock{}
+	r := setup(querier)
+
+	req := httptest.NewRequest("GET", "/person/1", nil)
+	req.Header.Set("Accept", "text/html")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotAcceptable, rr.Code)
+	assert.JSONEq(t, `{"errors":["Acceptable media types are application/json, text/csv, application/msgpack"]}`, rr.Body.String())
+}
+
+func TestPostPersonNotAcceptable(t *testing.T) {
+	querier := &QuerierMock{}
+	r := setup(querier)
+
+	req := httptest.NewRequest("POST", "/person", nil)
+	req.Header.Set("Accept", "text/csv")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotAcceptable, rr.Code)
+}
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"goapi-template/db"
 	"goapi-template/models"
@@ -18,12 +21,18 @@ import (
 //	@Security		OAuth2Implicit
 //
 //	@Tags			person
-//	@Produce		json
+//	@Produce		json,text/csv,application/msgpack
 //	@Param			id				path		int	true	"Person ID"
 //	@Success		200				{object}	models.Person
 //	@Failure		400				{object}	models.ErrorResult
+//	@Failure		406				{object}	models.ErrorResult
 //	@Router			/person/{id}	[get]
 func (h Handlers) GetPerson(w http.ResponseWriter, r *http.Request) {
+	mediaType, ok := negotiate(w, r, personMediaTypes...)
+	if !ok {
+		return
+	}
+
 	id, err := strconv.ParseInt(getParam(r, "id"), 10, 32)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, &models.ErrorResult{Errors: []string{"ID is invalid"}})
@@ -37,16 +46,43 @@ func (h Handlers) GetPerson(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, status, body)
 		return
 	}
-	body := models.Person{
-		ID:         int(result.ID),
-		Name:       result.Name,
-		Email:      result.Email,
-		CreatedAt:  result.CreatedAt.Time,
-		UpdatedAt:  result.UpdatedAt.Time,
-		UpdateUser: result.UpdateUser,
+
+	writeNegotiated(w, http.StatusOK, mediaType, toPersonModel(result))
+}
+
+// GetPeople godoc
+//
+//	@Summary		Retrieves the people the caller is allowed to see
+//	@Description	get people filtered by the authorization policy
+//
+//	@Security		OAuth2Implicit
+//
+//	@Tags			person
+//	@Produce		json,text/csv,application/msgpack
+//	@Success		200		{array}		models.Person
+//	@Failure		406		{object}	models.ErrorResult
+//	@Failure		500		{object}	models.ErrorResult
+//	@Router			/person	[get]
+func (h Handlers) GetPeople(w http.ResponseWriter, r *http.Request) {
+	mediaType, ok := negotiate(w, r, personMediaTypes...)
+	if !ok {
+		return
+	}
+
+	result, err := h.Queries.GetPeopleFiltered(r.Context(), getFilter(r.Context()))
+
+	if err != nil {
+		status, body := errorToHttpResult(err, r.Context())
+		writeJSON(w, status, body)
+		return
+	}
+
+	body := make([]models.Person, len(result))
+	for i, person := range result {
+		body[i] = toPersonModel(person)
 	}
 
-	writeJSON(w, http.StatusOK, body)
+	writeNegotiated(w, http.StatusOK, mediaType, body)
 }
 
 // AddAccount godoc
@@ -62,25 +98,28 @@ func (h Handlers) GetPerson(w http.ResponseWriter, r *http.Request) {
 //	@Param			person	body		models.Person	true	"Add person"
 //	@Success		202		{object}	models.Person
 //	@Failure		400		{object}	[]string
+//	@Failure		406		{object}	models.ErrorResult
+//	@Failure		409		{object}	models.ErrorResult
+//	@Failure		413		{object}	models.ErrorResult
+//	@Failure		415		{object}	models.ErrorResult
 //	@Router			/person [post]
 func (h Handlers) PostPerson(w http.ResponseWriter, r *http.Request) {
+	if _, ok := negotiate(w, r, mediaTypeJSON); !ok {
+		return
+	}
+
 	body := &models.Person{}
-	if err := bindJSON(r, body); err != nil {
+	if err := h.bindJSON(r, body); err != nil {
 		status, body := errorToHttpResult(err, r.Context())
 		writeJSON(w, status, body)
 		return
 	}
 
 	body.ID = 0 // ensure we leverage auto increment
+	body.Email = h.Emails.Normalize(body.Email)
 	body.UpdateUser = getUserEmail(r.Context())
 
-	result, err := h.Queries.InsertPerson(r.Context(), db.InsertPersonParams{
-		Name:       body.Name,
-		Email:      body.Email,
-		CreatedAt:  pgtype.Timestamp{Time: body.CreatedAt, Valid: true},
-		UpdatedAt:  pgtype.Timestamp{Time: body.UpdatedAt, Valid: true},
-		UpdateUser: body.UpdateUser,
-	})
+	result, err := h.Queries.InsertPerson(r.Context(), toInsertPersonParams(body))
 
 	if err != nil {
 		status, body := errorToHttpResult(err, r.Context())
@@ -91,6 +130,99 @@ func (h Handlers) PostPerson(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusAccepted, &models.IdResult{ID: int(result.ID)})
 }
 
+// peopleChunkSize is how many people of a batch are inserted per query.
+const peopleChunkSize = 1000
+
+// errDuplicateEmail is returned for the people of a batch whose email is
+// already used by an earlier person of the batch.
+var errDuplicateEmail = errors.New("duplicate email")
+
+// PostPeople godoc
+//
+//	@Summary		Add people
+//	@Description	add by json people, either all of them are added or none. The body may be sent compressed with gzip or zstd
+//
+//	@Security		OAuth2Implicit
+//
+//	@Tags			person
+//	@Accept			json
+//	@Produce		json
+//	@Param			people	body		[]models.Person	true	"Add people"
+//	@Success		202		{array}		models.IdResult
+//	@Failure		400		{object}	models.ErrorResult
+//	@Failure		406		{object}	models.ErrorResult
+//	@Failure		409		{object}	models.ErrorResult
+//	@Failure		413		{object}	models.ErrorResult
+//	@Failure		415		{object}	models.ErrorResult
+//	@Router			/person/batch [post]
+func (h Handlers) PostPeople(w http.ResponseWriter, r *http.Request) {
+	if _, ok := negotiate(w, r, mediaTypeJSON); !ok {
+		return
+	}
+
+	updateUser := getUserEmail(r.Context())
+
+	// the body is read and validated before the transaction starts, so slow
+	// clients don't hold a connection of the pool
+	people, err := newSpool[models.Person]()
+	if err != nil {
+		status, body := errorToHttpResult(err, r.Context())
+		writeJSON(w, status, body)
+		return
+	}
+	defer people.Close()
+
+	emails := map[string]struct{}{}
+	_, err = decodeJSONArray(h, r, func(i int, person *models.Person) error {
+		person.Email = h.Emails.Normalize(person.Email)
+		person.UpdateUser = updateUser
+
+		email := strings.ToLower(person.Email)
+		if _, ok := emails[email]; ok {
+			return errDuplicateEmail
+		}
+		emails[email] = struct{}{}
+
+		return people.add(person)
+	})
+
+	if err != nil {
+		status, body := errorToHttpResult(err, r.Context())
+		writeJSON(w, status, body)
+		return
+	}
+
+	if people.count == 0 {
+		writeJSON(w, http.StatusBadRequest, &models.ErrorResult{Errors: []string{"At least one person is required"}})
+		return
+	}
+
+	var ids []models.IdResult
+	err = h.Tx.RunInTx(r.Context(), db.TxOptions{}, func(ctx context.Context, q db.Querier) error {
+		ids = make([]models.IdResult, 0, people.count)
+
+		return people.chunks(peopleChunkSize, func(chunk []models.Person) error {
+			result, err := q.InsertPeople(ctx, toInsertPeopleParams(chunk))
+			if err != nil {
+				return err
+			}
+
+			for _, person := range result {
+				ids = append(ids, models.IdResult{ID: int(person.ID)})
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		status, body := errorToHttpResult(err, r.Context())
+		writeJSON(w, status, body)
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, ids)
+}
+
 // PutPerson godoc
 //
 //	@Summary		Update person
@@ -105,6 +237,9 @@ func (h Handlers) PostPerson(w http.ResponseWriter, r *http.Request) {
 //	@Param			person	body		models.Person	true	"Update person"
 //	@Success		202		{object}	models.Person
 //	@Failure		400		{object}	models.ErrorResult
+//	@Failure		409		{object}	models.ErrorResult
+//	@Failure		413		{object}	models.ErrorResult
+//	@Failure		415		{object}	models.ErrorResult
 //	@Router			/person/{id} [put]
 func (h Handlers) PutPerson(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.ParseInt(getParam(r, "id"), 10, 32)
@@ -113,12 +248,13 @@ func (h Handlers) PutPerson(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	body := &models.Person{}
-	if err := bindJSON(r, body); err != nil {
+	if err := h.bindJSON(r, body); err != nil {
 		status, err := errorToHttpResult(err, r.Context())
 		writeJSON(w, status, err)
 		return
 	}
 
+	body.Email = h.Emails.Normalize(body.Email)
 	body.UpdateUser = getUserEmail(r.Context())
 
 	recordsUpdated, err := h.Queries.UpdatePerson(r.Context(), db.UpdatePersonParams{
@@ -178,3 +314,44 @@ func (h Handlers) DeletePerson(w http.ResponseWriter, r *http.Request) {
 
 	writeStatus(w, http.StatusAccepted)
 }
+
+func toInsertPersonParams(person *models.Person) db.InsertPersonParams {
+	return db.InsertPersonParams{
+		Name:       person.Name,
+		Email:      person.Email,
+		CreatedAt:  pgtype.Timestamp{Time: person.CreatedAt, Valid: true},
+		UpdatedAt:  pgtype.Timestamp{Time: person.UpdatedAt, Valid: true},
+		UpdateUser: person.UpdateUser,
+	}
+}
+
+func toInsertPeopleParams(people []models.Person) db.InsertPeopleParams {
+	arg := db.InsertPeopleParams{
+		Names:       make([]string, len(people)),
+		Emails:      make([]string, len(people)),
+		CreatedAts:  make([]pgtype.Timestamp, len(people)),
+		UpdatedAts:  make([]pgtype.Timestamp, len(people)),
+		UpdateUsers: make([]string, len(people)),
+	}
+
+	for i, person := range people {
+		arg.Names[i] = person.Name
+		arg.Emails[i] = person.Email
+		arg.CreatedAts[i] = pgtype.Timestamp{Time: person.CreatedAt, Valid: true}
+		arg.UpdatedAts[i] = pgtype.Timestamp{Time: person.UpdatedAt, Valid: true}
+		arg.UpdateUsers[i] = person.UpdateUser
+	}
+
+	return arg
+}
+
+func toPersonModel(person db.Person) models.Person {
+	return models.Person{
+		ID:         int(person.ID),
+		Name:       person.Name,
+		Email:      person.Email,
+		CreatedAt:  person.CreatedAt.Time,
+		UpdatedAt:  person.UpdatedAt.Time,
+		UpdateUser: person.UpdateUser,
+	}
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
 	"goapi-template/db"
 	"goapi-template/models"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -73,6 +76,200 @@ func TestPostPersonDuplicate(t *testing.T) {
 	assert.Equal(t, "Record duplication detected", result.Errors[0])
 }
 
+func TestPostPersonEmailConflict(t *testing.T) {
+	db := &QuerierMock{
+		InsertPersonError: &pgconn.PgError{Code: "23505", ConstraintName: "person_tenant_id_lower_email_key"},
+	}
+	r := setup(db)
+
+	person := models.Person{
+		Name:  "Test",
+		Email: "test@test.com",
+	}
+
+	code, result, _, err := makeRequest[models.ErrorResult](r, "POST", "/person", person)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "Email is already in use", result.Errors[0])
+}
+
+func TestPostPersonNormalizesEmail(t *testing.T) {
+	db := &QuerierMock{InsertPersonResult: db.Person{ID: 1}}
+	r := setup(db)
+
+	person := models.Person{
+		Name:  "Test",
+		Email: " Test@Company.COM ",
+	}
+
+	code, _, _, err := makeRequest[models.IdResult](r, "POST", "/person", person)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, "Test@company.com", db.InsertPersonArg.Email)
+}
+
+func TestPostPeopleSuccess(t *testing.T) {
+	db := &QuerierMock{}
+	r := setup(db)
+
+	people := []models.Person{
+		{Name: "Demo Company", Email: "demo@company.com"},
+		{Name: "Other Company", Email: "Other@Company.COM"},
+	}
+
+	code, body, _, err := makeRequest[[]models.IdResult](r, "POST", "/person/batch", people)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, []models.IdResult{{ID: 1}, {ID: 2}}, *body)
+	assert.Len(t, db.InsertPeopleArgs, 1)
+	assert.Equal(t, []string{"demo@company.com", "Other@company.com"}, db.InsertPeopleArgs[0].Emails)
+	assert.Equal(t, []string{"Demo Company", "Other Company"}, db.InsertPeopleArgs[0].Names)
+}
+
+func TestPostPeopleChunks(t *testing.T) {
+	db := &QuerierMock{}
+	r := setup(db)
+
+	people := make([]models.Person, peopleChunkSize*2+1)
+	for i := range people {
+		people[i] = models.Person{Name: "Demo Company", Email: fmt.Sprintf("demo%d@company.com", i)}
+	}
+
+	code, body, _, err := makeRequest[[]models.IdResult](r, "POST", "/person/batch", people)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Len(t, *body, len(people))
+	assert.Equal(t, models.IdResult{ID: len(people)}, (*body)[len(people)-1])
+	assert.Len(t, db.InsertPeopleArgs, 3)
+	assert.Len(t, db.InsertPeopleArgs[2].Names, 1)
+	assert.Equal(t, "demo2000@company.com", db.InsertPeopleArgs[2].Emails[0])
+}
+
+func TestPostPeopleRetried(t *testing.T) {
+	querier := &QuerierMock{}
+	r := setupWithTx(querier, &retryingTxRunner{Queries: querier})
+
+	people := []models.Person{
+		{Name: "Demo Company", Email: "demo@company.com"},
+		{Name: "Other Company", Email: "other@company.com"},
+	}
+
+	code, body, _, err := makeRequest[[]models.IdResult](r, "POST", "/person/batch", people)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusAccepted, code)
+	// the spooled people are read again by the retry, whose ids are returned
+	assert.Equal(t, []models.IdResult{{ID: 3}, {ID: 4}}, *body)
+	assert.Len(t, querier.InsertPeopleArgs, 2)
+	assert.Equal(t, querier.InsertPeopleArgs[0], querier.InsertPeopleArgs[1])
+}
+
+func TestPostPeopleValidation(t *testing.T) {
+	db := &QuerierMock{}
+	r := setup(db)
+
+	people := []models.Person{
+		{Name: "Demo Company", Email: "demo@company.com"},
+		{Name: "", Email: "other@company.com"},
+	}
+
+	code, result, _, err := makeRequest[models.ErrorResult](r, "POST", "/person/batch", people)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{"[1]: Name is required"}, result.Errors)
+	// nothing is inserted before the whole batch is valid
+	assert.Len(t, db.InsertPeopleArgs, 0)
+}
+
+func TestPostPeopleEmpty(t *testing.T) {
+	r := setup(&QuerierMock{})
+
+	code, result, _, err := makeRequest[models.ErrorResult](r, "POST", "/person/batch", []models.Person{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "At least one person is required", result.Errors[0])
+}
+
+func TestPostPeopleDuplicate(t *testing.T) {
+	db := &QuerierMock{}
+	r := setup(db)
+
+	people := []models.Person{
+		{Name: "Demo Company", Email: "demo@company.com"},
+		{Name: "Other Company", Email: "other@company.com"},
+		{Name: "Demo Company", Email: "Demo@Company.com"},
+	}
+
+	code, result, _, err := makeRequest[models.ErrorResult](r, "POST", "/person/batch", people)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, []string{"[2]: Email is already in use"}, result.Errors)
+	assert.Len(t, db.InsertPeopleArgs, 0)
+}
+
+func TestPostPeopleEmailInUse(t *testing.T) {
+	db := &QuerierMock{InsertPeopleError: &pgconn.PgError{Code: "23505", ConstraintName: "person_tenant_id_lower_email_key"}}
+	r := setup(db)
+
+	people := []models.Person{
+		{Name: "Demo Company", Email: "demo@company.com"},
+	}
+
+	code, result, _, err := makeRequest[models.ErrorResult](r, "POST", "/person/batch", people)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, []string{"Email is already in use"}, result.Errors)
+}
+
+func TestPostPeopleValidationOfEveryItem(t *testing.T) {
+	db := &QuerierMock{}
+	r := setup(db)
+
+	people := []models.Person{
+		{Name: "", Email: "demo@company.com"},
+		{Name: "Demo Company", Email: "other@company.com"},
+		{Name: "Demo Company", Email: ""},
+	}
+
+	code, result, _, err := makeRequest[models.ErrorResult](r, "POST", "/person/batch", people)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, []string{"[0]: Name is required", "[2]: Email is required"}, result.Errors)
+	assert.Len(t, db.InsertPeopleArgs, 0)
+}
+
+func TestPostPeopleInvalidItem(t *testing.T) {
+	db := &QuerierMock{}
+	r := setup(db)
+
+	req := httptest.NewRequest("POST", "/person/batch", strings.NewReader(`[{"name":"Demo Company","email":"demo@company.com"},{"name":1}]`))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"errors":["[1]: Invalid request body: cannot unmarshal number into Go struct field Person.name of type string"]}`, rr.Body.String())
+}
+
+func TestPostPeopleNotAnArray(t *testing.T) {
+	r := setup(&QuerierMock{})
+
+	req := httptest.NewRequest("POST", "/person/batch", strings.NewReader(`{"name":"Demo Company"}`))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.JSONEq(t, `{"errors":["Invalid request body: expected a JSON array"]}`, rr.Body.String())
+}
+
 func TestPutPersonSuccess(t *testing.T) {
 	db := &QuerierMock{
 		UpdatePersonResult: 1,
@@ -181,6 +378,53 @@ func TestGetPersonSuccess(t *testing.T) {
 	assert.Equal(t, person.Email, result.Email)
 }
 
+func TestGetPeopleSuccess(t *testing.T) {
+	querier := &QuerierMock{
+		GetPeopleResult: []db.Person{
+			{ID: 1, Name: "Test", Email: "mail@company.com", UpdateUser: "mail@test.com"},
+			{ID: 2, Name: "Test 2", Email: "mail2@company.com", UpdateUser: "mail@test.com"},
+		},
+	}
+	r := setup(querier)
+
+	code, result, _, err := makeRequest[[]models.Person](r, "GET", "/person", nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, *result, 2)
+	assert.Equal(t, 2, (*result)[1].ID)
+	assert.Equal(t, "update_user = $1", querier.GetPeopleFilter.Where)
+	assert.Equal(t, []any{"mail@test.com"}, querier.GetPeopleFilter.Args)
+}
+
+func TestGetPeopleEmpty(t *testing.T) {
+	querier := &QuerierMock{}
+	r := setup(querier)
+
+	code, result, _, err := makeRequest[[]models.Person](r, "GET", "/person", nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, *result)
+}
+
+func TestGetPeopleDbError(t *testing.T) {
+	querier := &QuerierMock{
+		GetPeopleError: fmt.Errorf("db error"),
+	}
+	r := setup(querier)
+
+	code, result, _, err := makeRequest[models.ErrorResult](r, "GET", "/person", nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, "Unknown error", result.Errors[0])
+}
+
+func TestGetFilterDefaultsToDenyAll(t *testing.T) {
+	assert.Equal(t, db.DenyAllFilter, getFilter(context.Background()))
+}
+
 func TestGetPersonNotFound(t *testing.T) {
 	db := &QuerierMock{
 		GetPersonByIdError: pgx.ErrNoRows,
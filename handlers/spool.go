@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+)
+
+// spool keeps the items of a request body in a temporary file, so they are
+// read and validated before a transaction starts, and read again in chunks
+// by the transaction, as many times as it is retried.
+type spool[T any] struct {
+	file   *os.File
+	writer *bufio.Writer
+	count  int
+}
+
+func newSpool[T any]() (*spool[T], error) {
+	file, err := os.CreateTemp("", "goapi-spool-*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+
+	return &spool[T]{file: file, writer: bufio.NewWriter(file)}, nil
+}
+
+func (s *spool[T]) add(item *T) error {
+	s.count++
+	return json.NewEncoder(s.writer).Encode(item)
+}
+
+// chunks calls fn with the items from the first one, size at a time. The
+// chunk is reused between the calls.
+func (s *spool[T]) chunks(size int, fn func(chunk []T) error) error {
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(s.file))
+	chunk := make([]T, 0, min(size, s.count))
+
+	for {
+		var item T
+		err := decoder.Decode(&item)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		chunk = append(chunk, item)
+		if len(chunk) == size {
+			if err := fn(chunk); err != nil {
+				return err
+			}
+			chunk = chunk[:0]
+		}
+	}
+
+	if len(chunk) > 0 {
+		return fn(chunk)
+	}
+
+	return nil
+}
+
+// Close removes the file.
+func (s *spool[T]) Close() error {
+	return errors.Join(s.file.Close(), os.Remove(s.file.Name()))
+}
@@ -3,14 +3,22 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
+	"strings"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	httpSwagger "github.com/swaggo/http-swagger/v2"
 
+	"goapi-template/admin"
 	"goapi-template/auth"
 	"goapi-template/cache"
 	"goapi-template/config"
@@ -22,13 +30,92 @@ import (
 
 var configValues *config.Configuration
 
+// version is set when building, e.g. -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
+// appCache is nil unless caching is enabled, the admin server flushes it
+var appCache cache.Cacher
+
+// responseCache is nil unless ENABLE_RESPONSE_CACHE is set
+var responseCache *middlewares.ResponseCache
+
+// rateLimit and ipRateLimit are nil unless RATE_LIMIT_ENABLED is set
+var rateLimit *middlewares.RateLimit
+var ipRateLimit *middlewares.RateLimit
+
+// bodyLimit, decompress and requestTimeout are set up along with the router
+var bodyLimit *middlewares.BodyLimit
+var decompress *middlewares.Decompress
+var requestTimeout *middlewares.Timeout
+
 func withMiddlewares(handler func(w http.ResponseWriter, r *http.Request)) http.Handler {
 	return middlewares.TraceMiddleware(
 		middlewares.LogMiddleware(
-			configValues.WebServerConfig.Cors.Handler(
-				auth.TokenAuthMiddleware(
-					auth.OpaMiddleware(
-						http.HandlerFunc(handler))))))
+			requestTimeout.Middleware(
+				withIPRateLimit(
+					bodyLimit.Middleware(
+						decompress.Middleware(
+							auth.TokenAuthMiddleware(
+								withRateLimit(
+									auth.OpaMiddleware(
+										withResponseCache(
+											withStickyPrimary(
+												middlewares.RequestTxMiddleware(http.HandlerFunc(handler)))))))))))))
+}
+
+// withRateLimit runs after authentication so callers can be limited by user.
+func withRateLimit(handler http.Handler) http.Handler {
+	if rateLimit == nil {
+		return handler
+	}
+
+	return rateLimit.Middleware(handler)
+}
+
+// withIPRateLimit runs before authentication so the requests failing it are
+// limited as well.
+func withIPRateLimit(handler http.Handler) http.Handler {
+	if ipRateLimit == nil {
+		return handler
+	}
+
+	return ipRateLimit.Middleware(handler)
+}
+
+// identity is who the request is made by, "" for anonymous callers.
+func identity(r *http.Request) string {
+	if user, ok := r.Context().Value(auth.UserKey).(*auth.User); ok {
+		return user.TenantID + "|" + user.ID
+	}
+	return ""
+}
+
+// withStickyPrimary lets callers read their own writes when reads go to
+// replicas.
+func withStickyPrimary(handler http.Handler) http.Handler {
+	if len(configValues.DatabaseConfig.ReplicaConnectionStrings) == 0 {
+		return handler
+	}
+
+	databaseConfig := configValues.DatabaseConfig
+	return middlewares.NewStickyPrimary(databaseConfig.StickyPrimaryWindow, []byte(databaseConfig.StickyPrimaryKey)).Middleware(handler)
+}
+
+// withResponseCache serves GETs from the response cache and lets writes
+// invalidate it, it runs after authorization so every request is still
+// authorized.
+func withResponseCache(handler http.Handler) http.Handler {
+	if responseCache == nil {
+		return handler
+	}
+
+	return responseCache.InvalidateMiddleware(responseCache.Middleware(handler))
+}
+
+func withFilterMiddlewares(handler func(w http.ResponseWriter, r *http.Request)) http.Handler {
+	return withMiddlewares(func(w http.ResponseWriter, r *http.Request) {
+		auth.OpaFilterMiddleware(http.HandlerFunc(handler)).ServeHTTP(w, r)
+	})
 }
 
 func onlyLogMiddleware(handler func(w http.ResponseWriter, r *http.Request)) http.Handler {
@@ -37,17 +124,36 @@ func onlyLogMiddleware(handler func(w http.ResponseWriter, r *http.Request)) htt
 			http.HandlerFunc(handler)))
 }
 
-func setupRouter(db db.Querier) http.Handler {
+func setupRouter(db db.Querier, txRunner db.TxRunner) http.Handler {
 	slog.Info("Starting API... \n")
 
-	controllers := handlers.New(db)
+	webServerConfig := configValues.WebServerConfig
+
+	controllers := handlers.New(db, txRunner)
+	controllers.Emails.ProviderRules = webServerConfig.EmailProviderRules
+	controllers.JSON.DisallowUnknownFields = webServerConfig.JSONDisallowUnknownFields
+	controllers.JSON.RejectTrailingData = webServerConfig.JSONRejectTrailingData
 	router := http.NewServeMux()
 
-	router.HandleFunc("OPTIONS /", configValues.WebServerConfig.Cors.HandlerFunc)
+	bodyLimit = middlewares.NewBodyLimit(int64(webServerConfig.MaxBodyBytes))
+	bodyLimit.Routes = webServerConfig.RouteMaxBodyBytes
+	// the body limit applies to the bodies as received, before decompression
+	decompress = middlewares.NewDecompress(webServerConfig.RequestDecompressionEncodings,
+		int64(webServerConfig.MaxDecompressedBodyBytes), int64(webServerConfig.MaxDecompressionRatio))
+	decompress.Routes = webServerConfig.RouteMaxDecompressedBodyBytes
+	requestTimeout = middlewares.NewTimeout(webServerConfig.RequestTimeout)
+	requestTimeout.Routes = webServerConfig.RouteRequestTimeouts
+
 	router.Handle("GET /health", onlyLogMiddleware(controllers.GetHealth))
+	// the admin server serves the metrics when it is enabled
+	if configValues.AdminConfig.Address == "" {
+		router.Handle("GET /metrics", promhttp.Handler())
+	}
 
+	router.Handle("GET /person", withFilterMiddlewares(controllers.GetPeople))
 	router.Handle("GET /person/{id}", withMiddlewares(controllers.GetPerson))
 	router.Handle("POST /person", withMiddlewares(controllers.PostPerson))
+	router.Handle("POST /person/batch", withMiddlewares(controllers.PostPeople))
 	router.Handle("PUT /person/{id}", withMiddlewares(controllers.PutPerson))
 	router.Handle("DELETE /person/{id}", withMiddlewares(controllers.DeletePerson))
 
@@ -62,54 +168,339 @@ func setupRouter(db db.Querier) http.Handler {
 		router.Handle("GET /swagger/", swaggerHandler)
 	}
 
-	return router
+	// CORS wraps the whole router to answer the preflights of every route
+	handler := middlewares.NewCors(router, webServerConfig.CorsOptions(), webServerConfig.CorsRouteOrigins).Handler(router)
+	if webServerConfig.CompressionEnabled {
+		handler = middlewares.NewCompress(webServerConfig.CompressionEncodings, webServerConfig.CompressionMinSize).Middleware(handler)
+	}
+
+	return handler
+}
+
+// rotateCredentials reconnects pool with connString when the secret it
+// references is rotated.
+func rotateCredentials(pool *pgxpool.Pool, credentials *db.Credentials, connString string) {
+	if err := db.RotateCredentials(pool, credentials, connString); err != nil {
+		slog.Error("Error rotating database credentials", "error", err)
+	}
+}
+
+// checkRole stops the API when pool connects with a role row level security
+// doesn't apply to, unless DB_ALLOW_RLS_BYPASS is set. Databases that can't
+// be reached yet are left to the health checks.
+func checkRole(ctx context.Context, pool *pgxpool.Pool, databaseConfig *config.DatabaseConfiguration) {
+	err := db.CheckRole(ctx, pool)
+	switch {
+	case err == nil:
+	case errors.Is(err, db.ErrRLSBypassed) && databaseConfig.AllowRLSBypass:
+		slog.Error("Tenants are NOT isolated from each other, DB_ALLOW_RLS_BYPASS must only be set for local databases", "error", err)
+	case errors.Is(err, db.ErrRLSBypassed):
+		log.Fatal(err)
+	default:
+		slog.Error("Error checking the database role", "error", err)
+	}
 }
 
-func initDB(ctx context.Context, configValues *config.Configuration) (db.Querier, func()) {
-	if err := db.Init(configValues.WebServerConfig.ConnectionString); err != nil {
+func newPool(ctx context.Context, connString string, databaseConfig *config.DatabaseConfiguration, tracer pgx.QueryTracer) (*pgxpool.Pool, *db.Credentials) {
+	credentials, err := db.NewCredentials(connString)
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	conn, err := pgxpool.New(ctx, configValues.WebServerConfig.ConnectionString)
+	pool, err := db.NewPool(ctx, credentials, databaseConfig, tracer)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	queries := db.New(conn)
+	return pool, credentials
+}
+
+func initDB(ctx context.Context, configValues *config.Configuration, secrets *config.SecretWatcher) (db.Querier, *pgxpool.Pool, func()) {
+	if configValues.DatabaseConfig.AutoMigrate {
+		migrateCtx, cancel := context.WithTimeout(ctx, configValues.DatabaseConfig.MigrationLockTimeout)
+		err := db.Init(migrateCtx, configValues.WebServerConfig.ConnectionString)
+		cancel()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	databaseConfig := configValues.DatabaseConfig
 
-	return queries, conn.Close
+	var tracer pgx.QueryTracer
+	if databaseConfig.SlowQueryThreshold > 0 {
+		tracer = db.NewSlowQueryTracer(databaseConfig.SlowQueryThreshold, func(ctx context.Context) any {
+			return ctx.Value(middlewares.ContextKey("traceId"))
+		})
+	}
+
+	conn, credentials := newPool(ctx, configValues.WebServerConfig.ConnectionString, databaseConfig, tracer)
+	checkRole(ctx, conn, databaseConfig)
+	secrets.OnChange("DB_CONNECTION_STRING", func(connString string) {
+		rotateCredentials(conn, credentials, connString)
+	})
+
+	queries := db.New(db.WithStatementTimeout(db.WithTenantIsolation(conn), databaseConfig.StatementTimeout))
+
+	if len(databaseConfig.ReplicaConnectionStrings) == 0 {
+		return queries, conn, conn.Close
+	}
+
+	pools := []*pgxpool.Pool{conn}
+	replicaCredentials := make([]*db.Credentials, len(databaseConfig.ReplicaConnectionStrings))
+	replicas := make([]*db.Replica, len(databaseConfig.ReplicaConnectionStrings))
+	for i, connectionString := range databaseConfig.ReplicaConnectionStrings {
+		replicaConn, credentials := newPool(ctx, connectionString, databaseConfig, tracer)
+		checkRole(ctx, replicaConn, databaseConfig)
+		pools = append(pools, replicaConn)
+		replicaCredentials[i] = credentials
+
+		connConfig := replicaConn.Config().ConnConfig
+		replicaName := fmt.Sprintf("%s:%d", connConfig.Host, connConfig.Port)
+		replicas[i] = db.NewReplica(replicaName, db.WithStatementTimeout(db.WithTenantIsolation(replicaConn), databaseConfig.StatementTimeout))
+	}
+
+	// the replicas are listed in the same order in the rotated secret
+	secrets.OnChange("DB_REPLICA_CONNECTION_STRINGS", func(value string) {
+		var connectionStrings []string
+		for _, connectionString := range strings.Split(value, ",") {
+			if connectionString = strings.TrimSpace(connectionString); connectionString != "" {
+				connectionStrings = append(connectionStrings, connectionString)
+			}
+		}
+		if len(connectionStrings) != len(replicaCredentials) {
+			slog.Error("Cannot rotate replica credentials, the number of replicas changed", "replicas", len(replicaCredentials))
+			return
+		}
+
+		for i, connectionString := range connectionStrings {
+			rotateCredentials(pools[i+1], replicaCredentials[i], connectionString)
+		}
+	})
+
+	routingQuerier := db.NewRoutingQuerier(queries, replicas, databaseConfig.ReplicaMaxLag, databaseConfig.StickyPrimaryWindow)
+	routingQuerier.CheckReplicas(ctx)
+	stopChecks := routingQuerier.StartReplicaChecks(databaseConfig.ReplicaCheckInterval)
+
+	slog.Info("Routing reads to replicas", "replicas", len(replicas))
+
+	return routingQuerier, conn, func() {
+		stopChecks()
+		for _, pool := range pools {
+			pool.Close()
+		}
+	}
 }
 
-func initCache(querier db.Querier, configValues *config.CacheConfiguration) (db.Querier, func()) {
+// initTx creates the transaction runner, transactions go through querier's
+// decorator so the caching querier only touches the cache once they
+// committed.
+func initTx(pool *pgxpool.Pool, querier db.Querier, configValues *config.DatabaseConfiguration) db.TxRunner {
+	decorator, _ := querier.(db.TxDecorator)
+
+	txRunner := db.NewTxRunner(pool, decorator)
+	txRunner.IsoLevel = db.ParseIsoLevel(configValues.TxIsolationLevel)
+	txRunner.MaxRetries = configValues.TxMaxRetries
+	txRunner.StickyWindow = configValues.StickyPrimaryWindow
+	txRunner.StatementTimeout = configValues.StatementTimeout
+
+	return txRunner
+}
+
+// reconnectOnRotation reconnects to Redis when its passwords are rotated.
+func reconnectOnRotation(reconnector cache.Reconnector, configValues *config.CacheConfiguration, secrets *config.SecretWatcher) {
+	current := *configValues
+
+	reconnect := func() {
+		if err := reconnector.Reconnect(&current); err != nil {
+			slog.Error("Error reconnecting to redis", "error", err)
+		}
+	}
+
+	secrets.OnChange("REDIS_PASSWORD", func(password string) {
+		current.RedisPassword = password
+		reconnect()
+	})
+	secrets.OnChange("REDIS_SENTINEL_PASSWORD", func(password string) {
+		current.RedisSentinelPassword = password
+		reconnect()
+	})
+}
+
+// initCache returns the RateLimiter of the cache as well, nil when the cache
+// is disabled or isn't shared.
+func initCache(querier db.Querier, configValues *config.CacheConfiguration, secrets *config.SecretWatcher) (db.Querier, cache.RateLimiter, func()) {
+	if !configValues.EnableTransparentCaching && !configValues.EnableResponseCache {
+		return querier, nil, func() {}
+	}
+
+	cacher, cacheDispose, err := cache.NewCacher(configValues)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	serializer, err := cache.NewSerializer(configValues.Codec, configValues.Compression, configValues.CompressionThreshold)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache.SetSerializer(serializer)
+
+	_, canLock := cacher.(cache.Locker)
+
+	if reconnector, ok := cacher.(cache.Reconnector); ok {
+		reconnectOnRotation(reconnector, configValues, secrets)
+	}
+
+	rateLimiter, canRateLimit := cacher.(cache.RateLimiter)
+
+	if configValues.BreakerFailureThreshold > 0 {
+		cacher = cache.NewBreakerCacher(cacher, configValues.BreakerFailureThreshold, configValues.BreakerSlowThreshold, configValues.BreakerOpenTimeout)
+		if canRateLimit {
+			rateLimiter = cacher.(cache.RateLimiter)
+		}
+	}
+
+	appCache = cacher
+
+	if configValues.EnableResponseCache {
+		responseCache = middlewares.NewResponseCache(cacher, configValues.ResponseMaxAge, configValues.ResponseVary)
+		responseCache.Identity = identity
+	}
+
 	// replace regular querier with caching querier if config says so
 	if configValues.EnableTransparentCaching {
-		cache := cache.NewRawCacher(configValues)
+		cachingQuerier := db.NewCachingQuerier(querier, cacher)
+		cachingQuerier.SoftExpiration = configValues.SoftExpiration
+		cachingQuerier.HardExpiration = configValues.Expiration
+		cachingQuerier.NegativeExpiration = configValues.NegativeExpiration
+		cachingQuerier.EntityExpirations = configValues.EntityExpirations
+		cachingQuerier.Jitter = configValues.ExpirationJitter
+		cachingQuerier.LockTimeout = configValues.LockTimeout
+
+		if canLock && configValues.EnableDistributedLock {
+			cachingQuerier.Locker = cacher.(cache.Locker)
+		}
+
+		querier = cachingQuerier
+	}
+
+	return querier, rateLimiter, cacheDispose
+}
+
+// initRateLimit reuses the cache connection for the redis backend, or opens
+// its own when the cache doesn't use redis.
+func initRateLimit(rateLimiter cache.RateLimiter, configValues *config.Configuration) func() {
+	rateLimitConfig := configValues.RateLimitConfig
+	if !rateLimitConfig.Enabled {
+		return func() {}
+	}
 
-		return db.NewCachingQuerier(querier, cache), cache.Close
+	dispose := func() {}
+	switch {
+	case rateLimitConfig.Backend == "memory":
+		rateLimiter = cache.NewMemoryRateLimiter()
+	case rateLimiter == nil:
+		cacher, err := cache.NewRawCacher(configValues.CacheConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rateLimiter, dispose = cacher, cacher.Close
 	}
 
-	return querier, func() {}
+	algorithm := cache.Algorithm(rateLimitConfig.Algorithm)
+	rateLimit = middlewares.NewRateLimit(rateLimiter, cache.Quota{Algorithm: algorithm, Limit: rateLimitConfig.Requests, Window: rateLimitConfig.Window})
+	rateLimit.Keys = rateLimitConfig.Keys
+	rateLimit.APIKeyHeader = rateLimitConfig.APIKeyHeader
+	rateLimit.TrustedProxies = rateLimitConfig.TrustedPrefixes
+	rateLimit.Identity = identity
+
+	if rateLimitConfig.IPRequests > 0 {
+		ipRateLimit = middlewares.NewRateLimit(rateLimiter, cache.Quota{Algorithm: algorithm, Limit: rateLimitConfig.IPRequests, Window: rateLimitConfig.IPWindow})
+		ipRateLimit.Keys = []string{"ip"}
+		// every request counts, authenticated or not
+		ipRateLimit.Scope = "pre-auth|"
+		ipRateLimit.TrustedProxies = rateLimitConfig.TrustedPrefixes
+	}
+
+	for route, quota := range rateLimitConfig.RouteQuotas {
+		if quota == nil {
+			rateLimit.Routes[route] = nil
+			continue
+		}
+		rateLimit.Routes[route] = &cache.Quota{Algorithm: algorithm, Limit: quota.Requests, Window: quota.Window}
+	}
+
+	slog.Info("Rate limiting enabled", "backend", rateLimitConfig.Backend, "algorithm", algorithm)
+
+	return dispose
+}
+
+// startAdminServer serves the admin endpoints on ADMIN_ADDRESS, in the
+// background.
+func startAdminServer(querier db.Querier, txRunner db.TxRunner, configValues *config.Configuration) func(ctx context.Context) error {
+	adminConfig := configValues.AdminConfig
+	if adminConfig.Address == "" {
+		return func(ctx context.Context) error { return nil }
+	}
+
+	adminServer := &admin.Server{
+		Token:          adminConfig.Token,
+		Ready:          handlers.New(querier, txRunner).GetHealth,
+		Config:         func(w io.Writer) { configValues.Print(w, true) },
+		Cache:          appCache,
+		ReloadPolicies: auth.ReloadPolicies,
+		Build:          admin.NewBuildInfo(version),
+	}
+
+	// no write timeout, CPU profiles and traces take as long as requested
+	srv := &http.Server{
+		Addr:              adminConfig.Address,
+		Handler:           adminServer.Handler(),
+		ReadHeaderTimeout: configValues.WebServerConfig.ReadHeaderTimeout,
+	}
+
+	slog.Info("Starting admin server", "address", adminConfig.Address)
 
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Error starting admin server", "error", err)
+		}
+	}()
+
+	return srv.Shutdown
 }
 
-func startWebServer(querier db.Querier, configValues *config.Configuration) func(ctx context.Context) error {
+func startWebServer(querier db.Querier, txRunner db.TxRunner, configValues *config.Configuration) func(ctx context.Context) error {
 	slog.Info("Setting up API router...\n")
 	docs.SwaggerInfo.BasePath = "/"
 
-	router := setupRouter(querier)
+	router := setupRouter(querier, txRunner)
 
+	webServerConfig := configValues.WebServerConfig
 	srv := &http.Server{
-		Addr: configValues.WebServerConfig.WebPort,
+		Addr:              webServerConfig.WebPort,
+		ReadHeaderTimeout: webServerConfig.ReadHeaderTimeout,
+		ReadTimeout:       webServerConfig.ReadTimeout,
+		WriteTimeout:      webServerConfig.WriteTimeout,
+		IdleTimeout:       webServerConfig.IdleTimeout,
+		MaxHeaderBytes:    webServerConfig.MaxHeaderBytes,
 	}
 	srv.Handler = router
 
-	useTls := configValues.WebServerConfig.TLSCertFile != "" && configValues.WebServerConfig.TLSCertKeyFile != ""
+	useTls := webServerConfig.UseTLS()
 
-	slog.Info("Starting TLS server", "port", configValues.WebServerConfig.WebPort, "tls", useTls)
+	slog.Info("Starting TLS server", "port", webServerConfig.WebPort, "tls", useTls, "clientAuth", webServerConfig.TLSClientAuth)
 
 	var err error
 	if useTls {
-		err = srv.ListenAndServeTLS(configValues.WebServerConfig.TLSCertFile, configValues.WebServerConfig.TLSCertKeyFile)
+		tlsConfig, tlsErr := webServerConfig.TLSConfig()
+		if tlsErr != nil {
+			log.Fatal(tlsErr)
+		}
+		srv.TLSConfig = tlsConfig
+
+		// the certificate comes from tlsConfig.GetCertificate
+		err = srv.ListenAndServeTLS("", "")
 	} else {
 		err = srv.ListenAndServe()
 	}
@@ -128,20 +519,60 @@ func startWebServer(querier db.Querier, configValues *config.Configuration) func
 func main() {
 	ctx := context.Background()
 
-	slog.Info("loading .env file...\n")
-	configValues = config.LoadConfig()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrationConfig, err := config.LoadMigration(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runMigrate(os.Args[2:], migrationConfig.WebServerConfig.ConnectionString); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfig(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	slog.Info("Loading configuration...\n")
+	loadedConfig, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	configValues = loadedConfig
+
+	var logLevel slog.Level
+	logLevel.UnmarshalText([]byte(configValues.AdminConfig.LogLevel))
+	admin.SetLogLevel(logLevel)
 
 	slog.Info("Init auth...\n")
 	auth.Init(configValues.AuthConfig)
 
+	secrets := config.NewSecretWatcher(configValues)
+
 	slog.Info("Init DB...\n")
-	querier, dbDispose := initDB(ctx, configValues)
+	querier, pool, dbDispose := initDB(ctx, configValues, secrets)
 	defer dbDispose()
 
 	slog.Info("Init Caching...")
-	querier, cacheDispose := initCache(querier, configValues.CacheConfig)
+	querier, rateLimiter, cacheDispose := initCache(querier, configValues.CacheConfig, secrets)
 	defer cacheDispose()
 
-	webDispose := startWebServer(querier, configValues)
+	slog.Info("Init Rate limiting...")
+	rateLimitDispose := initRateLimit(rateLimiter, configValues)
+	defer rateLimitDispose()
+
+	stopSecrets := secrets.Start(configValues.SecretsConfig.RefreshInterval)
+	defer stopSecrets()
+
+	txRunner := initTx(pool, querier, configValues.DatabaseConfig)
+
+	adminDispose := startAdminServer(querier, txRunner, configValues)
+	defer adminDispose(ctx)
+
+	webDispose := startWebServer(querier, txRunner, configValues)
 	defer webDispose(ctx)
 }
@@ -0,0 +1,51 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"goapi-template/models"
+	"net/http"
+)
+
+// BodyLimit limits the size of request bodies. Bodies announcing a larger
+// Content-Length are rejected right away, the others fail to be read past the
+// limit with an *http.MaxBytesError.
+type BodyLimit struct {
+	// MaxBytes applies to the routes missing from Routes, 0 disables it
+	MaxBytes int64
+	// Routes overrides MaxBytes for routes by their pattern
+	Routes map[string]int64
+}
+
+func NewBodyLimit(maxBytes int64) *BodyLimit {
+	return &BodyLimit{MaxBytes: maxBytes, Routes: map[string]int64{}}
+}
+
+func (l *BodyLimit) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		maxBytes, ok := l.Routes[r.Pattern]
+		if !ok {
+			maxBytes = l.MaxBytes
+		}
+
+		if maxBytes <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > maxBytes {
+			writeError(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	data := &models.ErrorResult{Errors: []string{message}}
+	result, _ := json.Marshal(data)
+	w.Write(result)
+}
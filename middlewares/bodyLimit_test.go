@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serveBodyLimited(bodyLimit *BodyLimit, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	bodyLimit.Middleware(next).ServeHTTP(w, r)
+
+	return w
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	bodyLimit := NewBodyLimit(4)
+
+	w := serveBodyLimited(bodyLimit, httptest.NewRequest("POST", "/person", strings.NewReader("1234")))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serveBodyLimited(bodyLimit, httptest.NewRequest("POST", "/person", strings.NewReader("12345")))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.JSONEq(t, `{"errors":["Request body is too large"]}`, w.Body.String())
+
+	// without a Content-Length, the body fails to be read past the limit
+	r := httptest.NewRequest("POST", "/person", io.NopCloser(strings.NewReader("12345")))
+	r.ContentLength = -1
+	w = serveBodyLimited(bodyLimit, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestBodyLimitRoutes(t *testing.T) {
+	bodyLimit := NewBodyLimit(4)
+	bodyLimit.Routes["POST /person/batch"] = 8
+	bodyLimit.Routes["POST /upload"] = 0
+
+	r := httptest.NewRequest("POST", "/person/batch", strings.NewReader("12345678"))
+	r.Pattern = "POST /person/batch"
+	assert.Equal(t, http.StatusOK, serveBodyLimited(bodyLimit, r).Code)
+
+	r = httptest.NewRequest("POST", "/upload", strings.NewReader("123456789"))
+	r.Pattern = "POST /upload"
+	assert.Equal(t, http.StatusOK, serveBodyLimited(bodyLimit, r).Code)
+}
@@ -0,0 +1,261 @@
+package middlewares
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoder is implemented by the writers of every encoding.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoders pools the writers of each supported encoding.
+var encoders = map[string]*sync.Pool{
+	"zstd": {New: func() any {
+		encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return encoder
+	}},
+	"br": {New: func() any {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}},
+	"gzip": {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+}
+
+// defaultSkipTypes are the content types that are compressed already.
+var defaultSkipTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif",
+	"audio/", "video/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd", "application/x-brotli",
+}
+
+// Compress compresses responses with the encoding the Accept-Encoding header
+// of the request prefers. Responses smaller than MinSize, already encoded or
+// of one of SkipTypes are sent as is.
+type Compress struct {
+	// Encodings are zstd, br or gzip, by order of preference when the client
+	// accepts several equally
+	Encodings []string
+	MinSize   int
+	// SkipTypes are prefixes of the content types that aren't compressed
+	SkipTypes []string
+}
+
+func NewCompress(encodings []string, minSize int) *Compress {
+	return &Compress{Encodings: encodings, MinSize: minSize, SkipTypes: defaultSkipTypes}
+}
+
+// compressWriter holds the response back until MinSize bytes were written
+// or the handler returned, to decide whether it is compressed.
+type compressWriter struct {
+	http.ResponseWriter
+	compress *Compress
+	encoding string
+	head     bool
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder encoder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+
+	// informational responses don't end the headers
+	if code >= 100 && code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	w.status = code
+}
+
+func (w *compressWriter) Write(body []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if !w.decided {
+		w.buf = append(w.buf, body...)
+		if len(w.buf) < w.compress.MinSize {
+			return len(body), nil
+		}
+
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(body), nil
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(body)
+	}
+
+	return w.ResponseWriter.Write(body)
+}
+
+// decide writes the headers and the buffered body, compressed when large is
+// set and the response can be.
+func (w *compressWriter) decide(large bool) error {
+	w.decided = true
+
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	if large && w.compressible() {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		w.encoder = encoders[w.encoding].Get().(encoder)
+		w.encoder.Reset(w.ResponseWriter)
+	}
+
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	buf := w.buf
+	w.buf = nil
+
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+
+	return err
+}
+
+func (w *compressWriter) compressible() bool {
+	header := w.Header()
+	if w.head || w.status < 200 || w.status == http.StatusNoContent || w.status == http.StatusNotModified || w.status == http.StatusPartialContent {
+		return false
+	}
+
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	for _, skipped := range w.compress.SkipTypes {
+		if strings.HasPrefix(contentType, skipped) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Flush sends what was written so far, deciding on the compression with it.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buf) >= w.compress.MinSize)
+	}
+
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// close writes the rest of the response.
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide(false)
+	}
+
+	if w.encoder != nil {
+		w.encoder.Close()
+		w.encoder.Reset(nil)
+		encoders[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (c *Compress) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addVary(w.Header(), "Accept-Encoding")
+
+		encoding := c.encoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		writer := &compressWriter{ResponseWriter: w, compress: c, encoding: encoding, head: r.Method == http.MethodHead}
+		defer writer.close()
+
+		next.ServeHTTP(writer, r)
+	})
+}
+
+// encoding returns the encoding of c.Encodings acceptEncoding prefers, ""
+// when the response must not be compressed.
+func (c *Compress) encoding(acceptEncoding string) string {
+	weights := map[string]float64{}
+	for _, item := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		weights[name] = 1
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(q, 64); err == nil {
+				weights[name] = parsed
+			}
+		}
+	}
+
+	best, bestWeight := "", 0.0
+	for _, encoding := range c.Encodings {
+		weight, ok := weights[encoding]
+		if !ok {
+			weight, ok = weights["*"]
+		}
+
+		if ok && weight > bestWeight {
+			best, bestWeight = encoding, weight
+		}
+	}
+
+	return best
+}
+
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+
+	header.Add("Vary", name)
+}
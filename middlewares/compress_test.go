@@ -0,0 +1,139 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func compressRequest(compress *Compress, method string, acceptEncoding string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/test", nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+
+	compress.Middleware(handler).ServeHTTP(w, r)
+
+	return w
+}
+
+func jsonHandler(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "1")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(body))
+	}
+}
+
+func decompress(t *testing.T, encoding string, body []byte) string {
+	var reader io.Reader
+	switch encoding {
+	case "gzip":
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		assert.Nil(t, err)
+		reader = gzipReader
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case "zstd":
+		zstdReader, err := zstd.NewReader(bytes.NewReader(body))
+		assert.Nil(t, err)
+		defer zstdReader.Close()
+		reader = zstdReader
+	}
+
+	result, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+
+	return string(result)
+}
+
+func TestCompressEncodings(t *testing.T) {
+	body := `{"name":"` + strings.Repeat("test", 512) + `"}`
+	compress := NewCompress([]string{"zstd", "br", "gzip"}, 1024)
+
+	for _, encoding := range compress.Encodings {
+		w := compressRequest(compress, "GET", encoding, jsonHandler(body))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, encoding, w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Empty(t, w.Header().Get("Content-Length"))
+		assert.Less(t, w.Body.Len(), len(body))
+		assert.Equal(t, body, decompress(t, encoding, w.Body.Bytes()))
+	}
+}
+
+func TestCompressPreference(t *testing.T) {
+	compress := NewCompress([]string{"zstd", "br", "gzip"}, 0)
+
+	assert.Equal(t, "zstd", compress.encoding("gzip, br, zstd"))
+	assert.Equal(t, "br", compress.encoding("gzip;q=0.5, br"))
+	assert.Equal(t, "gzip", compress.encoding("GZIP"))
+	assert.Equal(t, "zstd", compress.encoding("*"))
+	assert.Equal(t, "br", compress.encoding("*, zstd;q=0"))
+	assert.Equal(t, "", compress.encoding("identity"))
+	assert.Equal(t, "", compress.encoding("gzip;q=0"))
+	assert.Equal(t, "", compress.encoding(""))
+}
+
+func TestCompressSmallResponse(t *testing.T) {
+	compress := NewCompress([]string{"gzip"}, 1024)
+
+	w := compressRequest(compress, "GET", "gzip", jsonHandler(`{"name":"test"}`))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "1", w.Header().Get("Content-Length"))
+	assert.Equal(t, `{"name":"test"}`, w.Body.String())
+}
+
+func TestCompressSkippedResponses(t *testing.T) {
+	compress := NewCompress([]string{"gzip"}, 0)
+	body := strings.Repeat("a", 2048)
+
+	image := compressRequest(compress, "GET", "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte(body))
+	})
+	encoded := compressRequest(compress, "GET", "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		w.Write([]byte(body))
+	})
+	notModified := compressRequest(compress, "GET", "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})
+	head := compressRequest(compress, "HEAD", "gzip", jsonHandler(body))
+	identity := compressRequest(compress, "GET", "", jsonHandler(body))
+
+	assert.Empty(t, image.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, image.Body.String())
+	assert.Equal(t, "br", encoded.Header().Get("Content-Encoding"))
+	assert.Equal(t, body, encoded.Body.String())
+	assert.Equal(t, http.StatusNotModified, notModified.Code)
+	assert.Empty(t, notModified.Header().Get("Content-Encoding"))
+	assert.Empty(t, head.Header().Get("Content-Encoding"))
+	assert.Empty(t, identity.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", identity.Header().Get("Vary"))
+}
+
+func TestCompressSniffsContentType(t *testing.T) {
+	compress := NewCompress([]string{"gzip"}, 0)
+
+	w := compressRequest(compress, "GET", "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>" + strings.Repeat("a", 2048) + "</body></html>"))
+	})
+
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+}
@@ -0,0 +1,84 @@
+package middlewares
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/rs/cors"
+)
+
+// Cors applies the CORS policy of the route a request is for. Preflights are
+// only allowed for the methods the router serves on the requested path, so
+// clients learn about missing routes before sending the actual request.
+type Cors struct {
+	router *http.ServeMux
+	policy *cors.Cors
+	// routes are the policies overriding the allowed origins of the paths
+	// starting with their prefix, longest prefix first
+	routes []routeCors
+}
+
+type routeCors struct {
+	prefix string
+	policy *cors.Cors
+}
+
+// NewCors builds the policies of router from options. routeOrigins overrides
+// the allowed origins of the paths starting with its keys, e.g. "/public/".
+func NewCors(router *http.ServeMux, options cors.Options, routeOrigins map[string][]string) *Cors {
+	c := &Cors{router: router, policy: cors.New(options)}
+
+	for prefix, origins := range routeOrigins {
+		routeOptions := options
+		routeOptions.AllowedOrigins = origins
+		c.routes = append(c.routes, routeCors{prefix: prefix, policy: cors.New(routeOptions)})
+	}
+
+	sort.Slice(c.routes, func(i, j int) bool {
+		return len(c.routes[i].prefix) > len(c.routes[j].prefix)
+	})
+
+	return c
+}
+
+func (c *Cors) policyFor(path string) *cors.Cors {
+	for _, route := range c.routes {
+		if strings.HasPrefix(path, route.prefix) {
+			return route.policy
+		}
+	}
+
+	return c.policy
+}
+
+// serves tells whether the router has a route for method on the path of r.
+func (c *Cors) serves(r *http.Request, method string) bool {
+	probe := r.Clone(r.Context())
+	probe.Method = method
+
+	_, pattern := c.router.Handler(probe)
+
+	return pattern != ""
+}
+
+// Handler answers preflights and adds the CORS headers to the other
+// requests, which go on to next.
+func (c *Cors) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := c.policyFor(r.URL.Path)
+
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || requestedMethod == "" {
+			policy.Handler(next).ServeHTTP(w, r)
+			return
+		}
+
+		if !c.serves(r, strings.ToUpper(requestedMethod)) {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		policy.HandlerFunc(w, r)
+	})
+}
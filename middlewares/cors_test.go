@@ -0,0 +1,101 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/cors"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCors() http.Handler {
+	router := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("OK")) }
+	router.HandleFunc("GET /person", ok)
+	router.HandleFunc("POST /person", ok)
+	router.HandleFunc("GET /person/{id}", ok)
+	router.HandleFunc("PUT /person/{id}", ok)
+	router.HandleFunc("DELETE /person/{id}", ok)
+	router.HandleFunc("GET /public/docs", ok)
+
+	options := cors.Options{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.partner.com"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		ExposedHeaders:   []string{"ETag", "X-Trace-Id"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+
+	return NewCors(router, options, map[string][]string{"/public/": {"*"}}).Handler(router)
+}
+
+func preflight(handler http.Handler, path string, origin string, method string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("OPTIONS", path, nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", method)
+	r.Header.Set("Access-Control-Request-Headers", "authorization")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestCorsPreflight(t *testing.T) {
+	handler := newTestCors()
+
+	w := preflight(handler, "/person/1", "https://app.example.com", "DELETE")
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "DELETE", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+}
+
+func TestCorsPreflightWildcardSubdomain(t *testing.T) {
+	handler := newTestCors()
+
+	w := preflight(handler, "/person", "https://eu.partner.com", "POST")
+	assert.Equal(t, "https://eu.partner.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = preflight(handler, "/person", "https://partner.com.evil.com", "POST")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCorsPreflightUnservedMethod(t *testing.T) {
+	handler := newTestCors()
+
+	w := preflight(handler, "/person", "https://app.example.com", "DELETE")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = preflight(handler, "/unknown", "https://app.example.com", "GET")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestCorsRouteOverride(t *testing.T) {
+	handler := newTestCors()
+
+	w := preflight(handler, "/public/docs", "https://anyone.com", "GET")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = preflight(handler, "/person", "https://anyone.com", "GET")
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCorsActualRequest(t *testing.T) {
+	handler := newTestCors()
+
+	r := httptest.NewRequest("GET", "/person/1", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "OK", w.Body.String())
+	assert.Equal(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Etag, X-Trace-Id", w.Header().Get("Access-Control-Expose-Headers"))
+}
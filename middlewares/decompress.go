@@ -0,0 +1,143 @@
+package middlewares
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ratioGrace is how many decompressed bytes are read before MaxRatio
+// applies, the first bytes of a stream are always expanded the most.
+const ratioGrace = 64 << 10
+
+// Decompress decodes the request bodies sent with a Content-Encoding of
+// Encodings, others are rejected with a 415. Against decompression bombs, the
+// decoded bodies fail to be read past MaxBytes, or past MaxRatio times the
+// bytes received, with an *http.MaxBytesError.
+type Decompress struct {
+	// Encodings are gzip or zstd
+	Encodings []string
+	// MaxBytes applies to the routes missing from Routes, 0 disables it
+	MaxBytes int64
+	// MaxRatio limits how much larger the decoded body is than the
+	// received one, 0 disables it
+	MaxRatio int64
+	// Routes overrides MaxBytes for routes by their pattern
+	Routes map[string]int64
+}
+
+func NewDecompress(encodings []string, maxBytes int64, maxRatio int64) *Decompress {
+	return &Decompress{Encodings: encodings, MaxBytes: maxBytes, MaxRatio: maxRatio, Routes: map[string]int64{}}
+}
+
+// decompressedBody reads the decoded body, counting the bytes on both sides
+// to enforce the limits.
+type decompressedBody struct {
+	decoder  io.Reader
+	close    func()
+	body     io.ReadCloser
+	received *countingReader
+
+	maxBytes int64
+	maxRatio int64
+	read     int64
+	err      error
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.decoder.Read(p)
+	b.read += int64(n)
+
+	// zstd refuses the frames announcing more than maxBytes up front
+	if b.maxBytes > 0 && (b.read > b.maxBytes || errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded)) {
+		b.err = &http.MaxBytesError{Limit: b.maxBytes}
+		return 0, b.err
+	}
+
+	if limit := b.maxRatio * b.received.n; b.maxRatio > 0 && b.read > ratioGrace && b.read > limit {
+		b.err = &http.MaxBytesError{Limit: limit}
+		return 0, b.err
+	}
+
+	return n, err
+}
+
+func (b *decompressedBody) Close() error {
+	b.close()
+	return b.body.Close()
+}
+
+func (d *Decompress) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		if encoding == "" || encoding == "identity" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !slices.Contains(d.Encodings, encoding) {
+			// tells the client what it can send instead, see RFC 7694
+			w.Header().Set("Accept-Encoding", strings.Join(d.Encodings, ", "))
+			writeError(w, http.StatusUnsupportedMediaType, "Unsupported Content-Encoding "+encoding)
+			return
+		}
+
+		maxBytes, ok := d.Routes[r.Pattern]
+		if !ok {
+			maxBytes = d.MaxBytes
+		}
+
+		received := &countingReader{Reader: r.Body}
+		body := &decompressedBody{body: r.Body, received: received, maxBytes: maxBytes, maxRatio: d.MaxRatio}
+
+		switch encoding {
+		case "gzip":
+			decoder, err := gzip.NewReader(received)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Request body can't be decompressed")
+				return
+			}
+			body.decoder, body.close = decoder, func() { decoder.Close() }
+		case "zstd":
+			options := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+			if maxBytes > 0 {
+				options = append(options, zstd.WithDecoderMaxMemory(uint64(maxBytes)))
+			}
+
+			decoder, err := zstd.NewReader(received, options...)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "Request body can't be decompressed")
+				return
+			}
+			body.decoder, body.close = decoder, decoder.Close
+		}
+
+		// the handlers see the decoded body only
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		r.Body = body
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,131 @@
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func compressBody(t *testing.T, encoding string, body string) *bytes.Buffer {
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(&buffer)
+	case "zstd":
+		zstdWriter, err := zstd.NewWriter(&buffer)
+		assert.Nil(t, err)
+		writer = zstdWriter
+	}
+
+	writer.Write([]byte(body))
+	writer.Close()
+
+	return &buffer
+}
+
+// serveDecompressed answers with the body the handler read, or a 413 when it
+// is too large.
+func serveDecompressed(decompress *Decompress, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Header().Set("X-Content-Encoding", r.Header.Get("Content-Encoding"))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	})
+
+	decompress.Middleware(next).ServeHTTP(w, r)
+
+	return w
+}
+
+func TestDecompressMiddleware(t *testing.T) {
+	decompress := NewDecompress([]string{"gzip", "zstd"}, 1024, 100)
+	body := `[{"name":"Demo Company","email":"demo@company.com"}]`
+
+	for _, encoding := range decompress.Encodings {
+		r := httptest.NewRequest("POST", "/person/batch", compressBody(t, encoding, body))
+		r.Header.Set("Content-Encoding", encoding)
+
+		w := serveDecompressed(decompress, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, body, w.Body.String())
+		assert.Empty(t, w.Header().Get("X-Content-Encoding"))
+	}
+
+	// bodies without an encoding are untouched
+	w := serveDecompressed(decompress, httptest.NewRequest("POST", "/person/batch", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, body, w.Body.String())
+}
+
+func TestDecompressUnsupportedEncoding(t *testing.T) {
+	decompress := NewDecompress([]string{"gzip", "zstd"}, 1024, 100)
+
+	r := httptest.NewRequest("POST", "/person/batch", strings.NewReader("body"))
+	r.Header.Set("Content-Encoding", "br")
+
+	w := serveDecompressed(decompress, r)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Equal(t, "gzip, zstd", w.Header().Get("Accept-Encoding"))
+	assert.JSONEq(t, `{"errors":["Unsupported Content-Encoding br"]}`, w.Body.String())
+}
+
+func TestDecompressInvalidBody(t *testing.T) {
+	decompress := NewDecompress([]string{"gzip"}, 1024, 100)
+
+	r := httptest.NewRequest("POST", "/person/batch", strings.NewReader("not gzip"))
+	r.Header.Set("Content-Encoding", "gzip")
+
+	w := serveDecompressed(decompress, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"errors":["Request body can't be decompressed"]}`, w.Body.String())
+}
+
+func TestDecompressMaxBytes(t *testing.T) {
+	decompress := NewDecompress([]string{"gzip", "zstd"}, 1024, 0)
+	decompress.Routes["POST /person/batch"] = 4096
+	body := strings.Repeat("a", 2048)
+
+	for _, encoding := range decompress.Encodings {
+		r := httptest.NewRequest("POST", "/person", compressBody(t, encoding, body))
+		r.Header.Set("Content-Encoding", encoding)
+		w := serveDecompressed(decompress, r)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code, encoding)
+
+		r = httptest.NewRequest("POST", "/person/batch", compressBody(t, encoding, body))
+		r.Pattern = "POST /person/batch"
+		r.Header.Set("Content-Encoding", encoding)
+		w = serveDecompressed(decompress, r)
+		assert.Equal(t, http.StatusOK, w.Code, encoding)
+	}
+}
+
+func TestDecompressMaxRatio(t *testing.T) {
+	decompress := NewDecompress([]string{"gzip"}, 0, 100)
+	bomb := compressBody(t, "gzip", strings.Repeat("a", 10<<20))
+
+	r := httptest.NewRequest("POST", "/person/batch", bomb)
+	r.Header.Set("Content-Encoding", "gzip")
+
+	w := serveDecompressed(decompress, r)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
@@ -1,26 +1,43 @@
 package middlewares
 
 import (
-	"bytes"
 	"log/slog"
 	"net/http"
 	"time"
 )
 
+// LogResponseWriter records the status and the size of the response. The
+// size is counted before Compress compresses the response.
 type LogResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
-	buf        bytes.Buffer
+	bytes      int
 }
 
 func (w *LogResponseWriter) WriteHeader(code int) {
-	w.statusCode = code
+	// informational responses are followed by the final one
+	if w.statusCode == 0 && code >= 200 {
+		w.statusCode = code
+	}
 	w.ResponseWriter.WriteHeader(code)
 }
 
 func (w *LogResponseWriter) Write(body []byte) (int, error) {
-	w.buf.Write(body)
-	return w.ResponseWriter.Write(body)
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(body)
+	w.bytes += n
+	return n, err
+}
+
+// Flush lets handlers stream through the writer.
+func (w *LogResponseWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *LogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
 
 func newLogResponseWriter(w http.ResponseWriter) *LogResponseWriter {
@@ -34,6 +51,11 @@ func LogMiddleware(next http.Handler) http.Handler {
 		logRespWriter := newLogResponseWriter(w)
 		next.ServeHTTP(logRespWriter, r)
 
+		// net/http answers 200 when nothing was written
+		if logRespWriter.statusCode == 0 {
+			logRespWriter.statusCode = http.StatusOK
+		}
+
 		slog.Info(
 			"WebRequest",
 			"proto", r.Proto,
@@ -41,6 +63,7 @@ func LogMiddleware(next http.Handler) http.Handler {
 			"url", r.URL,
 			"duration", time.Since(startTime),
 			"status", logRespWriter.statusCode,
+			"bytes", logRespWriter.bytes,
 			"traceId", r.Context().Value(ContextKey("traceId")))
 	})
 }
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -39,3 +40,28 @@ func TestLogMiddleware(t *testing.T) {
 	assert.Contains(t, buffer.String(), "duration=")
 	assert.Contains(t, buffer.String(), "status=200")
 }
+
+func TestLogMiddlewareCompressed(t *testing.T) {
+	var buffer *bytes.Buffer = new(bytes.Buffer)
+	logger := slog.New(slog.NewTextHandler(buffer, nil))
+	slog.SetDefault(logger)
+
+	// Setup
+	r := httptest.NewRequest("GET", "/test", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 2048)))
+		http.NewResponseController(w).Flush()
+	})
+
+	// Execution
+	NewCompress([]string{"gzip"}, 1024).Middleware(LogMiddleware(next)).ServeHTTP(w, r)
+
+	// Validation
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.True(t, w.Flushed)
+	assert.Contains(t, buffer.String(), "status=200")
+	assert.Contains(t, buffer.String(), "bytes=2048")
+}
@@ -0,0 +1,180 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"goapi-template/cache"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit limits how many requests each caller makes. Callers are
+// identified by the first of Keys that applies to the request, and routes
+// with their own quota in Routes are counted separately. Only authenticated
+// callers and client IPs are keys, anything else the client sends could be
+// changed on every request to get a new quota: API keys only apply to the
+// requests Identity authenticated, and are counted per caller.
+type RateLimit struct {
+	Limiter cache.RateLimiter
+	// Quota applies to the routes missing from Routes
+	Quota cache.Quota
+	// Routes overrides the quota of routes by their pattern, e.g.
+	// "POST /person/batch", a nil quota disables the limit
+	Routes map[string]*cache.Quota
+	// Keys are any of user, api-key and ip
+	Keys         []string
+	APIKeyHeader string
+	// Scope prefixes the keys, so the limiters sharing a Limiter count
+	// separately
+	Scope string
+	// TrustedProxies are the proxies whose X-Forwarded-For header is trusted
+	TrustedProxies []netip.Prefix
+	// Identity returns the authenticated caller, "" for anonymous callers
+	Identity func(r *http.Request) string
+}
+
+func NewRateLimit(limiter cache.RateLimiter, quota cache.Quota) *RateLimit {
+	return &RateLimit{
+		Limiter:      limiter,
+		Quota:        quota,
+		Routes:       map[string]*cache.Quota{},
+		Keys:         []string{"user", "ip"},
+		APIKeyHeader: "X-API-Key",
+	}
+}
+
+// Middleware answers 429 with Retry-After once the caller is over its quota
+// and sends the RateLimit headers otherwise. Requests are let through when
+// the limiter fails, an unavailable backend must not take the API down.
+func (l *RateLimit) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		quota, key, ok := l.quotaFor(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowance, err := l.Limiter.Take(r.Context(), key, quota)
+		if err != nil {
+			slog.Error("Error taking from rate limit", "error", err, "traceId", r.Context().Value(ContextKey("traceId")))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(allowance.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(allowance.Remaining))
+		header.Set("RateLimit-Reset", seconds(allowance.Reset))
+		header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", quota.Limit, seconds(quota.Window)))
+
+		if !allowance.Allowed {
+			header.Set("Retry-After", seconds(allowance.RetryAfter))
+			writeError(w, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// quotaFor returns the quota of the route r is for and the key of its caller.
+// ok is false when the route isn't limited or the caller can't be identified.
+func (l *RateLimit) quotaFor(r *http.Request) (cache.Quota, string, bool) {
+	caller := l.caller(r)
+	if caller == "" {
+		return cache.Quota{}, "", false
+	}
+	caller = l.Scope + caller
+
+	routeQuota, ok := l.Routes[r.Pattern]
+	if !ok {
+		return l.Quota, caller, true
+	}
+	if routeQuota == nil {
+		return cache.Quota{}, "", false
+	}
+
+	return *routeQuota, r.Pattern + "|" + caller, true
+}
+
+func (l *RateLimit) caller(r *http.Request) string {
+	for _, key := range l.Keys {
+		switch key {
+		case "user":
+			if l.Identity == nil {
+				continue
+			}
+			if identity := l.Identity(r); identity != "" {
+				return "user:" + identity
+			}
+		case "api-key":
+			if l.Identity == nil {
+				continue
+			}
+			// only a digest of the key ends up in the backend
+			apiKey := r.Header.Get(l.APIKeyHeader)
+			if identity := l.Identity(r); identity != "" && apiKey != "" {
+				digest := sha256.Sum256([]byte(apiKey))
+				return "api-key:" + identity + "|" + hex.EncodeToString(digest[:])
+			}
+		case "ip":
+			if ip := l.clientIP(r); ip.IsValid() {
+				return "ip:" + ip.String()
+			}
+		}
+	}
+
+	return ""
+}
+
+// clientIP is the peer address, unless it's a trusted proxy. Then the
+// X-Forwarded-For addresses are walked from the closest one until one isn't
+// trusted, as the ones before it could have been set by the client.
+func (l *RateLimit) clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	ip = ip.Unmap()
+
+	var forwarded []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0 && l.trusted(ip); i-- {
+		next, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		ip = next.Unmap()
+	}
+
+	return ip
+}
+
+func (l *RateLimit) trusted(ip netip.Addr) bool {
+	for _, prefix := range l.TrustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// seconds rounds d up to whole seconds, as the RateLimit headers expect.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
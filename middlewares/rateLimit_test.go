@@ -0,0 +1,174 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"goapi-template/cache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingRateLimiter struct{}
+
+func (failingRateLimiter) Take(context.Context, string, cache.Quota) (cache.Allowance, error) {
+	return cache.Allowance{}, errors.New("unavailable")
+}
+
+func serveRateLimited(rateLimit *RateLimit, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rateLimit.Middleware(next).ServeHTTP(w, r)
+
+	return w
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	rateLimit := NewRateLimit(cache.NewMemoryRateLimiter(), cache.Quota{Algorithm: cache.TokenBucket, Limit: 2, Window: time.Minute})
+
+	r := httptest.NewRequest("GET", "/person", nil)
+	w := serveRateLimited(rateLimit, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+
+	serveRateLimited(rateLimit, r)
+	w = serveRateLimited(rateLimit, r)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	assert.JSONEq(t, `{"errors":["Too many requests"]}`, w.Body.String())
+}
+
+func TestRateLimitKeys(t *testing.T) {
+	rateLimit := NewRateLimit(cache.NewMemoryRateLimiter(), cache.Quota{Algorithm: cache.TokenBucket, Limit: 1, Window: time.Minute})
+	rateLimit.Identity = func(r *http.Request) string { return r.Header.Get("X-User") }
+
+	request := func(user string, apiKey string) *http.Request {
+		r := httptest.NewRequest("GET", "/person", nil)
+		r.Header.Set("X-User", user)
+		r.Header.Set("X-API-Key", apiKey)
+		return r
+	}
+
+	assert.Equal(t, http.StatusOK, serveRateLimited(rateLimit, request("a", "")).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveRateLimited(rateLimit, request("a", "")).Code)
+	// the same client IP, but another user
+	assert.Equal(t, http.StatusOK, serveRateLimited(rateLimit, request("b", "")).Code)
+	assert.Equal(t, http.StatusOK, serveRateLimited(rateLimit, request("", "")).Code)
+	// unauthenticated headers don't get a new quota
+	assert.Equal(t, http.StatusTooManyRequests, serveRateLimited(rateLimit, request("", "key")).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveRateLimited(rateLimit, request("", "other key")).Code)
+}
+
+func TestRateLimitAPIKeys(t *testing.T) {
+	limiter := cache.NewMemoryRateLimiter()
+	rateLimit := NewRateLimit(limiter, cache.Quota{Algorithm: cache.TokenBucket, Limit: 1, Window: time.Minute})
+	rateLimit.Keys = []string{"api-key", "user", "ip"}
+	rateLimit.Identity = func(r *http.Request) string { return r.Header.Get("X-User") }
+
+	request := func(user string, apiKey string) *http.Request {
+		r := httptest.NewRequest("GET", "/person", nil)
+		r.Header.Set("X-User", user)
+		r.Header.Set("X-API-Key", apiKey)
+		return r
+	}
+
+	assert.Equal(t, http.StatusOK, serveRateLimited(rateLimit, request("a", "key")).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveRateLimited(rateLimit, request("a", "key")).Code)
+	// each key of an authenticated caller has its quota
+	assert.Equal(t, http.StatusOK, serveRateLimited(rateLimit, request("a", "other key")).Code)
+	// and another caller sending the same key doesn't use it up
+	assert.Equal(t, http.StatusOK, serveRateLimited(rateLimit, request("b", "key")).Code)
+	// the keys of unauthenticated callers are ignored
+	assert.Equal(t, http.StatusOK, serveRateLimited(rateLimit, request("", "key")).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveRateLimited(rateLimit, request("", "new key")).Code)
+	assert.Equal(t, 4, limiter.Len())
+}
+
+func TestRateLimitScope(t *testing.T) {
+	limiter := cache.NewMemoryRateLimiter()
+	quota := cache.Quota{Algorithm: cache.TokenBucket, Limit: 1, Window: time.Minute}
+	ipRateLimit := NewRateLimit(limiter, quota)
+	ipRateLimit.Keys = []string{"ip"}
+	ipRateLimit.Scope = "pre-auth|"
+	rateLimit := NewRateLimit(limiter, quota)
+
+	r := httptest.NewRequest("GET", "/person", nil)
+
+	assert.Equal(t, http.StatusOK, serveRateLimited(ipRateLimit, r).Code)
+	// counted separately, with the same client IP key
+	assert.Equal(t, http.StatusOK, serveRateLimited(rateLimit, r).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveRateLimited(ipRateLimit, r).Code)
+	assert.Equal(t, 2, limiter.Len())
+}
+
+func TestRateLimitRoutes(t *testing.T) {
+	rateLimit := NewRateLimit(cache.NewMemoryRateLimiter(), cache.Quota{Algorithm: cache.TokenBucket, Limit: 1, Window: time.Minute})
+	rateLimit.Routes["POST /person/batch"] = &cache.Quota{Algorithm: cache.SlidingWindow, Limit: 5, Window: time.Hour}
+	rateLimit.Routes["GET /health"] = nil
+
+	request := func(method string, pattern string) *http.Request {
+		r := httptest.NewRequest(method, "/", nil)
+		r.Pattern = pattern
+		return r
+	}
+
+	assert.Equal(t, http.StatusOK, serveRateLimited(rateLimit, request("GET", "GET /person")).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveRateLimited(rateLimit, request("GET", "GET /person")).Code)
+
+	w := serveRateLimited(rateLimit, request("POST", "POST /person/batch"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "5;w=3600", w.Header().Get("RateLimit-Policy"))
+
+	w = serveRateLimited(rateLimit, request("GET", "GET /health"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateLimitClientIP(t *testing.T) {
+	rateLimit := NewRateLimit(cache.NewMemoryRateLimiter(), cache.Quota{})
+	rateLimit.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"203.0.113.1:1234", "198.51.100.1", "203.0.113.1"},
+		{"10.0.0.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"10.0.0.1:1234", "198.51.100.2, 198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "not an ip", "10.0.0.1"},
+		{"[::ffff:203.0.113.1]:1234", "", "203.0.113.1"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+
+		assert.Equal(t, test.expected, rateLimit.clientIP(r).String(), test.remoteAddr+" "+test.forwarded)
+	}
+}
+
+func TestRateLimitFailsOpen(t *testing.T) {
+	rateLimit := NewRateLimit(failingRateLimiter{}, cache.Quota{Algorithm: cache.TokenBucket, Limit: 1, Window: time.Minute})
+
+	w := serveRateLimited(rateLimit, httptest.NewRequest("GET", "/person", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
@@ -0,0 +1,82 @@
+package middlewares
+
+import (
+	"context"
+	"goapi-template/db"
+	"log/slog"
+	"net/http"
+)
+
+// txResponseWriter ends the request transaction before the response is
+// written: it is committed unless the response is an error, and a response
+// whose transaction can't be committed is replaced with a 500.
+type txResponseWriter struct {
+	http.ResponseWriter
+	ctx         context.Context
+	tx          *db.RequestTx
+	wroteHeader bool
+	// failed discards the body of the response the commit failed for
+	failed bool
+}
+
+func (w *txResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+
+	// the response is decided, the transaction ends even if the caller
+	// went away
+	ctx := context.WithoutCancel(w.ctx)
+	if code >= http.StatusBadRequest {
+		if err := w.tx.Rollback(ctx); err != nil {
+			slog.Warn("Error rolling back the request transaction", "error", err, "traceId", ctx.Value(ContextKey("traceId")))
+		}
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	if err := w.tx.Commit(ctx); err != nil {
+		slog.Error("Error committing the request transaction", "error", err, "traceId", ctx.Value(ContextKey("traceId")))
+		w.failed = true
+		w.Header().Del("Location")
+		w.Header().Del("ETag")
+		writeError(w.ResponseWriter, http.StatusInternalServerError, "Unknown error")
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *txResponseWriter) Write(body []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.failed {
+		return len(body), nil
+	}
+	return w.ResponseWriter.Write(body)
+}
+
+func (w *txResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// RequestTxMiddleware runs the queries of the request in one transaction,
+// see db.WithRequestTx, which is committed right before the response is
+// written so that its writes are visible by the time the caller gets it.
+func RequestTxMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, tx := db.WithRequestTx(r.Context())
+		// no-op once the response was written, and covers next panicking
+		defer tx.Rollback(context.WithoutCancel(ctx))
+
+		txWriter := &txResponseWriter{ResponseWriter: w, ctx: ctx, tx: tx}
+		next.ServeHTTP(txWriter, r.WithContext(ctx))
+
+		if !txWriter.wroteHeader {
+			txWriter.WriteHeader(http.StatusOK)
+		}
+	})
+}
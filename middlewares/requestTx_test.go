@@ -0,0 +1,127 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"goapi-template/db"
+	"net/http"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+// poolMock begins txMocks, the queries without a tenant aren't expected.
+type poolMock struct {
+	db.DBTX
+	txs         []*txMock
+	commitError error
+}
+
+func (m *poolMock) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	tx := &txMock{commitError: m.commitError}
+	m.txs = append(m.txs, tx)
+	return tx, nil
+}
+
+type txMock struct {
+	pgx.Tx
+	commitError error
+	committed   bool
+	rolledBack  bool
+}
+
+func (m *txMock) Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+func (m *txMock) Commit(ctx context.Context) error {
+	if m.commitError != nil {
+		return m.commitError
+	}
+	m.committed = true
+	return nil
+}
+
+func (m *txMock) Rollback(ctx context.Context) error {
+	if !m.committed {
+		m.rolledBack = true
+	}
+	return nil
+}
+
+// writingHandler deletes a person of the acme tenant and answers with
+// status.
+func writingHandler(pool *poolMock, status int) http.Handler {
+	conn := db.WithTenantIsolation(pool)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := db.WithTenant(r.Context(), "acme")
+		conn.Exec(ctx, "DELETE FROM person WHERE id = 1")
+		conn.Exec(ctx, "DELETE FROM person WHERE id = 2")
+
+		w.Header().Set("Location", "/person/1")
+		w.WriteHeader(status)
+		w.Write([]byte("deleted"))
+	})
+}
+
+func TestRequestTxCommitsBeforeTheResponse(t *testing.T) {
+	pool := &poolMock{}
+
+	w := serve(RequestTxMiddleware(writingHandler(pool, http.StatusAccepted)), "DELETE", "/person/1", nil)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "deleted", w.Body.String())
+	assert.Len(t, pool.txs, 1)
+	assert.True(t, pool.txs[0].committed)
+}
+
+func TestRequestTxRollsBackErrors(t *testing.T) {
+	pool := &poolMock{}
+
+	w := serve(RequestTxMiddleware(writingHandler(pool, http.StatusConflict)), "DELETE", "/person/1", nil)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.False(t, pool.txs[0].committed)
+	assert.True(t, pool.txs[0].rolledBack)
+}
+
+func TestRequestTxCommitError(t *testing.T) {
+	pool := &poolMock{commitError: errors.New("commit failed")}
+
+	w := serve(RequestTxMiddleware(writingHandler(pool, http.StatusAccepted)), "DELETE", "/person/1", nil)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.JSONEq(t, `{"errors":["Unknown error"]}`, w.Body.String())
+	assert.Empty(t, w.Header().Get("Location"))
+	assert.True(t, pool.txs[0].rolledBack)
+}
+
+func TestRequestTxCommitsWithoutResponse(t *testing.T) {
+	pool := &poolMock{}
+	conn := db.WithTenantIsolation(pool)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn.Exec(db.WithTenant(r.Context(), "acme"), "DELETE FROM person")
+	})
+
+	w := serve(RequestTxMiddleware(handler), "DELETE", "/person/1", nil)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, pool.txs[0].committed)
+}
+
+func TestRequestTxRollsBackPanics(t *testing.T) {
+	pool := &poolMock{}
+	conn := db.WithTenantIsolation(pool)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn.Exec(db.WithTenant(r.Context(), "acme"), "DELETE FROM person")
+		panic("failed")
+	})
+
+	assert.Panics(t, func() {
+		serve(RequestTxMiddleware(handler), "DELETE", "/person/1", nil)
+	})
+	assert.True(t, pool.txs[0].rolledBack)
+}
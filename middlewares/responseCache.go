@@ -0,0 +1,262 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"goapi-template/cache"
+	"goapi-template/db"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResponseCache caches full GET responses. Responses are keyed by path,
+// query, the Vary request headers and the caller's Identity, and tagged with
+// the resource prefix of their path (e.g. /person for /person/1) within the
+// caller's tenant, so any successful write under that prefix invalidates the
+// responses of its tenant.
+type ResponseCache struct {
+	Cache cache.Cacher
+	// MaxAge is how long responses are cached, it is sent to clients as well
+	MaxAge time.Duration
+	// Vary lists the request headers responses depend on
+	Vary []string
+	// Identity returns who a response is computed for, on authorized routes
+	// responses are never shared between callers. Return "" for anonymous
+	// callers.
+	Identity func(r *http.Request) string
+}
+
+type cachedResponse struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt int64       `json:"storedAt"`
+}
+
+// responseRecorder passes the response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+	// beforeHeader, when set, is called with the status right before the
+	// headers are sent
+	beforeHeader func(status int)
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+		if w.beforeHeader != nil {
+			w.beforeHeader(code)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(body []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(body)
+	return w.ResponseWriter.Write(body)
+}
+
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Middleware serves GET requests from the cache and caches 200 responses.
+// Requests with Cache-Control: no-cache skip the cached response but refresh
+// it, no-store skips the cache altogether.
+func (c *ResponseCache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requestCacheControl := r.Header.Get("Cache-Control")
+		if hasDirective(requestCacheControl, "no-store") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity := ""
+		if c.Identity != nil {
+			identity = c.Identity(r)
+		}
+
+		key, err := cache.TaggedKey(r.Context(), c.Cache, c.key(r, identity), responseTag(r))
+		if err != nil {
+			slog.Error("Error getting response from cache", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !hasDirective(requestCacheControl, "no-cache") {
+			cached, err := cache.GetObject[cachedResponse](c.Cache, r.Context(), key)
+			if err != nil {
+				slog.Error("Error getting response from cache", "error", err)
+			}
+
+			if cached != nil {
+				c.writeCached(w, cached)
+				return
+			}
+		}
+
+		// the headers set by the middlewares before, such as the rate limits,
+		// belong to this request only
+		before := w.Header().Clone()
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		stored := false
+		// only the responses that are stored are announced as cacheable,
+		// clients mustn't keep the errors either
+		recorder.beforeHeader = func(status int) {
+			header := w.Header()
+			switch {
+			case hasDirective(header.Get("Cache-Control"), "no-store"):
+			case status == http.StatusOK:
+				c.setHeaders(header, 0, "MISS")
+				stored = true
+			default:
+				header.Set("Cache-Control", "no-store")
+			}
+		}
+		// the response is cached, so it mustn't come from a replica that
+		// hasn't replayed a recent write yet
+		next.ServeHTTP(recorder, r.WithContext(db.WithPrimaryReads(r.Context())))
+
+		if !stored {
+			return
+		}
+
+		cached := &cachedResponse{
+			Status:   recorder.status,
+			Header:   handlerHeader(w.Header(), before),
+			Body:     recorder.body.Bytes(),
+			StoredAt: time.Now().UnixMilli(),
+		}
+
+		if err := cache.SetObject(c.Cache, r.Context(), key, cached, cache.WithExpiration(c.MaxAge)); err != nil {
+			slog.Error("Error setting response into cache", "error", err)
+		}
+	})
+}
+
+// InvalidateMiddleware drops the cached responses of a resource prefix after
+// a successful write to it.
+func (c *ResponseCache) InvalidateMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status >= 400 {
+			return
+		}
+
+		if err := cache.InvalidateTags(r.Context(), c.Cache, responseTag(r)); err != nil {
+			slog.Error("Error invalidating cached responses", "path", r.URL.Path, "error", err)
+		}
+	})
+}
+
+// uncachedHeaders aren't stored with the responses even when the handler
+// sets them. Cookies and trace ids belong to the request the response was
+// first computed for, the others are set again on every hit.
+var uncachedHeaders = []string{"Set-Cookie", http.CanonicalHeaderKey(TraceHeader), "Cache-Control", "Age", "X-Cache", "Vary"}
+
+// handlerHeader returns the headers of header the handler set, the ones that
+// differ from before.
+func handlerHeader(header http.Header, before http.Header) http.Header {
+	result := http.Header{}
+	for name, values := range header {
+		if slices.Equal(values, before[name]) || slices.Contains(uncachedHeaders, name) {
+			continue
+		}
+		result[name] = slices.Clone(values)
+	}
+
+	return result
+}
+
+func (c *ResponseCache) writeCached(w http.ResponseWriter, cached *cachedResponse) {
+	header := w.Header()
+	for name, values := range cached.Header {
+		header[name] = values
+	}
+
+	age := time.Since(time.UnixMilli(cached.StoredAt))
+	c.setHeaders(header, age, "HIT")
+
+	w.WriteHeader(cached.Status)
+	w.Write(cached.Body)
+}
+
+func (c *ResponseCache) setHeaders(header http.Header, age time.Duration, status string) {
+	scope := "public"
+	vary := c.Vary
+	if c.Identity != nil {
+		scope = "private"
+		vary = append([]string{"Authorization"}, vary...)
+	}
+
+	header.Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(c.MaxAge.Seconds())))
+	header.Set("Age", strconv.Itoa(int(age.Seconds())))
+	header.Set("X-Cache", status)
+	// added to the Vary of the middlewares before, such as Compress
+	for _, name := range vary {
+		addVary(header, name)
+	}
+}
+
+// key hashes everything a response depends on, the query is kept in its
+// canonical (sorted) form.
+func (c *ResponseCache) key(r *http.Request, identity string) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%s\n%s\n", r.Method, r.URL.Path, r.URL.Query().Encode(), identity)
+	for _, name := range c.Vary {
+		fmt.Fprintf(hash, "%s: %s\n", name, strings.Join(r.Header.Values(name), ","))
+	}
+
+	return "response:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseTag returns the tag of the resource prefix of the path of r, its
+// first segment, in the tenant of r when it has one, see db.WithTenant.
+func responseTag(r *http.Request) string {
+	prefix, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	tag := "response:/" + prefix
+
+	if tenant := db.TenantFrom(r.Context()); tenant != "" {
+		return "tenant:" + tenant + ":" + tag
+	}
+
+	return tag
+}
+
+func hasDirective(cacheControl string, directive string) bool {
+	for _, value := range strings.Split(cacheControl, ",") {
+		if strings.EqualFold(strings.TrimSpace(value), directive) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func NewResponseCache(cacher cache.Cacher, maxAge time.Duration, vary []string) *ResponseCache {
+	return &ResponseCache{Cache: cacher, MaxAge: maxAge, Vary: vary}
+}
@@ -0,0 +1,270 @@
+package middlewares
+
+import (
+	"goapi-template/cache"
+	"goapi-template/db"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingHandler answers with the number of times it was called
+func countingHandler(calls *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=1")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(strconv.Itoa(*calls)))
+	})
+}
+
+func newTestResponseCache() *ResponseCache {
+	return NewResponseCache(cache.NewMemoryCacher(100, 0, time.Hour), time.Minute, []string{"Accept"})
+}
+
+func serve(handler http.Handler, method string, url string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, nil)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestResponseCacheHit(t *testing.T) {
+	calls := 0
+	handler := newTestResponseCache().Middleware(countingHandler(&calls))
+
+	first := serve(handler, "GET", "/person?b=2&a=1", nil)
+	second := serve(handler, "GET", "/person?a=1&b=2", nil)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "MISS", first.Header().Get("X-Cache"))
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.Equal(t, "1", second.Body.String())
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=60", second.Header().Get("Cache-Control"))
+	assert.Equal(t, "0", second.Header().Get("Age"))
+	assert.Equal(t, "Accept", second.Header().Get("Vary"))
+	assert.Empty(t, second.Header().Get("Set-Cookie"))
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	calls := 0
+	handler := newTestResponseCache().Middleware(countingHandler(&calls))
+
+	serve(handler, "GET", "/person", map[string]string{"Accept": "application/json"})
+	result := serve(handler, "GET", "/person", map[string]string{"Accept": "text/csv"})
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "2", result.Body.String())
+}
+
+func TestResponseCacheIdentity(t *testing.T) {
+	calls := 0
+	responseCache := newTestResponseCache()
+	responseCache.Identity = func(r *http.Request) string { return r.Header.Get("X-User") }
+	handler := responseCache.Middleware(countingHandler(&calls))
+
+	serve(handler, "GET", "/person", map[string]string{"X-User": "a"})
+	other := serve(handler, "GET", "/person", map[string]string{"X-User": "b"})
+	same := serve(handler, "GET", "/person", map[string]string{"X-User": "a"})
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "2", other.Body.String())
+	assert.Equal(t, "1", same.Body.String())
+	assert.Equal(t, "private, max-age=60", same.Header().Get("Cache-Control"))
+	assert.Equal(t, []string{"Authorization", "Accept"}, same.Header().Values("Vary"))
+}
+
+func TestResponseCacheNoCache(t *testing.T) {
+	calls := 0
+	handler := newTestResponseCache().Middleware(countingHandler(&calls))
+
+	serve(handler, "GET", "/person", nil)
+	refreshed := serve(handler, "GET", "/person", map[string]string{"Cache-Control": "no-cache"})
+	cached := serve(handler, "GET", "/person", nil)
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "2", refreshed.Body.String())
+	assert.Equal(t, "2", cached.Body.String())
+}
+
+func TestResponseCacheNoStore(t *testing.T) {
+	calls := 0
+	handler := newTestResponseCache().Middleware(countingHandler(&calls))
+
+	serve(handler, "GET", "/person", map[string]string{"Cache-Control": "no-store"})
+	result := serve(handler, "GET", "/person", nil)
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "MISS", result.Header().Get("X-Cache"))
+}
+
+func TestResponseCacheFillsFromPrimary(t *testing.T) {
+	handler := newTestResponseCache().Middleware(routingHandler())
+
+	assert.Equal(t, "primary", serve(handler, "GET", "/person/1", nil).Body.String())
+	// responses that aren't cached may come from a replica
+	assert.Equal(t, "replica", serve(handler, "GET", "/person/1", map[string]string{"Cache-Control": "no-store"}).Body.String())
+}
+
+func TestResponseCacheOnlyCachesOk(t *testing.T) {
+	calls := 0
+	handler := newTestResponseCache().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	serve(handler, "GET", "/person/1", nil)
+	serve(handler, "GET", "/person/1", nil)
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestResponseCacheInvalidatedByWrites(t *testing.T) {
+	calls := 0
+	responseCache := newTestResponseCache()
+	get := responseCache.Middleware(countingHandler(&calls))
+	write := responseCache.InvalidateMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/person/2" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve(get, "GET", "/person", nil)
+	serve(get, "GET", "/health", nil)
+
+	// failed writes don't invalidate anything
+	serve(write, "PUT", "/person/2", nil)
+	serve(get, "GET", "/person", nil)
+	assert.Equal(t, 2, calls)
+
+	serve(write, "PUT", "/person/1", nil)
+	result := serve(get, "GET", "/person", nil)
+	health := serve(get, "GET", "/health", nil)
+
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, "3", result.Body.String())
+	assert.Equal(t, "HIT", health.Header().Get("X-Cache"))
+}
+
+func TestResponseTag(t *testing.T) {
+	assert.Equal(t, "response:/person", responseTag(httptest.NewRequest("GET", "/person", nil)))
+	assert.Equal(t, "response:/person", responseTag(httptest.NewRequest("GET", "/person/1", nil)))
+	assert.Equal(t, "response:/", responseTag(httptest.NewRequest("GET", "/", nil)))
+
+	r := httptest.NewRequest("GET", "/person/1", nil)
+	assert.Equal(t, "tenant:acme:response:/person", responseTag(r.WithContext(db.WithTenant(r.Context(), "acme"))))
+}
+
+// withTenant serves the requests of handler in tenant
+func withTenant(tenant string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r.WithContext(db.WithTenant(r.Context(), tenant)))
+	})
+}
+
+func TestResponseCacheInvalidatesTheTenantOfWrites(t *testing.T) {
+	calls := 0
+	responseCache := newTestResponseCache()
+	get := responseCache.Middleware(countingHandler(&calls))
+	write := responseCache.InvalidateMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve(withTenant("acme", get), "GET", "/person", nil)
+	serve(withTenant("other", get), "GET", "/person", nil)
+	assert.Equal(t, 2, calls)
+
+	serve(withTenant("acme", write), "PUT", "/person/1", nil)
+
+	acme := serve(withTenant("acme", get), "GET", "/person", nil)
+	other := serve(withTenant("other", get), "GET", "/person", nil)
+
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, "MISS", acme.Header().Get("X-Cache"))
+	assert.Equal(t, "HIT", other.Header().Get("X-Cache"))
+}
+
+func TestResponseCacheErrorsAreNotCacheable(t *testing.T) {
+	handler := newTestResponseCache().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotAcceptable)
+	}))
+
+	result := serve(handler, "GET", "/person/1", nil)
+
+	assert.Equal(t, http.StatusNotAcceptable, result.Code)
+	assert.Equal(t, "no-store", result.Header().Get("Cache-Control"))
+	assert.Empty(t, result.Header().Get("X-Cache"))
+}
+
+func TestResponseCacheHandlerNoStore(t *testing.T) {
+	calls := 0
+	handler := newTestResponseCache().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Write([]byte("OK"))
+	}))
+
+	serve(handler, "GET", "/person/1", nil)
+	result := serve(handler, "GET", "/person/1", nil)
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, "private, no-store", result.Header().Get("Cache-Control"))
+	assert.Empty(t, result.Header().Get("X-Cache"))
+}
+
+func TestResponseCacheHitKeepsRateLimitHeaders(t *testing.T) {
+	calls := 0
+	remaining := 10
+	responseCache := newTestResponseCache()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// set before the cache, as RateLimit does
+		remaining--
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		responseCache.Middleware(countingHandler(&calls)).ServeHTTP(w, r)
+	})
+
+	serve(handler, "GET", "/person", nil)
+	result := serve(handler, "GET", "/person", nil)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "HIT", result.Header().Get("X-Cache"))
+	assert.Equal(t, "8", result.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "application/json", result.Header().Get("Content-Type"))
+}
+
+func TestResponseCacheHitKeepsTraceId(t *testing.T) {
+	calls := 0
+	handler := TraceMiddleware(newTestResponseCache().Middleware(countingHandler(&calls)))
+
+	first := serve(handler, "GET", "/person", nil)
+	second := serve(handler, "GET", "/person", nil)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.NotEmpty(t, second.Header().Get(TraceHeader))
+	assert.NotEqual(t, first.Header().Get(TraceHeader), second.Header().Get(TraceHeader))
+}
+
+func TestResponseCacheKeepsCompressVary(t *testing.T) {
+	calls := 0
+	handler := NewCompress([]string{"gzip"}, 1024).Middleware(newTestResponseCache().Middleware(countingHandler(&calls)))
+
+	first := serve(handler, "GET", "/person", map[string]string{"Accept-Encoding": "gzip"})
+	second := serve(handler, "GET", "/person", map[string]string{"Accept-Encoding": "gzip"})
+
+	assert.Equal(t, []string{"Accept-Encoding", "Accept"}, first.Header().Values("Vary"))
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.Equal(t, []string{"Accept-Encoding", "Accept"}, second.Header().Values("Vary"))
+}
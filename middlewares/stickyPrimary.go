@@ -0,0 +1,123 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"goapi-template/db"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StickyPrimaryCookie holds the end of the window, in unix milliseconds,
+// during which the caller's reads go to the primary database, followed by
+// its signature when StickyPrimary has a Key.
+const StickyPrimaryCookie = "db_primary_until"
+
+// StickyPrimary lets callers read their own writes when reads go to
+// replicas: after a write, the reads of the request and of the caller's next
+// requests go to the primary for the sticky window of db.RoutingQuerier.
+type StickyPrimary struct {
+	// Window is the sticky window, cookies ending later than it from now
+	// are ignored
+	Window time.Duration
+	// Key signs the cookies with HMAC-SHA256 when set, so that callers
+	// can't send their reads to the primary without writing
+	Key []byte
+
+	now func() time.Time
+}
+
+func NewStickyPrimary(window time.Duration, key []byte) *StickyPrimary {
+	return &StickyPrimary{Window: window, Key: key, now: time.Now}
+}
+
+func (s *StickyPrimary) sign(value string) string {
+	if len(s.Key) == 0 {
+		return value
+	}
+
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *StickyPrimary) cookie(until time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     StickyPrimaryCookie,
+		Value:    s.sign(strconv.FormatInt(until.UnixMilli(), 10)),
+		Path:     "/",
+		Expires:  until,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// until returns the end of the window of the request, the zero time when its
+// cookie is missing, forged, or already ended.
+func (s *StickyPrimary) until(r *http.Request) time.Time {
+	cookie, err := r.Cookie(StickyPrimaryCookie)
+	if err != nil {
+		return time.Time{}
+	}
+
+	value, _, _ := strings.Cut(cookie.Value, ".")
+	if !hmac.Equal([]byte(s.sign(value)), []byte(cookie.Value)) {
+		return time.Time{}
+	}
+
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	now := s.now()
+	until := time.UnixMilli(millis)
+	if !until.After(now) || until.After(now.Add(s.Window)) {
+		return time.Time{}
+	}
+
+	return until
+}
+
+// stickyResponseWriter sets the cookie before the response is written when
+// the request wrote to the database.
+type stickyResponseWriter struct {
+	http.ResponseWriter
+	primary     *StickyPrimary
+	sticky      *db.Sticky
+	until       time.Time
+	wroteHeader bool
+}
+
+func (w *stickyResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if until := w.sticky.Until(); until.After(w.until) {
+			http.SetCookie(w.ResponseWriter, w.primary.cookie(until))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *stickyResponseWriter) Write(body []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(body)
+}
+
+func (w *stickyResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (s *StickyPrimary) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		until := s.until(r)
+		ctx, sticky := db.WithSticky(r.Context(), until)
+
+		next.ServeHTTP(&stickyResponseWriter{ResponseWriter: w, primary: s, sticky: sticky, until: until}, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,114 @@
+package middlewares
+
+import (
+	"context"
+	"goapi-template/db"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// routingHandler writes through a RoutingQuerier on POST and answers with
+// where GET reads were routed to
+func routingHandler() http.Handler {
+	querier := db.NewRoutingQuerier(
+		&QuerierMock{Name: "primary"},
+		[]*db.Replica{{Name: "replica", Queries: &QuerierMock{Name: "replica"}}},
+		time.Second,
+		time.Minute,
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			querier.InsertPerson(r.Context(), db.InsertPersonParams{})
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		person, _ := querier.GetPersonById(r.Context(), 1)
+		w.Write([]byte(person.Name))
+	})
+}
+
+type QuerierMock struct {
+	db.Querier
+	Name string
+}
+
+func (m *QuerierMock) GetPersonById(ctx context.Context, id int32) (db.Person, error) {
+	return db.Person{Name: m.Name}, nil
+}
+
+func (m *QuerierMock) InsertPerson(ctx context.Context, arg db.InsertPersonParams) (db.Person, error) {
+	return db.Person{}, nil
+}
+
+func TestStickyPrimaryAfterWrite(t *testing.T) {
+	handler := NewStickyPrimary(time.Minute, nil).Middleware(routingHandler())
+
+	read := serve(handler, "GET", "/person/1", nil)
+	assert.Equal(t, "replica", read.Body.String())
+	assert.Empty(t, read.Result().Cookies())
+
+	write := serve(handler, "POST", "/person", nil)
+	cookies := write.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, StickyPrimaryCookie, cookies[0].Name)
+	assert.True(t, cookies[0].HttpOnly)
+
+	read = serve(handler, "GET", "/person/1", map[string]string{"Cookie": cookies[0].Name + "=" + cookies[0].Value})
+	assert.Equal(t, "primary", read.Body.String())
+	assert.Empty(t, read.Result().Cookies())
+}
+
+func TestStickyPrimaryExpiredCookie(t *testing.T) {
+	handler := NewStickyPrimary(time.Minute, nil).Middleware(routingHandler())
+
+	expired := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	read := serve(handler, "GET", "/person/1", map[string]string{"Cookie": StickyPrimaryCookie + "=" + expired})
+
+	assert.Equal(t, "replica", read.Body.String())
+}
+
+func TestStickyPrimaryInvalidCookie(t *testing.T) {
+	handler := NewStickyPrimary(time.Minute, nil).Middleware(routingHandler())
+
+	read := serve(handler, "GET", "/person/1", map[string]string{"Cookie": StickyPrimaryCookie + "=soon"})
+
+	assert.Equal(t, "replica", read.Body.String())
+}
+
+func TestStickyPrimaryFarFutureCookie(t *testing.T) {
+	handler := NewStickyPrimary(time.Minute, nil).Middleware(routingHandler())
+
+	forever := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	read := serve(handler, "GET", "/person/1", map[string]string{"Cookie": StickyPrimaryCookie + "=" + forever})
+
+	assert.Equal(t, "replica", read.Body.String())
+}
+
+func TestStickyPrimarySignedCookie(t *testing.T) {
+	handler := NewStickyPrimary(time.Minute, []byte("secret")).Middleware(routingHandler())
+
+	write := serve(handler, "POST", "/person", nil)
+	cookie := write.Result().Cookies()[0]
+	assert.Contains(t, cookie.Value, ".")
+
+	read := serve(handler, "GET", "/person/1", map[string]string{"Cookie": cookie.Name + "=" + cookie.Value})
+	assert.Equal(t, "primary", read.Body.String())
+
+	// the signature of another window
+	until, signature, _ := strings.Cut(cookie.Value, ".")
+	millis, _ := strconv.ParseInt(until, 10, 64)
+	forged := strconv.FormatInt(millis+1, 10) + "." + signature
+	read = serve(handler, "GET", "/person/1", map[string]string{"Cookie": StickyPrimaryCookie + "=" + forged})
+	assert.Equal(t, "replica", read.Body.String())
+
+	// unsigned
+	read = serve(handler, "GET", "/person/1", map[string]string{"Cookie": StickyPrimaryCookie + "=" + until})
+	assert.Equal(t, "replica", read.Body.String())
+}
@@ -0,0 +1,71 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Timeout cancels the context of requests running longer than their
+// timeout, which cancels their queries. Handlers report the cancellation,
+// requests timing out before anything was written get a 503.
+type Timeout struct {
+	// Timeout applies to the routes missing from Routes, 0 disables it
+	Timeout time.Duration
+	// Routes overrides Timeout for routes by their pattern
+	Routes map[string]time.Duration
+}
+
+// timeoutWriter tracks whether the response was started.
+type timeoutWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutWriter) Write(body []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(body)
+}
+
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func NewTimeout(timeout time.Duration) *Timeout {
+	return &Timeout{Timeout: timeout, Routes: map[string]time.Duration{}}
+}
+
+func (t *Timeout) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout, ok := t.Routes[r.Pattern]
+		if !ok {
+			timeout = t.Timeout
+		}
+
+		if timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		writer := &timeoutWriter{ResponseWriter: w}
+		next.ServeHTTP(writer, r.WithContext(ctx))
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			slog.Warn("Request timed out", "timeout", timeout, "traceId", r.Context().Value(ContextKey("traceId")))
+
+			if !writer.written {
+				writeError(w, http.StatusServiceUnavailable, "Request timed out")
+			}
+		}
+	})
+}
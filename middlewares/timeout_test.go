@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	timeout := NewTimeout(10 * time.Millisecond)
+	w := httptest.NewRecorder()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	timeout.Middleware(next).ServeHTTP(w, httptest.NewRequest("GET", "/person", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"errors":["Request timed out"]}`, w.Body.String())
+}
+
+func TestTimeoutMiddlewareKeepsHandlerResponse(t *testing.T) {
+	timeout := NewTimeout(10 * time.Millisecond)
+	w := httptest.NewRecorder()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusGatewayTimeout)
+	})
+
+	timeout.Middleware(next).ServeHTTP(w, httptest.NewRequest("GET", "/person", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestTimeoutRoutes(t *testing.T) {
+	timeout := NewTimeout(time.Millisecond)
+	timeout.Routes["POST /person/batch"] = time.Minute
+	w := httptest.NewRecorder()
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok := r.Context().Deadline()
+		assert.True(t, ok)
+		assert.Greater(t, time.Until(deadline), time.Second)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	r := httptest.NewRequest("POST", "/person/batch", nil)
+	r.Pattern = "POST /person/batch"
+	timeout.Middleware(next).ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -9,11 +9,16 @@ import (
 
 type ContextKey string
 
+// TraceHeader is the response header holding the trace id of the request, to
+// match client side errors with the logs.
+const TraceHeader = "X-Trace-Id"
+
 func TraceMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		traceId := uuid.New()
 
 		ctx := context.WithValue(r.Context(), ContextKey("traceId"), traceId)
+		w.Header().Set(TraceHeader, traceId.String())
 
 		newR := r.WithContext(ctx)
 
@@ -26,4 +26,5 @@ func TestTraceMiddleware(t *testing.T) {
 
 	// Validation
 	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, w.Header().Get(TraceHeader), 36)
 }
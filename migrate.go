@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"goapi-template/db"
+)
+
+const migrateUsage = `usage: migrate <command>
+
+commands:
+  up [N]       apply all or N pending migrations
+  down N       roll back N migrations
+  down --all   roll back every migration, dropping every table
+  goto V       migrate up or down to version V
+  version      print the current version
+  force V      set the version without migrating, after a failed migration
+  create NAME  create empty up and down migrations in ` + db.MigrationsDir
+
+var errMigrateUsage = errors.New(migrateUsage)
+
+// runMigrate runs the migrate subcommand, e.g. `go run . migrate down 1`.
+func runMigrate(args []string, connString string) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+
+	command, args := args[0], args[1:]
+
+	if command == "create" {
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+
+		paths, err := db.CreateMigration(db.MigrationsDir, args[0])
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+		return err
+	}
+
+	migrator, err := db.NewMigrator(connString)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch command {
+	case "up":
+		steps := 0
+		if len(args) > 0 {
+			if steps, err = strconv.Atoi(args[0]); err != nil || steps <= 0 {
+				return fmt.Errorf("N must be a positive number")
+			}
+		}
+
+		err = migrator.Up(steps)
+	case "down":
+		// rolling back everything drops the data, it is never the default
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+
+		if args[0] == "--all" {
+			err = migrator.DownAll()
+			break
+		}
+
+		steps, parseErr := strconv.Atoi(args[0])
+		if parseErr != nil || steps <= 0 {
+			return fmt.Errorf("N must be a positive number, or --all")
+		}
+
+		err = migrator.Down(steps)
+	case "goto":
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+
+		version, parseErr := strconv.ParseUint(args[0], 10, 32)
+		if parseErr != nil {
+			return fmt.Errorf("V must be a version number")
+		}
+
+		err = migrator.Goto(uint(version))
+	case "force":
+		if len(args) != 1 {
+			return errMigrateUsage
+		}
+
+		version, parseErr := strconv.Atoi(args[0])
+		if parseErr != nil {
+			return fmt.Errorf("V must be a version number, or -1")
+		}
+
+		err = migrator.Force(version)
+	case "version":
+	default:
+		return errMigrateUsage
+	}
+
+	if err != nil {
+		return err
+	}
+
+	version, dirty, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("version %d", version)
+	if dirty {
+		fmt.Print(" (dirty)")
+	}
+	fmt.Println()
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package models
+
+import "strings"
+
+// emailProvider holds the rules of a provider that delivers different
+// spellings of an address to the same mailbox.
+type emailProvider struct {
+	// Domain replaces the aliases of the provider domain
+	Domain string
+	// IgnoreDots drops the dots of the local part
+	IgnoreDots bool
+	// PlusTags drops the "+tag" suffix of the local part
+	PlusTags bool
+}
+
+var emailProviders = map[string]emailProvider{
+	"gmail.com":      {Domain: "gmail.com", IgnoreDots: true, PlusTags: true},
+	"googlemail.com": {Domain: "gmail.com", IgnoreDots: true, PlusTags: true},
+	"outlook.com":    {Domain: "outlook.com", PlusTags: true},
+	"hotmail.com":    {Domain: "hotmail.com", PlusTags: true},
+	"live.com":       {Domain: "live.com", PlusTags: true},
+}
+
+// EmailNormalizer puts emails in the form they are stored and compared in.
+type EmailNormalizer struct {
+	// ProviderRules applies the rules of well known providers, so that
+	// "J.Doe+news@googlemail.com" and "jdoe@gmail.com" are the same email
+	ProviderRules bool
+}
+
+// Normalize trims email and lower-cases its domain. The local part is kept as
+// is, unless a provider rule applies.
+func (n EmailNormalizer) Normalize(email string) string {
+	email = strings.TrimSpace(email)
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+
+	local, domain := email[:at], strings.ToLower(email[at+1:])
+
+	if provider, ok := emailProviders[domain]; ok && n.ProviderRules {
+		local = strings.ToLower(local)
+		if provider.PlusTags {
+			local, _, _ = strings.Cut(local, "+")
+		}
+		if provider.IgnoreDots {
+			local = strings.ReplaceAll(local, ".", "")
+		}
+		domain = provider.Domain
+	}
+
+	return local + "@" + domain
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	normalizer := EmailNormalizer{}
+
+	assert.Equal(t, "John.Doe@company.com", normalizer.Normalize("  John.Doe@Company.COM "))
+	assert.Equal(t, "J.Doe+news@gmail.com", normalizer.Normalize("J.Doe+news@GMail.com"))
+	assert.Equal(t, "not an email", normalizer.Normalize(" not an email "))
+}
+
+func TestNormalizeEmailProviderRules(t *testing.T) {
+	normalizer := EmailNormalizer{ProviderRules: true}
+
+	assert.Equal(t, "jdoe@gmail.com", normalizer.Normalize("J.Doe+news@googlemail.com"))
+	assert.Equal(t, "j.doe@outlook.com", normalizer.Normalize("J.Doe+news@Outlook.com"))
+	assert.Equal(t, "J.Doe+news@company.com", normalizer.Normalize("J.Doe+news@company.com"))
+}
@@ -2,6 +2,7 @@ package models
 
 type HealthResult struct {
 	Healthy      bool               `json:"healthy"`
+	Degraded     bool               `json:"degraded"`
 	Dependencies []HealthResultItem `json:"dependencies"`
 }
 